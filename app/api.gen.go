@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/oapi-codegen/runtime"
 	openapi_types "github.com/oapi-codegen/runtime/types"
 )
 
@@ -18,41 +19,433 @@ const (
 	ApiKeyAuthScopes = "ApiKeyAuth.Scopes"
 )
 
+// Defines values for JobResponseStatus.
+const (
+	Failed    JobResponseStatus = "failed"
+	Paused    JobResponseStatus = "paused"
+	Queued    JobResponseStatus = "queued"
+	Running   JobResponseStatus = "running"
+	Succeeded JobResponseStatus = "succeeded"
+)
+
+// Valid indicates whether the value is a known member of the JobResponseStatus enum.
+func (e JobResponseStatus) Valid() bool {
+	switch e {
+	case Failed:
+		return true
+	case Paused:
+		return true
+	case Queued:
+		return true
+	case Running:
+		return true
+	case Succeeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// Defines values for UploadPhotoDuplicateResponseAction.
+const (
+	Merged  UploadPhotoDuplicateResponseAction = "merged"
+	Skipped UploadPhotoDuplicateResponseAction = "skipped"
+)
+
+// Valid indicates whether the value is a known member of the UploadPhotoDuplicateResponseAction enum.
+func (e UploadPhotoDuplicateResponseAction) Valid() bool {
+	switch e {
+	case Merged:
+		return true
+	case Skipped:
+		return true
+	default:
+		return false
+	}
+}
+
+// Defines values for UploadPhotoRequestDuplicate.
+const (
+	Keep  UploadPhotoRequestDuplicate = "keep"
+	Merge UploadPhotoRequestDuplicate = "merge"
+	Skip  UploadPhotoRequestDuplicate = "skip"
+)
+
+// Valid indicates whether the value is a known member of the UploadPhotoRequestDuplicate enum.
+func (e UploadPhotoRequestDuplicate) Valid() bool {
+	switch e {
+	case Keep:
+		return true
+	case Merge:
+		return true
+	case Skip:
+		return true
+	default:
+		return false
+	}
+}
+
+// CreatePlantRequest defines model for CreatePlantRequest.
+type CreatePlantRequest struct {
+	// AcquiredOn 入手日
+	AcquiredOn *openapi_types.Date `json:"acquired_on,omitempty"`
+
+	// Location 置き場所
+	Location *string `json:"location,omitempty"`
+	Name     string  `json:"name"`
+
+	// Species 品種・種類
+	Species *string `json:"species,omitempty"`
+
+	// UserUuid 植物を所有するユーザーのUUID（省略可。指定する場合はAPIトークンのユーザーと一致する必要がある）
+	UserUuid *string `json:"user_uuid,omitempty"`
+}
+
 // CreateUserRequest defines model for CreateUserRequest.
 type CreateUserRequest struct {
 	Password string `json:"password"`
 	Username string `json:"username"`
 }
 
+// DiaryListResponse defines model for DiaryListResponse.
+type DiaryListResponse struct {
+	Diaries []DiaryResponse `json:"diaries"`
+	Page    int             `json:"page"`
+	PerPage int             `json:"per_page"`
+
+	// Total 絞り込み条件に一致する日記の総数
+	Total int `json:"total"`
+}
+
+// DiaryObservationResponse 日記の生成時に写真から推定した植物の状態（構造化出力に対応していないプロバイダで生成した日記では省略）
+type DiaryObservationResponse struct {
+	// Flowering 花が咲いているか
+	Flowering bool `json:"flowering"`
+
+	// Fruiting 実がなっているか
+	Fruiting bool `json:"fruiting"`
+
+	// HealthScore 健康状態（1〜5、不明の場合は省略）
+	HealthScore *int `json:"health_score,omitempty"`
+
+	// Issues 検出した問題（yellowing、browning、wilting、pests、disease、overwatering、underwatering、sunburn）
+	Issues []string `json:"issues"`
+
+	// LeafCount 葉の枚数（不明の場合は省略）
+	LeafCount *int `json:"leaf_count,omitempty"`
+
+	// Tags 写真の特徴を表すタグ
+	Tags []string `json:"tags"`
+}
+
+// DiaryResponse defines model for DiaryResponse.
+type DiaryResponse struct {
+	// Content 日記本文
+	Content string `json:"content"`
+
+	// CreatedAt 撮影日時
+	CreatedAt time.Time `json:"created_at"`
+	Id        int       `json:"id"`
+
+	// ImageUrl 写真のURL（サーバーからの相対パス）
+	ImageUrl string `json:"image_url"`
+
+	// Observation 日記の生成時に写真から推定した植物の状態（構造化出力に対応していないプロバイダで生成した日記では省略）
+	Observation *DiaryObservationResponse `json:"observation,omitempty"`
+
+	// PlantUuid 日記の植物のUUID（植物未指定の場合は省略）
+	PlantUuid *string `json:"plant_uuid,omitempty"`
+
+	// Provider 日記本文を生成したプロバイダ名（不明な場合は省略）
+	Provider *string `json:"provider,omitempty"`
+}
+
+// JobResponse defines model for JobResponse.
+type JobResponse struct {
+	// Attempts 日記生成の試行回数
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+	JobId     string    `json:"job_id"`
+
+	// LastError 最後に失敗した際のエラー内容（pausedの場合は一時停止の理由）
+	LastError *string `json:"last_error,omitempty"`
+
+	// Status pausedは使用料金が予算の上限に達したため生成を一時停止している状態（上限を下回ると再開する）
+	Status    JobResponseStatus `json:"status"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// JobResponseStatus pausedは使用料金が予算の上限に達したため生成を一時停止している状態（上限を下回ると再開する）
+type JobResponseStatus string
+
+// PlantResponse defines model for PlantResponse.
+type PlantResponse struct {
+	AcquiredOn *openapi_types.Date `json:"acquired_on,omitempty"`
+	CreatedAt  time.Time           `json:"created_at"`
+	Location   string              `json:"location"`
+	Name       string              `json:"name"`
+	PlantUuid  string              `json:"plant_uuid"`
+	Species    string              `json:"species"`
+}
+
+// ProviderStatsListResponse defines model for ProviderStatsListResponse.
+type ProviderStatsListResponse struct {
+	// Providers プロバイダを試す順
+	Providers []ProviderStatsResponse `json:"providers"`
+}
+
+// ProviderStatsResponse defines model for ProviderStatsResponse.
+type ProviderStatsResponse struct {
+	// Failures 日記の生成に失敗した回数
+	Failures int `json:"failures"`
+
+	// LastError 直近の失敗のエラー内容（失敗していない場合は省略）
+	LastError *string `json:"last_error,omitempty"`
+
+	// LastFailureAt 直近の失敗日時（失敗していない場合は省略）
+	LastFailureAt *time.Time `json:"last_failure_at,omitempty"`
+
+	// Name プロバイダ名（openai、ollama、gemini、template、mock）
+	Name string `json:"name"`
+
+	// Successes 日記の生成に成功した回数
+	Successes int `json:"successes"`
+}
+
+// RegenerateDiaryResponse defines model for RegenerateDiaryResponse.
+type RegenerateDiaryResponse struct {
+	// Content 再生成された日記本文
+	Content string `json:"content"`
+	DiaryId int    `json:"diary_id"`
+
+	// PreviousContent 置き換え前の日記本文
+	PreviousContent string `json:"previous_content"`
+
+	// PreviousRevisionId 置き換え前の本文の版ID
+	PreviousRevisionId int `json:"previous_revision_id"`
+}
+
+// RetryStatsListResponse defines model for RetryStatsListResponse.
+type RetryStatsListResponse struct {
+	// Operations 操作名の順
+	Operations []RetryStatsResponse `json:"operations"`
+}
+
+// RetryStatsResponse defines model for RetryStatsResponse.
+type RetryStatsResponse struct {
+	// Attempts リトライを含む試行回数の合計
+	Attempts int `json:"attempts"`
+
+	// Calls 操作を実行した回数
+	Calls int `json:"calls"`
+
+	// Canceled キャンセル（サーバーの停止やリクエストの切断）で終了した回数
+	Canceled int `json:"canceled"`
+
+	// Failures リトライの上限まで失敗した回数（プロバイダが指定した待ち時間が長すぎて諦めた場合を含む）
+	Failures int `json:"failures"`
+
+	// Operation 操作名（generate diary など）
+	Operation string `json:"operation"`
+
+	// PermanentFailures リトライしない失敗（画像ファイルがない、認証エラーなど）で終了した回数
+	PermanentFailures int `json:"permanent_failures"`
+
+	// RateLimited プロバイダに待ち時間を指定された（HTTP 429）失敗の回数
+	RateLimited int `json:"rate_limited"`
+
+	// Successes 成功した回数
+	Successes int `json:"successes"`
+}
+
+// UpdateDiaryRequest defines model for UpdateDiaryRequest.
+type UpdateDiaryRequest struct {
+	// Content 新しい日記本文
+	Content string `json:"content"`
+}
+
+// UploadPhotoDuplicateResponse defines model for UploadPhotoDuplicateResponse.
+type UploadPhotoDuplicateResponse struct {
+	// Action skippedは写真を保存しなかった、mergedは写真を保存して重複する写真の日記に統合した
+	Action UploadPhotoDuplicateResponseAction `json:"action"`
+
+	// Distance 重複する写真との知覚ハッシュの距離（0〜64、小さいほど似ている）
+	Distance int `json:"distance"`
+
+	// DuplicateOf 重複する写真のURL（サーバーからの相対パス）
+	DuplicateOf string `json:"duplicate_of"`
+
+	// DuplicateOfDiaryId 重複する写真の日記のID（日記が未作成の場合は省略）
+	DuplicateOfDiaryId *int `json:"duplicate_of_diary_id,omitempty"`
+
+	// ImageUrl 統合して保存した写真のURL（mergedの場合のみ）
+	ImageUrl *string `json:"image_url,omitempty"`
+}
+
+// UploadPhotoDuplicateResponseAction skippedは写真を保存しなかった、mergedは写真を保存して重複する写真の日記に統合した
+type UploadPhotoDuplicateResponseAction string
+
 // UploadPhotoRequest defines model for UploadPhotoRequest.
 type UploadPhotoRequest struct {
-	CapturedAt *time.Time         `json:"captured_at,omitempty"`
-	Photo      openapi_types.File `json:"photo"`
-	UserUuid   string             `json:"user_uuid"`
+	// CapturedAt 撮影日時（省略時はEXIFの撮影日時、EXIFにもない場合はサーバーの受信時刻。EXIFの向きに従って回転して保存する）
+	CapturedAt *time.Time `json:"captured_at,omitempty"`
+
+	// Duplicate 同じ植物の撮影日時の近い写真（duplicate_window 以内）に見た目がほぼ同じ写真がある場合の扱い。
+	// keepは通常どおり登録、skipは保存せずに重複する写真を返し、mergeは写真を保存して重複する写真の日記に統合する（日記は生成しない）
+	Duplicate *UploadPhotoRequestDuplicate `json:"duplicate,omitempty"`
+
+	// DuplicateWindow 重複を探す撮影日時の前後の範囲（秒）
+	DuplicateWindow *int `json:"duplicate_window,omitempty"`
+
+	// Photo 写真（JPEG・PNG・WebP・GIF）。JPEG以外はJPEGに変換し、EXIFの位置情報は除いて保存する
+	Photo openapi_types.File `json:"photo"`
+
+	// PlantUuid 写真の植物のUUID（省略時は植物未指定の日記になる）
+	PlantUuid *string `json:"plant_uuid,omitempty"`
+
+	// UserUuid 写真のユーザーのUUID（省略可。指定する場合はAPIトークンのユーザーと一致する必要がある）
+	UserUuid *string `json:"user_uuid,omitempty"`
 }
 
+// UploadPhotoRequestDuplicate 同じ植物の撮影日時の近い写真（duplicate_window 以内）に見た目がほぼ同じ写真がある場合の扱い。
+// keepは通常どおり登録、skipは保存せずに重複する写真を返し、mergeは写真を保存して重複する写真の日記に統合する（日記は生成しない）
+type UploadPhotoRequestDuplicate string
+
 // UploadPhotoResponse defines model for UploadPhotoResponse.
 type UploadPhotoResponse struct {
 	JobId string `json:"job_id"`
 }
 
+// UsageBudgetStatusResponse defines model for UsageBudgetStatusResponse.
+type UsageBudgetStatusResponse struct {
+	// DailyCost 今日（JST）の料金（USD）
+	DailyCost float64 `json:"daily_cost"`
+
+	// DailyLimit 1日の料金の上限（USD、0は上限なし）
+	DailyLimit float64 `json:"daily_limit"`
+
+	// Exceeded 上限に達していて日記の生成を一時停止しているかどうか
+	Exceeded bool `json:"exceeded"`
+
+	// MonthlyCost 今月（JST）の料金（USD）
+	MonthlyCost float64 `json:"monthly_cost"`
+
+	// MonthlyLimit 1ヶ月の料金の上限（USD、0は上限なし）
+	MonthlyLimit float64 `json:"monthly_limit"`
+	Username     string  `json:"username"`
+}
+
+// UsageResponse defines model for UsageResponse.
+type UsageResponse struct {
+	// Budgets ユーザーごとの予算の状況
+	Budgets []UsageBudgetStatusResponse `json:"budgets"`
+
+	// Daily 日ごとの使用量（新しい日から順）
+	Daily []UsageSummaryResponse `json:"daily"`
+
+	// Monthly 月ごとの使用量（新しい月から順）
+	Monthly []UsageSummaryResponse `json:"monthly"`
+}
+
+// UsageSummaryResponse defines model for UsageSummaryResponse.
+type UsageSummaryResponse struct {
+	// AverageLatencyMs 1回の生成にかかった平均時間（ミリ秒）
+	AverageLatencyMs int `json:"average_latency_ms"`
+
+	// Cost 料金の合計（USD）
+	Cost float64 `json:"cost"`
+
+	// Count 日記を生成（再生成を含む）した回数
+	Count int `json:"count"`
+
+	// Period 集計した日（YYYY-MM-DD）または月（YYYY-MM）
+	Period string `json:"period"`
+
+	// PromptTokens 入力のトークン数の合計
+	PromptTokens int `json:"prompt_tokens"`
+
+	// ResponseTokens 出力のトークン数の合計
+	ResponseTokens int    `json:"response_tokens"`
+	Username       string `json:"username"`
+}
+
 // UserResponse defines model for UserResponse.
 type UserResponse struct {
 	Username string `json:"username"`
 	Uuid     string `json:"uuid"`
 }
 
+// GetApiDiariesParams defines parameters for GetApiDiaries.
+type GetApiDiariesParams struct {
+	// Page ページ番号（1始まり）
+	Page *int `form:"page,omitempty" json:"page,omitempty"`
+
+	// PerPage 1ページあたりの件数
+	PerPage *int `form:"per_page,omitempty" json:"per_page,omitempty"`
+
+	// From この日（日本時間）以降に撮影された日記に絞り込む
+	From *openapi_types.Date `form:"from,omitempty" json:"from,omitempty"`
+
+	// To この日（日本時間）までに撮影された日記に絞り込む
+	To *openapi_types.Date `form:"to,omitempty" json:"to,omitempty"`
+
+	// Q 本文に含まれるキーワード
+	Q *string `form:"q,omitempty" json:"q,omitempty"`
+}
+
+// PostApiPhotosParams defines parameters for PostApiPhotos.
+type PostApiPhotosParams struct {
+	// IdempotencyKey 再送時に同じ写真を重複して登録しないためのキー（1〜255文字の表示可能なASCII）。同じキーのリクエストには24時間、最初のリクエストと同じ結果（登録したジョブ、または重複する写真）を返す。処理に失敗したリクエストのキーは再利用できる
+	IdempotencyKey *string `json:"Idempotency-Key,omitempty"`
+}
+
+// PatchApiDiariesIdJSONRequestBody defines body for PatchApiDiariesId for application/json ContentType.
+type PatchApiDiariesIdJSONRequestBody = UpdateDiaryRequest
+
 // PostApiPhotosMultipartRequestBody defines body for PostApiPhotos for multipart/form-data ContentType.
 type PostApiPhotosMultipartRequestBody = UploadPhotoRequest
 
+// PostApiPlantsJSONRequestBody defines body for PostApiPlants for application/json ContentType.
+type PostApiPlantsJSONRequestBody = CreatePlantRequest
+
 // PostApiUsersJSONRequestBody defines body for PostApiUsers for application/json ContentType.
 type PostApiUsersJSONRequestBody = CreateUserRequest
 
 // ServerInterface represents all server handlers.
 type ServerInterface interface {
+	// 日記の一覧を取得する
+	// (GET /api/diaries)
+	GetApiDiaries(w http.ResponseWriter, r *http.Request, params GetApiDiariesParams)
+	// 日記を削除する
+	// (DELETE /api/diaries/{id})
+	DeleteApiDiariesId(w http.ResponseWriter, r *http.Request, id int)
+	// 日記を取得する
+	// (GET /api/diaries/{id})
+	GetApiDiariesId(w http.ResponseWriter, r *http.Request, id int)
+	// 日記の本文を更新する
+	// (PATCH /api/diaries/{id})
+	PatchApiDiariesId(w http.ResponseWriter, r *http.Request, id int)
+	// 写真から日記を再生成する
+	// (POST /api/diaries/{id}/regenerate)
+	PostApiDiariesIdRegenerate(w http.ResponseWriter, r *http.Request, id int)
+	// 日記生成プロバイダごとの成功・失敗回数を取得する
+	// (GET /api/generator/providers)
+	GetApiGeneratorProviders(w http.ResponseWriter, r *http.Request)
+	// 操作ごとのリトライの回数を取得する
+	// (GET /api/generator/retries)
+	GetApiGeneratorRetries(w http.ResponseWriter, r *http.Request)
+	// 日記生成ジョブの状態を取得する
+	// (GET /api/jobs/{job_id})
+	GetApiJobsJobId(w http.ResponseWriter, r *http.Request, jobId string)
 	// 写真をアップロードする
 	// (POST /api/photos)
-	PostApiPhotos(w http.ResponseWriter, r *http.Request)
+	PostApiPhotos(w http.ResponseWriter, r *http.Request, params PostApiPhotosParams)
+	// 植物を登録する
+	// (POST /api/plants)
+	PostApiPlants(w http.ResponseWriter, r *http.Request)
+	// 日記の生成の使用量と予算を取得する
+	// (GET /api/usage)
+	GetApiUsage(w http.ResponseWriter, r *http.Request)
 	// ユーザーを作成する
 	// (POST /api/users)
 	PostApiUsers(w http.ResponseWriter, r *http.Request)
@@ -67,9 +460,335 @@ type ServerInterfaceWrapper struct {
 
 type MiddlewareFunc func(http.Handler) http.Handler
 
+// GetApiDiaries operation middleware
+func (siw *ServerInterfaceWrapper) GetApiDiaries(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, ApiKeyAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetApiDiariesParams
+
+	// ------------- Optional query parameter "page" -------------
+
+	err = runtime.BindQueryParameterWithOptions("form", true, false, "page", r.URL.Query(), &params.Page, runtime.BindQueryParameterOptions{Type: "integer", Format: ""})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "page", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "per_page" -------------
+
+	err = runtime.BindQueryParameterWithOptions("form", true, false, "per_page", r.URL.Query(), &params.PerPage, runtime.BindQueryParameterOptions{Type: "integer", Format: ""})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "per_page", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "from" -------------
+
+	err = runtime.BindQueryParameterWithOptions("form", true, false, "from", r.URL.Query(), &params.From, runtime.BindQueryParameterOptions{Type: "string", Format: "date"})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "from", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "to" -------------
+
+	err = runtime.BindQueryParameterWithOptions("form", true, false, "to", r.URL.Query(), &params.To, runtime.BindQueryParameterOptions{Type: "string", Format: "date"})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "to", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "q" -------------
+
+	err = runtime.BindQueryParameterWithOptions("form", true, false, "q", r.URL.Query(), &params.Q, runtime.BindQueryParameterOptions{Type: "string", Format: ""})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "q", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetApiDiaries(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// DeleteApiDiariesId operation middleware
+func (siw *ServerInterfaceWrapper) DeleteApiDiariesId(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id int
+
+	err = runtime.BindStyledParameterWithOptions("simple", "id", r.PathValue("id"), &id, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true, Type: "integer", Format: ""})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, ApiKeyAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.DeleteApiDiariesId(w, r, id)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetApiDiariesId operation middleware
+func (siw *ServerInterfaceWrapper) GetApiDiariesId(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id int
+
+	err = runtime.BindStyledParameterWithOptions("simple", "id", r.PathValue("id"), &id, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true, Type: "integer", Format: ""})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, ApiKeyAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetApiDiariesId(w, r, id)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// PatchApiDiariesId operation middleware
+func (siw *ServerInterfaceWrapper) PatchApiDiariesId(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id int
+
+	err = runtime.BindStyledParameterWithOptions("simple", "id", r.PathValue("id"), &id, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true, Type: "integer", Format: ""})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, ApiKeyAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PatchApiDiariesId(w, r, id)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// PostApiDiariesIdRegenerate operation middleware
+func (siw *ServerInterfaceWrapper) PostApiDiariesIdRegenerate(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id int
+
+	err = runtime.BindStyledParameterWithOptions("simple", "id", r.PathValue("id"), &id, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true, Type: "integer", Format: ""})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, ApiKeyAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PostApiDiariesIdRegenerate(w, r, id)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetApiGeneratorProviders operation middleware
+func (siw *ServerInterfaceWrapper) GetApiGeneratorProviders(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, ApiKeyAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetApiGeneratorProviders(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetApiGeneratorRetries operation middleware
+func (siw *ServerInterfaceWrapper) GetApiGeneratorRetries(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, ApiKeyAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetApiGeneratorRetries(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetApiJobsJobId operation middleware
+func (siw *ServerInterfaceWrapper) GetApiJobsJobId(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "job_id" -------------
+	var jobId string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "job_id", r.PathValue("job_id"), &jobId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true, Type: "string", Format: ""})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "job_id", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, ApiKeyAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetApiJobsJobId(w, r, jobId)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 // PostApiPhotos operation middleware
 func (siw *ServerInterfaceWrapper) PostApiPhotos(w http.ResponseWriter, r *http.Request) {
 
+	var err error
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, ApiKeyAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params PostApiPhotosParams
+
+	headers := r.Header
+
+	// ------------- Optional header parameter "Idempotency-Key" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("Idempotency-Key")]; found {
+		var IdempotencyKey string
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "Idempotency-Key", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithOptions("simple", "Idempotency-Key", valueList[0], &IdempotencyKey, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: false, Type: "string", Format: ""})
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "Idempotency-Key", Err: err})
+			return
+		}
+
+		params.IdempotencyKey = &IdempotencyKey
+
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PostApiPhotos(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// PostApiPlants operation middleware
+func (siw *ServerInterfaceWrapper) PostApiPlants(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, ApiKeyAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PostApiPlants(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetApiUsage operation middleware
+func (siw *ServerInterfaceWrapper) GetApiUsage(w http.ResponseWriter, r *http.Request) {
+
 	ctx := r.Context()
 
 	ctx = context.WithValue(ctx, ApiKeyAuthScopes, []string{})
@@ -77,7 +796,7 @@ func (siw *ServerInterfaceWrapper) PostApiPhotos(w http.ResponseWriter, r *http.
 	r = r.WithContext(ctx)
 
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.PostApiPhotos(w, r)
+		siw.Handler.GetApiUsage(w, r)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -227,7 +946,17 @@ func HandlerWithOptions(si ServerInterface, options StdHTTPServerOptions) http.H
 		ErrorHandlerFunc:   options.ErrorHandlerFunc,
 	}
 
+	m.HandleFunc("GET "+options.BaseURL+"/api/diaries", wrapper.GetApiDiaries)
+	m.HandleFunc("DELETE "+options.BaseURL+"/api/diaries/{id}", wrapper.DeleteApiDiariesId)
+	m.HandleFunc("GET "+options.BaseURL+"/api/diaries/{id}", wrapper.GetApiDiariesId)
+	m.HandleFunc("PATCH "+options.BaseURL+"/api/diaries/{id}", wrapper.PatchApiDiariesId)
+	m.HandleFunc("POST "+options.BaseURL+"/api/diaries/{id}/regenerate", wrapper.PostApiDiariesIdRegenerate)
+	m.HandleFunc("GET "+options.BaseURL+"/api/generator/providers", wrapper.GetApiGeneratorProviders)
+	m.HandleFunc("GET "+options.BaseURL+"/api/generator/retries", wrapper.GetApiGeneratorRetries)
+	m.HandleFunc("GET "+options.BaseURL+"/api/jobs/{job_id}", wrapper.GetApiJobsJobId)
 	m.HandleFunc("POST "+options.BaseURL+"/api/photos", wrapper.PostApiPhotos)
+	m.HandleFunc("POST "+options.BaseURL+"/api/plants", wrapper.PostApiPlants)
+	m.HandleFunc("GET "+options.BaseURL+"/api/usage", wrapper.GetApiUsage)
 	m.HandleFunc("POST "+options.BaseURL+"/api/users", wrapper.PostApiUsers)
 
 	return m
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// apiTokenPrefix はAPIトークンの先頭に付ける識別子（ログや設定ファイル中でトークンと判別しやすくする）
+const apiTokenPrefix = "pdt_"
+
+// apiTokenDisplayLength は一覧表示用に保存するトークン先頭部分の長さ
+const apiTokenDisplayLength = len(apiTokenPrefix) + 8
+
+// generateAPIToken はランダムな平文のAPIトークンを生成する
+func generateAPIToken() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate API token: %w", err)
+	}
+	return apiTokenPrefix + hex.EncodeToString(b), nil
+}
+
+// hashAPIToken は平文のAPIトークンを保存・照合用のハッシュ値に変換する。
+// トークンは十分な長さの乱数のため、パスワードと異なりソルトやストレッチングは不要
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAPITokenScope は文字列をAPIトークンのスコープに変換する。不明な値の場合はfalseを返す
+func parseAPITokenScope(s string) (APITokenScope, bool) {
+	switch scope := APITokenScope(s); scope {
+	case APITokenScopeUpload, APITokenScopeRead, APITokenScopeWrite, APITokenScopeAdmin:
+		return scope, true
+	default:
+		return "", false
+	}
+}
+
+// allowsAPITokenScope はトークンのスコープで、allowedのいずれかを要求する操作を行えるかどうかを返す。
+// adminスコープは全ての操作を行える
+func allowsAPITokenScope(scope APITokenScope, allowed ...APITokenScope) bool {
+	if scope == APITokenScopeAdmin {
+		return true
+	}
+	for _, a := range allowed {
+		if scope == a {
+			return true
+		}
+	}
+	return false
+}
+
+// apiTokenScopeLabel はAPIトークンのスコープを表示用の文言に変換する
+func apiTokenScopeLabel(scope APITokenScope) string {
+	switch scope {
+	case APITokenScopeUpload:
+		return "アップロード"
+	case APITokenScopeRead:
+		return "読み取り"
+	case APITokenScopeWrite:
+		return "書き込み"
+	case APITokenScopeAdmin:
+		return "管理"
+	default:
+		return string(scope)
+	}
+}
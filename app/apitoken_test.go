@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateAPIToken(t *testing.T) {
+	token, err := generateAPIToken()
+	if err != nil {
+		t.Fatalf("generateAPIToken failed: %v", err)
+	}
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		t.Errorf("expected prefix %q, got %q", apiTokenPrefix, token)
+	}
+	if len(token) != len(apiTokenPrefix)+40 {
+		t.Errorf("unexpected token length %d", len(token))
+	}
+
+	other, err := generateAPIToken()
+	if err != nil {
+		t.Fatalf("generateAPIToken failed: %v", err)
+	}
+	if token == other {
+		t.Error("expected different tokens")
+	}
+}
+
+func TestHashAPIToken(t *testing.T) {
+	hash := hashAPIToken("pdt_0123456789")
+	if hash != hashAPIToken("pdt_0123456789") {
+		t.Error("expected the same hash for the same token")
+	}
+	if hash == hashAPIToken("pdt_0123456788") {
+		t.Error("expected different hashes for different tokens")
+	}
+	if strings.Contains(hash, "0123456789") {
+		t.Errorf("hash should not contain the plain token: %s", hash)
+	}
+}
+
+func TestAllowsAPITokenScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		scope   APITokenScope
+		allowed []APITokenScope
+		want    bool
+	}{
+		{
+			name:    "一致するスコープ",
+			scope:   APITokenScopeUpload,
+			allowed: []APITokenScope{APITokenScopeUpload},
+			want:    true,
+		},
+		{
+			name:    "複数の候補のいずれかに一致",
+			scope:   APITokenScopeRead,
+			allowed: []APITokenScope{APITokenScopeUpload, APITokenScopeRead},
+			want:    true,
+		},
+		{
+			name:    "一致しないスコープ",
+			scope:   APITokenScopeRead,
+			allowed: []APITokenScope{APITokenScopeUpload},
+			want:    false,
+		},
+		{
+			name:    "adminは常に許可",
+			scope:   APITokenScopeAdmin,
+			allowed: []APITokenScope{APITokenScopeUpload},
+			want:    true,
+		},
+		{
+			name:    "admin専用の操作",
+			scope:   APITokenScopeUpload,
+			allowed: []APITokenScope{APITokenScopeAdmin},
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := allowsAPITokenScope(tt.scope, tt.allowed...); got != tt.want {
+				t.Errorf("allowsAPITokenScope(%q, %v) = %v, want %v", tt.scope, tt.allowed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAPITokenScope(t *testing.T) {
+	for _, s := range []string{"upload", "read", "write", "admin"} {
+		if scope, ok := parseAPITokenScope(s); !ok || string(scope) != s {
+			t.Errorf("parseAPITokenScope(%q) = %q, %v", s, scope, ok)
+		}
+	}
+	if _, ok := parseAPITokenScope("owner"); ok {
+		t.Error("expected unknown scope to be rejected")
+	}
+}
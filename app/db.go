@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
@@ -108,23 +109,34 @@ type SQLiteDiaryRepository struct {
 	db *sql.DB
 }
 
-// NewSQLiteDiaryRepository は新しいSQLiteDiaryRepositoryを生成する
-func NewSQLiteDiaryRepository(db *sql.DB) *SQLiteDiaryRepository {
-	return &SQLiteDiaryRepository{db: db}
+// diaryColumns はDiaryの取得時にSELECTするカラム（scanDiaryの引数順）
+const diaryColumns = "id, user_id, plant_id, image_path, content, created_at, deleted_at, provider"
+
+// rowScanner は *sql.Row と *sql.Rows に共通のScanメソッドを表す
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
-// GetAllDiaries は全ての日記を新着順（created_at DESC）で返す
-func (r *SQLiteDiaryRepository) GetAllDiaries() ([]Diary, error) {
-	rows, err := r.db.Query("SELECT id, image_path, content, created_at FROM diary ORDER BY created_at DESC")
-	if err != nil {
-		return nil, err
+// scanDiary は diaryColumns の順でSELECTした1行をDiaryに読み込む
+func scanDiary(row rowScanner) (Diary, error) {
+	var d Diary
+	var userID, plantID sql.NullInt64
+	var deletedAt sql.NullTime
+	if err := row.Scan(&d.ID, &userID, &plantID, &d.ImagePath, &d.Content, &d.CreatedAt, &deletedAt, &d.Provider); err != nil {
+		return Diary{}, err
 	}
-	defer rows.Close()
+	d.UserID = int(userID.Int64)
+	d.PlantID = int(plantID.Int64)
+	d.DeletedAt = deletedAt.Time
+	return d, nil
+}
 
+// scanDiaries は diaryColumns の順でSELECTした全行をDiaryのスライスに読み込む
+func scanDiaries(rows *sql.Rows) ([]Diary, error) {
 	var diaries []Diary
 	for rows.Next() {
-		var d Diary
-		if err := rows.Scan(&d.ID, &d.ImagePath, &d.Content, &d.CreatedAt); err != nil {
+		d, err := scanDiary(rows)
+		if err != nil {
 			return nil, err
 		}
 		diaries = append(diaries, d)
@@ -136,11 +148,60 @@ func (r *SQLiteDiaryRepository) GetAllDiaries() ([]Diary, error) {
 	return diaries, nil
 }
 
-// GetDiaryByID は指定IDの日記を返す。見つからない場合はnilを返す
-func (r *SQLiteDiaryRepository) GetDiaryByID(id int) (*Diary, error) {
-	var d Diary
-	err := r.db.QueryRow("SELECT id, image_path, content, created_at FROM diary WHERE id = ?", id).
-		Scan(&d.ID, &d.ImagePath, &d.Content, &d.CreatedAt)
+// scopeCondition はOwnerScopeをuser_idカラムに対するWHERE条件に変換する。絞り込みなしの場合は空文字を返す
+func scopeCondition(scope OwnerScope) (string, []interface{}) {
+	switch {
+	case scope.UserID != 0:
+		return "user_id = ?", []interface{}{scope.UserID}
+	case scope.PublicOnly:
+		return "user_id IN (SELECT id FROM users WHERE diaries_public = 1)", nil
+	default:
+		return "", nil
+	}
+}
+
+// buildWhere はOwnerScopeと追加の条件をANDで結合したWHERE句とその引数を返す。条件がない場合は空文字を返す
+func buildWhere(scope OwnerScope, conds []string, args []interface{}) (string, []interface{}) {
+	if cond, scopeArgs := scopeCondition(scope); cond != "" {
+		conds = append([]string{cond}, conds...)
+		args = append(scopeArgs, args...)
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// diaryWhere はゴミ箱の日記を除外する条件（OwnerScopeのPlantIDを指定した場合は植物の条件も）を加えて
+// buildWhere と同じWHERE句とその引数を返す
+func diaryWhere(scope OwnerScope, conds []string, args []interface{}) (string, []interface{}) {
+	if scope.PlantID != 0 {
+		conds = append([]string{"plant_id = ?"}, conds...)
+		args = append([]interface{}{scope.PlantID}, args...)
+	}
+	return buildWhere(scope, append([]string{"deleted_at IS NULL"}, conds...), args)
+}
+
+// NewSQLiteDiaryRepository は新しいSQLiteDiaryRepositoryを生成する
+func NewSQLiteDiaryRepository(db *sql.DB) *SQLiteDiaryRepository {
+	return &SQLiteDiaryRepository{db: db}
+}
+
+// GetAllDiaries は範囲内の全ての日記を新着順（created_at DESC）で返す
+func (r *SQLiteDiaryRepository) GetAllDiaries(ctx context.Context, scope OwnerScope) ([]Diary, error) {
+	where, args := diaryWhere(scope, nil, nil)
+	rows, err := r.db.QueryContext(ctx, "SELECT "+diaryColumns+" FROM diary"+where+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanDiaries(rows)
+}
+
+// GetDiaryByID は指定IDの日記を返す。見つからない場合やゴミ箱の日記の場合はnilを返す
+func (r *SQLiteDiaryRepository) GetDiaryByID(ctx context.Context, id int) (*Diary, error) {
+	d, err := scanDiary(r.db.QueryRowContext(ctx, "SELECT "+diaryColumns+" FROM diary WHERE id = ? AND deleted_at IS NULL", id))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -151,23 +212,206 @@ func (r *SQLiteDiaryRepository) GetDiaryByID(id int) (*Diary, error) {
 }
 
 // CreateDiary は新しい日記エントリを作成する
-func (r *SQLiteDiaryRepository) CreateDiary(imagePath, content string, createdAt time.Time) error {
-	_, err := r.db.Exec("INSERT INTO diary (image_path, content, created_at) VALUES (?, ?, ?)", imagePath, content, createdAt)
+func (r *SQLiteDiaryRepository) CreateDiary(ctx context.Context, imagePath, content string, createdAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO diary (image_path, content, created_at) VALUES (?, ?, ?)", imagePath, content, createdAt)
 	return err
 }
 
-// CreateDiaryForUser は指定ユーザーの新しい日記エントリを作成する
-func (r *SQLiteDiaryRepository) CreateDiaryForUser(userID int, imagePath, content string, createdAt time.Time) error {
-	_, err := r.db.Exec(
-		"INSERT INTO diary (image_path, content, created_at, user_id) VALUES (?, ?, ?, ?)",
-		imagePath, content, createdAt, userID,
-	)
+// CreateDiaryForUser は指定ユーザーの新しい日記エントリを作成する。plantIDが0の場合は植物未指定として記録する
+func (r *SQLiteDiaryRepository) CreateDiaryForUser(ctx context.Context, userID, plantID int, imagePath, content string, createdAt time.Time) error {
+	_, err := r.CreateGeneratedDiary(ctx, userID, plantID, imagePath, content, "", nil, createdAt)
 	return err
 }
 
+// CreateGeneratedDiary は本文を生成したプロバイダ名と写真から推定した植物の状態（nilの場合は記録しない）を記録して、
+// 指定ユーザーの新しい日記エントリを作成し、作成した日記のIDを返す
+func (r *SQLiteDiaryRepository) CreateGeneratedDiary(ctx context.Context, userID, plantID int, imagePath, content, provider string, observation *DiaryObservation, createdAt time.Time) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO diary (image_path, content, created_at, user_id, plant_id, provider) VALUES (?, ?, ?, ?, ?, ?)",
+		imagePath, content, createdAt, userID, nullableID(plantID), provider,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if observation != nil {
+		if err := insertObservation(ctx, tx, int(id), observation); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// UpdateDiaryGeneration は指定IDの日記の本文を生成したプロバイダ名と植物の状態を更新する。
+// observationがnilの場合は植物の状態を削除する。見つからない場合はエラーを返す
+func (r *SQLiteDiaryRepository) UpdateDiaryGeneration(ctx context.Context, id int, provider string, observation *DiaryObservation) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE diary SET provider = ? WHERE id = ?", provider, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("diary %d not found", id)
+	}
+
+	if err := deleteObservation(ctx, tx, id); err != nil {
+		return err
+	}
+	if observation != nil {
+		if err := insertObservation(ctx, tx, id, observation); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// insertObservation は指定IDの日記の植物の状態を、検出した問題・タグとあわせて追加する
+func insertObservation(ctx context.Context, tx *sql.Tx, diaryID int, o *DiaryObservation) error {
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO diary_observations (diary_id, health_score, leaf_count, flowering, fruiting) VALUES (?, ?, ?, ?, ?)",
+		diaryID, o.HealthScore, o.LeafCount, o.Flowering, o.Fruiting,
+	); err != nil {
+		return err
+	}
+	for _, issue := range o.Issues {
+		if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO diary_issues (diary_id, issue) VALUES (?, ?)", diaryID, issue); err != nil {
+			return err
+		}
+	}
+	for _, tag := range o.Tags {
+		if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO diary_tags (diary_id, tag) VALUES (?, ?)", diaryID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanObservationLabels は (diary_id, 値) の順でSELECTした全行を、addで日記IDに対応する植物の状態へ追加する
+func scanObservationLabels(rows *sql.Rows, observations map[int]DiaryObservation, add func(o *DiaryObservation, v string)) error {
+	defer rows.Close()
+	for rows.Next() {
+		var diaryID int
+		var v string
+		if err := rows.Scan(&diaryID, &v); err != nil {
+			return err
+		}
+		if o, ok := observations[diaryID]; ok {
+			add(&o, v)
+			observations[diaryID] = o
+		}
+	}
+	return rows.Err()
+}
+
+// deleteObservation は指定IDの日記の植物の状態を、検出した問題・タグとあわせて削除する
+func deleteObservation(ctx context.Context, tx *sql.Tx, diaryID int) error {
+	for _, table := range []string{"diary_tags", "diary_issues", "diary_observations"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE diary_id = ?", diaryID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDiaryObservations は指定IDの日記の植物の状態を日記IDをキーにして返す。植物の状態がない日記は含まない
+func (r *SQLiteDiaryRepository) GetDiaryObservations(ctx context.Context, diaryIDs []int) (map[int]DiaryObservation, error) {
+	result := make(map[int]DiaryObservation)
+	// SQLiteのバインド変数の上限を超えないよう分割して取得する
+	for start := 0; start < len(diaryIDs); start += observationQueryBatchSize {
+		end := min(start+observationQueryBatchSize, len(diaryIDs))
+		if err := r.loadDiaryObservations(ctx, diaryIDs[start:end], result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// observationQueryBatchSize は GetDiaryObservations で1回のクエリに指定する日記IDの最大数
+const observationQueryBatchSize = 500
+
+// loadDiaryObservations は指定IDの日記の植物の状態をresultに読み込む
+func (r *SQLiteDiaryRepository) loadDiaryObservations(ctx context.Context, diaryIDs []int, result map[int]DiaryObservation) error {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(diaryIDs)), ", ")
+	args := make([]interface{}, len(diaryIDs))
+	for i, id := range diaryIDs {
+		args[i] = id
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT diary_id, health_score, leaf_count, flowering, fruiting FROM diary_observations WHERE diary_id IN ("+placeholders+")",
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o DiaryObservation
+		if err := rows.Scan(&o.DiaryID, &o.HealthScore, &o.LeafCount, &o.Flowering, &o.Fruiting); err != nil {
+			return err
+		}
+		result[o.DiaryID] = o
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// 問題・タグは登録順（rowid順）で読み込む
+	issueRows, err := r.db.QueryContext(ctx, "SELECT diary_id, issue FROM diary_issues WHERE diary_id IN ("+placeholders+") ORDER BY rowid", args...)
+	if err != nil {
+		return err
+	}
+	if err := scanObservationLabels(issueRows, result, func(o *DiaryObservation, v string) { o.Issues = append(o.Issues, v) }); err != nil {
+		return err
+	}
+	tagRows, err := r.db.QueryContext(ctx, "SELECT diary_id, tag FROM diary_tags WHERE diary_id IN ("+placeholders+") ORDER BY rowid", args...)
+	if err != nil {
+		return err
+	}
+	if err := scanObservationLabels(tagRows, result, func(o *DiaryObservation, v string) { o.Tags = append(o.Tags, v) }); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetDiariesByPlantID は指定植物の日記を新着順（created_at DESC）で返す
+func (r *SQLiteDiaryRepository) GetDiariesByPlantID(ctx context.Context, plantID int) ([]Diary, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+diaryColumns+" FROM diary WHERE plant_id = ? AND deleted_at IS NULL ORDER BY created_at DESC", plantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanDiaries(rows)
+}
+
 // UpdateDiaryContent は指定IDの日記のcontentを更新し、updated_atも現在時刻に更新する
-func (r *SQLiteDiaryRepository) UpdateDiaryContent(id int, content string) error {
-	result, err := r.db.Exec(
+func (r *SQLiteDiaryRepository) UpdateDiaryContent(ctx context.Context, id int, content string) error {
+	result, err := r.db.ExecContext(ctx,
 		"UPDATE diary SET content = ?, updated_at = ? WHERE id = ?",
 		content, time.Now().UTC(), id,
 	)
@@ -184,10 +428,10 @@ func (r *SQLiteDiaryRepository) UpdateDiaryContent(id int, content string) error
 	return nil
 }
 
-// IsImageProcessed は指定画像パスが既に処理済みかどうかを返す
-func (r *SQLiteDiaryRepository) IsImageProcessed(imagePath string) (bool, error) {
+// IsImageProcessed は指定画像パスが既に処理済みかどうかを返す。ゴミ箱の日記の写真も処理済みとして扱う
+func (r *SQLiteDiaryRepository) IsImageProcessed(ctx context.Context, imagePath string) (bool, error) {
 	var exists bool
-	err := r.db.QueryRow("SELECT EXISTS(SELECT 1 FROM diary WHERE image_path = ? LIMIT 1)", imagePath).Scan(&exists)
+	err := r.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM diary WHERE image_path = ? LIMIT 1)", imagePath).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
@@ -195,9 +439,9 @@ func (r *SQLiteDiaryRepository) IsImageProcessed(imagePath string) (bool, error)
 }
 
 // GetLatestDiaryCreatedAt は最新の日記の作成日時を返す。日記が存在しない場合はゼロ値を返す
-func (r *SQLiteDiaryRepository) GetLatestDiaryCreatedAt() (time.Time, error) {
+func (r *SQLiteDiaryRepository) GetLatestDiaryCreatedAt(ctx context.Context) (time.Time, error) {
 	var createdAt time.Time
-	err := r.db.QueryRow("SELECT created_at FROM diary ORDER BY created_at DESC LIMIT 1").Scan(&createdAt)
+	err := r.db.QueryRowContext(ctx, "SELECT created_at FROM diary WHERE deleted_at IS NULL ORDER BY created_at DESC LIMIT 1").Scan(&createdAt)
 	if err == sql.ErrNoRows {
 		return time.Time{}, nil
 	}
@@ -207,15 +451,16 @@ func (r *SQLiteDiaryRepository) GetLatestDiaryCreatedAt() (time.Time, error) {
 	return createdAt, nil
 }
 
-// GetAvailableYearMonths は日記が存在する年月一覧をJST基準で新しい順に返す
-func (r *SQLiteDiaryRepository) GetAvailableYearMonths() ([]YearMonth, error) {
-	rows, err := r.db.Query(`
+// GetAvailableYearMonths は範囲内の日記が存在する年月一覧をJST基準で新しい順に返す
+func (r *SQLiteDiaryRepository) GetAvailableYearMonths(ctx context.Context, scope OwnerScope) ([]YearMonth, error) {
+	where, args := diaryWhere(scope, nil, nil)
+	rows, err := r.db.QueryContext(ctx, `
 		SELECT DISTINCT
 			CAST(strftime('%Y', datetime(created_at, '+9 hours')) AS INTEGER),
 			CAST(strftime('%m', datetime(created_at, '+9 hours')) AS INTEGER)
-		FROM diary
+		FROM diary`+where+`
 		ORDER BY 1 DESC, 2 DESC
-	`)
+	`, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -236,65 +481,213 @@ func (r *SQLiteDiaryRepository) GetAvailableYearMonths() ([]YearMonth, error) {
 	return result, nil
 }
 
-// SearchDiaries はキーワードを含む日記を新着順（created_at DESC）で返す
-func (r *SQLiteDiaryRepository) SearchDiaries(keyword string) ([]Diary, error) {
-	rows, err := r.db.Query(
-		"SELECT id, image_path, content, created_at FROM diary WHERE content LIKE ? ORDER BY created_at DESC",
-		"%"+keyword+"%",
-	)
+// SearchDiaries は範囲内でキーワードを含む日記を新着順（created_at DESC）で返す
+func (r *SQLiteDiaryRepository) SearchDiaries(ctx context.Context, scope OwnerScope, keyword string) ([]Diary, error) {
+	where, args := diaryWhere(scope, []string{"content LIKE ?"}, []interface{}{"%" + keyword + "%"})
+	rows, err := r.db.QueryContext(ctx, "SELECT "+diaryColumns+" FROM diary"+where+" ORDER BY created_at DESC", args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var diaries []Diary
-	for rows.Next() {
-		var d Diary
-		if err := rows.Scan(&d.ID, &d.ImagePath, &d.Content, &d.CreatedAt); err != nil {
-			return nil, err
-		}
-		diaries = append(diaries, d)
+	return scanDiaries(rows)
+}
+
+// GetDiariesAsc は範囲内の全日記または指定期間の日記を古い順（created_at ASC）で返す。from/toがゼロ値の場合はその条件を無視する
+func (r *SQLiteDiaryRepository) GetDiariesAsc(ctx context.Context, scope OwnerScope, from, to time.Time) ([]Diary, error) {
+	var conds []string
+	var args []interface{}
+	if !from.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, from)
 	}
-	if err := rows.Err(); err != nil {
+	if !to.IsZero() {
+		conds = append(conds, "created_at <= ?")
+		args = append(args, to)
+	}
+	where, args := diaryWhere(scope, conds, args)
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+diaryColumns+" FROM diary"+where+" ORDER BY created_at ASC", args...)
+	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return diaries, nil
+	return scanDiaries(rows)
 }
 
-// GetDiariesAsc は全日記または指定期間の日記を古い順（created_at ASC）で返す。from/toがゼロ値の場合はその条件を無視する
-func (r *SQLiteDiaryRepository) GetDiariesAsc(from, to time.Time) ([]Diary, error) {
-	var rows *sql.Rows
-	var err error
+// QueryDiaries は範囲内で条件に一致する日記を新着順（created_at DESC）で返す。2つ目の戻り値はページング前の件数
+func (r *SQLiteDiaryRepository) QueryDiaries(ctx context.Context, scope OwnerScope, q DiaryQuery) ([]Diary, int, error) {
+	var conds []string
+	var args []interface{}
+	if q.Keyword != "" {
+		conds = append(conds, "content LIKE ?")
+		args = append(args, "%"+q.Keyword+"%")
+	}
+	if !q.From.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, q.From)
+	}
+	if !q.To.IsZero() {
+		conds = append(conds, "created_at <= ?")
+		args = append(args, q.To)
+	}
+	where, args := diaryWhere(scope, conds, args)
 
-	switch {
-	case from.IsZero() && to.IsZero():
-		rows, err = r.db.Query("SELECT id, image_path, content, created_at FROM diary ORDER BY created_at ASC")
-	case from.IsZero():
-		rows, err = r.db.Query("SELECT id, image_path, content, created_at FROM diary WHERE created_at <= ? ORDER BY created_at ASC", to)
-	case to.IsZero():
-		rows, err = r.db.Query("SELECT id, image_path, content, created_at FROM diary WHERE created_at >= ? ORDER BY created_at ASC", from)
-	default:
-		rows, err = r.db.Query("SELECT id, image_path, content, created_at FROM diary WHERE created_at >= ? AND created_at <= ? ORDER BY created_at ASC", from, to)
+	var total int
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM diary"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	// SQLiteではOFFSETの指定にLIMITが必須のため、無制限は -1 で表す
+	limit := q.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT "+diaryColumns+" FROM diary"+where+" ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?",
+		append(args, limit, q.Offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	diaries, err := scanDiaries(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return diaries, total, nil
+}
+
+// DeleteDiary は指定IDの日記をゴミ箱へ移動する。見つからない場合や既にゴミ箱にある場合はエラーを返す
+func (r *SQLiteDiaryRepository) DeleteDiary(ctx context.Context, id int) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE diary SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("diary %d not found", id)
 	}
+	return nil
+}
+
+// GetDeletedDiaries は指定ユーザーのゴミ箱の日記を削除日時の新しい順（deleted_at DESC）で返す
+func (r *SQLiteDiaryRepository) GetDeletedDiaries(ctx context.Context, userID int) ([]Diary, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT "+diaryColumns+" FROM diary WHERE user_id = ? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC",
+		userID,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var diaries []Diary
-	for rows.Next() {
-		var d Diary
-		if err := rows.Scan(&d.ID, &d.ImagePath, &d.Content, &d.CreatedAt); err != nil {
+	return scanDiaries(rows)
+}
+
+// RestoreDiary は指定IDの日記をゴミ箱から元に戻す。ゴミ箱にない場合はエラーを返す
+func (r *SQLiteDiaryRepository) RestoreDiary(ctx context.Context, id int) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE diary SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("deleted diary %d not found", id)
+	}
+	return nil
+}
+
+// PurgeDiary はゴミ箱にある指定IDの日記を版履歴・写真の撮影情報とともに完全に削除し、日記の写真に重複として統合した写真のパスを返す
+// （統合した写真の撮影情報・知覚ハッシュもあわせて削除する。写真ファイルは呼び出し側で削除する）。
+// 植物の表紙に指定されていた場合は表紙の指定を解除する。ゴミ箱にない場合はエラーを返す
+func (r *SQLiteDiaryRepository) PurgeDiary(ctx context.Context, id int) ([]string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var userID, plantID sql.NullInt64
+	var imagePath string
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, "SELECT user_id, plant_id, image_path, created_at FROM diary WHERE id = ? AND deleted_at IS NOT NULL", id).Scan(&userID, &plantID, &imagePath, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("deleted diary %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE plants SET cover_diary_id = NULL WHERE cover_diary_id = ?", id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM diary_revisions WHERE diary_id = ?", id); err != nil {
+		return nil, err
+	}
+	if err := deleteObservation(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM diary WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	mergedPaths, err := mergedPhotoPaths(ctx, tx, imagePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range append([]string{imagePath}, mergedPaths...) {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM photo_metadata WHERE image_path = ?", path); err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM photo_hashes WHERE image_path = ?", path); err != nil {
 			return nil, err
 		}
-		diaries = append(diaries, d)
 	}
-	if err := rows.Err(); err != nil {
+	// 削除した日記の内容が要約に残らないよう、日記を含む週・月の植物の要約とユーザーの全ての日記の要約も削除する（次回の日記生成時に作り直す）
+	date := jstDate(createdAt)
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM diary_summaries WHERE user_id = ? AND plant_id IN (?, 0) AND ((period = ? AND start_date = ?) OR (period = ? AND start_date = ?))",
+		userID.Int64, plantID.Int64, SummaryPeriodWeek, weekOfMonthStart(date).Format("2006-01-02"), SummaryPeriodMonth, monthStart(date).Format("2006-01-02"),
+	); err != nil {
 		return nil, err
 	}
 
-	return diaries, nil
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return mergedPaths, nil
+}
+
+// mergedPhotoPaths は写真に重複として統合した写真のパスを返す
+func mergedPhotoPaths(ctx context.Context, tx *sql.Tx, imagePath string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT image_path FROM photo_hashes WHERE duplicate_of = ? ORDER BY image_path", imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// nullableID は0を未指定（NULL）として扱うIDをSQLの引数に変換する
+func nullableID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
 }
 
 // generateUUID はhyphenなし32文字のUUIDを生成する
@@ -306,6 +699,9 @@ func generateUUID() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
+// disabledPasswordHash はログインできないユーザー（既存データ移行用のsystemユーザー）のパスワードハッシュ
+const disabledPasswordHash = "DISABLED"
+
 // SQLiteUserRepository はSQLiteを使用したUserRepositoryの実装
 type SQLiteUserRepository struct {
 	db *sql.DB
@@ -317,8 +713,8 @@ func NewSQLiteUserRepository(db *sql.DB) *SQLiteUserRepository {
 }
 
 // CreateUser は新しいユーザーを作成する
-func (r *SQLiteUserRepository) CreateUser(uuid, username, passwordHash string) error {
-	_, err := r.db.Exec(
+func (r *SQLiteUserRepository) CreateUser(ctx context.Context, uuid, username, passwordHash string) error {
+	_, err := r.db.ExecContext(ctx,
 		"INSERT INTO users (uuid, username, password_hash) VALUES (?, ?, ?)",
 		uuid, username, passwordHash,
 	)
@@ -326,12 +722,12 @@ func (r *SQLiteUserRepository) CreateUser(uuid, username, passwordHash string) e
 }
 
 // GetUserByUsername はusernameからユーザーを取得する。見つからない場合はnilを返す
-func (r *SQLiteUserRepository) GetUserByUsername(username string) (*User, error) {
+func (r *SQLiteUserRepository) GetUserByUsername(ctx context.Context, username string) (*User, error) {
 	var u User
-	err := r.db.QueryRow(
-		"SELECT id, uuid, username, password_hash, created_at FROM users WHERE username = ?",
+	err := r.db.QueryRowContext(ctx,
+		"SELECT id, uuid, username, password_hash, diaries_public, created_at FROM users WHERE username = ?",
 		username,
-	).Scan(&u.ID, &u.UUID, &u.Username, &u.PasswordHash, &u.CreatedAt)
+	).Scan(&u.ID, &u.UUID, &u.Username, &u.PasswordHash, &u.DiariesPublic, &u.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -342,12 +738,12 @@ func (r *SQLiteUserRepository) GetUserByUsername(username string) (*User, error)
 }
 
 // GetUserByID はIDからユーザーを取得する。見つからない場合はnilを返す
-func (r *SQLiteUserRepository) GetUserByID(id int) (*User, error) {
+func (r *SQLiteUserRepository) GetUserByID(ctx context.Context, id int) (*User, error) {
 	var u User
-	err := r.db.QueryRow(
-		"SELECT id, uuid, username, password_hash, created_at FROM users WHERE id = ?",
+	err := r.db.QueryRowContext(ctx,
+		"SELECT id, uuid, username, password_hash, diaries_public, created_at FROM users WHERE id = ?",
 		id,
-	).Scan(&u.ID, &u.UUID, &u.Username, &u.PasswordHash, &u.CreatedAt)
+	).Scan(&u.ID, &u.UUID, &u.Username, &u.PasswordHash, &u.DiariesPublic, &u.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -357,13 +753,32 @@ func (r *SQLiteUserRepository) GetUserByID(id int) (*User, error) {
 	return &u, nil
 }
 
+// GetAllUsers は全てのユーザーをIDの昇順で返す
+func (r *SQLiteUserRepository) GetAllUsers(ctx context.Context) ([]User, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, uuid, username, password_hash, diaries_public, created_at FROM users ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.UUID, &u.Username, &u.PasswordHash, &u.DiariesPublic, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
 // GetUserByUUID はUUIDからユーザーを取得する。見つからない場合はnilを返す
-func (r *SQLiteUserRepository) GetUserByUUID(uuid string) (*User, error) {
+func (r *SQLiteUserRepository) GetUserByUUID(ctx context.Context, uuid string) (*User, error) {
 	var u User
-	err := r.db.QueryRow(
-		"SELECT id, uuid, username, password_hash, created_at FROM users WHERE uuid = ?",
+	err := r.db.QueryRowContext(ctx,
+		"SELECT id, uuid, username, password_hash, diaries_public, created_at FROM users WHERE uuid = ?",
 		uuid,
-	).Scan(&u.ID, &u.UUID, &u.Username, &u.PasswordHash, &u.CreatedAt)
+	).Scan(&u.ID, &u.UUID, &u.Username, &u.PasswordHash, &u.DiariesPublic, &u.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -373,22 +788,48 @@ func (r *SQLiteUserRepository) GetUserByUUID(uuid string) (*User, error) {
 	return &u, nil
 }
 
-// SQLiteSessionRepository はSQLiteを使用したSessionRepositoryの実装
-type SQLiteSessionRepository struct {
-	db *sql.DB
-}
-
-// NewSQLiteSessionRepository は新しいSQLiteSessionRepositoryを生成する
-func NewSQLiteSessionRepository(db *sql.DB) *SQLiteSessionRepository {
-	return &SQLiteSessionRepository{db: db}
+// SetDiariesPublic は指定ユーザーの日記を公開するかどうかを更新する
+func (r *SQLiteUserRepository) SetDiariesPublic(ctx context.Context, id int, public bool) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE users SET diaries_public = ? WHERE id = ?", public, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("user %d not found", id)
+	}
+	return nil
+}
+
+// HasLoginUser はログイン可能なユーザー（systemユーザー以外）が存在するかどうかを返す
+func (r *SQLiteUserRepository) HasLoginUser(ctx context.Context) (bool, error) {
+	var exists bool
+	err := r.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE password_hash != ? LIMIT 1)", disabledPasswordHash).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// SQLiteSessionRepository はSQLiteを使用したSessionRepositoryの実装
+type SQLiteSessionRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteSessionRepository は新しいSQLiteSessionRepositoryを生成する
+func NewSQLiteSessionRepository(db *sql.DB) *SQLiteSessionRepository {
+	return &SQLiteSessionRepository{db: db}
 }
 
 // CreateSession は新しいセッションを作成する（期限切れセッションも同時に削除）
-func (r *SQLiteSessionRepository) CreateSession(id string, userID int, expiresAt time.Time) error {
-	if _, err := r.db.Exec("DELETE FROM sessions WHERE expires_at < ?", time.Now()); err != nil {
+func (r *SQLiteSessionRepository) CreateSession(ctx context.Context, id string, userID int, expiresAt time.Time) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at < ?", time.Now()); err != nil {
 		log.Printf("WARN: failed to delete expired sessions: %v", err)
 	}
-	_, err := r.db.Exec(
+	_, err := r.db.ExecContext(ctx,
 		"INSERT INTO sessions (id, user_id, expires_at) VALUES (?, ?, ?)",
 		id, userID, expiresAt,
 	)
@@ -396,9 +837,9 @@ func (r *SQLiteSessionRepository) CreateSession(id string, userID int, expiresAt
 }
 
 // GetSessionByID はセッションIDからセッションを取得する。見つからない場合や期限切れの場合はnilを返す
-func (r *SQLiteSessionRepository) GetSessionByID(id string) (*Session, error) {
+func (r *SQLiteSessionRepository) GetSessionByID(ctx context.Context, id string) (*Session, error) {
 	var s Session
-	err := r.db.QueryRow(
+	err := r.db.QueryRowContext(ctx,
 		"SELECT id, user_id, created_at, expires_at FROM sessions WHERE id = ? AND expires_at > ?",
 		id, time.Now(),
 	).Scan(&s.ID, &s.UserID, &s.CreatedAt, &s.ExpiresAt)
@@ -412,30 +853,974 @@ func (r *SQLiteSessionRepository) GetSessionByID(id string) (*Session, error) {
 }
 
 // DeleteSession はセッションを削除する
-func (r *SQLiteSessionRepository) DeleteSession(id string) error {
-	_, err := r.db.Exec("DELETE FROM sessions WHERE id = ?", id)
+func (r *SQLiteSessionRepository) DeleteSession(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM sessions WHERE id = ?", id)
 	return err
 }
 
-// GetDiariesInDateRange は指定日付範囲内の日記を古い順（created_at ASC）で返す
-func (r *SQLiteDiaryRepository) GetDiariesInDateRange(startDate, endDate time.Time) ([]Diary, error) {
-	rows, err := r.db.Query("SELECT id, image_path, content, created_at FROM diary WHERE created_at >= ? AND created_at <= ? ORDER BY created_at ASC", startDate, endDate)
+// GetDiariesInDateRange は範囲内で指定日付範囲内の日記を古い順（created_at ASC）で返す
+func (r *SQLiteDiaryRepository) GetDiariesInDateRange(ctx context.Context, scope OwnerScope, startDate, endDate time.Time) ([]Diary, error) {
+	where, args := diaryWhere(scope, []string{"created_at >= ?", "created_at <= ?"}, []interface{}{startDate, endDate})
+	rows, err := r.db.QueryContext(ctx, "SELECT "+diaryColumns+" FROM diary"+where+" ORDER BY created_at ASC", args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var diaries []Diary
+	return scanDiaries(rows)
+}
+
+// SQLiteJobRepository はSQLiteを使用したJobRepositoryの実装
+type SQLiteJobRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteJobRepository は新しいSQLiteJobRepositoryを生成する
+func NewSQLiteJobRepository(db *sql.DB) *SQLiteJobRepository {
+	return &SQLiteJobRepository{db: db}
+}
+
+// CreateJob はqueued状態の新しいジョブを作成する。plantIDが0の場合は植物未指定として記録する
+func (r *SQLiteJobRepository) CreateJob(ctx context.Context, id string, userID, plantID int, imagePath string, capturedAt time.Time) error {
+	now := time.Now().UTC()
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO jobs (id, user_id, plant_id, image_path, captured_at, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		id, userID, nullableID(plantID), imagePath, capturedAt, JobStatusQueued, now, now,
+	)
+	return err
+}
+
+// jobColumns はジョブの取得で選択するカラム（scanJob の読み込み順）
+const jobColumns = "id, user_id, plant_id, image_path, captured_at, status, attempts, last_error, created_at, updated_at"
+
+// scanJob は jobColumns の順に選択した1行をJobに読み込む
+func scanJob(row rowScanner) (Job, error) {
+	var j Job
+	var plantID sql.NullInt64
+	var lastError sql.NullString
+	if err := row.Scan(&j.ID, &j.UserID, &plantID, &j.ImagePath, &j.CapturedAt, &j.Status, &j.Attempts, &lastError, &j.CreatedAt, &j.UpdatedAt); err != nil {
+		return Job{}, err
+	}
+	j.PlantID = int(plantID.Int64)
+	j.LastError = lastError.String
+	return j, nil
+}
+
+// GetJobByID は指定IDのジョブを返す。見つからない場合はnilを返す
+func (r *SQLiteJobRepository) GetJobByID(ctx context.Context, id string) (*Job, error) {
+	j, err := scanJob(r.db.QueryRowContext(ctx, "SELECT "+jobColumns+" FROM jobs WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// GetQueuedJobs はqueued状態のジョブを古い順（created_at ASC）で返す
+func (r *SQLiteJobRepository) GetQueuedJobs(ctx context.Context) ([]Job, error) {
+	return r.getJobsByStatus(ctx, JobStatusQueued)
+}
+
+// GetPausedJobs はpaused状態のジョブを古い順（created_at ASC）で返す
+func (r *SQLiteJobRepository) GetPausedJobs(ctx context.Context) ([]Job, error) {
+	return r.getJobsByStatus(ctx, JobStatusPaused)
+}
+
+// getJobsByStatus は指定した状態のジョブを古い順（created_at ASC）で返す
+func (r *SQLiteJobRepository) getJobsByStatus(ctx context.Context, status JobStatus) ([]Job, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+jobColumns+" FROM jobs WHERE status = ? ORDER BY created_at ASC", status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
 	for rows.Next() {
-		var d Diary
-		if err := rows.Scan(&d.ID, &d.ImagePath, &d.Content, &d.CreatedAt); err != nil {
+		j, err := scanJob(rows)
+		if err != nil {
 			return nil, err
 		}
-		diaries = append(diaries, d)
+		jobs = append(jobs, j)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return diaries, nil
+	return jobs, nil
+}
+
+// HasJobForImage は指定画像パスのジョブが状態を問わず存在するかどうかを返す
+func (r *SQLiteJobRepository) HasJobForImage(ctx context.Context, imagePath string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM jobs WHERE image_path = ? LIMIT 1)", imagePath).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// MarkJobRunning は指定IDのqueued状態のジョブをrunning状態に更新する。
+// queued状態でない場合は他のWorkerとの二重処理を避けるためエラーを返す
+func (r *SQLiteJobRepository) MarkJobRunning(ctx context.Context, id string) error {
+	return r.updateJobStatus(ctx,
+		id,
+		"UPDATE jobs SET status = ?, updated_at = ? WHERE id = ? AND status = ?",
+		JobStatusRunning, time.Now().UTC(), id, JobStatusQueued,
+	)
+}
+
+// MarkJobSucceeded は指定IDのジョブをsucceeded状態に更新し、試行回数を加算する
+func (r *SQLiteJobRepository) MarkJobSucceeded(ctx context.Context, id string, attempts int) error {
+	return r.updateJobStatus(ctx,
+		id,
+		"UPDATE jobs SET status = ?, attempts = attempts + ?, last_error = NULL, updated_at = ? WHERE id = ?",
+		JobStatusSucceeded, attempts, time.Now().UTC(), id,
+	)
+}
+
+// MarkJobFailed は指定IDのジョブをfailed状態に更新し、試行回数の加算と最終エラーの記録を行う
+func (r *SQLiteJobRepository) MarkJobFailed(ctx context.Context, id string, attempts int, lastError string) error {
+	return r.updateJobStatus(ctx,
+		id,
+		"UPDATE jobs SET status = ?, attempts = attempts + ?, last_error = ?, updated_at = ? WHERE id = ?",
+		JobStatusFailed, attempts, lastError, time.Now().UTC(), id,
+	)
+}
+
+// MarkJobPaused は指定IDのrunning状態のジョブをpaused状態に更新し、一時停止の理由を記録する
+func (r *SQLiteJobRepository) MarkJobPaused(ctx context.Context, id string, lastError string) error {
+	return r.updateJobStatus(ctx,
+		id,
+		"UPDATE jobs SET status = ?, last_error = ?, updated_at = ? WHERE id = ? AND status = ?",
+		JobStatusPaused, lastError, time.Now().UTC(), id, JobStatusRunning,
+	)
+}
+
+// ResumePausedJob は指定IDのpaused状態のジョブをqueued状態に戻す。
+// paused状態でない場合は二重に再開しないようエラーを返す
+func (r *SQLiteJobRepository) ResumePausedJob(ctx context.Context, id string) error {
+	return r.updateJobStatus(ctx,
+		id,
+		"UPDATE jobs SET status = ?, last_error = NULL, updated_at = ? WHERE id = ? AND status = ?",
+		JobStatusQueued, time.Now().UTC(), id, JobStatusPaused,
+	)
+}
+
+// RequeueRunningJobs はrunning状態のまま残っているジョブ（プロセス停止で中断されたもの）をqueued状態に戻し、件数を返す
+func (r *SQLiteJobRepository) RequeueRunningJobs(ctx context.Context) (int, error) {
+	result, err := r.db.ExecContext(ctx,
+		"UPDATE jobs SET status = ?, updated_at = ? WHERE status = ?",
+		JobStatusQueued, time.Now().UTC(), JobStatusRunning,
+	)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rows), nil
+}
+
+// DeleteJob は指定IDのジョブを削除する
+func (r *SQLiteJobRepository) DeleteJob(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM jobs WHERE id = ?", id)
+	return err
+}
+
+// updateJobStatus はジョブ更新クエリを実行し、対象が存在しない場合はエラーを返す
+func (r *SQLiteJobRepository) updateJobStatus(ctx context.Context, id string, query string, args ...interface{}) error {
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("job %s not found or not in expected state", id)
+	}
+	return nil
+}
+
+// SQLiteDiaryRevisionRepository はSQLiteを使用したDiaryRevisionRepositoryの実装
+type SQLiteDiaryRevisionRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteDiaryRevisionRepository は新しいSQLiteDiaryRevisionRepositoryを生成する
+func NewSQLiteDiaryRevisionRepository(db *sql.DB) *SQLiteDiaryRevisionRepository {
+	return &SQLiteDiaryRevisionRepository{db: db}
+}
+
+// ReplaceDiaryContent は日記の本文を新しい版に置き換え、置き換え前の版のIDを返す。
+// 版履歴がまだない日記は、置き換え前の本文を最初の版として保存してから新しい版を記録する。
+// userIDが0の場合は変更者なしとして記録する
+func (r *SQLiteDiaryRevisionRepository) ReplaceDiaryContent(ctx context.Context, diaryID int, content string, source RevisionSource, userID int) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var previousID int
+	err = tx.QueryRowContext(ctx, "SELECT id FROM diary_revisions WHERE diary_id = ? ORDER BY id DESC LIMIT 1", diaryID).Scan(&previousID)
+	if err == sql.ErrNoRows {
+		previousID, err = snapshotDiaryContent(ctx, tx, diaryID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO diary_revisions (diary_id, content, source, user_id, created_at) VALUES (?, ?, ?, ?, ?)",
+		diaryID, content, source, nullableID(userID), now,
+	); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE diary SET content = ?, updated_at = ? WHERE id = ?", content, now, diaryID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return previousID, nil
+}
+
+// snapshotDiaryContent は日記の現在の本文を最初の版として保存し、その版のIDを返す。
+// 一度も編集されていない本文は日記作成時のAI生成、編集済みの本文は履歴記録開始前の本文として扱う
+func snapshotDiaryContent(ctx context.Context, tx *sql.Tx, diaryID int) (int, error) {
+	var content string
+	var createdAt time.Time
+	var updatedAt sql.NullTime
+	err := tx.QueryRowContext(ctx, "SELECT content, created_at, updated_at FROM diary WHERE id = ?", diaryID).
+		Scan(&content, &createdAt, &updatedAt)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("diary %d not found", diaryID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	source := RevisionSourceGeneration
+	snapshotAt := createdAt
+	if updatedAt.Valid {
+		source = RevisionSourceOriginal
+		snapshotAt = updatedAt.Time
+	}
+
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO diary_revisions (diary_id, content, source, created_at) VALUES (?, ?, ?, ?)",
+		diaryID, content, source, snapshotAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// GetRevisionByID は指定IDの版を返す。見つからない場合はnilを返す
+func (r *SQLiteDiaryRevisionRepository) GetRevisionByID(ctx context.Context, id int) (*DiaryRevision, error) {
+	var rev DiaryRevision
+	var userID sql.NullInt64
+	err := r.db.QueryRowContext(ctx,
+		"SELECT id, diary_id, content, source, user_id, created_at FROM diary_revisions WHERE id = ?",
+		id,
+	).Scan(&rev.ID, &rev.DiaryID, &rev.Content, &rev.Source, &userID, &rev.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rev.UserID = int(userID.Int64)
+	return &rev, nil
+}
+
+// GetRevisionsByDiaryID は指定日記の版を新しい順（id DESC）で返す
+func (r *SQLiteDiaryRevisionRepository) GetRevisionsByDiaryID(ctx context.Context, diaryID int) ([]DiaryRevision, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, diary_id, content, source, user_id, created_at FROM diary_revisions WHERE diary_id = ? ORDER BY id DESC",
+		diaryID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []DiaryRevision
+	for rows.Next() {
+		var rev DiaryRevision
+		var userID sql.NullInt64
+		if err := rows.Scan(&rev.ID, &rev.DiaryID, &rev.Content, &rev.Source, &userID, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		rev.UserID = int(userID.Int64)
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// SQLitePlantRepository はSQLiteを使用したPlantRepositoryの実装
+type SQLitePlantRepository struct {
+	db *sql.DB
+}
+
+// NewSQLitePlantRepository は新しいSQLitePlantRepositoryを生成する
+func NewSQLitePlantRepository(db *sql.DB) *SQLitePlantRepository {
+	return &SQLitePlantRepository{db: db}
+}
+
+// plantSelect はPlantの取得に使うSELECT句。表紙は指定した日記の写真、未指定の場合は最新の日記の写真とする。
+// 表紙に指定した日記がゴミ箱にある場合は未指定と同じ扱いにする
+const plantSelect = `
+	SELECT p.id, p.uuid, p.user_id, p.name, p.species, p.location, p.acquired_on, p.cover_diary_id,
+		COALESCE(
+			(SELECT image_path FROM diary WHERE id = p.cover_diary_id AND deleted_at IS NULL),
+			(SELECT image_path FROM diary WHERE plant_id = p.id AND deleted_at IS NULL ORDER BY created_at DESC LIMIT 1),
+			''
+		),
+		p.created_at
+	FROM plants p`
+
+// scanPlant は plantSelect でSELECTした1行をPlantに読み込む
+func scanPlant(row rowScanner) (Plant, error) {
+	var p Plant
+	var acquiredOn sql.NullTime
+	var coverDiaryID sql.NullInt64
+	if err := row.Scan(&p.ID, &p.UUID, &p.UserID, &p.Name, &p.Species, &p.Location, &acquiredOn, &coverDiaryID, &p.CoverImagePath, &p.CreatedAt); err != nil {
+		return Plant{}, err
+	}
+	p.AcquiredOn = acquiredOn.Time
+	p.CoverDiaryID = int(coverDiaryID.Int64)
+	return p, nil
+}
+
+// CreatePlant は新しい植物を作成する。acquiredOnがゼロ値の場合は入手日不明として記録する
+func (r *SQLitePlantRepository) CreatePlant(ctx context.Context, uuid string, userID int, name, species, location string, acquiredOn time.Time) error {
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO plants (uuid, user_id, name, species, location, acquired_on, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		uuid, userID, name, species, location, sql.NullTime{Time: acquiredOn, Valid: !acquiredOn.IsZero()}, time.Now().UTC(),
+	)
+	return err
+}
+
+// GetPlantByID は指定IDの植物を返す。見つからない場合はnilを返す
+func (r *SQLitePlantRepository) GetPlantByID(ctx context.Context, id int) (*Plant, error) {
+	p, err := scanPlant(r.db.QueryRowContext(ctx, plantSelect+" WHERE p.id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetPlantByUUID は指定UUIDの植物を返す。見つからない場合はnilを返す
+func (r *SQLitePlantRepository) GetPlantByUUID(ctx context.Context, uuid string) (*Plant, error) {
+	p, err := scanPlant(r.db.QueryRowContext(ctx, plantSelect+" WHERE p.uuid = ?", uuid))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetAllPlants は範囲内の全ての植物を登録順（id ASC）で返す
+func (r *SQLitePlantRepository) GetAllPlants(ctx context.Context, scope OwnerScope) ([]Plant, error) {
+	where, args := buildWhere(scope, nil, nil)
+	rows, err := r.db.QueryContext(ctx, plantSelect+where+" ORDER BY p.id ASC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plants []Plant
+	for rows.Next() {
+		p, err := scanPlant(rows)
+		if err != nil {
+			return nil, err
+		}
+		plants = append(plants, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return plants, nil
+}
+
+// SetPlantCover は植物の表紙を指定した日記の写真に変更する。日記がその植物のものでない場合はエラーを返す
+func (r *SQLitePlantRepository) SetPlantCover(ctx context.Context, id, diaryID int) error {
+	result, err := r.db.ExecContext(ctx,
+		"UPDATE plants SET cover_diary_id = ? WHERE id = ? AND EXISTS(SELECT 1 FROM diary WHERE id = ? AND plant_id = ? AND deleted_at IS NULL)",
+		diaryID, id, diaryID, id,
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("plant %d not found or diary %d does not belong to it", id, diaryID)
+	}
+	return nil
+}
+
+// SQLiteAPITokenRepository はSQLiteを使用したAPITokenRepositoryの実装
+type SQLiteAPITokenRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteAPITokenRepository は新しいSQLiteAPITokenRepositoryを生成する
+func NewSQLiteAPITokenRepository(db *sql.DB) *SQLiteAPITokenRepository {
+	return &SQLiteAPITokenRepository{db: db}
+}
+
+// apiTokenColumns はapi_tokensテーブルからAPITokenを読み出す際のカラム
+const apiTokenColumns = "id, user_id, name, token_hash, prefix, scope, last_used_at, created_at"
+
+// scanAPIToken は apiTokenColumns の順に並んだ行をAPITokenとして読み出す
+func scanAPIToken(row rowScanner) (APIToken, error) {
+	var t APIToken
+	var lastUsedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &t.Prefix, &t.Scope, &lastUsedAt, &t.CreatedAt); err != nil {
+		return APIToken{}, err
+	}
+	t.LastUsedAt = lastUsedAt.Time
+	return t, nil
+}
+
+// CreateAPIToken は新しいAPIトークンを作成する
+func (r *SQLiteAPITokenRepository) CreateAPIToken(ctx context.Context, userID int, name, tokenHash, prefix string, scope APITokenScope) error {
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO api_tokens (user_id, name, token_hash, prefix, scope) VALUES (?, ?, ?, ?, ?)",
+		userID, name, tokenHash, prefix, scope,
+	)
+	return err
+}
+
+// GetAPITokenByHash はトークンのハッシュ値から有効なAPIトークンを取得する。見つからない場合や失効済みの場合はnilを返す
+func (r *SQLiteAPITokenRepository) GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error) {
+	t, err := scanAPIToken(r.db.QueryRowContext(ctx,
+		"SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ? AND revoked_at IS NULL",
+		tokenHash,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetAPITokensByUserID は指定ユーザーの有効なAPIトークンを作成順（id ASC）で返す
+func (r *SQLiteAPITokenRepository) GetAPITokensByUserID(ctx context.Context, userID int) ([]APIToken, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = ? AND revoked_at IS NULL ORDER BY id ASC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// RevokeAPIToken は指定ユーザーのAPIトークンを失効させる。対象が存在しない場合や失効済みの場合はエラーを返す
+func (r *SQLiteAPITokenRepository) RevokeAPIToken(ctx context.Context, id, userID int) error {
+	result, err := r.db.ExecContext(ctx,
+		"UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), id, userID,
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("api token %d of user %d not found", id, userID)
+	}
+	return nil
+}
+
+// TouchAPIToken はAPIトークンの最終使用日時を更新する
+func (r *SQLiteAPITokenRepository) TouchAPIToken(ctx context.Context, id int, usedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE api_tokens SET last_used_at = ? WHERE id = ?", usedAt.UTC(), id)
+	return err
+}
+
+// SQLitePromptTemplateRepository はSQLiteを使用したPromptTemplateRepositoryの実装
+type SQLitePromptTemplateRepository struct {
+	db *sql.DB
+}
+
+// NewSQLitePromptTemplateRepository は新しいSQLitePromptTemplateRepositoryを生成する
+func NewSQLitePromptTemplateRepository(db *sql.DB) *SQLitePromptTemplateRepository {
+	return &SQLitePromptTemplateRepository{db: db}
+}
+
+// promptTemplateColumns はprompt_templatesテーブルからPromptTemplateを読み出す際のカラム
+const promptTemplateColumns = "user_id, plant_id, persona, template, updated_at"
+
+// scanPromptTemplate は promptTemplateColumns の順に並んだ行をPromptTemplateとして読み出す
+func scanPromptTemplate(row rowScanner) (PromptTemplate, error) {
+	var t PromptTemplate
+	if err := row.Scan(&t.UserID, &t.PlantID, &t.Persona, &t.Template, &t.UpdatedAt); err != nil {
+		return PromptTemplate{}, err
+	}
+	return t, nil
+}
+
+// GetPromptTemplate はユーザー全体（plantIDが0）または植物のプロンプトの設定を取得する。設定がない場合はnilを返す
+func (r *SQLitePromptTemplateRepository) GetPromptTemplate(ctx context.Context, userID, plantID int) (*PromptTemplate, error) {
+	t, err := scanPromptTemplate(r.db.QueryRowContext(ctx,
+		"SELECT "+promptTemplateColumns+" FROM prompt_templates WHERE user_id = ? AND plant_id = ?",
+		userID, plantID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetPromptTemplatesByUserID はユーザーのプロンプトの設定を、ユーザー全体の設定、植物の登録順に返す
+func (r *SQLitePromptTemplateRepository) GetPromptTemplatesByUserID(ctx context.Context, userID int) ([]PromptTemplate, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+promptTemplateColumns+" FROM prompt_templates WHERE user_id = ? ORDER BY plant_id ASC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []PromptTemplate
+	for rows.Next() {
+		t, err := scanPromptTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+// SavePromptTemplate はユーザー全体（plantIDが0）または植物のプロンプトの設定を作成・更新する
+func (r *SQLitePromptTemplateRepository) SavePromptTemplate(ctx context.Context, userID, plantID int, persona, template string) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO prompt_templates (user_id, plant_id, persona, template, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, plant_id) DO UPDATE SET persona = excluded.persona, template = excluded.template, updated_at = excluded.updated_at`,
+		userID, plantID, persona, template, time.Now().UTC(),
+	)
+	return err
+}
+
+// DeletePromptTemplate はプロンプトの設定を削除する。設定がない場合は何もしない
+func (r *SQLitePromptTemplateRepository) DeletePromptTemplate(ctx context.Context, userID, plantID int) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM prompt_templates WHERE user_id = ? AND plant_id = ?", userID, plantID)
+	return err
+}
+
+// SQLiteDiarySummaryRepository はSQLiteを使用したDiarySummaryRepositoryの実装
+type SQLiteDiarySummaryRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteDiarySummaryRepository は新しいSQLiteDiarySummaryRepositoryを生成する
+func NewSQLiteDiarySummaryRepository(db *sql.DB) *SQLiteDiarySummaryRepository {
+	return &SQLiteDiarySummaryRepository{db: db}
+}
+
+// GetDiarySummaries は期間の初日がsince（JST、YYYY-MM-DD）以降のユーザー・植物（0の場合はユーザーの全ての日記）の要約を、
+// 期間の初日の古い順に返す
+func (r *SQLiteDiarySummaryRepository) GetDiarySummaries(ctx context.Context, userID, plantID int, since string) ([]DiarySummary, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT user_id, plant_id, period, start_date, source_hash, content, created_at FROM diary_summaries WHERE user_id = ? AND plant_id = ? AND start_date >= ? ORDER BY start_date ASC, period ASC",
+		userID, plantID, since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []DiarySummary
+	for rows.Next() {
+		var s DiarySummary
+		if err := rows.Scan(&s.UserID, &s.PlantID, &s.Period, &s.StartDate, &s.SourceHash, &s.Content, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// SaveDiarySummary はユーザー・植物（0の場合はユーザーの全ての日記）の週（period が SummaryPeriodWeek）または月の要約を作成・更新する
+func (r *SQLiteDiarySummaryRepository) SaveDiarySummary(ctx context.Context, userID, plantID int, period, startDate, sourceHash, content string) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO diary_summaries (user_id, plant_id, period, start_date, source_hash, content, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, plant_id, period, start_date) DO UPDATE SET source_hash = excluded.source_hash, content = excluded.content, created_at = excluded.created_at`,
+		userID, plantID, period, startDate, sourceHash, content, time.Now().UTC(),
+	)
+	return err
+}
+
+// SQLiteUsageRepository はSQLiteを使用したUsageRepositoryの実装
+type SQLiteUsageRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteUsageRepository は新しいSQLiteUsageRepositoryを生成する
+func NewSQLiteUsageRepository(db *sql.DB) *SQLiteUsageRepository {
+	return &SQLiteUsageRepository{db: db}
+}
+
+// CreateUsageRecord は日記の生成の使用量を記録する。CreatedAtがゼロ値の場合は現在時刻を記録する
+func (r *SQLiteUsageRepository) CreateUsageRecord(ctx context.Context, record UsageRecord) error {
+	createdAt := record.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO generation_usage (user_id, diary_id, provider, model, prompt_tokens, response_tokens, cost, latency_ms, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		record.UserID, nullableID(record.DiaryID), record.Provider, record.Model, record.PromptTokens, record.ResponseTokens,
+		record.Cost, record.Latency.Milliseconds(), createdAt.UTC(),
+	)
+	return err
+}
+
+// GetUsageRecords は範囲内の使用量の記録のうち、from以上to未満に記録したものを古い順（created_at ASC）で返す
+func (r *SQLiteUsageRepository) GetUsageRecords(ctx context.Context, scope OwnerScope, from, to time.Time) ([]UsageRecord, error) {
+	where, args := buildWhere(scope, []string{"created_at >= ?", "created_at < ?"}, []interface{}{from.UTC(), to.UTC()})
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, user_id, diary_id, provider, model, prompt_tokens, response_tokens, cost, latency_ms, created_at FROM generation_usage"+where+" ORDER BY created_at ASC, id ASC",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []UsageRecord
+	for rows.Next() {
+		var u UsageRecord
+		var diaryID sql.NullInt64
+		var latencyMs int64
+		if err := rows.Scan(&u.ID, &u.UserID, &diaryID, &u.Provider, &u.Model, &u.PromptTokens, &u.ResponseTokens, &u.Cost, &latencyMs, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		u.DiaryID = int(diaryID.Int64)
+		u.Latency = time.Duration(latencyMs) * time.Millisecond
+		records = append(records, u)
+	}
+	return records, rows.Err()
+}
+
+// GetUsageCost はユーザーがfrom以上to未満に使用した料金（USD）の合計を返す
+func (r *SQLiteUsageRepository) GetUsageCost(ctx context.Context, userID int, from, to time.Time) (float64, error) {
+	var cost float64
+	err := r.db.QueryRowContext(ctx,
+		"SELECT COALESCE(SUM(cost), 0) FROM generation_usage WHERE user_id = ? AND created_at >= ? AND created_at < ?",
+		userID, from.UTC(), to.UTC(),
+	).Scan(&cost)
+	return cost, err
+}
+
+// GetUsageBudget はユーザー（0の場合は全ユーザーの既定）の予算を返す。設定がない場合はnilを返す
+func (r *SQLiteUsageRepository) GetUsageBudget(ctx context.Context, userID int) (*UsageBudget, error) {
+	var b UsageBudget
+	err := r.db.QueryRowContext(ctx,
+		"SELECT user_id, daily_limit, monthly_limit, updated_at FROM usage_budgets WHERE user_id = ?",
+		userID,
+	).Scan(&b.UserID, &b.DailyLimit, &b.MonthlyLimit, &b.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// GetUsageBudgets は全ての予算をユーザーIDの昇順（既定の予算が先頭）で返す
+func (r *SQLiteUsageRepository) GetUsageBudgets(ctx context.Context) ([]UsageBudget, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT user_id, daily_limit, monthly_limit, updated_at FROM usage_budgets ORDER BY user_id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var budgets []UsageBudget
+	for rows.Next() {
+		var b UsageBudget
+		if err := rows.Scan(&b.UserID, &b.DailyLimit, &b.MonthlyLimit, &b.UpdatedAt); err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, b)
+	}
+	return budgets, rows.Err()
+}
+
+// SaveUsageBudget はユーザー（0の場合は全ユーザーの既定）の予算を作成・更新する
+func (r *SQLiteUsageRepository) SaveUsageBudget(ctx context.Context, userID int, dailyLimit, monthlyLimit float64) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO usage_budgets (user_id, daily_limit, monthly_limit, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET daily_limit = excluded.daily_limit, monthly_limit = excluded.monthly_limit, updated_at = excluded.updated_at`,
+		userID, dailyLimit, monthlyLimit, time.Now().UTC(),
+	)
+	return err
+}
+
+// DeleteUsageBudget はユーザー（0の場合は全ユーザーの既定）の予算を削除する。設定がない場合は何もしない
+func (r *SQLiteUsageRepository) DeleteUsageBudget(ctx context.Context, userID int) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM usage_budgets WHERE user_id = ?", userID)
+	return err
+}
+
+// SQLitePhotoMetadataRepository はSQLiteを使用したPhotoMetadataRepositoryの実装
+type SQLitePhotoMetadataRepository struct {
+	db *sql.DB
+}
+
+// NewSQLitePhotoMetadataRepository は新しいSQLitePhotoMetadataRepositoryを生成する
+func NewSQLitePhotoMetadataRepository(db *sql.DB) *SQLitePhotoMetadataRepository {
+	return &SQLitePhotoMetadataRepository{db: db}
+}
+
+// SavePhotoMetadata は写真の撮影情報を作成・更新する（同じパスの写真を保存し直した場合は置き換える）
+func (r *SQLitePhotoMetadataRepository) SavePhotoMetadata(ctx context.Context, meta PhotoMetadata) error {
+	var capturedAt sql.NullTime
+	if meta.CapturedAt != nil {
+		capturedAt = sql.NullTime{Time: meta.CapturedAt.UTC(), Valid: true}
+	}
+	var latitude, longitude sql.NullFloat64
+	if meta.Latitude != nil && meta.Longitude != nil {
+		latitude = sql.NullFloat64{Float64: *meta.Latitude, Valid: true}
+		longitude = sql.NullFloat64{Float64: *meta.Longitude, Valid: true}
+	}
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO photo_metadata (image_path, captured_at, orientation, camera_make, camera_model, exposure_time, f_number, iso, focal_length, latitude, longitude, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (image_path) DO UPDATE SET captured_at = excluded.captured_at, orientation = excluded.orientation,
+			camera_make = excluded.camera_make, camera_model = excluded.camera_model, exposure_time = excluded.exposure_time,
+			f_number = excluded.f_number, iso = excluded.iso, focal_length = excluded.focal_length,
+			latitude = excluded.latitude, longitude = excluded.longitude, created_at = excluded.created_at`,
+		meta.ImagePath, capturedAt, meta.Orientation, meta.CameraMake, meta.CameraModel, meta.ExposureTime,
+		meta.FNumber, meta.ISO, meta.FocalLength, latitude, longitude, time.Now().UTC(),
+	)
+	return err
+}
+
+// GetPhotoMetadata は写真の撮影情報を取得する。撮影情報がない場合はnilを返す
+func (r *SQLitePhotoMetadataRepository) GetPhotoMetadata(ctx context.Context, imagePath string) (*PhotoMetadata, error) {
+	meta := PhotoMetadata{ImagePath: imagePath}
+	var capturedAt sql.NullTime
+	var latitude, longitude sql.NullFloat64
+	err := r.db.QueryRowContext(ctx,
+		`SELECT captured_at, orientation, camera_make, camera_model, exposure_time, f_number, iso, focal_length, latitude, longitude
+		FROM photo_metadata WHERE image_path = ?`,
+		imagePath,
+	).Scan(&capturedAt, &meta.Orientation, &meta.CameraMake, &meta.CameraModel, &meta.ExposureTime,
+		&meta.FNumber, &meta.ISO, &meta.FocalLength, &latitude, &longitude)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if capturedAt.Valid {
+		meta.CapturedAt = &capturedAt.Time
+	}
+	if latitude.Valid && longitude.Valid {
+		meta.Latitude, meta.Longitude = &latitude.Float64, &longitude.Float64
+	}
+	return &meta, nil
+}
+
+// SQLitePhotoHashRepository はSQLiteを使用したPhotoHashRepositoryの実装
+type SQLitePhotoHashRepository struct {
+	db *sql.DB
+}
+
+// NewSQLitePhotoHashRepository は新しいSQLitePhotoHashRepositoryを生成する
+func NewSQLitePhotoHashRepository(db *sql.DB) *SQLitePhotoHashRepository {
+	return &SQLitePhotoHashRepository{db: db}
+}
+
+// SavePhotoHash は写真の知覚ハッシュを作成・更新する（同じパスの写真を保存し直した場合は置き換える）
+func (r *SQLitePhotoHashRepository) SavePhotoHash(ctx context.Context, hash PhotoHash) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO photo_hashes (image_path, user_id, plant_id, hash, captured_at, duplicate_of, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (image_path) DO UPDATE SET user_id = excluded.user_id, plant_id = excluded.plant_id, hash = excluded.hash,
+			captured_at = excluded.captured_at, duplicate_of = excluded.duplicate_of, created_at = excluded.created_at`,
+		hash.ImagePath, hash.UserID, hash.PlantID, formatPhotoHash(hash.Hash), hash.CapturedAt.UTC(), hash.DuplicateOf, time.Now().UTC(),
+	)
+	return err
+}
+
+// photoHashColumns は photo_hashes の取得列。日記が未作成の写真の日記IDは0
+const photoHashColumns = `h.image_path, h.user_id, h.plant_id, h.hash, h.captured_at, h.duplicate_of, COALESCE(d.id, 0)
+	FROM photo_hashes h LEFT JOIN diary d ON d.image_path = h.image_path`
+
+// scanPhotoHash は photoHashColumns の1行を読み込む
+func scanPhotoHash(row rowScanner) (PhotoHash, error) {
+	var h PhotoHash
+	var hash string
+	if err := row.Scan(&h.ImagePath, &h.UserID, &h.PlantID, &hash, &h.CapturedAt, &h.DuplicateOf, &h.DiaryID); err != nil {
+		return h, err
+	}
+	v, err := strconv.ParseUint(hash, 16, 64)
+	if err != nil {
+		return h, fmt.Errorf("invalid photo hash of %s: %w", h.ImagePath, err)
+	}
+	h.Hash = v
+	return h, nil
+}
+
+// GetPhotoHash は写真の知覚ハッシュを取得する。記録がない場合はnilを返す
+func (r *SQLitePhotoHashRepository) GetPhotoHash(ctx context.Context, imagePath string) (*PhotoHash, error) {
+	h, err := scanPhotoHash(r.db.QueryRowContext(ctx, "SELECT "+photoHashColumns+" WHERE h.image_path = ?", imagePath))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// FindPhotoHashes はユーザーの植物（plantIDが0の場合は植物未指定）の写真のうち、撮影日時がfrom以上to以下の知覚ハッシュを撮影日時順に返す。
+// from・toがゼロ値の場合は期間で絞り込まない。ゴミ箱の日記の写真は含まない
+func (r *SQLitePhotoHashRepository) FindPhotoHashes(ctx context.Context, userID, plantID int, from, to time.Time) ([]PhotoHash, error) {
+	query := "SELECT " + photoHashColumns + " WHERE h.user_id = ? AND h.plant_id = ? AND d.deleted_at IS NULL"
+	args := []any{userID, plantID}
+	if !from.IsZero() {
+		query += " AND h.captured_at >= ?"
+		args = append(args, from.UTC())
+	}
+	if !to.IsZero() {
+		query += " AND h.captured_at <= ?"
+		args = append(args, to.UTC())
+	}
+	query += " ORDER BY h.captured_at, h.image_path"
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []PhotoHash
+	for rows.Next() {
+		h, err := scanPhotoHash(rows)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, rows.Err()
+}
+
+// SQLiteUploadIdempotencyKeyRepository はSQLiteを使用したUploadIdempotencyKeyRepositoryの実装
+type SQLiteUploadIdempotencyKeyRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteUploadIdempotencyKeyRepository は新しいSQLiteUploadIdempotencyKeyRepositoryを生成する
+func NewSQLiteUploadIdempotencyKeyRepository(db *sql.DB) *SQLiteUploadIdempotencyKeyRepository {
+	return &SQLiteUploadIdempotencyKeyRepository{db: db}
+}
+
+// ReserveUploadIdempotencyKey はユーザーのキーを処理中として登録し、nilを返す。
+// 既に登録されているキーの場合は登録せず、既存のキーを返す（expiredBeforeより前に登録されたキーは期限切れとして登録し直す）
+func (r *SQLiteUploadIdempotencyKeyRepository) ReserveUploadIdempotencyKey(ctx context.Context, userID int, key, fingerprint string, expiredBefore time.Time) (*UploadIdempotencyKey, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM upload_idempotency_keys WHERE user_id = ? AND key = ? AND created_at < ?",
+		userID, key, expiredBefore.UTC(),
+	); err != nil {
+		return nil, err
+	}
+
+	existing := UploadIdempotencyKey{UserID: userID, Key: key}
+	err = tx.QueryRowContext(ctx,
+		"SELECT fingerprint, job_id, action, duplicate_of, distance, image_path, created_at FROM upload_idempotency_keys WHERE user_id = ? AND key = ?",
+		userID, key,
+	).Scan(&existing.Fingerprint, &existing.JobID, &existing.Action, &existing.DuplicateOf, &existing.Distance, &existing.ImagePath, &existing.CreatedAt)
+	if err == nil {
+		return &existing, tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO upload_idempotency_keys (user_id, key, fingerprint, created_at) VALUES (?, ?, ?, ?)",
+		userID, key, fingerprint, time.Now().UTC(),
+	); err != nil {
+		return nil, err
+	}
+	return nil, tx.Commit()
+}
+
+// CompleteUploadIdempotencyKey は処理中のキーに登録したジョブを記録する
+func (r *SQLiteUploadIdempotencyKeyRepository) CompleteUploadIdempotencyKey(ctx context.Context, userID int, key, jobID string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE upload_idempotency_keys SET job_id = ? WHERE user_id = ? AND key = ?", jobID, userID, key)
+	return err
+}
+
+// CompleteUploadIdempotencyKeyDuplicate は処理中のキーに、重複する写真としてスキップ・統合した結果を記録する。
+// imagePathは統合して保存した写真のパス（スキップした場合は空）
+func (r *SQLiteUploadIdempotencyKeyRepository) CompleteUploadIdempotencyKeyDuplicate(ctx context.Context, userID int, key, action, duplicateOf string, distance int, imagePath string) error {
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE upload_idempotency_keys SET action = ?, duplicate_of = ?, distance = ?, image_path = ? WHERE user_id = ? AND key = ?",
+		action, duplicateOf, distance, imagePath, userID, key,
+	)
+	return err
+}
+
+// DeleteUploadIdempotencyKey はキーを削除する（処理に失敗したリクエストを同じキーで再送できるようにする）。キーがない場合は何もしない
+func (r *SQLiteUploadIdempotencyKeyRepository) DeleteUploadIdempotencyKey(ctx context.Context, userID int, key string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM upload_idempotency_keys WHERE user_id = ? AND key = ?", userID, key)
+	return err
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"reflect"
 	"testing"
 	"time"
 
@@ -15,21 +16,28 @@ func setupTestDB(t *testing.T) *sql.DB {
 	if err != nil {
 		t.Fatalf("failed to open database: %v", err)
 	}
+	// インメモリDBは接続ごとに別DBになるため、本番同様に接続を1本に制限する
+	db.SetMaxOpenConns(1)
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS diary (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			image_path TEXT NOT NULL UNIQUE,
 			content TEXT NOT NULL,
 			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			user_id INTEGER REFERENCES users(id)
+			updated_at DATETIME,
+			user_id INTEGER REFERENCES users(id),
+			plant_id INTEGER REFERENCES plants(id),
+			deleted_at DATETIME,
+			provider TEXT NOT NULL DEFAULT ''
 		);
 		CREATE INDEX IF NOT EXISTS idx_created_at ON diary(created_at DESC);
 		CREATE TABLE IF NOT EXISTS users (
-			id            INTEGER PRIMARY KEY AUTOINCREMENT,
-			uuid          TEXT NOT NULL UNIQUE,
-			username      TEXT NOT NULL UNIQUE,
-			password_hash TEXT NOT NULL,
-			created_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			id             INTEGER PRIMARY KEY AUTOINCREMENT,
+			uuid           TEXT NOT NULL UNIQUE,
+			username       TEXT NOT NULL UNIQUE,
+			password_hash  TEXT NOT NULL,
+			diaries_public INTEGER NOT NULL DEFAULT 1,
+			created_at     DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 		);
 		CREATE TABLE IF NOT EXISTS sessions (
 			id         TEXT PRIMARY KEY,
@@ -38,6 +46,138 @@ func setupTestDB(t *testing.T) *sql.DB {
 			expires_at DATETIME NOT NULL
 		);
 		CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+		CREATE TABLE IF NOT EXISTS jobs (
+			id          TEXT PRIMARY KEY,
+			user_id     INTEGER NOT NULL REFERENCES users(id),
+			plant_id    INTEGER REFERENCES plants(id),
+			image_path  TEXT NOT NULL,
+			captured_at DATETIME NOT NULL,
+			status      TEXT NOT NULL DEFAULT 'queued',
+			attempts    INTEGER NOT NULL DEFAULT 0,
+			last_error  TEXT,
+			created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS diary_revisions (
+			id         INTEGER PRIMARY KEY AUTOINCREMENT,
+			diary_id   INTEGER NOT NULL REFERENCES diary(id),
+			content    TEXT NOT NULL,
+			source     TEXT NOT NULL,
+			user_id    INTEGER REFERENCES users(id),
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS plants (
+			id             INTEGER PRIMARY KEY AUTOINCREMENT,
+			uuid           TEXT NOT NULL UNIQUE,
+			user_id        INTEGER NOT NULL REFERENCES users(id),
+			name           TEXT NOT NULL,
+			species        TEXT NOT NULL DEFAULT '',
+			location       TEXT NOT NULL DEFAULT '',
+			acquired_on    DATE,
+			cover_diary_id INTEGER REFERENCES diary(id),
+			created_at     DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id      INTEGER NOT NULL REFERENCES users(id),
+			name         TEXT NOT NULL,
+			token_hash   TEXT NOT NULL UNIQUE,
+			prefix       TEXT NOT NULL,
+			scope        TEXT NOT NULL,
+			last_used_at DATETIME,
+			revoked_at   DATETIME,
+			created_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS diary_observations (
+			diary_id     INTEGER PRIMARY KEY REFERENCES diary(id),
+			health_score INTEGER NOT NULL DEFAULT 0,
+			leaf_count   INTEGER NOT NULL DEFAULT 0,
+			flowering    INTEGER NOT NULL DEFAULT 0,
+			fruiting     INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE TABLE IF NOT EXISTS diary_issues (
+			diary_id INTEGER NOT NULL REFERENCES diary(id),
+			issue    TEXT NOT NULL,
+			PRIMARY KEY (diary_id, issue)
+		);
+		CREATE TABLE IF NOT EXISTS diary_tags (
+			diary_id INTEGER NOT NULL REFERENCES diary(id),
+			tag      TEXT NOT NULL,
+			PRIMARY KEY (diary_id, tag)
+		);
+		CREATE TABLE IF NOT EXISTS prompt_templates (
+			user_id    INTEGER NOT NULL REFERENCES users(id),
+			plant_id   INTEGER NOT NULL DEFAULT 0,
+			persona    TEXT NOT NULL,
+			template   TEXT NOT NULL DEFAULT '',
+			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, plant_id)
+		);
+		CREATE TABLE IF NOT EXISTS diary_summaries (
+			user_id     INTEGER NOT NULL REFERENCES users(id),
+			plant_id    INTEGER NOT NULL DEFAULT 0,
+			period      TEXT NOT NULL,
+			start_date  TEXT NOT NULL,
+			source_hash TEXT NOT NULL,
+			content     TEXT NOT NULL,
+			created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, plant_id, period, start_date)
+		);
+
+		CREATE TABLE IF NOT EXISTS generation_usage (
+			id              INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id         INTEGER NOT NULL REFERENCES users(id),
+			diary_id        INTEGER,
+			provider        TEXT NOT NULL DEFAULT '',
+			model           TEXT NOT NULL DEFAULT '',
+			prompt_tokens   INTEGER NOT NULL DEFAULT 0,
+			response_tokens INTEGER NOT NULL DEFAULT 0,
+			cost            REAL NOT NULL DEFAULT 0,
+			latency_ms      INTEGER NOT NULL DEFAULT 0,
+			created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS usage_budgets (
+			user_id       INTEGER PRIMARY KEY,
+			daily_limit   REAL NOT NULL DEFAULT 0,
+			monthly_limit REAL NOT NULL DEFAULT 0,
+			updated_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS photo_metadata (
+			image_path    TEXT PRIMARY KEY,
+			captured_at   DATETIME,
+			orientation   INTEGER NOT NULL DEFAULT 0,
+			camera_make   TEXT NOT NULL DEFAULT '',
+			camera_model  TEXT NOT NULL DEFAULT '',
+			exposure_time TEXT NOT NULL DEFAULT '',
+			f_number      REAL NOT NULL DEFAULT 0,
+			iso           INTEGER NOT NULL DEFAULT 0,
+			focal_length  REAL NOT NULL DEFAULT 0,
+			latitude      REAL,
+			longitude     REAL,
+			created_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS upload_idempotency_keys (
+			user_id     INTEGER NOT NULL REFERENCES users(id),
+			key         TEXT NOT NULL,
+			fingerprint TEXT NOT NULL,
+			job_id      TEXT NOT NULL DEFAULT '',
+			created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			action       TEXT NOT NULL DEFAULT '',
+			duplicate_of TEXT NOT NULL DEFAULT '',
+			distance     INTEGER NOT NULL DEFAULT 0,
+			image_path   TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (user_id, key)
+		);
+		CREATE TABLE IF NOT EXISTS photo_hashes (
+			image_path   TEXT PRIMARY KEY,
+			user_id      INTEGER NOT NULL REFERENCES users(id),
+			plant_id     INTEGER NOT NULL DEFAULT 0,
+			hash         TEXT NOT NULL,
+			captured_at  DATETIME NOT NULL,
+			duplicate_of TEXT NOT NULL DEFAULT '',
+			created_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
 	`)
 	if err != nil {
 		t.Fatalf("failed to create table: %v", err)
@@ -50,12 +190,12 @@ func TestSQLiteDiaryRepository_CreateDiary(t *testing.T) {
 	db := setupTestDB(t)
 	repo := NewSQLiteDiaryRepository(db)
 
-	err := repo.CreateDiary("/path/to/image.jpg", "テスト日記", time.Now())
+	err := repo.CreateDiary(t.Context(), "/path/to/image.jpg", "テスト日記", time.Now())
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
-	diaries, err := repo.GetAllDiaries()
+	diaries, err := repo.GetAllDiaries(t.Context(), OwnerScope{})
 	if err != nil {
 		t.Fatalf("GetAllDiaries failed: %v", err)
 	}
@@ -81,13 +221,13 @@ func TestSQLiteDiaryRepository_CreateDiary_DuplicateImagePath(t *testing.T) {
 	db := setupTestDB(t)
 	repo := NewSQLiteDiaryRepository(db)
 
-	err := repo.CreateDiary("/path/to/image.jpg", "日記1", time.Now())
+	err := repo.CreateDiary(t.Context(), "/path/to/image.jpg", "日記1", time.Now())
 	if err != nil {
 		t.Fatalf("first CreateDiary failed: %v", err)
 	}
 
 	// 同じimage_pathで再度作成するとUNIQUE制約エラーになる
-	err = repo.CreateDiary("/path/to/image.jpg", "日記2", time.Now())
+	err = repo.CreateDiary(t.Context(), "/path/to/image.jpg", "日記2", time.Now())
 	if err == nil {
 		t.Error("expected error for duplicate image_path, got nil")
 	}
@@ -97,12 +237,12 @@ func TestSQLiteDiaryRepository_GetDiaryByID(t *testing.T) {
 	db := setupTestDB(t)
 	repo := NewSQLiteDiaryRepository(db)
 
-	err := repo.CreateDiary("/path/to/image.jpg", "テスト日記", time.Now())
+	err := repo.CreateDiary(t.Context(), "/path/to/image.jpg", "テスト日記", time.Now())
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
-	diary, err := repo.GetDiaryByID(1)
+	diary, err := repo.GetDiaryByID(t.Context(), 1)
 	if err != nil {
 		t.Fatalf("GetDiaryByID failed: %v", err)
 	}
@@ -128,7 +268,7 @@ func TestSQLiteDiaryRepository_GetDiaryByID_NotFound(t *testing.T) {
 	db := setupTestDB(t)
 	repo := NewSQLiteDiaryRepository(db)
 
-	diary, err := repo.GetDiaryByID(999)
+	diary, err := repo.GetDiaryByID(t.Context(), 999)
 	if err != nil {
 		t.Fatalf("GetDiaryByID failed: %v", err)
 	}
@@ -159,7 +299,7 @@ func TestSQLiteDiaryRepository_GetAllDiaries_Order(t *testing.T) {
 		t.Fatalf("Insert failed: %v", err)
 	}
 
-	diaries, err := repo.GetAllDiaries()
+	diaries, err := repo.GetAllDiaries(t.Context(), OwnerScope{})
 	if err != nil {
 		t.Fatalf("GetAllDiaries failed: %v", err)
 	}
@@ -186,7 +326,7 @@ func TestSQLiteDiaryRepository_GetAllDiaries_Empty(t *testing.T) {
 	db := setupTestDB(t)
 	repo := NewSQLiteDiaryRepository(db)
 
-	diaries, err := repo.GetAllDiaries()
+	diaries, err := repo.GetAllDiaries(t.Context(), OwnerScope{})
 	if err != nil {
 		t.Fatalf("GetAllDiaries failed: %v", err)
 	}
@@ -201,7 +341,7 @@ func TestSQLiteDiaryRepository_IsImageProcessed(t *testing.T) {
 	repo := NewSQLiteDiaryRepository(db)
 
 	// 未処理の画像
-	processed, err := repo.IsImageProcessed("/path/to/new.jpg")
+	processed, err := repo.IsImageProcessed(t.Context(), "/path/to/new.jpg")
 	if err != nil {
 		t.Fatalf("IsImageProcessed failed: %v", err)
 	}
@@ -211,13 +351,13 @@ func TestSQLiteDiaryRepository_IsImageProcessed(t *testing.T) {
 	}
 
 	// 画像を処理
-	err = repo.CreateDiary("/path/to/new.jpg", "新しい日記", time.Now())
+	err = repo.CreateDiary(t.Context(), "/path/to/new.jpg", "新しい日記", time.Now())
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
 	// 処理済みの画像
-	processed, err = repo.IsImageProcessed("/path/to/new.jpg")
+	processed, err = repo.IsImageProcessed(t.Context(), "/path/to/new.jpg")
 	if err != nil {
 		t.Fatalf("IsImageProcessed failed: %v", err)
 	}
@@ -239,13 +379,13 @@ func TestSQLiteDiaryRepository_CreateDiary_CustomCreatedAt(t *testing.T) {
 
 	// カスタム日時を指定してdiary作成
 	customTime := time.Date(2026, 2, 16, 11, 10, 0, 0, time.UTC)
-	err := repo.CreateDiary("/path/to/20260216_1110_UTC.jpg", "UTC日時テスト", customTime)
+	err := repo.CreateDiary(t.Context(), "/path/to/20260216_1110_UTC.jpg", "UTC日時テスト", customTime)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
 	// 日記を取得して日時を確認
-	diaries, err := repo.GetAllDiaries()
+	diaries, err := repo.GetAllDiaries(t.Context(), OwnerScope{})
 	if err != nil {
 		t.Fatalf("GetAllDiaries failed: %v", err)
 	}
@@ -260,12 +400,98 @@ func TestSQLiteDiaryRepository_CreateDiary_CustomCreatedAt(t *testing.T) {
 	}
 }
 
+func TestSQLiteDiaryRepository_CreateGeneratedDiary(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+
+	observation := &DiaryObservation{
+		HealthScore: 4,
+		LeafCount:   12,
+		Flowering:   true,
+		Issues:      []string{IssueYellowing, IssuePests},
+		Tags:        []string{"つぼみ", "新芽"},
+	}
+	if _, err := repo.CreateGeneratedDiary(t.Context(), 1, 0, "/path/to/generated.jpg", "生成した日記", ProviderGemini, observation, time.Now()); err != nil {
+		t.Fatalf("CreateGeneratedDiary failed: %v", err)
+	}
+	if err := repo.CreateDiaryForUser(t.Context(), 1, 0, "/path/to/unknown.jpg", "プロバイダ不明の日記", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+
+	diaries, err := repo.GetAllDiaries(t.Context(), OwnerScope{})
+	if err != nil {
+		t.Fatalf("GetAllDiaries failed: %v", err)
+	}
+	if len(diaries) != 2 || diaries[0].Provider != ProviderGemini || diaries[1].Provider != "" {
+		t.Fatalf("unexpected diaries: %+v", diaries)
+	}
+
+	observations, err := repo.GetDiaryObservations(t.Context(), []int{diaries[0].ID, diaries[1].ID})
+	if err != nil {
+		t.Fatalf("GetDiaryObservations failed: %v", err)
+	}
+	want := *observation
+	want.DiaryID = diaries[0].ID
+	if len(observations) != 1 || !reflect.DeepEqual(observations[diaries[0].ID], want) {
+		t.Errorf("GetDiaryObservations() = %+v, want only %+v", observations, want)
+	}
+
+	// 再生成でプロバイダが変わり、構造化出力に対応していないプロバイダで生成した場合
+	if err := repo.UpdateDiaryGeneration(t.Context(), diaries[0].ID, ProviderOllama, nil); err != nil {
+		t.Fatalf("UpdateDiaryGeneration failed: %v", err)
+	}
+	diary, err := repo.GetDiaryByID(t.Context(), diaries[0].ID)
+	if err != nil {
+		t.Fatalf("GetDiaryByID failed: %v", err)
+	}
+	if diary.Provider != ProviderOllama {
+		t.Errorf("expected provider %q, got %q", ProviderOllama, diary.Provider)
+	}
+	observations, err = repo.GetDiaryObservations(t.Context(), []int{diaries[0].ID})
+	if err != nil {
+		t.Fatalf("GetDiaryObservations failed: %v", err)
+	}
+	if len(observations) != 0 {
+		t.Errorf("expected observation to be removed, got %+v", observations)
+	}
+
+	if err := repo.UpdateDiaryGeneration(t.Context(), 999, ProviderOllama, nil); err == nil {
+		t.Error("expected error for non-existent diary, got nil")
+	}
+}
+
+func TestSQLiteDiaryRepository_PurgeDiary_RemovesObservation(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+
+	observation := &DiaryObservation{HealthScore: 3, Issues: []string{IssueWilting}, Tags: []string{"植え替え"}}
+	if _, err := repo.CreateGeneratedDiary(t.Context(), 1, 0, "/path/to/generated.jpg", "生成した日記", ProviderGemini, observation, time.Now()); err != nil {
+		t.Fatalf("CreateGeneratedDiary failed: %v", err)
+	}
+	if err := repo.DeleteDiary(t.Context(), 1); err != nil {
+		t.Fatalf("DeleteDiary failed: %v", err)
+	}
+	if _, err := repo.PurgeDiary(t.Context(), 1); err != nil {
+		t.Fatalf("PurgeDiary failed: %v", err)
+	}
+
+	for _, table := range []string{"diary_observations", "diary_issues", "diary_tags"} {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+			t.Fatalf("failed to count %s: %v", table, err)
+		}
+		if count != 0 {
+			t.Errorf("expected %s to be empty, got %d rows", table, count)
+		}
+	}
+}
+
 func TestSQLiteDiaryRepository_GetLatestDiaryCreatedAt(t *testing.T) {
 	db := setupTestDB(t)
 	repo := NewSQLiteDiaryRepository(db)
 
 	// 日記が存在しない場合はゼロ値を返す
-	latest, err := repo.GetLatestDiaryCreatedAt()
+	latest, err := repo.GetLatestDiaryCreatedAt(t.Context())
 	if err != nil {
 		t.Fatalf("GetLatestDiaryCreatedAt failed: %v", err)
 	}
@@ -279,23 +505,23 @@ func TestSQLiteDiaryRepository_GetLatestDiaryCreatedAt(t *testing.T) {
 	time2 := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
 	time3 := time.Date(2026, 1, 3, 10, 0, 0, 0, time.UTC)
 
-	err = repo.CreateDiary("/path/1.jpg", "日記1", time1)
+	err = repo.CreateDiary(t.Context(), "/path/1.jpg", "日記1", time1)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
-	err = repo.CreateDiary("/path/2.jpg", "日記2", time2)
+	err = repo.CreateDiary(t.Context(), "/path/2.jpg", "日記2", time2)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
-	err = repo.CreateDiary("/path/3.jpg", "日記3", time3)
+	err = repo.CreateDiary(t.Context(), "/path/3.jpg", "日記3", time3)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
 	// 最新の日記の日時を取得
-	latest, err = repo.GetLatestDiaryCreatedAt()
+	latest, err = repo.GetLatestDiaryCreatedAt(t.Context())
 	if err != nil {
 		t.Fatalf("GetLatestDiaryCreatedAt failed: %v", err)
 	}
@@ -316,22 +542,22 @@ func TestSQLiteDiaryRepository_GetDiariesInDateRange(t *testing.T) {
 	time3 := time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC)
 	time4 := time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)
 
-	err := repo.CreateDiary("/path/1.jpg", "日記1", time1)
+	err := repo.CreateDiary(t.Context(), "/path/1.jpg", "日記1", time1)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
-	err = repo.CreateDiary("/path/2.jpg", "日記2", time2)
+	err = repo.CreateDiary(t.Context(), "/path/2.jpg", "日記2", time2)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
-	err = repo.CreateDiary("/path/3.jpg", "日記3", time3)
+	err = repo.CreateDiary(t.Context(), "/path/3.jpg", "日記3", time3)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
-	err = repo.CreateDiary("/path/4.jpg", "日記4", time4)
+	err = repo.CreateDiary(t.Context(), "/path/4.jpg", "日記4", time4)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
@@ -340,7 +566,7 @@ func TestSQLiteDiaryRepository_GetDiariesInDateRange(t *testing.T) {
 	startDate := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
 	endDate := time.Date(2026, 2, 5, 0, 0, 0, 0, time.UTC)
 
-	diaries, err := repo.GetDiariesInDateRange(startDate, endDate)
+	diaries, err := repo.GetDiariesInDateRange(t.Context(), OwnerScope{}, startDate, endDate)
 	if err != nil {
 		t.Fatalf("GetDiariesInDateRange failed: %v", err)
 	}
@@ -365,7 +591,7 @@ func TestSQLiteDiaryRepository_GetAvailableYearMonths(t *testing.T) {
 	repo := NewSQLiteDiaryRepository(db)
 
 	// 日記が存在しない場合は空を返す
-	months, err := repo.GetAvailableYearMonths()
+	months, err := repo.GetAvailableYearMonths(t.Context(), OwnerScope{})
 	if err != nil {
 		t.Fatalf("GetAvailableYearMonths failed: %v", err)
 	}
@@ -380,20 +606,20 @@ func TestSQLiteDiaryRepository_GetAvailableYearMonths(t *testing.T) {
 	time3 := time.Date(2026, 2, 5, 12, 0, 0, 0, jst)
 	time4 := time.Date(2025, 12, 15, 12, 0, 0, 0, jst)
 
-	if err := repo.CreateDiary("/path/1.jpg", "日記1", time1); err != nil {
+	if err := repo.CreateDiary(t.Context(), "/path/1.jpg", "日記1", time1); err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
-	if err := repo.CreateDiary("/path/2.jpg", "日記2", time2); err != nil {
+	if err := repo.CreateDiary(t.Context(), "/path/2.jpg", "日記2", time2); err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
-	if err := repo.CreateDiary("/path/3.jpg", "日記3", time3); err != nil {
+	if err := repo.CreateDiary(t.Context(), "/path/3.jpg", "日記3", time3); err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
-	if err := repo.CreateDiary("/path/4.jpg", "日記4", time4); err != nil {
+	if err := repo.CreateDiary(t.Context(), "/path/4.jpg", "日記4", time4); err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
-	months, err = repo.GetAvailableYearMonths()
+	months, err = repo.GetAvailableYearMonths(t.Context(), OwnerScope{})
 	if err != nil {
 		t.Fatalf("GetAvailableYearMonths failed: %v", err)
 	}
@@ -437,7 +663,7 @@ func TestSQLiteDiaryRepository_SearchDiaries(t *testing.T) {
 	}
 
 	// キーワードで検索
-	diaries, err := repo.SearchDiaries("葉")
+	diaries, err := repo.SearchDiaries(t.Context(), OwnerScope{}, "葉")
 	if err != nil {
 		t.Fatalf("SearchDiaries failed: %v", err)
 	}
@@ -455,7 +681,7 @@ func TestSQLiteDiaryRepository_SearchDiaries(t *testing.T) {
 	}
 
 	// マッチしないキーワード
-	diaries, err = repo.SearchDiaries("実")
+	diaries, err = repo.SearchDiaries(t.Context(), OwnerScope{}, "実")
 	if err != nil {
 		t.Fatalf("SearchDiaries failed: %v", err)
 	}
@@ -464,13 +690,112 @@ func TestSQLiteDiaryRepository_SearchDiaries(t *testing.T) {
 	}
 }
 
+func TestSQLiteDiaryRepository_OwnerScope(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+	userRepo := NewSQLiteUserRepository(db)
+
+	// alice（公開）とbob（非公開）の日記を作成
+	if err := userRepo.CreateUser(t.Context(), "uuid-alice", "alice", "hash"); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if err := userRepo.CreateUser(t.Context(), "uuid-bob", "bob", "hash"); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	alice, _ := userRepo.GetUserByUsername(t.Context(), "alice")
+	bob, _ := userRepo.GetUserByUsername(t.Context(), "bob")
+	if err := userRepo.SetDiariesPublic(t.Context(), bob.ID, false); err != nil {
+		t.Fatalf("SetDiariesPublic failed: %v", err)
+	}
+
+	time1 := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	time2 := time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC)
+	if err := repo.CreateDiaryForUser(t.Context(), alice.ID, 0, "/path/alice.jpg", "aliceの葉", time1); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	if err := repo.CreateDiaryForUser(t.Context(), bob.ID, 0, "/path/bob.jpg", "bobの葉", time2); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		scope      OwnerScope
+		wantImages []string
+		wantMonths int
+	}{
+		{
+			name:       "範囲指定なしは全ユーザー",
+			scope:      OwnerScope{},
+			wantImages: []string{"/path/alice.jpg", "/path/bob.jpg"},
+			wantMonths: 2,
+		},
+		{
+			name:       "ユーザー指定は本人の日記のみ",
+			scope:      OwnerScope{UserID: bob.ID},
+			wantImages: []string{"/path/bob.jpg"},
+			wantMonths: 1,
+		},
+		{
+			name:       "公開のみは公開ユーザーの日記のみ",
+			scope:      OwnerScope{PublicOnly: true},
+			wantImages: []string{"/path/alice.jpg"},
+			wantMonths: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all, err := repo.GetAllDiaries(t.Context(), tt.scope)
+			if err != nil {
+				t.Fatalf("GetAllDiaries failed: %v", err)
+			}
+			searched, err := repo.SearchDiaries(t.Context(), tt.scope, "葉")
+			if err != nil {
+				t.Fatalf("SearchDiaries failed: %v", err)
+			}
+			ranged, err := repo.GetDiariesInDateRange(t.Context(), tt.scope, time1.AddDate(0, 0, -1), time2.AddDate(0, 0, 1))
+			if err != nil {
+				t.Fatalf("GetDiariesInDateRange failed: %v", err)
+			}
+			// 期間指定の取得のみ古い順のため、含まれる日記の集合で比較する
+			for _, got := range [][]Diary{all, searched, ranged} {
+				if len(got) != len(tt.wantImages) {
+					t.Fatalf("expected %d diaries, got %d", len(tt.wantImages), len(got))
+				}
+				images := make(map[string]bool)
+				for _, d := range got {
+					images[d.ImagePath] = true
+				}
+				for _, want := range tt.wantImages {
+					if !images[want] {
+						t.Errorf("expected %s to be included, got %+v", want, got)
+					}
+				}
+			}
+
+			months, err := repo.GetAvailableYearMonths(t.Context(), tt.scope)
+			if err != nil {
+				t.Fatalf("GetAvailableYearMonths failed: %v", err)
+			}
+			if len(months) != tt.wantMonths {
+				t.Errorf("expected %d months, got %d", tt.wantMonths, len(months))
+			}
+		})
+	}
+
+	// 日記の所有ユーザーが取得できることを確認
+	if all, _ := repo.GetAllDiaries(t.Context(), OwnerScope{UserID: alice.ID}); len(all) != 1 || all[0].UserID != alice.ID {
+		t.Errorf("expected diary owned by alice, got %+v", all)
+	}
+}
+
 func TestSQLiteDiaryRepository_GetDiariesInDateRange_Empty(t *testing.T) {
 	db := setupTestDB(t)
 	repo := NewSQLiteDiaryRepository(db)
 
 	// 日記を1件作成
 	time1 := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
-	err := repo.CreateDiary("/path/1.jpg", "日記1", time1)
+	err := repo.CreateDiary(t.Context(), "/path/1.jpg", "日記1", time1)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
@@ -479,7 +804,7 @@ func TestSQLiteDiaryRepository_GetDiariesInDateRange_Empty(t *testing.T) {
 	startDate := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
 	endDate := time.Date(2026, 2, 28, 0, 0, 0, 0, time.UTC)
 
-	diaries, err := repo.GetDiariesInDateRange(startDate, endDate)
+	diaries, err := repo.GetDiariesInDateRange(t.Context(), OwnerScope{}, startDate, endDate)
 	if err != nil {
 		t.Fatalf("GetDiariesInDateRange failed: %v", err)
 	}
@@ -494,12 +819,12 @@ func TestSQLiteUserRepository_CreateUser(t *testing.T) {
 	db := setupTestDB(t)
 	repo := NewSQLiteUserRepository(db)
 
-	err := repo.CreateUser("abc123", "alice", "hashedpassword")
+	err := repo.CreateUser(t.Context(), "abc123", "alice", "hashedpassword")
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
 
-	user, err := repo.GetUserByUsername("alice")
+	user, err := repo.GetUserByUsername(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetUserByUsername failed: %v", err)
 	}
@@ -522,7 +847,7 @@ func TestSQLiteUserRepository_GetUserByUsername_NotFound(t *testing.T) {
 	db := setupTestDB(t)
 	repo := NewSQLiteUserRepository(db)
 
-	user, err := repo.GetUserByUsername("nonexistent")
+	user, err := repo.GetUserByUsername(t.Context(), "nonexistent")
 	if err != nil {
 		t.Fatalf("GetUserByUsername failed: %v", err)
 	}
@@ -536,13 +861,13 @@ func TestSQLiteUserRepository_CreateUser_DuplicateUsername(t *testing.T) {
 	db := setupTestDB(t)
 	repo := NewSQLiteUserRepository(db)
 
-	err := repo.CreateUser("uuid1", "alice", "hash1")
+	err := repo.CreateUser(t.Context(), "uuid1", "alice", "hash1")
 	if err != nil {
 		t.Fatalf("first CreateUser failed: %v", err)
 	}
 
 	// 同じusernameで作成するとUNIQUE制約エラー
-	err = repo.CreateUser("uuid2", "alice", "hash2")
+	err = repo.CreateUser(t.Context(), "uuid2", "alice", "hash2")
 	if err == nil {
 		t.Error("expected error for duplicate username, got nil")
 	}
@@ -552,12 +877,12 @@ func TestSQLiteUserRepository_GetUserByID(t *testing.T) {
 	db := setupTestDB(t)
 	repo := NewSQLiteUserRepository(db)
 
-	err := repo.CreateUser("uuid-001", "alice", "hashedpassword")
+	err := repo.CreateUser(t.Context(), "uuid-001", "alice", "hashedpassword")
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
 
-	user, err := repo.GetUserByUsername("alice")
+	user, err := repo.GetUserByUsername(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetUserByUsername failed: %v", err)
 	}
@@ -565,7 +890,7 @@ func TestSQLiteUserRepository_GetUserByID(t *testing.T) {
 		t.Fatal("expected user, got nil")
 	}
 
-	found, err := repo.GetUserByID(user.ID)
+	found, err := repo.GetUserByID(t.Context(), user.ID)
 	if err != nil {
 		t.Fatalf("GetUserByID failed: %v", err)
 	}
@@ -577,7 +902,7 @@ func TestSQLiteUserRepository_GetUserByID(t *testing.T) {
 	}
 
 	// 存在しないIDを取得
-	notFound, err := repo.GetUserByID(9999)
+	notFound, err := repo.GetUserByID(t.Context(), 9999)
 	if err != nil {
 		t.Fatalf("GetUserByID failed: %v", err)
 	}
@@ -586,6 +911,62 @@ func TestSQLiteUserRepository_GetUserByID(t *testing.T) {
 	}
 }
 
+func TestSQLiteUserRepository_SetDiariesPublic(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteUserRepository(db)
+
+	if err := repo.CreateUser(t.Context(), "uuid-001", "alice", "hash"); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	user, err := repo.GetUserByUsername(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GetUserByUsername failed: %v", err)
+	}
+	// 既定では公開
+	if !user.DiariesPublic {
+		t.Error("expected diaries to be public by default")
+	}
+
+	if err := repo.SetDiariesPublic(t.Context(), user.ID, false); err != nil {
+		t.Fatalf("SetDiariesPublic failed: %v", err)
+	}
+	found, err := repo.GetUserByID(t.Context(), user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID failed: %v", err)
+	}
+	if found.DiariesPublic {
+		t.Error("expected diaries to be private")
+	}
+}
+
+func TestSQLiteUserRepository_HasLoginUser(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteUserRepository(db)
+
+	// systemユーザーのみの場合はログイン可能なユーザーなし
+	if err := repo.CreateUser(t.Context(), "00000000000000000000000000000000", "system", disabledPasswordHash); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	has, err := repo.HasLoginUser(t.Context())
+	if err != nil {
+		t.Fatalf("HasLoginUser failed: %v", err)
+	}
+	if has {
+		t.Error("expected no login user")
+	}
+
+	if err := repo.CreateUser(t.Context(), "uuid-001", "alice", "hash"); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	has, err = repo.HasLoginUser(t.Context())
+	if err != nil {
+		t.Fatalf("HasLoginUser failed: %v", err)
+	}
+	if !has {
+		t.Error("expected login user")
+	}
+}
+
 func TestSQLiteUserRepository_ImplementsInterface(t *testing.T) {
 	db := setupTestDB(t)
 	// コンパイル時にインターフェースを満たすことを確認
@@ -597,20 +978,20 @@ func TestSQLiteSessionRepository_CreateAndGet(t *testing.T) {
 	userRepo := NewSQLiteUserRepository(db)
 	sessionRepo := NewSQLiteSessionRepository(db)
 
-	if err := userRepo.CreateUser("uuid-001", "alice", "hash"); err != nil {
+	if err := userRepo.CreateUser(t.Context(), "uuid-001", "alice", "hash"); err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
-	user, err := userRepo.GetUserByUsername("alice")
+	user, err := userRepo.GetUserByUsername(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetUserByUsername failed: %v", err)
 	}
 
 	expiresAt := time.Now().Add(7 * 24 * time.Hour)
-	if err := sessionRepo.CreateSession("sess-001", user.ID, expiresAt); err != nil {
+	if err := sessionRepo.CreateSession(t.Context(), "sess-001", user.ID, expiresAt); err != nil {
 		t.Fatalf("CreateSession failed: %v", err)
 	}
 
-	session, err := sessionRepo.GetSessionByID("sess-001")
+	session, err := sessionRepo.GetSessionByID(t.Context(), "sess-001")
 	if err != nil {
 		t.Fatalf("GetSessionByID failed: %v", err)
 	}
@@ -627,10 +1008,10 @@ func TestSQLiteSessionRepository_GetExpiredSession(t *testing.T) {
 	userRepo := NewSQLiteUserRepository(db)
 	sessionRepo := NewSQLiteSessionRepository(db)
 
-	if err := userRepo.CreateUser("uuid-001", "alice", "hash"); err != nil {
+	if err := userRepo.CreateUser(t.Context(), "uuid-001", "alice", "hash"); err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
-	user, err := userRepo.GetUserByUsername("alice")
+	user, err := userRepo.GetUserByUsername(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetUserByUsername failed: %v", err)
 	}
@@ -642,7 +1023,7 @@ func TestSQLiteSessionRepository_GetExpiredSession(t *testing.T) {
 		t.Fatalf("Insert expired session failed: %v", err)
 	}
 
-	session, err := sessionRepo.GetSessionByID("expired-sess")
+	session, err := sessionRepo.GetSessionByID(t.Context(), "expired-sess")
 	if err != nil {
 		t.Fatalf("GetSessionByID failed: %v", err)
 	}
@@ -656,24 +1037,24 @@ func TestSQLiteSessionRepository_DeleteSession(t *testing.T) {
 	userRepo := NewSQLiteUserRepository(db)
 	sessionRepo := NewSQLiteSessionRepository(db)
 
-	if err := userRepo.CreateUser("uuid-001", "alice", "hash"); err != nil {
+	if err := userRepo.CreateUser(t.Context(), "uuid-001", "alice", "hash"); err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
-	user, err := userRepo.GetUserByUsername("alice")
+	user, err := userRepo.GetUserByUsername(t.Context(), "alice")
 	if err != nil {
 		t.Fatalf("GetUserByUsername failed: %v", err)
 	}
 
 	expiresAt := time.Now().Add(7 * 24 * time.Hour)
-	if err := sessionRepo.CreateSession("sess-del", user.ID, expiresAt); err != nil {
+	if err := sessionRepo.CreateSession(t.Context(), "sess-del", user.ID, expiresAt); err != nil {
 		t.Fatalf("CreateSession failed: %v", err)
 	}
 
-	if err := sessionRepo.DeleteSession("sess-del"); err != nil {
+	if err := sessionRepo.DeleteSession(t.Context(), "sess-del"); err != nil {
 		t.Fatalf("DeleteSession failed: %v", err)
 	}
 
-	session, err := sessionRepo.GetSessionByID("sess-del")
+	session, err := sessionRepo.GetSessionByID(t.Context(), "sess-del")
 	if err != nil {
 		t.Fatalf("GetSessionByID failed: %v", err)
 	}
@@ -687,3 +1068,1445 @@ func TestSQLiteSessionRepository_ImplementsInterface(t *testing.T) {
 	// コンパイル時にインターフェースを満たすことを確認
 	var _ SessionRepository = NewSQLiteSessionRepository(db)
 }
+
+func TestSQLiteJobRepository_CreateAndGet(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteJobRepository(db)
+
+	capturedAt := time.Date(2026, 2, 1, 3, 0, 0, 0, time.UTC)
+	if err := repo.CreateJob(t.Context(), "job1", 1, 3, "/path/to/image.jpg", capturedAt); err != nil {
+		t.Fatalf("CreateJob failed: %v", err)
+	}
+
+	job, err := repo.GetJobByID(t.Context(), "job1")
+	if err != nil {
+		t.Fatalf("GetJobByID failed: %v", err)
+	}
+	if job == nil {
+		t.Fatal("expected job, got nil")
+	}
+	if job.Status != JobStatusQueued {
+		t.Errorf("expected status %q, got %q", JobStatusQueued, job.Status)
+	}
+	if job.UserID != 1 || job.PlantID != 3 || job.ImagePath != "/path/to/image.jpg" || !job.CapturedAt.Equal(capturedAt) {
+		t.Errorf("unexpected job: %+v", job)
+	}
+	if job.Attempts != 0 || job.LastError != "" {
+		t.Errorf("expected no attempts and no error, got attempts=%d lastError=%q", job.Attempts, job.LastError)
+	}
+
+	// 存在しないIDはnil
+	job, err = repo.GetJobByID(t.Context(), "unknown")
+	if err != nil {
+		t.Fatalf("GetJobByID failed: %v", err)
+	}
+	if job != nil {
+		t.Errorf("expected nil for non-existent job, got %+v", job)
+	}
+}
+
+func TestSQLiteJobRepository_StatusTransitions(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteJobRepository(db)
+
+	if err := repo.CreateJob(t.Context(), "job1", 1, 0, "/path/1.jpg", time.Now()); err != nil {
+		t.Fatalf("CreateJob failed: %v", err)
+	}
+	if err := repo.CreateJob(t.Context(), "job2", 1, 0, "/path/2.jpg", time.Now()); err != nil {
+		t.Fatalf("CreateJob failed: %v", err)
+	}
+
+	if err := repo.MarkJobRunning(t.Context(), "job1"); err != nil {
+		t.Fatalf("MarkJobRunning failed: %v", err)
+	}
+	if err := repo.MarkJobFailed(t.Context(), "job1", 4, "api error"); err != nil {
+		t.Fatalf("MarkJobFailed failed: %v", err)
+	}
+	job, err := repo.GetJobByID(t.Context(), "job1")
+	if err != nil {
+		t.Fatalf("GetJobByID failed: %v", err)
+	}
+	if job.Status != JobStatusFailed || job.Attempts != 4 || job.LastError != "api error" {
+		t.Errorf("unexpected failed job: %+v", job)
+	}
+
+	if err := repo.MarkJobSucceeded(t.Context(), "job2", 1); err != nil {
+		t.Fatalf("MarkJobSucceeded failed: %v", err)
+	}
+	job, err = repo.GetJobByID(t.Context(), "job2")
+	if err != nil {
+		t.Fatalf("GetJobByID failed: %v", err)
+	}
+	if job.Status != JobStatusSucceeded || job.Attempts != 1 || job.LastError != "" {
+		t.Errorf("unexpected succeeded job: %+v", job)
+	}
+
+	// 存在しないジョブの更新はエラー
+	if err := repo.MarkJobRunning(t.Context(), "unknown"); err == nil {
+		t.Error("expected error for non-existent job, got nil")
+	}
+}
+
+func TestSQLiteJobRepository_QueuedAndRequeue(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteJobRepository(db)
+
+	for _, id := range []string{"job1", "job2", "job3"} {
+		if err := repo.CreateJob(t.Context(), id, 1, 0, "/path/"+id+".jpg", time.Now()); err != nil {
+			t.Fatalf("CreateJob failed: %v", err)
+		}
+	}
+	if err := repo.MarkJobRunning(t.Context(), "job1"); err != nil {
+		t.Fatalf("MarkJobRunning failed: %v", err)
+	}
+	if err := repo.MarkJobSucceeded(t.Context(), "job2", 1); err != nil {
+		t.Fatalf("MarkJobSucceeded failed: %v", err)
+	}
+
+	jobs, err := repo.GetQueuedJobs(t.Context())
+	if err != nil {
+		t.Fatalf("GetQueuedJobs failed: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != "job3" {
+		t.Fatalf("expected only job3 to be queued, got %+v", jobs)
+	}
+
+	// 中断されたrunningジョブはqueuedに戻る
+	requeued, err := repo.RequeueRunningJobs(t.Context())
+	if err != nil {
+		t.Fatalf("RequeueRunningJobs failed: %v", err)
+	}
+	if requeued != 1 {
+		t.Errorf("expected 1 requeued job, got %d", requeued)
+	}
+
+	jobs, err = repo.GetQueuedJobs(t.Context())
+	if err != nil {
+		t.Fatalf("GetQueuedJobs failed: %v", err)
+	}
+	if len(jobs) != 2 {
+		t.Errorf("expected 2 queued jobs, got %d", len(jobs))
+	}
+}
+
+func TestSQLiteJobRepository_PauseAndResume(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteJobRepository(db)
+
+	for _, id := range []string{"job1", "job2"} {
+		if err := repo.CreateJob(t.Context(), id, 1, 0, "/path/"+id+".jpg", time.Now()); err != nil {
+			t.Fatalf("CreateJob failed: %v", err)
+		}
+	}
+
+	// running状態でないジョブは一時停止できない
+	if err := repo.MarkJobPaused(t.Context(), "job1", "予算の上限"); err == nil {
+		t.Error("expected error when pausing queued job, got nil")
+	}
+	if err := repo.MarkJobRunning(t.Context(), "job1"); err != nil {
+		t.Fatalf("MarkJobRunning failed: %v", err)
+	}
+	if err := repo.MarkJobPaused(t.Context(), "job1", "予算の上限"); err != nil {
+		t.Fatalf("MarkJobPaused failed: %v", err)
+	}
+
+	paused, err := repo.GetPausedJobs(t.Context())
+	if err != nil {
+		t.Fatalf("GetPausedJobs failed: %v", err)
+	}
+	if len(paused) != 1 || paused[0].ID != "job1" || paused[0].LastError != "予算の上限" {
+		t.Fatalf("expected only job1 to be paused, got %+v", paused)
+	}
+	// 一時停止したジョブは起動時に再投入しない
+	if requeued, err := repo.RequeueRunningJobs(t.Context()); err != nil || requeued != 0 {
+		t.Errorf("expected paused job not to be requeued, got %d, %v", requeued, err)
+	}
+
+	if err := repo.ResumePausedJob(t.Context(), "job1"); err != nil {
+		t.Fatalf("ResumePausedJob failed: %v", err)
+	}
+	job, err := repo.GetJobByID(t.Context(), "job1")
+	if err != nil {
+		t.Fatalf("GetJobByID failed: %v", err)
+	}
+	if job.Status != JobStatusQueued || job.LastError != "" {
+		t.Errorf("expected resumed job to be queued without error, got %+v", job)
+	}
+	// 二重に再開しない
+	if err := repo.ResumePausedJob(t.Context(), "job1"); err == nil {
+		t.Error("expected error when resuming queued job, got nil")
+	}
+}
+
+func TestSQLiteJobRepository_ImplementsInterface(t *testing.T) {
+	db := setupTestDB(t)
+	var _ JobRepository = NewSQLiteJobRepository(db)
+}
+
+func TestSQLiteDiaryRevisionRepository_ReplaceDiaryContent(t *testing.T) {
+	db := setupTestDB(t)
+	diaryRepo := NewSQLiteDiaryRepository(db)
+	repo := NewSQLiteDiaryRevisionRepository(db)
+
+	createdAt := time.Date(2026, 2, 1, 3, 0, 0, 0, time.UTC)
+	if err := diaryRepo.CreateDiary(t.Context(), "/path/to/image.jpg", "AIの日記", createdAt); err != nil {
+		t.Fatalf("CreateDiary failed: %v", err)
+	}
+
+	// 初回は置き換え前の本文が最初の版として保存される
+	previousID, err := repo.ReplaceDiaryContent(t.Context(), 1, "再生成した日記", RevisionSourceRegeneration, 0)
+	if err != nil {
+		t.Fatalf("ReplaceDiaryContent failed: %v", err)
+	}
+	previous, err := repo.GetRevisionByID(t.Context(), previousID)
+	if err != nil {
+		t.Fatalf("GetRevisionByID failed: %v", err)
+	}
+	if previous == nil {
+		t.Fatal("expected previous revision, got nil")
+	}
+	if previous.Content != "AIの日記" || previous.Source != RevisionSourceGeneration || !previous.CreatedAt.Equal(createdAt) {
+		t.Errorf("unexpected snapshot revision: %+v", previous)
+	}
+
+	diary, err := diaryRepo.GetDiaryByID(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("GetDiaryByID failed: %v", err)
+	}
+	if diary.Content != "再生成した日記" {
+		t.Errorf("expected diary content to be replaced, got '%s'", diary.Content)
+	}
+
+	// 2回目は直前の版のIDが返り、スナップショットは追加されない
+	secondPreviousID, err := repo.ReplaceDiaryContent(t.Context(), 1, "AIの日記", RevisionSourceRestore, 5)
+	if err != nil {
+		t.Fatalf("ReplaceDiaryContent failed: %v", err)
+	}
+
+	revisions, err := repo.GetRevisionsByDiaryID(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("GetRevisionsByDiaryID failed: %v", err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("expected 3 revisions, got %d", len(revisions))
+	}
+	if secondPreviousID != revisions[1].ID {
+		t.Errorf("expected previous revision ID %d, got %d", revisions[1].ID, secondPreviousID)
+	}
+	if revisions[0].Source != RevisionSourceRestore || revisions[0].UserID != 5 {
+		t.Errorf("unexpected latest revision: %+v", revisions[0])
+	}
+	if revisions[1].Source != RevisionSourceRegeneration || revisions[1].UserID != 0 {
+		t.Errorf("unexpected regeneration revision: %+v", revisions[1])
+	}
+}
+
+func TestSQLiteDiaryRevisionRepository_ReplaceDiaryContent_EditedDiary(t *testing.T) {
+	db := setupTestDB(t)
+	diaryRepo := NewSQLiteDiaryRepository(db)
+	repo := NewSQLiteDiaryRevisionRepository(db)
+
+	if err := diaryRepo.CreateDiary(t.Context(), "/path/to/image.jpg", "AIの日記", time.Now()); err != nil {
+		t.Fatalf("CreateDiary failed: %v", err)
+	}
+	if err := diaryRepo.UpdateDiaryContent(t.Context(), 1, "手で直した日記"); err != nil {
+		t.Fatalf("UpdateDiaryContent failed: %v", err)
+	}
+
+	previousID, err := repo.ReplaceDiaryContent(t.Context(), 1, "再生成した日記", RevisionSourceRegeneration, 0)
+	if err != nil {
+		t.Fatalf("ReplaceDiaryContent failed: %v", err)
+	}
+	previous, err := repo.GetRevisionByID(t.Context(), previousID)
+	if err != nil {
+		t.Fatalf("GetRevisionByID failed: %v", err)
+	}
+	if previous.Content != "手で直した日記" || previous.Source != RevisionSourceOriginal {
+		t.Errorf("unexpected snapshot revision: %+v", previous)
+	}
+}
+
+func TestSQLiteDiaryRevisionRepository_ReplaceDiaryContent_NotFound(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRevisionRepository(db)
+
+	if _, err := repo.ReplaceDiaryContent(t.Context(), 999, "日記", RevisionSourceRegeneration, 0); err == nil {
+		t.Error("expected error for non-existent diary, got nil")
+	}
+
+	revision, err := repo.GetRevisionByID(t.Context(), 999)
+	if err != nil {
+		t.Fatalf("GetRevisionByID failed: %v", err)
+	}
+	if revision != nil {
+		t.Errorf("expected nil for non-existent revision, got %+v", revision)
+	}
+}
+
+func TestSQLiteDiaryRevisionRepository_ImplementsInterface(t *testing.T) {
+	db := setupTestDB(t)
+	var _ DiaryRevisionRepository = NewSQLiteDiaryRevisionRepository(db)
+}
+
+func TestSQLiteDiaryRepository_GetDiariesByPlantID(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	if err := repo.CreateDiaryForUser(t.Context(), 1, 1, "/path/1.jpg", "植物1の古い日記", base); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	if err := repo.CreateDiaryForUser(t.Context(), 1, 1, "/path/2.jpg", "植物1の新しい日記", base.Add(time.Hour)); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	if err := repo.CreateDiaryForUser(t.Context(), 1, 2, "/path/3.jpg", "植物2の日記", base); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	if err := repo.CreateDiaryForUser(t.Context(), 1, 0, "/path/4.jpg", "植物未指定の日記", base); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+
+	diaries, err := repo.GetDiariesByPlantID(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("GetDiariesByPlantID failed: %v", err)
+	}
+	if len(diaries) != 2 {
+		t.Fatalf("expected 2 diaries, got %d", len(diaries))
+	}
+	if diaries[0].Content != "植物1の新しい日記" || diaries[1].Content != "植物1の古い日記" {
+		t.Errorf("expected newest first, got %q, %q", diaries[0].Content, diaries[1].Content)
+	}
+	if diaries[0].PlantID != 1 {
+		t.Errorf("expected plant ID 1, got %d", diaries[0].PlantID)
+	}
+
+	// 植物未指定の日記はPlantIDが0になる
+	diary, err := repo.GetDiaryByID(t.Context(), 4)
+	if err != nil {
+		t.Fatalf("GetDiaryByID failed: %v", err)
+	}
+	if diary.PlantID != 0 {
+		t.Errorf("expected plant ID 0, got %d", diary.PlantID)
+	}
+}
+
+func TestSQLiteDiaryRepository_QueryDiaries(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+
+	base := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	contents := []string{"葉が出た", "花が咲いた", "葉が増えた", "実がなった"}
+	for i, c := range contents {
+		if err := repo.CreateDiaryForUser(t.Context(), 1, 0, "/path/"+c+".jpg", c, base.AddDate(0, 0, i)); err != nil {
+			t.Fatalf("CreateDiaryForUser failed: %v", err)
+		}
+	}
+	if err := repo.CreateDiaryForUser(t.Context(), 2, 0, "/path/other.jpg", "他のユーザーの葉", base); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		query       DiaryQuery
+		wantContent []string
+		wantTotal   int
+	}{
+		{
+			name:        "条件なしは新着順で全件",
+			query:       DiaryQuery{},
+			wantContent: []string{"実がなった", "葉が増えた", "花が咲いた", "葉が出た"},
+			wantTotal:   4,
+		},
+		{
+			name:        "キーワードで絞り込み",
+			query:       DiaryQuery{Keyword: "葉"},
+			wantContent: []string{"葉が増えた", "葉が出た"},
+			wantTotal:   2,
+		},
+		{
+			name:        "期間で絞り込み（両端を含む）",
+			query:       DiaryQuery{From: base.AddDate(0, 0, 1), To: base.AddDate(0, 0, 2)},
+			wantContent: []string{"葉が増えた", "花が咲いた"},
+			wantTotal:   2,
+		},
+		{
+			name:        "ページングしても総数は絞り込み後の件数",
+			query:       DiaryQuery{Limit: 2, Offset: 2},
+			wantContent: []string{"花が咲いた", "葉が出た"},
+			wantTotal:   4,
+		},
+		{
+			name:        "範囲外のページは空",
+			query:       DiaryQuery{Limit: 2, Offset: 4},
+			wantContent: nil,
+			wantTotal:   4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diaries, total, err := repo.QueryDiaries(t.Context(), OwnerScope{UserID: 1}, tt.query)
+			if err != nil {
+				t.Fatalf("QueryDiaries failed: %v", err)
+			}
+			if total != tt.wantTotal {
+				t.Errorf("expected total %d, got %d", tt.wantTotal, total)
+			}
+			if len(diaries) != len(tt.wantContent) {
+				t.Fatalf("expected %d diaries, got %d", len(tt.wantContent), len(diaries))
+			}
+			for i, want := range tt.wantContent {
+				if diaries[i].Content != want {
+					t.Errorf("diaries[%d]: expected %q, got %q", i, want, diaries[i].Content)
+				}
+			}
+		})
+	}
+}
+
+func TestSQLiteDiaryRepository_DeleteAndRestore(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+	plantRepo := NewSQLitePlantRepository(db)
+
+	if err := plantRepo.CreatePlant(t.Context(), "plant1", 1, "ミニトマト", "", "", time.Time{}); err != nil {
+		t.Fatalf("CreatePlant failed: %v", err)
+	}
+	plant, _ := plantRepo.GetPlantByUUID(t.Context(), "plant1")
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	if err := repo.CreateDiaryForUser(t.Context(), 1, plant.ID, "/path/1.jpg", "削除する日記", base); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	if err := repo.CreateDiaryForUser(t.Context(), 1, plant.ID, "/path/2.jpg", "残す日記", base.Add(-time.Hour)); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	if err := plantRepo.SetPlantCover(t.Context(), plant.ID, 1); err != nil {
+		t.Fatalf("SetPlantCover failed: %v", err)
+	}
+
+	if err := repo.DeleteDiary(t.Context(), 1); err != nil {
+		t.Fatalf("DeleteDiary failed: %v", err)
+	}
+
+	// ゴミ箱の日記は通常の取得対象外
+	diary, err := repo.GetDiaryByID(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("GetDiaryByID failed: %v", err)
+	}
+	if diary != nil {
+		t.Errorf("expected deleted diary to be hidden, got %+v", diary)
+	}
+	all, err := repo.GetAllDiaries(t.Context(), OwnerScope{})
+	if err != nil {
+		t.Fatalf("GetAllDiaries failed: %v", err)
+	}
+	if len(all) != 1 || all[0].ID != 2 {
+		t.Errorf("expected only diary 2, got %+v", all)
+	}
+	byPlant, err := repo.GetDiariesByPlantID(t.Context(), plant.ID)
+	if err != nil {
+		t.Fatalf("GetDiariesByPlantID failed: %v", err)
+	}
+	if len(byPlant) != 1 {
+		t.Errorf("expected 1 diary of plant, got %d", len(byPlant))
+	}
+	// 写真は処理済みのまま扱い、ポーリングで再登録しない
+	processed, err := repo.IsImageProcessed(t.Context(), "/path/1.jpg")
+	if err != nil {
+		t.Fatalf("IsImageProcessed failed: %v", err)
+	}
+	if !processed {
+		t.Error("expected photo of deleted diary to remain processed")
+	}
+	// 表紙に指定した日記がゴミ箱にある場合は最新の日記の写真を表紙にする
+	plant, _ = plantRepo.GetPlantByUUID(t.Context(), "plant1")
+	if plant.CoverImagePath != "/path/2.jpg" {
+		t.Errorf("expected cover to fall back to remaining diary, got %q", plant.CoverImagePath)
+	}
+
+	deleted, err := repo.GetDeletedDiaries(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("GetDeletedDiaries failed: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0].ID != 1 || deleted[0].DeletedAt.IsZero() {
+		t.Fatalf("expected diary 1 in trash, got %+v", deleted)
+	}
+	deleted, err = repo.GetDeletedDiaries(t.Context(), 2)
+	if err != nil {
+		t.Fatalf("GetDeletedDiaries failed: %v", err)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("expected empty trash for other user, got %d", len(deleted))
+	}
+
+	if err := repo.DeleteDiary(t.Context(), 1); err == nil {
+		t.Error("expected error for diary already in trash, got nil")
+	}
+
+	if err := repo.RestoreDiary(t.Context(), 1); err != nil {
+		t.Fatalf("RestoreDiary failed: %v", err)
+	}
+	diary, err = repo.GetDiaryByID(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("GetDiaryByID failed: %v", err)
+	}
+	if diary == nil || !diary.DeletedAt.IsZero() {
+		t.Errorf("expected restored diary, got %+v", diary)
+	}
+	plant, _ = plantRepo.GetPlantByUUID(t.Context(), "plant1")
+	if plant.CoverImagePath != "/path/1.jpg" {
+		t.Errorf("expected restored cover, got %q", plant.CoverImagePath)
+	}
+
+	if err := repo.RestoreDiary(t.Context(), 1); err == nil {
+		t.Error("expected error for diary not in trash, got nil")
+	}
+}
+
+func TestSQLiteDiaryRepository_PurgeDiary(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+	revisionRepo := NewSQLiteDiaryRevisionRepository(db)
+	plantRepo := NewSQLitePlantRepository(db)
+
+	if err := plantRepo.CreatePlant(t.Context(), "plant1", 1, "ミニトマト", "", "", time.Time{}); err != nil {
+		t.Fatalf("CreatePlant failed: %v", err)
+	}
+	plant, _ := plantRepo.GetPlantByUUID(t.Context(), "plant1")
+	if err := repo.CreateDiaryForUser(t.Context(), 1, plant.ID, "/path/1.jpg", "削除する日記", time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	// 版履歴と表紙の指定がある日記も完全に削除できる
+	if _, err := revisionRepo.ReplaceDiaryContent(t.Context(), 1, "編集した日記", RevisionSourceManualEdit, 1); err != nil {
+		t.Fatalf("ReplaceDiaryContent failed: %v", err)
+	}
+	if err := plantRepo.SetPlantCover(t.Context(), plant.ID, 1); err != nil {
+		t.Fatalf("SetPlantCover failed: %v", err)
+	}
+
+	// ゴミ箱にない日記は完全に削除できない
+	if _, err := repo.PurgeDiary(t.Context(), 1); err == nil {
+		t.Error("expected error for diary not in trash, got nil")
+	}
+
+	if err := repo.DeleteDiary(t.Context(), 1); err != nil {
+		t.Fatalf("DeleteDiary failed: %v", err)
+	}
+	if _, err := repo.PurgeDiary(t.Context(), 1); err != nil {
+		t.Fatalf("PurgeDiary failed: %v", err)
+	}
+
+	deleted, err := repo.GetDeletedDiaries(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("GetDeletedDiaries failed: %v", err)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("expected empty trash, got %d", len(deleted))
+	}
+	revisions, err := revisionRepo.GetRevisionsByDiaryID(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("GetRevisionsByDiaryID failed: %v", err)
+	}
+	if len(revisions) != 0 {
+		t.Errorf("expected revisions to be deleted, got %d", len(revisions))
+	}
+	processed, err := repo.IsImageProcessed(t.Context(), "/path/1.jpg")
+	if err != nil {
+		t.Fatalf("IsImageProcessed failed: %v", err)
+	}
+	if processed {
+		t.Error("expected purged diary to be removed")
+	}
+	plant, _ = plantRepo.GetPlantByUUID(t.Context(), "plant1")
+	if plant.CoverDiaryID != 0 || plant.CoverImagePath != "" {
+		t.Errorf("unexpected cover: diary %d, path %q", plant.CoverDiaryID, plant.CoverImagePath)
+	}
+}
+
+func TestSQLitePlantRepository_CreateAndGet(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLitePlantRepository(db)
+
+	acquiredOn := time.Date(2025, 5, 3, 0, 0, 0, 0, time.UTC)
+	if err := repo.CreatePlant(t.Context(), "plant1", 1, "ミニトマト", "アイコ", "ベランダ", acquiredOn); err != nil {
+		t.Fatalf("CreatePlant failed: %v", err)
+	}
+	if err := repo.CreatePlant(t.Context(), "plant2", 1, "バジル", "", "", time.Time{}); err != nil {
+		t.Fatalf("CreatePlant failed: %v", err)
+	}
+
+	plant, err := repo.GetPlantByUUID(t.Context(), "plant1")
+	if err != nil {
+		t.Fatalf("GetPlantByUUID failed: %v", err)
+	}
+	if plant == nil {
+		t.Fatal("expected plant, got nil")
+	}
+	if plant.Name != "ミニトマト" || plant.Species != "アイコ" || plant.Location != "ベランダ" || plant.UserID != 1 {
+		t.Errorf("unexpected plant: %+v", plant)
+	}
+	if !plant.AcquiredOn.Equal(acquiredOn) {
+		t.Errorf("expected acquired on %v, got %v", acquiredOn, plant.AcquiredOn)
+	}
+
+	byID, err := repo.GetPlantByID(t.Context(), plant.ID)
+	if err != nil {
+		t.Fatalf("GetPlantByID failed: %v", err)
+	}
+	if byID == nil || byID.UUID != "plant1" {
+		t.Errorf("unexpected plant by ID: %+v", byID)
+	}
+
+	plants, err := repo.GetAllPlants(t.Context(), OwnerScope{})
+	if err != nil {
+		t.Fatalf("GetAllPlants failed: %v", err)
+	}
+	if len(plants) != 2 || plants[0].UUID != "plant1" || plants[1].UUID != "plant2" {
+		t.Fatalf("unexpected plants: %+v", plants)
+	}
+	if !plants[1].AcquiredOn.IsZero() {
+		t.Errorf("expected zero acquired on, got %v", plants[1].AcquiredOn)
+	}
+
+	// 存在しないUUIDはnil
+	missing, err := repo.GetPlantByUUID(t.Context(), "unknown")
+	if err != nil {
+		t.Fatalf("GetPlantByUUID failed: %v", err)
+	}
+	if missing != nil {
+		t.Errorf("expected nil for non-existent plant, got %+v", missing)
+	}
+}
+
+func TestSQLitePlantRepository_Cover(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLitePlantRepository(db)
+	diaryRepo := NewSQLiteDiaryRepository(db)
+
+	if err := repo.CreatePlant(t.Context(), "plant1", 1, "ミニトマト", "", "", time.Time{}); err != nil {
+		t.Fatalf("CreatePlant failed: %v", err)
+	}
+
+	// 日記がない場合、表紙は空
+	plant, err := repo.GetPlantByUUID(t.Context(), "plant1")
+	if err != nil {
+		t.Fatalf("GetPlantByUUID failed: %v", err)
+	}
+	if plant.CoverImagePath != "" {
+		t.Errorf("expected empty cover, got %q", plant.CoverImagePath)
+	}
+
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	if err := diaryRepo.CreateDiaryForUser(t.Context(), 1, plant.ID, "/path/old.jpg", "古い日記", base); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	if err := diaryRepo.CreateDiaryForUser(t.Context(), 1, plant.ID, "/path/new.jpg", "新しい日記", base.Add(time.Hour)); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	if err := diaryRepo.CreateDiaryForUser(t.Context(), 1, 0, "/path/other.jpg", "別の日記", base); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+
+	// 表紙未指定の場合は最新の日記の写真
+	plant, err = repo.GetPlantByUUID(t.Context(), "plant1")
+	if err != nil {
+		t.Fatalf("GetPlantByUUID failed: %v", err)
+	}
+	if plant.CoverImagePath != "/path/new.jpg" {
+		t.Errorf("expected latest photo as cover, got %q", plant.CoverImagePath)
+	}
+
+	if err := repo.SetPlantCover(t.Context(), plant.ID, 1); err != nil {
+		t.Fatalf("SetPlantCover failed: %v", err)
+	}
+	plant, err = repo.GetPlantByUUID(t.Context(), "plant1")
+	if err != nil {
+		t.Fatalf("GetPlantByUUID failed: %v", err)
+	}
+	if plant.CoverDiaryID != 1 || plant.CoverImagePath != "/path/old.jpg" {
+		t.Errorf("unexpected cover: diary %d, path %q", plant.CoverDiaryID, plant.CoverImagePath)
+	}
+
+	// 他の植物の日記は表紙にできない
+	if err := repo.SetPlantCover(t.Context(), plant.ID, 3); err == nil {
+		t.Error("expected error for diary of another plant, got nil")
+	}
+}
+
+func TestSQLitePlantRepository_ImplementsInterface(t *testing.T) {
+	db := setupTestDB(t)
+	var _ PlantRepository = NewSQLitePlantRepository(db)
+}
+
+func TestSQLiteAPITokenRepository_CreateAndGet(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteAPITokenRepository(db)
+
+	if err := repo.CreateAPIToken(t.Context(), 1, "ベランダのカメラ", "hash1", "pdt_0123abcd", APITokenScopeUpload); err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+	if err := repo.CreateAPIToken(t.Context(), 1, "閲覧用", "hash2", "pdt_4567efgh", APITokenScopeRead); err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+	if err := repo.CreateAPIToken(t.Context(), 2, "bobのカメラ", "hash3", "pdt_89abijkl", APITokenScopeAdmin); err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+
+	// 同じハッシュ値のトークンはUNIQUE制約エラー
+	if err := repo.CreateAPIToken(t.Context(), 1, "重複", "hash1", "pdt_0123abcd", APITokenScopeUpload); err == nil {
+		t.Error("expected error for duplicate token hash, got nil")
+	}
+
+	token, err := repo.GetAPITokenByHash(t.Context(), "hash1")
+	if err != nil {
+		t.Fatalf("GetAPITokenByHash failed: %v", err)
+	}
+	if token == nil {
+		t.Fatal("expected token, got nil")
+	}
+	if token.UserID != 1 || token.Name != "ベランダのカメラ" || token.Prefix != "pdt_0123abcd" || token.Scope != APITokenScopeUpload {
+		t.Errorf("unexpected token: %+v", token)
+	}
+	if !token.LastUsedAt.IsZero() {
+		t.Errorf("expected zero last used at, got %v", token.LastUsedAt)
+	}
+
+	tokens, err := repo.GetAPITokensByUserID(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("GetAPITokensByUserID failed: %v", err)
+	}
+	if len(tokens) != 2 || tokens[0].TokenHash != "hash1" || tokens[1].TokenHash != "hash2" {
+		t.Errorf("unexpected tokens: %+v", tokens)
+	}
+
+	// 存在しないハッシュ値はnil
+	missing, err := repo.GetAPITokenByHash(t.Context(), "unknown")
+	if err != nil {
+		t.Fatalf("GetAPITokenByHash failed: %v", err)
+	}
+	if missing != nil {
+		t.Errorf("expected nil for unknown token, got %+v", missing)
+	}
+}
+
+func TestSQLiteAPITokenRepository_TouchAndRevoke(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteAPITokenRepository(db)
+
+	if err := repo.CreateAPIToken(t.Context(), 1, "カメラ", "hash1", "pdt_0123abcd", APITokenScopeUpload); err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+	token, err := repo.GetAPITokenByHash(t.Context(), "hash1")
+	if err != nil || token == nil {
+		t.Fatalf("GetAPITokenByHash failed: %v", err)
+	}
+
+	usedAt := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	if err := repo.TouchAPIToken(t.Context(), token.ID, usedAt); err != nil {
+		t.Fatalf("TouchAPIToken failed: %v", err)
+	}
+	token, err = repo.GetAPITokenByHash(t.Context(), "hash1")
+	if err != nil || token == nil {
+		t.Fatalf("GetAPITokenByHash failed: %v", err)
+	}
+	if !token.LastUsedAt.Equal(usedAt) {
+		t.Errorf("expected last used at %v, got %v", usedAt, token.LastUsedAt)
+	}
+
+	// 他のユーザーのトークンは失効できない
+	if err := repo.RevokeAPIToken(t.Context(), token.ID, 2); err == nil {
+		t.Error("expected error for token of another user, got nil")
+	}
+
+	if err := repo.RevokeAPIToken(t.Context(), token.ID, 1); err != nil {
+		t.Fatalf("RevokeAPIToken failed: %v", err)
+	}
+
+	// 失効済みのトークンは取得できない
+	revoked, err := repo.GetAPITokenByHash(t.Context(), "hash1")
+	if err != nil {
+		t.Fatalf("GetAPITokenByHash failed: %v", err)
+	}
+	if revoked != nil {
+		t.Errorf("expected nil for revoked token, got %+v", revoked)
+	}
+	tokens, err := repo.GetAPITokensByUserID(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("GetAPITokensByUserID failed: %v", err)
+	}
+	if len(tokens) != 0 {
+		t.Errorf("expected no tokens, got %+v", tokens)
+	}
+
+	// 失効済みのトークンを再度失効させるとエラー
+	if err := repo.RevokeAPIToken(t.Context(), token.ID, 1); err == nil {
+		t.Error("expected error for revoked token, got nil")
+	}
+}
+
+func TestSQLiteAPITokenRepository_ImplementsInterface(t *testing.T) {
+	db := setupTestDB(t)
+	var _ APITokenRepository = NewSQLiteAPITokenRepository(db)
+}
+
+func TestSQLitePromptTemplateRepository_SaveAndGet(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLitePromptTemplateRepository(db)
+
+	// 設定がない場合はnil
+	missing, err := repo.GetPromptTemplate(t.Context(), 1, 0)
+	if err != nil {
+		t.Fatalf("GetPromptTemplate failed: %v", err)
+	}
+	if missing != nil {
+		t.Errorf("expected nil for missing template, got %+v", missing)
+	}
+
+	if err := repo.SavePromptTemplate(t.Context(), 1, 0, PersonaHaiku, ""); err != nil {
+		t.Fatalf("SavePromptTemplate failed: %v", err)
+	}
+	if err := repo.SavePromptTemplate(t.Context(), 1, 3, PersonaCustom, "{{.PlantName}}の日記を書いてください。"); err != nil {
+		t.Fatalf("SavePromptTemplate failed: %v", err)
+	}
+	if err := repo.SavePromptTemplate(t.Context(), 2, 0, PersonaChild, ""); err != nil {
+		t.Fatalf("SavePromptTemplate failed: %v", err)
+	}
+
+	// 同じ対象への保存は上書きする
+	if err := repo.SavePromptTemplate(t.Context(), 1, 0, PersonaBotanist, ""); err != nil {
+		t.Fatalf("SavePromptTemplate failed: %v", err)
+	}
+	setting, err := repo.GetPromptTemplate(t.Context(), 1, 0)
+	if err != nil {
+		t.Fatalf("GetPromptTemplate failed: %v", err)
+	}
+	if setting == nil || setting.Persona != PersonaBotanist || setting.Template != "" || setting.UpdatedAt.IsZero() {
+		t.Errorf("unexpected template: %+v", setting)
+	}
+
+	settings, err := repo.GetPromptTemplatesByUserID(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("GetPromptTemplatesByUserID failed: %v", err)
+	}
+	if len(settings) != 2 || settings[0].PlantID != 0 || settings[1].PlantID != 3 || settings[1].Template != "{{.PlantName}}の日記を書いてください。" {
+		t.Errorf("unexpected templates: %+v", settings)
+	}
+
+	if err := repo.DeletePromptTemplate(t.Context(), 1, 3); err != nil {
+		t.Fatalf("DeletePromptTemplate failed: %v", err)
+	}
+	deleted, err := repo.GetPromptTemplate(t.Context(), 1, 3)
+	if err != nil {
+		t.Fatalf("GetPromptTemplate failed: %v", err)
+	}
+	if deleted != nil {
+		t.Errorf("expected nil for deleted template, got %+v", deleted)
+	}
+}
+
+func TestSQLitePromptTemplateRepository_ImplementsInterface(t *testing.T) {
+	db := setupTestDB(t)
+	var _ PromptTemplateRepository = NewSQLitePromptTemplateRepository(db)
+}
+
+func TestSQLiteDiarySummaryRepository_SaveAndGet(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiarySummaryRepository(db)
+
+	if err := repo.SaveDiarySummary(t.Context(), 1, 0, SummaryPeriodMonth, "2026-01-01", "hash1", "1月の要約"); err != nil {
+		t.Fatalf("SaveDiarySummary failed: %v", err)
+	}
+	if err := repo.SaveDiarySummary(t.Context(), 1, 0, SummaryPeriodWeek, "2026-02-01", "hash2", "2月第1週の要約"); err != nil {
+		t.Fatalf("SaveDiarySummary failed: %v", err)
+	}
+	if err := repo.SaveDiarySummary(t.Context(), 1, 0, SummaryPeriodMonth, "2025-12-01", "hash3", "12月の要約"); err != nil {
+		t.Fatalf("SaveDiarySummary failed: %v", err)
+	}
+	if err := repo.SaveDiarySummary(t.Context(), 2, 0, SummaryPeriodMonth, "2026-01-01", "hash4", "別のユーザーの要約"); err != nil {
+		t.Fatalf("SaveDiarySummary failed: %v", err)
+	}
+
+	// 同じ期間への保存は上書きする
+	if err := repo.SaveDiarySummary(t.Context(), 1, 0, SummaryPeriodMonth, "2026-01-01", "hash5", "作り直した1月の要約"); err != nil {
+		t.Fatalf("SaveDiarySummary failed: %v", err)
+	}
+
+	// 指定日以降の、指定ユーザーの要約のみを古い順に返す
+	summaries, err := repo.GetDiarySummaries(t.Context(), 1, 0, "2026-01-01")
+	if err != nil {
+		t.Fatalf("GetDiarySummaries failed: %v", err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("expected 2 summaries, got %+v", summaries)
+	}
+	if summaries[0].StartDate != "2026-01-01" || summaries[0].SourceHash != "hash5" || summaries[0].Content != "作り直した1月の要約" || summaries[0].CreatedAt.IsZero() {
+		t.Errorf("unexpected first summary: %+v", summaries[0])
+	}
+	if summaries[1].Period != SummaryPeriodWeek || summaries[1].StartDate != "2026-02-01" {
+		t.Errorf("unexpected second summary: %+v", summaries[1])
+	}
+}
+
+func TestSQLiteDiaryRepository_PurgeDiary_RemovesSummaries(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+	summaryRepo := NewSQLiteDiarySummaryRepository(db)
+
+	// JSTで 2026-02-10 の日記
+	if err := repo.CreateDiaryForUser(t.Context(), 1, 0, "/path/1.jpg", "削除する日記", time.Date(2026, 2, 9, 16, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	for _, s := range []struct{ period, startDate string }{
+		{SummaryPeriodWeek, "2026-02-08"},
+		{SummaryPeriodMonth, "2026-02-01"},
+		{SummaryPeriodWeek, "2026-02-01"},
+	} {
+		if err := summaryRepo.SaveDiarySummary(t.Context(), 1, 0, s.period, s.startDate, "hash", "要約"); err != nil {
+			t.Fatalf("SaveDiarySummary failed: %v", err)
+		}
+	}
+
+	if err := repo.DeleteDiary(t.Context(), 1); err != nil {
+		t.Fatalf("DeleteDiary failed: %v", err)
+	}
+	if _, err := repo.PurgeDiary(t.Context(), 1); err != nil {
+		t.Fatalf("PurgeDiary failed: %v", err)
+	}
+
+	// 日記を含む週・月の要約のみを削除する
+	summaries, err := summaryRepo.GetDiarySummaries(t.Context(), 1, 0, "2026-01-01")
+	if err != nil {
+		t.Fatalf("GetDiarySummaries failed: %v", err)
+	}
+	if len(summaries) != 1 || summaries[0].Period != SummaryPeriodWeek || summaries[0].StartDate != "2026-02-01" {
+		t.Errorf("unexpected summaries after purge: %+v", summaries)
+	}
+}
+
+func TestSQLiteDiaryRepository_PurgeDiary_RemovesPlantSummaries(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+	summaryRepo := NewSQLiteDiarySummaryRepository(db)
+
+	// JSTで 2026-02-10 の植物1の日記
+	if err := repo.CreateDiaryForUser(t.Context(), 1, 1, "/path/1.jpg", "削除する日記", time.Date(2026, 2, 9, 16, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	for _, plantID := range []int{0, 1, 2} {
+		if err := summaryRepo.SaveDiarySummary(t.Context(), 1, plantID, SummaryPeriodMonth, "2026-02-01", "hash", "要約"); err != nil {
+			t.Fatalf("SaveDiarySummary failed: %v", err)
+		}
+	}
+
+	if err := repo.DeleteDiary(t.Context(), 1); err != nil {
+		t.Fatalf("DeleteDiary failed: %v", err)
+	}
+	if _, err := repo.PurgeDiary(t.Context(), 1); err != nil {
+		t.Fatalf("PurgeDiary failed: %v", err)
+	}
+
+	// 日記の植物とユーザーの全ての日記の要約を削除し、他の植物の要約は残す
+	for _, tt := range []struct {
+		plantID int
+		want    int
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 1},
+	} {
+		summaries, err := summaryRepo.GetDiarySummaries(t.Context(), 1, tt.plantID, "2026-01-01")
+		if err != nil {
+			t.Fatalf("GetDiarySummaries failed: %v", err)
+		}
+		if len(summaries) != tt.want {
+			t.Errorf("plant %d: expected %d summaries after purge, got %+v", tt.plantID, tt.want, summaries)
+		}
+	}
+}
+
+func TestSQLiteDiarySummaryRepository_ImplementsInterface(t *testing.T) {
+	db := setupTestDB(t)
+	var _ DiarySummaryRepository = NewSQLiteDiarySummaryRepository(db)
+}
+
+func TestSQLiteUsageRepository_Records(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteUsageRepository(db)
+
+	base := time.Date(2026, 3, 10, 3, 0, 0, 0, time.UTC)
+	records := []UsageRecord{
+		{UserID: 1, DiaryID: 10, Provider: ProviderGemini, Model: "gemini-2.5-flash", PromptTokens: 1000, ResponseTokens: 200, Cost: 0.0008, Latency: 1500 * time.Millisecond, CreatedAt: base},
+		{UserID: 1, DiaryID: 11, Provider: ProviderGemini, Model: "gemini-2.5-flash", PromptTokens: 2000, ResponseTokens: 400, Cost: 0.0016, CreatedAt: base.Add(time.Hour)},
+		{UserID: 2, Provider: ProviderTemplate, CreatedAt: base.Add(2 * time.Hour)},
+		{UserID: 1, DiaryID: 12, Cost: 0.5, CreatedAt: base.AddDate(0, 0, 1)},
+	}
+	for _, r := range records {
+		if err := repo.CreateUsageRecord(t.Context(), r); err != nil {
+			t.Fatalf("CreateUsageRecord failed: %v", err)
+		}
+	}
+
+	got, err := repo.GetUsageRecords(t.Context(), OwnerScope{UserID: 1}, base, base.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("GetUsageRecords failed: %v", err)
+	}
+	if len(got) != 2 || got[0].DiaryID != 10 || got[1].DiaryID != 11 {
+		t.Fatalf("unexpected records: %+v", got)
+	}
+	if got[0].Model != "gemini-2.5-flash" || got[0].PromptTokens != 1000 || got[0].ResponseTokens != 200 || got[0].Latency != 1500*time.Millisecond {
+		t.Errorf("unexpected record: %+v", got[0])
+	}
+
+	all, err := repo.GetUsageRecords(t.Context(), OwnerScope{}, base, base.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatalf("GetUsageRecords failed: %v", err)
+	}
+	if len(all) != 4 || all[2].UserID != 2 || all[2].DiaryID != 0 {
+		t.Errorf("unexpected records of all users: %+v", all)
+	}
+
+	cost, err := repo.GetUsageCost(t.Context(), 1, base, base.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("GetUsageCost failed: %v", err)
+	}
+	if cost < 0.0023999 || cost > 0.0024001 {
+		t.Errorf("expected cost 0.0024, got %f", cost)
+	}
+	if cost, err := repo.GetUsageCost(t.Context(), 3, base, base.AddDate(0, 0, 2)); err != nil || cost != 0 {
+		t.Errorf("expected no cost for user without records, got %f, %v", cost, err)
+	}
+}
+
+func TestSQLiteUsageRepository_Budgets(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteUsageRepository(db)
+
+	if budget, err := repo.GetUsageBudget(t.Context(), 1); err != nil || budget != nil {
+		t.Fatalf("expected no budget, got %+v, %v", budget, err)
+	}
+
+	if err := repo.SaveUsageBudget(t.Context(), 0, 1, 20); err != nil {
+		t.Fatalf("SaveUsageBudget failed: %v", err)
+	}
+	if err := repo.SaveUsageBudget(t.Context(), 1, 0.5, 5); err != nil {
+		t.Fatalf("SaveUsageBudget failed: %v", err)
+	}
+	// 保存済みの予算は上書きする
+	if err := repo.SaveUsageBudget(t.Context(), 1, 0.8, 0); err != nil {
+		t.Fatalf("SaveUsageBudget failed: %v", err)
+	}
+
+	budget, err := repo.GetUsageBudget(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("GetUsageBudget failed: %v", err)
+	}
+	if budget == nil || budget.DailyLimit != 0.8 || budget.MonthlyLimit != 0 {
+		t.Errorf("unexpected budget: %+v", budget)
+	}
+
+	budgets, err := repo.GetUsageBudgets(t.Context())
+	if err != nil {
+		t.Fatalf("GetUsageBudgets failed: %v", err)
+	}
+	if len(budgets) != 2 || budgets[0].UserID != 0 || budgets[1].UserID != 1 {
+		t.Errorf("unexpected budgets: %+v", budgets)
+	}
+
+	if err := repo.DeleteUsageBudget(t.Context(), 1); err != nil {
+		t.Fatalf("DeleteUsageBudget failed: %v", err)
+	}
+	if budget, err := repo.GetUsageBudget(t.Context(), 1); err != nil || budget != nil {
+		t.Errorf("expected budget to be deleted, got %+v, %v", budget, err)
+	}
+}
+
+func TestSQLiteUsageRepository_ImplementsInterface(t *testing.T) {
+	db := setupTestDB(t)
+	var _ UsageRepository = NewSQLiteUsageRepository(db)
+}
+
+func TestSQLitePhotoMetadataRepository_SaveAndGet(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLitePhotoMetadataRepository(db)
+
+	// 撮影情報がない場合はnilを返す
+	if meta, err := repo.GetPhotoMetadata(t.Context(), "/path/1.jpg"); err != nil || meta != nil {
+		t.Fatalf("expected nil metadata, got %+v, %v", meta, err)
+	}
+
+	capturedAt := time.Date(2026, 2, 1, 3, 4, 5, 0, time.UTC)
+	lat, lon := 35.6812, 139.7671
+	want := PhotoMetadata{
+		ImagePath: "/path/1.jpg", CapturedAt: &capturedAt, Orientation: 6,
+		CameraMake: "Canon", CameraModel: "EOS", ExposureTime: "1/125", FNumber: 2.8, ISO: 100, FocalLength: 35,
+		Latitude: &lat, Longitude: &lon,
+	}
+	if err := repo.SavePhotoMetadata(t.Context(), want); err != nil {
+		t.Fatalf("SavePhotoMetadata failed: %v", err)
+	}
+	got, err := repo.GetPhotoMetadata(t.Context(), "/path/1.jpg")
+	if err != nil {
+		t.Fatalf("GetPhotoMetadata failed: %v", err)
+	}
+	if got == nil || got.CapturedAt == nil || !got.CapturedAt.Equal(capturedAt) || got.Orientation != 6 ||
+		got.CameraMake != "Canon" || got.CameraModel != "EOS" || got.ExposureTime != "1/125" ||
+		got.FNumber != 2.8 || got.ISO != 100 || got.FocalLength != 35 || got.Coordinates() != "35.68120, 139.76710" {
+		t.Errorf("unexpected metadata: %+v", got)
+	}
+
+	// 同じ写真の撮影情報は置き換える
+	if err := repo.SavePhotoMetadata(t.Context(), PhotoMetadata{ImagePath: "/path/1.jpg", CameraModel: "Pixel"}); err != nil {
+		t.Fatalf("SavePhotoMetadata failed: %v", err)
+	}
+	got, err = repo.GetPhotoMetadata(t.Context(), "/path/1.jpg")
+	if err != nil {
+		t.Fatalf("GetPhotoMetadata failed: %v", err)
+	}
+	if got.CameraModel != "Pixel" || got.CapturedAt != nil || got.Latitude != nil || got.Coordinates() != "" {
+		t.Errorf("expected replaced metadata, got %+v", got)
+	}
+}
+
+func TestSQLiteDiaryRepository_PurgeDiary_RemovesPhotoMetadata(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+	metaRepo := NewSQLitePhotoMetadataRepository(db)
+
+	if err := repo.CreateDiaryForUser(t.Context(), 1, 0, "/path/1.jpg", "削除する日記", time.Now()); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	for _, path := range []string{"/path/1.jpg", "/path/2.jpg"} {
+		if err := metaRepo.SavePhotoMetadata(t.Context(), PhotoMetadata{ImagePath: path, CameraModel: "EOS"}); err != nil {
+			t.Fatalf("SavePhotoMetadata failed: %v", err)
+		}
+	}
+	if err := repo.DeleteDiary(t.Context(), 1); err != nil {
+		t.Fatalf("DeleteDiary failed: %v", err)
+	}
+	if _, err := repo.PurgeDiary(t.Context(), 1); err != nil {
+		t.Fatalf("PurgeDiary failed: %v", err)
+	}
+
+	if meta, err := metaRepo.GetPhotoMetadata(t.Context(), "/path/1.jpg"); err != nil || meta != nil {
+		t.Errorf("expected metadata to be removed, got %+v, %v", meta, err)
+	}
+	// 他の写真の撮影情報は残す
+	if meta, err := metaRepo.GetPhotoMetadata(t.Context(), "/path/2.jpg"); err != nil || meta == nil {
+		t.Errorf("expected other metadata to remain, got %+v, %v", meta, err)
+	}
+}
+
+func TestSQLitePhotoMetadataRepository_ImplementsInterface(t *testing.T) {
+	db := setupTestDB(t)
+	var _ PhotoMetadataRepository = NewSQLitePhotoMetadataRepository(db)
+}
+
+func TestSQLitePhotoHashRepository_SaveAndGet(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLitePhotoHashRepository(db)
+
+	// 記録がない場合はnilを返す
+	if hash, err := repo.GetPhotoHash(t.Context(), "/path/1.jpg"); err != nil || hash != nil {
+		t.Fatalf("expected nil photo hash, got %+v, %v", hash, err)
+	}
+
+	capturedAt := time.Date(2026, 2, 1, 3, 4, 5, 0, time.UTC)
+	want := PhotoHash{ImagePath: "/path/1.jpg", UserID: 1, PlantID: 2, Hash: 0xfedcba9876543210, CapturedAt: capturedAt}
+	if err := repo.SavePhotoHash(t.Context(), want); err != nil {
+		t.Fatalf("SavePhotoHash failed: %v", err)
+	}
+	got, err := repo.GetPhotoHash(t.Context(), "/path/1.jpg")
+	if err != nil {
+		t.Fatalf("GetPhotoHash failed: %v", err)
+	}
+	if got == nil || got.UserID != 1 || got.PlantID != 2 || got.Hash != want.Hash || !got.CapturedAt.Equal(capturedAt) ||
+		got.DuplicateOf != "" || got.DiaryID != 0 {
+		t.Errorf("unexpected photo hash: %+v", got)
+	}
+
+	// 日記を作成した写真は日記のIDを返す
+	diaryRepo := NewSQLiteDiaryRepository(db)
+	if err := diaryRepo.CreateDiaryForUser(t.Context(), 1, 2, "/path/1.jpg", "日記", capturedAt); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	got, err = repo.GetPhotoHash(t.Context(), "/path/1.jpg")
+	if err != nil || got == nil || got.DiaryID == 0 {
+		t.Errorf("expected diary id, got %+v, %v", got, err)
+	}
+
+	// 同じ写真の知覚ハッシュは置き換える
+	want.DuplicateOf = "/path/0.jpg"
+	if err := repo.SavePhotoHash(t.Context(), want); err != nil {
+		t.Fatalf("SavePhotoHash failed: %v", err)
+	}
+	if got, err := repo.GetPhotoHash(t.Context(), "/path/1.jpg"); err != nil || got.DuplicateOf != "/path/0.jpg" {
+		t.Errorf("expected replaced photo hash, got %+v, %v", got, err)
+	}
+}
+
+func TestSQLitePhotoHashRepository_FindPhotoHashes(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLitePhotoHashRepository(db)
+	diaryRepo := NewSQLiteDiaryRepository(db)
+
+	base := time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC)
+	for _, h := range []PhotoHash{
+		{ImagePath: "/path/late.jpg", UserID: 1, PlantID: 2, Hash: 1, CapturedAt: base.Add(2 * time.Hour)},
+		{ImagePath: "/path/early.jpg", UserID: 1, PlantID: 2, Hash: 2, CapturedAt: base.Add(-time.Hour)},
+		{ImagePath: "/path/trashed.jpg", UserID: 1, PlantID: 2, Hash: 3, CapturedAt: base},
+		{ImagePath: "/path/other-plant.jpg", UserID: 1, PlantID: 3, Hash: 4, CapturedAt: base},
+		{ImagePath: "/path/other-user.jpg", UserID: 2, PlantID: 2, Hash: 5, CapturedAt: base},
+	} {
+		if err := repo.SavePhotoHash(t.Context(), h); err != nil {
+			t.Fatalf("SavePhotoHash failed: %v", err)
+		}
+	}
+	// ゴミ箱の日記の写真は含まない
+	if err := diaryRepo.CreateDiaryForUser(t.Context(), 1, 2, "/path/trashed.jpg", "日記", base); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	trashed, err := repo.GetPhotoHash(t.Context(), "/path/trashed.jpg")
+	if err != nil || trashed == nil {
+		t.Fatalf("GetPhotoHash failed: %+v, %v", trashed, err)
+	}
+	if err := diaryRepo.DeleteDiary(t.Context(), trashed.DiaryID); err != nil {
+		t.Fatalf("DeleteDiary failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     []string
+	}{
+		{"all", time.Time{}, time.Time{}, []string{"/path/early.jpg", "/path/late.jpg"}},
+		{"from", base, time.Time{}, []string{"/path/late.jpg"}},
+		{"range", base.Add(-time.Hour), base.Add(time.Hour), []string{"/path/early.jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hashes, err := repo.FindPhotoHashes(t.Context(), 1, 2, tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("FindPhotoHashes failed: %v", err)
+			}
+			var got []string
+			for _, h := range hashes {
+				got = append(got, h.ImagePath)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLiteDiaryRepository_PurgeDiary_RemovesPhotoHash(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+	hashRepo := NewSQLitePhotoHashRepository(db)
+
+	if err := repo.CreateDiaryForUser(t.Context(), 1, 0, "/path/1.jpg", "削除する日記", time.Now()); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	for _, path := range []string{"/path/1.jpg", "/path/2.jpg"} {
+		if err := hashRepo.SavePhotoHash(t.Context(), PhotoHash{ImagePath: path, UserID: 1, Hash: 1, CapturedAt: time.Now()}); err != nil {
+			t.Fatalf("SavePhotoHash failed: %v", err)
+		}
+	}
+	if err := repo.DeleteDiary(t.Context(), 1); err != nil {
+		t.Fatalf("DeleteDiary failed: %v", err)
+	}
+	if _, err := repo.PurgeDiary(t.Context(), 1); err != nil {
+		t.Fatalf("PurgeDiary failed: %v", err)
+	}
+
+	if hash, err := hashRepo.GetPhotoHash(t.Context(), "/path/1.jpg"); err != nil || hash != nil {
+		t.Errorf("expected photo hash to be removed, got %+v, %v", hash, err)
+	}
+	// 他の写真の知覚ハッシュは残す
+	if hash, err := hashRepo.GetPhotoHash(t.Context(), "/path/2.jpg"); err != nil || hash == nil {
+		t.Errorf("expected other photo hash to remain, got %+v, %v", hash, err)
+	}
+}
+
+func TestSQLiteDiaryRepository_PurgeDiary_RemovesMergedPhotos(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+	hashRepo := NewSQLitePhotoHashRepository(db)
+	metaRepo := NewSQLitePhotoMetadataRepository(db)
+
+	if err := repo.CreateDiaryForUser(t.Context(), 1, 0, "/path/1.jpg", "削除する日記", time.Now()); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	hashes := []PhotoHash{
+		{ImagePath: "/path/1.jpg", UserID: 1, Hash: 1, CapturedAt: time.Now()},
+		{ImagePath: "/path/1_merged.jpg", UserID: 1, Hash: 1, CapturedAt: time.Now(), DuplicateOf: "/path/1.jpg"},
+		{ImagePath: "/path/2_merged.jpg", UserID: 1, Hash: 1, CapturedAt: time.Now(), DuplicateOf: "/path/2.jpg"},
+	}
+	for _, h := range hashes {
+		if err := hashRepo.SavePhotoHash(t.Context(), h); err != nil {
+			t.Fatalf("SavePhotoHash failed: %v", err)
+		}
+	}
+	if err := metaRepo.SavePhotoMetadata(t.Context(), PhotoMetadata{ImagePath: "/path/1_merged.jpg", CameraMake: "Canon"}); err != nil {
+		t.Fatalf("SavePhotoMetadata failed: %v", err)
+	}
+	if err := repo.DeleteDiary(t.Context(), 1); err != nil {
+		t.Fatalf("DeleteDiary failed: %v", err)
+	}
+
+	// 日記の写真に統合した写真のパスを返し、知覚ハッシュと撮影情報も削除する
+	merged, err := repo.PurgeDiary(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("PurgeDiary failed: %v", err)
+	}
+	if len(merged) != 1 || merged[0] != "/path/1_merged.jpg" {
+		t.Errorf("expected merged photo /path/1_merged.jpg, got %v", merged)
+	}
+	if hash, err := hashRepo.GetPhotoHash(t.Context(), "/path/1_merged.jpg"); err != nil || hash != nil {
+		t.Errorf("expected merged photo hash to be removed, got %+v, %v", hash, err)
+	}
+	if meta, err := metaRepo.GetPhotoMetadata(t.Context(), "/path/1_merged.jpg"); err != nil || meta != nil {
+		t.Errorf("expected merged photo metadata to be removed, got %+v, %v", meta, err)
+	}
+	// 他の写真に統合した写真は残す
+	if hash, err := hashRepo.GetPhotoHash(t.Context(), "/path/2_merged.jpg"); err != nil || hash == nil {
+		t.Errorf("expected photo merged into another photo to remain, got %+v, %v", hash, err)
+	}
+}
+
+func TestSQLitePhotoHashRepository_ImplementsInterface(t *testing.T) {
+	db := setupTestDB(t)
+	var _ PhotoHashRepository = NewSQLitePhotoHashRepository(db)
+}
+
+func TestSQLiteUploadIdempotencyKeyRepository_Reserve(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteUploadIdempotencyKeyRepository(db)
+	expiredBefore := time.Now().Add(-time.Hour)
+
+	// 未登録のキーは処理中として登録する
+	existing, err := repo.ReserveUploadIdempotencyKey(t.Context(), 1, "key-1", "fp-1", expiredBefore)
+	if err != nil || existing != nil {
+		t.Fatalf("expected key to be reserved, got %+v, %v", existing, err)
+	}
+
+	// 登録済みのキーは既存のキーを返す
+	existing, err = repo.ReserveUploadIdempotencyKey(t.Context(), 1, "key-1", "fp-2", expiredBefore)
+	if err != nil {
+		t.Fatalf("ReserveUploadIdempotencyKey failed: %v", err)
+	}
+	if existing == nil || existing.Fingerprint != "fp-1" || existing.JobID != "" {
+		t.Fatalf("expected pending key with fp-1, got %+v", existing)
+	}
+
+	// ジョブを記録した後は、ジョブIDを返す
+	if err := repo.CompleteUploadIdempotencyKey(t.Context(), 1, "key-1", "job-1"); err != nil {
+		t.Fatalf("CompleteUploadIdempotencyKey failed: %v", err)
+	}
+	existing, err = repo.ReserveUploadIdempotencyKey(t.Context(), 1, "key-1", "fp-1", expiredBefore)
+	if err != nil || existing == nil || existing.JobID != "job-1" {
+		t.Fatalf("expected completed key with job-1, got %+v, %v", existing, err)
+	}
+
+	// キーはユーザーごとに区別する
+	existing, err = repo.ReserveUploadIdempotencyKey(t.Context(), 2, "key-1", "fp-1", expiredBefore)
+	if err != nil || existing != nil {
+		t.Fatalf("expected key of another user to be reserved, got %+v, %v", existing, err)
+	}
+}
+
+func TestSQLiteUploadIdempotencyKeyRepository_CompleteDuplicate(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteUploadIdempotencyKeyRepository(db)
+	expiredBefore := time.Now().Add(-time.Hour)
+
+	if _, err := repo.ReserveUploadIdempotencyKey(t.Context(), 1, "key-1", "fp-1", expiredBefore); err != nil {
+		t.Fatalf("ReserveUploadIdempotencyKey failed: %v", err)
+	}
+	if err := repo.CompleteUploadIdempotencyKeyDuplicate(t.Context(), 1, "key-1", "merged", "user/original.jpg", 2, "user/merged.jpg"); err != nil {
+		t.Fatalf("CompleteUploadIdempotencyKeyDuplicate failed: %v", err)
+	}
+
+	// 重複する写真として処理したキーは処理中ではなく、記録した結果を返す
+	existing, err := repo.ReserveUploadIdempotencyKey(t.Context(), 1, "key-1", "fp-1", expiredBefore)
+	if err != nil || existing == nil {
+		t.Fatalf("expected completed key, got %+v, %v", existing, err)
+	}
+	if existing.Pending() {
+		t.Error("expected key with duplicate result not to be pending")
+	}
+	if existing.JobID != "" || existing.Action != "merged" || existing.DuplicateOf != "user/original.jpg" || existing.Distance != 2 || existing.ImagePath != "user/merged.jpg" {
+		t.Errorf("unexpected duplicate result: %+v", existing)
+	}
+}
+
+func TestSQLiteUploadIdempotencyKeyRepository_Expired(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteUploadIdempotencyKeyRepository(db)
+
+	if _, err := repo.ReserveUploadIdempotencyKey(t.Context(), 1, "key-1", "fp-1", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("ReserveUploadIdempotencyKey failed: %v", err)
+	}
+	if err := repo.CompleteUploadIdempotencyKey(t.Context(), 1, "key-1", "job-1"); err != nil {
+		t.Fatalf("CompleteUploadIdempotencyKey failed: %v", err)
+	}
+
+	// 期限切れのキーは登録し直す
+	existing, err := repo.ReserveUploadIdempotencyKey(t.Context(), 1, "key-1", "fp-2", time.Now().Add(time.Minute))
+	if err != nil || existing != nil {
+		t.Fatalf("expected expired key to be reserved again, got %+v, %v", existing, err)
+	}
+	existing, err = repo.ReserveUploadIdempotencyKey(t.Context(), 1, "key-1", "fp-1", time.Now().Add(-time.Hour))
+	if err != nil || existing == nil || existing.Fingerprint != "fp-2" || existing.JobID != "" {
+		t.Fatalf("expected new pending key with fp-2, got %+v, %v", existing, err)
+	}
+}
+
+func TestSQLiteUploadIdempotencyKeyRepository_Delete(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteUploadIdempotencyKeyRepository(db)
+	expiredBefore := time.Now().Add(-time.Hour)
+
+	if _, err := repo.ReserveUploadIdempotencyKey(t.Context(), 1, "key-1", "fp-1", expiredBefore); err != nil {
+		t.Fatalf("ReserveUploadIdempotencyKey failed: %v", err)
+	}
+	if err := repo.DeleteUploadIdempotencyKey(t.Context(), 1, "key-1"); err != nil {
+		t.Fatalf("DeleteUploadIdempotencyKey failed: %v", err)
+	}
+	// 削除したキーは再び登録できる
+	existing, err := repo.ReserveUploadIdempotencyKey(t.Context(), 1, "key-1", "fp-2", expiredBefore)
+	if err != nil || existing != nil {
+		t.Fatalf("expected deleted key to be reserved again, got %+v, %v", existing, err)
+	}
+	// 存在しないキーの削除はエラーにしない
+	if err := repo.DeleteUploadIdempotencyKey(t.Context(), 1, "unknown"); err != nil {
+		t.Errorf("DeleteUploadIdempotencyKey for unknown key failed: %v", err)
+	}
+}
+
+func TestSQLiteUploadIdempotencyKeyRepository_ImplementsInterface(t *testing.T) {
+	db := setupTestDB(t)
+	var _ UploadIdempotencyKeyRepository = NewSQLiteUploadIdempotencyKeyRepository(db)
+}
+
+func TestSQLiteUserRepository_GetAllUsers(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteUserRepository(db)
+
+	if err := repo.CreateUser(t.Context(), "uuid-2", "hanako", "hash"); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	users, err := repo.GetAllUsers(t.Context())
+	if err != nil {
+		t.Fatalf("GetAllUsers failed: %v", err)
+	}
+	if len(users) == 0 || users[len(users)-1].Username != "hanako" {
+		t.Errorf("unexpected users: %+v", users)
+	}
+	for i := 1; i < len(users); i++ {
+		if users[i-1].ID >= users[i].ID {
+			t.Errorf("expected users in ID order, got %+v", users)
+		}
+	}
+}
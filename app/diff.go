@@ -0,0 +1,96 @@
+package main
+
+import "strings"
+
+// DiffOp は差分の種類を表す
+type DiffOp string
+
+const (
+	DiffOpEqual  DiffOp = "equal"
+	DiffOpInsert DiffOp = "insert"
+	DiffOpDelete DiffOp = "delete"
+)
+
+// DiffSegment は差分の連続した区間を表す構造体
+type DiffSegment struct {
+	Op   DiffOp
+	Text string
+}
+
+// maxDiffCells は文字単位で差分を取る際のLCS表の最大セル数。超える場合は行単位で差分を取る
+const maxDiffCells = 1000000
+
+// diffText は2つの本文の差分を文字単位で返す。本文が長い場合は行単位で差分を取る
+func diffText(before, after string) []DiffSegment {
+	a := strings.Split(before, "")
+	b := strings.Split(after, "")
+	if len(a)*len(b) > maxDiffCells {
+		a = strings.SplitAfter(before, "\n")
+		b = strings.SplitAfter(after, "\n")
+	}
+	return diffTokens(a, b)
+}
+
+// diffTokens はトークン列の最長共通部分列から差分を求め、同じ種類の連続するトークンをまとめて返す
+func diffTokens(a, b []string) []DiffSegment {
+	// 共通の先頭・末尾を除いてLCS表を小さくする
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA := a[prefix : len(a)-suffix]
+	midB := b[prefix : len(b)-suffix]
+
+	// lcs[i][j] は midA[i:] と midB[j:] の最長共通部分列の長さ
+	lcs := make([][]int, len(midA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(midB)+1)
+	}
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var segments []DiffSegment
+	add := func(op DiffOp, text string) {
+		if text == "" {
+			return
+		}
+		if n := len(segments); n > 0 && segments[n-1].Op == op {
+			segments[n-1].Text += text
+			return
+		}
+		segments = append(segments, DiffSegment{Op: op, Text: text})
+	}
+
+	add(DiffOpEqual, strings.Join(a[:prefix], ""))
+	i, j := 0, 0
+	for i < len(midA) && j < len(midB) {
+		switch {
+		case midA[i] == midB[j]:
+			add(DiffOpEqual, midA[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			add(DiffOpDelete, midA[i])
+			i++
+		default:
+			add(DiffOpInsert, midB[j])
+			j++
+		}
+	}
+	add(DiffOpDelete, strings.Join(midA[i:], ""))
+	add(DiffOpInsert, strings.Join(midB[j:], ""))
+	add(DiffOpEqual, strings.Join(a[len(a)-suffix:], ""))
+
+	return segments
+}
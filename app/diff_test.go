@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffText(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   []DiffSegment
+	}{
+		{
+			name:   "変更なし",
+			before: "新芽が出た。",
+			after:  "新芽が出た。",
+			want:   []DiffSegment{{Op: DiffOpEqual, Text: "新芽が出た。"}},
+		},
+		{
+			name:   "文字の置き換え",
+			before: "新芽が二つ出た。",
+			after:  "新芽が三つ出た。",
+			want: []DiffSegment{
+				{Op: DiffOpEqual, Text: "新芽が"},
+				{Op: DiffOpDelete, Text: "二"},
+				{Op: DiffOpInsert, Text: "三"},
+				{Op: DiffOpEqual, Text: "つ出た。"},
+			},
+		},
+		{
+			name:   "末尾への追記",
+			before: "葉が増えた。",
+			after:  "葉が増えた。\n水をあげた。",
+			want: []DiffSegment{
+				{Op: DiffOpEqual, Text: "葉が増えた。"},
+				{Op: DiffOpInsert, Text: "\n水をあげた。"},
+			},
+		},
+		{
+			name:   "途中の削除",
+			before: "今日は晴れで、葉が増えた。",
+			after:  "葉が増えた。",
+			want: []DiffSegment{
+				{Op: DiffOpDelete, Text: "今日は晴れで、"},
+				{Op: DiffOpEqual, Text: "葉が増えた。"},
+			},
+		},
+		{
+			name:   "空の本文から",
+			before: "",
+			after:  "花が咲いた。",
+			want:   []DiffSegment{{Op: DiffOpInsert, Text: "花が咲いた。"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffText(tt.before, tt.after)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffText(%q, %q) = %+v, want %+v", tt.before, tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffText_LongContentFallsBackToLines(t *testing.T) {
+	line := strings.Repeat("葉", 200) + "\n"
+	before := strings.Repeat(line, 10)
+	after := strings.Repeat(line, 5) + "新しい行\n" + strings.Repeat(line, 5)
+
+	got := diffText(before, after)
+	want := []DiffSegment{
+		{Op: DiffOpEqual, Text: strings.Repeat(line, 5)},
+		{Op: DiffOpInsert, Text: "新しい行\n"},
+		{Op: DiffOpEqual, Text: strings.Repeat(line, 5)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected diff: %+v", got)
+	}
+}
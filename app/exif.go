@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// EXIFのタグ
+const (
+	exifTagMake               = 0x010F
+	exifTagModel              = 0x0110
+	exifTagOrientation        = 0x0112
+	exifTagExifIFD            = 0x8769
+	exifTagGPSIFD             = 0x8825
+	exifTagExposureTime       = 0x829A
+	exifTagFNumber            = 0x829D
+	exifTagISO                = 0x8827
+	exifTagDateTimeOriginal   = 0x9003
+	exifTagOffsetTimeOriginal = 0x9011
+	exifTagFocalLength        = 0x920A
+	exifTagGPSLatitudeRef     = 0x0001
+	exifTagGPSLatitude        = 0x0002
+	exifTagGPSLongitudeRef    = 0x0003
+	exifTagGPSLongitude       = 0x0004
+)
+
+// exifMaxIFDEntries は1つのIFDから読み込むタグ数の上限（壊れたEXIFで大量のタグを読まないようにする）
+const exifMaxIFDEntries = 512
+
+// errNoEXIF はJPEGにEXIFが含まれていないことを表す
+var errNoEXIF = errors.New("no exif")
+
+// ReadPhotoMetadata はJPEGのEXIFから撮影日時・向き・カメラ・露出・位置を読み込む。
+// EXIFがない場合はnilを返す。撮影日時にタイムゾーン（OffsetTimeOriginal）がない場合は日本時間とみなす
+func ReadPhotoMetadata(r io.Reader) (*PhotoMetadata, error) {
+	tiff, err := readEXIFSegment(bufio.NewReader(r))
+	if errors.Is(err, errNoEXIF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	x, ifd0, err := parseTIFF(tiff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse exif: %w", err)
+	}
+
+	meta := &PhotoMetadata{
+		CameraMake:  x.stringValue(ifd0[exifTagMake]),
+		CameraModel: x.stringValue(ifd0[exifTagModel]),
+		Orientation: x.uintValue(ifd0[exifTagOrientation]),
+	}
+	if meta.Orientation < 1 || meta.Orientation > 8 {
+		meta.Orientation = 0
+	}
+
+	if e, ok := ifd0[exifTagExifIFD]; ok {
+		sub, err := x.readIFD(uint32(x.uintValue(e)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse exif ifd: %w", err)
+		}
+		meta.CapturedAt = parseEXIFDateTime(x.stringValue(sub[exifTagDateTimeOriginal]), x.stringValue(sub[exifTagOffsetTimeOriginal]))
+		if num, den, ok := x.rational(sub[exifTagExposureTime], 0); ok && num > 0 {
+			meta.ExposureTime = formatExposureTime(num, den)
+		}
+		if num, den, ok := x.rational(sub[exifTagFNumber], 0); ok {
+			meta.FNumber = float64(num) / float64(den)
+		}
+		if num, den, ok := x.rational(sub[exifTagFocalLength], 0); ok {
+			meta.FocalLength = float64(num) / float64(den)
+		}
+		meta.ISO = x.uintValue(sub[exifTagISO])
+	}
+
+	if e, ok := ifd0[exifTagGPSIFD]; ok {
+		gps, err := x.readIFD(uint32(x.uintValue(e)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse gps ifd: %w", err)
+		}
+		lat, latOK := x.gpsCoordinate(gps[exifTagGPSLatitude], x.stringValue(gps[exifTagGPSLatitudeRef]), "S")
+		lon, lonOK := x.gpsCoordinate(gps[exifTagGPSLongitude], x.stringValue(gps[exifTagGPSLongitudeRef]), "W")
+		if latOK && lonOK && math.Abs(lat) <= 90 && math.Abs(lon) <= 180 {
+			meta.Latitude, meta.Longitude = &lat, &lon
+		}
+	}
+	return meta, nil
+}
+
+// readEXIFSegment はJPEGのマーカーを先頭から読み、EXIF（APP1）のTIFFデータを返す。画像データ（SOS）までにない場合はerrNoEXIFを返す
+func readEXIFSegment(r *bufio.Reader) ([]byte, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return nil, errors.New("not a jpeg")
+	}
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read jpeg marker: %w", err)
+		}
+		if b != 0xFF {
+			return nil, errors.New("invalid jpeg marker")
+		}
+		marker, err := r.ReadByte()
+		for err == nil && marker == 0xFF {
+			// マーカー前の埋め草
+			marker, err = r.ReadByte()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read jpeg marker: %w", err)
+		}
+		switch {
+		case marker == 0xDA || marker == 0xD9:
+			return nil, errNoEXIF
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// 長さを持たないマーカー
+			continue
+		}
+
+		var size [2]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return nil, fmt.Errorf("failed to read jpeg segment: %w", err)
+		}
+		n := int(binary.BigEndian.Uint16(size[:])) - 2
+		if n < 0 {
+			return nil, errors.New("invalid jpeg segment length")
+		}
+		if marker != 0xE1 {
+			if _, err := r.Discard(n); err != nil {
+				return nil, fmt.Errorf("failed to read jpeg segment: %w", err)
+			}
+			continue
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("failed to read jpeg segment: %w", err)
+		}
+		// APP1はXMPにも使われるため、EXIFの識別子で判定する
+		if bytes.HasPrefix(data, []byte("Exif\x00\x00")) {
+			return data[6:], nil
+		}
+	}
+}
+
+// stripEXIFLocation はJPEGから位置情報を除く。EXIFのGPS IFDのタグと値を消し、位置情報を含むことがあるEXIF以外のAPP1（XMP）を除く。
+// 画像データ（SOS以降）はそのまま残す
+func stripEXIFLocation(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("not a jpeg")
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	for i := 2; ; {
+		if i >= len(data) || data[i] != 0xFF {
+			return nil, errors.New("invalid jpeg marker")
+		}
+		j := i + 1
+		for j < len(data) && data[j] == 0xFF {
+			// マーカー前の埋め草
+			j++
+		}
+		if j >= len(data) {
+			return nil, errors.New("invalid jpeg marker")
+		}
+		marker := data[j]
+		switch {
+		case marker == 0xDA || marker == 0xD9:
+			return append(out, data[i:]...), nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// 長さを持たないマーカー
+			out = append(out, data[i:j+1]...)
+			i = j + 1
+			continue
+		}
+
+		if j+3 > len(data) {
+			return nil, errors.New("invalid jpeg segment length")
+		}
+		end := j + 1 + int(binary.BigEndian.Uint16(data[j+1:]))
+		if end < j+3 || end > len(data) {
+			return nil, errors.New("invalid jpeg segment length")
+		}
+		segment := data[j+3 : end]
+		switch {
+		case marker != 0xE1:
+			out = append(out, data[i:end]...)
+		case bytes.HasPrefix(segment, []byte("Exif\x00\x00")):
+			out = append(out, data[i:j+3]...)
+			start := len(out)
+			out = append(out, segment...)
+			if err := clearGPSIFD(out[start+6:]); err != nil {
+				return nil, fmt.Errorf("failed to strip gps: %w", err)
+			}
+		}
+		i = end
+	}
+}
+
+// clearGPSIFD はEXIFのTIFFデータのGPS IFDのタグと値を0で埋め、タグ数を0にする。GPS IFDがない場合は何もしない
+func clearGPSIFD(tiff []byte) error {
+	x, ifd0, err := parseTIFF(tiff)
+	if err != nil {
+		return err
+	}
+	e, ok := ifd0[exifTagGPSIFD]
+	if !ok {
+		return nil
+	}
+	offset := x.uintValue(e)
+	gps, err := x.readIFD(uint32(offset))
+	if err != nil {
+		return err
+	}
+	// 読み込んだタグの値は tiff の一部を指しているため、値を0で埋めるとtiffから位置情報が消える
+	for _, g := range gps {
+		clear(g.value)
+	}
+	n := int(x.order.Uint16(tiff[offset:]))
+	clear(tiff[offset+2 : offset+2+n*12])
+	x.order.PutUint16(tiff[offset:], 0)
+	return nil
+}
+
+// exifEntry はIFDのタグの型・個数・値（値のバイト列）
+type exifEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// exifTIFF はEXIFのTIFFデータとバイトオーダー
+type exifTIFF struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// parseTIFF はTIFFヘッダーを読み、IFD0のタグを返す
+func parseTIFF(data []byte) (*exifTIFF, map[uint16]exifEntry, error) {
+	if len(data) < 8 {
+		return nil, nil, errors.New("tiff header too short")
+	}
+	x := &exifTIFF{data: data}
+	switch string(data[:2]) {
+	case "II":
+		x.order = binary.LittleEndian
+	case "MM":
+		x.order = binary.BigEndian
+	default:
+		return nil, nil, errors.New("invalid tiff byte order")
+	}
+	if x.order.Uint16(data[2:]) != 42 {
+		return nil, nil, errors.New("invalid tiff magic")
+	}
+	ifd0, err := x.readIFD(x.order.Uint32(data[4:]))
+	if err != nil {
+		return nil, nil, err
+	}
+	return x, ifd0, nil
+}
+
+// exifTypeSize はEXIFの型ごとの1要素のバイト数（未対応の型は0）
+func exifTypeSize(typ uint16) int {
+	switch typ {
+	case 1, 2, 6, 7: // BYTE, ASCII, SBYTE, UNDEFINED
+		return 1
+	case 3, 8: // SHORT, SSHORT
+		return 2
+	case 4, 9, 11: // LONG, SLONG, FLOAT
+		return 4
+	case 5, 10, 12: // RATIONAL, SRATIONAL, DOUBLE
+		return 8
+	}
+	return 0
+}
+
+// readIFD はoffsetのIFDのタグを読み込む。値が範囲外を指すタグと未対応の型のタグは読み飛ばす
+func (x *exifTIFF) readIFD(offset uint32) (map[uint16]exifEntry, error) {
+	if int64(offset)+2 > int64(len(x.data)) {
+		return nil, errors.New("ifd offset out of range")
+	}
+	n := int(x.order.Uint16(x.data[offset:]))
+	if n > exifMaxIFDEntries {
+		return nil, fmt.Errorf("too many ifd entries: %d", n)
+	}
+	start := int(offset) + 2
+	if start+n*12 > len(x.data) {
+		return nil, errors.New("ifd entries out of range")
+	}
+
+	entries := make(map[uint16]exifEntry, n)
+	for i := range n {
+		b := x.data[start+i*12 : start+(i+1)*12]
+		e := exifEntry{typ: x.order.Uint16(b[2:]), count: x.order.Uint32(b[4:])}
+		size := int64(exifTypeSize(e.typ)) * int64(e.count)
+		if size == 0 {
+			continue
+		}
+		if size <= 4 {
+			e.value = b[8 : 8+size]
+		} else {
+			off := int64(x.order.Uint32(b[8:]))
+			if off+size > int64(len(x.data)) {
+				continue
+			}
+			e.value = x.data[off : off+size]
+		}
+		entries[x.order.Uint16(b)] = e
+	}
+	return entries, nil
+}
+
+// uintValue はSHORT・LONGのタグの最初の値を返す（タグがない場合は0）
+func (x *exifTIFF) uintValue(e exifEntry) int {
+	switch e.typ {
+	case 3:
+		return int(x.order.Uint16(e.value))
+	case 4:
+		return int(x.order.Uint32(e.value))
+	}
+	return 0
+}
+
+// stringValue はASCIIのタグの値を、末尾のNULと空白を除いて返す（タグがない場合は空）
+func (x *exifTIFF) stringValue(e exifEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	s, _, _ := strings.Cut(string(e.value), "\x00")
+	return strings.TrimSpace(s)
+}
+
+// rational はRATIONALのタグのi番目の値の分子と分母を返す。値がないか分母が0の場合はfalseを返す
+func (x *exifTIFF) rational(e exifEntry, i int) (uint32, uint32, bool) {
+	if e.typ != 5 || len(e.value) < (i+1)*8 {
+		return 0, 0, false
+	}
+	num := x.order.Uint32(e.value[i*8:])
+	den := x.order.Uint32(e.value[i*8+4:])
+	return num, den, den != 0
+}
+
+// gpsCoordinate は度・分・秒のGPSのタグを10進数の度に変換する。refがnegativeRef（南緯・西経）の場合は負の値にする
+func (x *exifTIFF) gpsCoordinate(e exifEntry, ref, negativeRef string) (float64, bool) {
+	var v float64
+	for i, unit := range []float64{1, 60, 3600} {
+		num, den, ok := x.rational(e, i)
+		if !ok {
+			return 0, false
+		}
+		v += float64(num) / float64(den) / unit
+	}
+	if strings.EqualFold(ref, negativeRef) {
+		v = -v
+	}
+	return v, true
+}
+
+// parseEXIFDateTime はEXIFの日時（YYYY:MM:DD HH:MM:SS）とタイムゾーン（±HH:MM）を解析する。
+// タイムゾーンがない場合は日本時間とみなす。解析できない場合はnilを返す
+func parseEXIFDateTime(datetime, offset string) *time.Time {
+	if datetime == "" {
+		return nil
+	}
+	loc := time.FixedZone("Asia/Tokyo", 9*60*60)
+	if offset != "" {
+		if t, err := time.Parse("-07:00", offset); err == nil {
+			loc = t.Location()
+		}
+	}
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", datetime, loc)
+	if err != nil {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// formatExposureTime は露出時間を表示用の文字列（1秒未満は「1/125」、1秒以上は「2」）にする
+func formatExposureTime(num, den uint32) string {
+	if num < den {
+		return fmt.Sprintf("1/%d", int(math.Round(float64(den)/float64(num))))
+	}
+	return fmt.Sprintf("%g", math.Round(float64(num)/float64(den)*10)/10)
+}
+
+// orientPhoto はEXIFの向き（2〜8）に従って写真を反転・回転し、正しい向きの写真を返す。向きが1か不明の場合はそのまま返す
+func orientPhoto(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range h {
+		for x := range w {
+			var dx, dy int
+			switch orientation {
+			case 2: // 左右反転
+				dx, dy = w-1-x, y
+			case 3: // 180度回転
+				dx, dy = w-1-x, h-1-y
+			case 4: // 上下反転
+				dx, dy = x, h-1-y
+			case 5: // 左上と右下を結ぶ対角線で反転
+				dx, dy = y, x
+			case 6: // 時計回りに90度回転
+				dx, dy = h-1-y, x
+			case 7: // 右上と左下を結ぶ対角線で反転
+				dx, dy = h-1-y, w-1-x
+			case 8: // 反時計回りに90度回転
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], rgba.Pix[rgba.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+	"time"
+)
+
+// exifTestByteOrder はテスト用のEXIFのバイトオーダー（binary.LittleEndian / binary.BigEndian）
+type exifTestByteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// exifTestTag はテスト用のEXIFのタグ（valueはバイトオーダー変換済みの値）
+type exifTestTag struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func exifTestShort(order exifTestByteOrder, tag uint16, v uint16) exifTestTag {
+	return exifTestTag{tag: tag, typ: 3, count: 1, value: order.AppendUint16(nil, v)}
+}
+
+func exifTestASCII(tag uint16, s string) exifTestTag {
+	return exifTestTag{tag: tag, typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+func exifTestRationals(order exifTestByteOrder, tag uint16, values ...uint32) exifTestTag {
+	var b []byte
+	for _, v := range values {
+		b = order.AppendUint32(b, v)
+	}
+	return exifTestTag{tag: tag, typ: 5, count: uint32(len(values) / 2), value: b}
+}
+
+// appendTestIFD はIFDとその値をbufの末尾に書き込み、タグごとの値（オフセット）の位置を返す
+func appendTestIFD(buf []byte, order exifTestByteOrder, tags []exifTestTag) ([]byte, map[uint16]int) {
+	start := len(buf)
+	dataOffset := start + 2 + 12*len(tags) + 4
+	var data []byte
+	positions := make(map[uint16]int, len(tags))
+
+	buf = order.AppendUint16(buf, uint16(len(tags)))
+	for _, tag := range tags {
+		buf = order.AppendUint16(buf, tag.tag)
+		buf = order.AppendUint16(buf, tag.typ)
+		buf = order.AppendUint32(buf, tag.count)
+		positions[tag.tag] = len(buf)
+		if len(tag.value) <= 4 {
+			buf = append(buf, append(tag.value, make([]byte, 4-len(tag.value))...)...)
+			continue
+		}
+		buf = order.AppendUint32(buf, uint32(dataOffset+len(data)))
+		data = append(data, tag.value...)
+	}
+	buf = order.AppendUint32(buf, 0)
+	return append(buf, data...), positions
+}
+
+// buildTestEXIF はIFD0・Exif IFD・GPS IFDのタグからJPEGのAPP1セグメントを作成する
+func buildTestEXIF(order exifTestByteOrder, ifd0, exifIFD, gpsIFD []exifTestTag) []byte {
+	tiff := []byte("II")
+	if order == binary.BigEndian {
+		tiff = []byte("MM")
+	}
+	tiff = order.AppendUint16(tiff, 42)
+	tiff = order.AppendUint32(tiff, 8)
+
+	ifd0 = append(ifd0,
+		exifTestTag{tag: exifTagExifIFD, typ: 4, count: 1, value: make([]byte, 4)},
+		exifTestTag{tag: exifTagGPSIFD, typ: 4, count: 1, value: make([]byte, 4)},
+	)
+	tiff, positions := appendTestIFD(tiff, order, ifd0)
+	order.PutUint32(tiff[positions[exifTagExifIFD]:], uint32(len(tiff)))
+	tiff, _ = appendTestIFD(tiff, order, exifIFD)
+	order.PutUint32(tiff[positions[exifTagGPSIFD]:], uint32(len(tiff)))
+	tiff, _ = appendTestIFD(tiff, order, gpsIFD)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	return append(app1, segment...)
+}
+
+func TestReadPhotoMetadata(t *testing.T) {
+	for _, order := range []exifTestByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			exif := buildTestEXIF(order,
+				[]exifTestTag{
+					exifTestASCII(exifTagMake, "Canon"),
+					exifTestASCII(exifTagModel, "EOS Kiss "),
+					exifTestShort(order, exifTagOrientation, 6),
+				},
+				[]exifTestTag{
+					exifTestASCII(exifTagDateTimeOriginal, "2026:02:01 12:34:56"),
+					exifTestASCII(exifTagOffsetTimeOriginal, "+09:00"),
+					exifTestRationals(order, exifTagExposureTime, 1, 125),
+					exifTestRationals(order, exifTagFNumber, 28, 10),
+					exifTestShort(order, exifTagISO, 400),
+					exifTestRationals(order, exifTagFocalLength, 35, 1),
+				},
+				[]exifTestTag{
+					exifTestASCII(exifTagGPSLatitudeRef, "N"),
+					exifTestRationals(order, exifTagGPSLatitude, 35, 1, 40, 1, 5232, 100),
+					exifTestASCII(exifTagGPSLongitudeRef, "W"),
+					exifTestRationals(order, exifTagGPSLongitude, 139, 1, 46, 1, 156, 100),
+				},
+			)
+
+			meta, err := ReadPhotoMetadata(bytes.NewReader(encodeTestJPEG(t, 8, 8, exif)))
+			if err != nil {
+				t.Fatalf("ReadPhotoMetadata failed: %v", err)
+			}
+			if meta == nil {
+				t.Fatal("expected metadata")
+			}
+			if meta.CameraMake != "Canon" || meta.CameraModel != "EOS Kiss" || meta.Orientation != 6 {
+				t.Errorf("unexpected camera: %+v", meta)
+			}
+			if meta.CapturedAt == nil || !meta.CapturedAt.Equal(time.Date(2026, 2, 1, 3, 34, 56, 0, time.UTC)) {
+				t.Errorf("unexpected captured_at: %v", meta.CapturedAt)
+			}
+			if meta.ExposureTime != "1/125" || meta.FNumber != 2.8 || meta.ISO != 400 || meta.FocalLength != 35 {
+				t.Errorf("unexpected exposure: %+v", meta)
+			}
+			if meta.Latitude == nil || math.Abs(*meta.Latitude-35.681200) > 1e-6 ||
+				meta.Longitude == nil || math.Abs(*meta.Longitude+139.767100) > 1e-6 {
+				t.Errorf("unexpected location: %s", meta.Coordinates())
+			}
+		})
+	}
+}
+
+func TestReadPhotoMetadata_NoEXIF(t *testing.T) {
+	// EXIF以外のAPP1（XMP）は読み飛ばす
+	xmp := []byte("http://ns.adobe.com/xap/1.0/\x00<x/>")
+	app1 := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(len(xmp)+2))
+	meta, err := ReadPhotoMetadata(bytes.NewReader(encodeTestJPEG(t, 8, 8, append(app1, xmp...))))
+	if err != nil {
+		t.Fatalf("ReadPhotoMetadata failed: %v", err)
+	}
+	if meta != nil {
+		t.Errorf("expected nil metadata, got %+v", meta)
+	}
+}
+
+func TestReadPhotoMetadata_Invalid(t *testing.T) {
+	brokenTIFF := append([]byte("Exif\x00\x00II*\x00"), binary.LittleEndian.AppendUint32(nil, 0xFFFF)...)
+	broken := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(len(brokenTIFF)+2))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "JPEG以外", data: []byte("not a jpeg")},
+		{name: "途中で終わる", data: []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x10, 'E', 'x'}},
+		{name: "IFDが範囲外", data: encodeTestJPEG(t, 8, 8, append(broken, brokenTIFF...))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadPhotoMetadata(bytes.NewReader(tt.data)); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestParseEXIFDateTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		datetime string
+		offset   string
+		want     time.Time
+	}{
+		{name: "タイムゾーンあり", datetime: "2026:02:01 12:00:00", offset: "-05:00", want: time.Date(2026, 2, 1, 17, 0, 0, 0, time.UTC)},
+		{name: "タイムゾーンなしは日本時間", datetime: "2026:02:01 12:00:00", want: time.Date(2026, 2, 1, 3, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseEXIFDateTime(tt.datetime, tt.offset)
+			if got == nil || !got.Equal(tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	for _, datetime := range []string{"", "0000:00:00 00:00:00", "2026-02-01T12:00:00"} {
+		if got := parseEXIFDateTime(datetime, ""); got != nil {
+			t.Errorf("expected nil for %q, got %v", datetime, got)
+		}
+	}
+}
+
+func TestOrientPhoto(t *testing.T) {
+	// 3x2の写真の左上の赤いピクセルが、向きに従ってどこへ移るかを確認する
+	red := color.RGBA{R: 255, A: 255}
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.Set(0, 0, red)
+
+	tests := []struct {
+		orientation  int
+		wantW, wantH int
+		wantX, wantY int
+	}{
+		{orientation: 1, wantW: 3, wantH: 2, wantX: 0, wantY: 0},
+		{orientation: 2, wantW: 3, wantH: 2, wantX: 2, wantY: 0},
+		{orientation: 3, wantW: 3, wantH: 2, wantX: 2, wantY: 1},
+		{orientation: 4, wantW: 3, wantH: 2, wantX: 0, wantY: 1},
+		{orientation: 5, wantW: 2, wantH: 3, wantX: 0, wantY: 0},
+		{orientation: 6, wantW: 2, wantH: 3, wantX: 1, wantY: 0},
+		{orientation: 7, wantW: 2, wantH: 3, wantX: 1, wantY: 2},
+		{orientation: 8, wantW: 2, wantH: 3, wantX: 0, wantY: 2},
+	}
+	for _, tt := range tests {
+		got := orientPhoto(src, tt.orientation)
+		if got.Bounds().Dx() != tt.wantW || got.Bounds().Dy() != tt.wantH {
+			t.Errorf("orientation %d: expected %dx%d, got %dx%d", tt.orientation, tt.wantW, tt.wantH, got.Bounds().Dx(), got.Bounds().Dy())
+			continue
+		}
+		if c := color.RGBAModel.Convert(got.At(tt.wantX, tt.wantY)); c != red {
+			t.Errorf("orientation %d: expected red at (%d, %d), got %v", tt.orientation, tt.wantX, tt.wantY, c)
+		}
+	}
+}
+
+func TestStripEXIFLocation(t *testing.T) {
+	var order exifTestByteOrder = binary.BigEndian
+	exif := buildTestEXIF(order,
+		[]exifTestTag{exifTestASCII(exifTagModel, "EOS")},
+		[]exifTestTag{exifTestASCII(exifTagDateTimeOriginal, "2026:02:01 12:34:56")},
+		[]exifTestTag{
+			exifTestASCII(exifTagGPSLatitudeRef, "N"),
+			exifTestRationals(order, exifTagGPSLatitude, 35, 1, 40, 1, 5232, 100),
+			exifTestASCII(exifTagGPSLongitudeRef, "E"),
+			exifTestRationals(order, exifTagGPSLongitude, 139, 1, 46, 1, 156, 100),
+		},
+	)
+	xmp := []byte("http://ns.adobe.com/xap/1.0/\x00<exif:GPSLatitude>35,40.872N</exif:GPSLatitude>")
+	xmpSegment := append(binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(len(xmp)+2)), xmp...)
+	data := encodeTestJPEG(t, 8, 8, append(exif, xmpSegment...))
+
+	got, err := stripEXIFLocation(data)
+	if err != nil {
+		t.Fatalf("stripEXIFLocation failed: %v", err)
+	}
+	// 位置情報以外の撮影情報と画像は残す
+	meta, err := ReadPhotoMetadata(bytes.NewReader(got))
+	if err != nil {
+		t.Fatalf("ReadPhotoMetadata failed: %v", err)
+	}
+	if meta == nil || meta.CameraModel != "EOS" || meta.CapturedAt == nil {
+		t.Errorf("expected metadata to remain, got %+v", meta)
+	}
+	if meta != nil && meta.Coordinates() != "" {
+		t.Errorf("expected location to be removed, got %s", meta.Coordinates())
+	}
+	if bytes.Contains(got, []byte("GPSLatitude")) || bytes.Contains(got, order.AppendUint32(nil, 5232)) {
+		t.Error("expected gps values and xmp to be removed")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(got)); err != nil {
+		t.Errorf("failed to decode stripped photo: %v", err)
+	}
+
+	// EXIFのないJPEGはそのまま
+	plain := encodeTestJPEG(t, 8, 8, nil)
+	if got, err := stripEXIFLocation(plain); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("expected plain jpeg to be unchanged, got err %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 日記生成プロバイダの名前。DIARY_PROVIDERS での指定と、日記に記録するプロバイダ名に使う
+const (
+	ProviderOpenAI   = "openai"
+	ProviderOllama   = "ollama"
+	ProviderGemini   = "gemini"
+	ProviderTemplate = "template"
+	ProviderMock     = "mock"
+)
+
+// DiaryProvider は名前付きの日記生成プロバイダ
+type DiaryProvider struct {
+	Name      string
+	Generator DiaryGenerator
+}
+
+// ProviderStats はプロバイダごとの日記生成の成功・失敗回数（プロセス起動後の累計）
+type ProviderStats struct {
+	Name          string
+	Successes     int
+	Failures      int
+	LastError     string    // 直近の失敗のエラー内容（失敗していない場合は空）
+	LastFailureAt time.Time // 直近の失敗日時（失敗していない場合はゼロ値）
+}
+
+// ProviderStatsReporter はプロバイダごとの生成結果の集計を返すインターフェース
+type ProviderStatsReporter interface {
+	ProviderStats() []ProviderStats
+}
+
+// FallbackDiaryGenerator は複数のプロバイダを指定順に試し、最初に成功したプロバイダの日記を返す。
+// 1つのプロバイダが停止・上限超過していても、後続のプロバイダで日記を生成できる
+type FallbackDiaryGenerator struct {
+	providers []DiaryProvider
+
+	mu    sync.Mutex
+	stats []ProviderStats // providersと同じ順
+	now   func() time.Time
+}
+
+// NewFallbackDiaryGenerator は指定順にプロバイダを試す FallbackDiaryGenerator を生成する。
+// プロバイダが空の場合や名前が重複している場合はエラーを返す
+func NewFallbackDiaryGenerator(providers ...DiaryProvider) (*FallbackDiaryGenerator, error) {
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("no diary providers")
+	}
+	stats := make([]ProviderStats, len(providers))
+	seen := make(map[string]bool)
+	for i, p := range providers {
+		if p.Name == "" || p.Generator == nil {
+			return nil, fmt.Errorf("diary provider %d has no name or generator", i)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("duplicate diary provider: %s", p.Name)
+		}
+		seen[p.Name] = true
+		stats[i].Name = p.Name
+	}
+	return &FallbackDiaryGenerator{providers: providers, stats: stats, now: time.Now}, nil
+}
+
+// ProviderNames はプロバイダ名を試す順に返す
+func (g *FallbackDiaryGenerator) ProviderNames() []string {
+	names := make([]string, len(g.providers))
+	for i, p := range g.providers {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// Generate はプロバイダを指定順に試し、最初に成功したプロバイダの日記を、結果の Provider にそのプロバイダ名、
+// Latency にそのプロバイダの生成にかかった時間（失敗したプロバイダの時間は含まない）を設定して返す。
+// 全てのプロバイダが失敗した場合は各プロバイダのエラーをまとめて返す。
+// ctxがキャンセルされた場合や写真を読み込めない場合は残りのプロバイダを試さず、プロバイダの失敗としても数えない
+func (g *FallbackDiaryGenerator) Generate(ctx context.Context, req DiaryRequest) (DiaryResult, error) {
+	var errs []error
+	for i, p := range g.providers {
+		start := time.Now()
+		result, err := p.Generator.Generate(ctx, req)
+		if err == nil {
+			g.recordSuccess(i)
+			result.Provider = p.Name
+			result.Latency = time.Since(start)
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return DiaryResult{}, fmt.Errorf("diary generation with %s canceled: %w", p.Name, err)
+		}
+		var imageErr *ImageFileError
+		if errors.As(err, &imageErr) {
+			return DiaryResult{}, fmt.Errorf("diary generation with %s failed: %w", p.Name, err)
+		}
+		g.recordFailure(i, err)
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+		if i < len(g.providers)-1 {
+			log.Printf("WARN: diary provider %s failed for %s: %v, trying %s", p.Name, req.ImagePath, err, g.providers[i+1].Name)
+		}
+	}
+	return DiaryResult{}, fmt.Errorf("all diary providers failed: %w", errors.Join(errs...))
+}
+
+// GenerateText はテキスト生成に対応したプロバイダを指定順に試し、最初に成功したプロバイダのテキストを、
+// Generate と同じく結果の Provider・Latency を設定して返す。
+// 日記の生成結果の集計には含めない。ctxがキャンセルされた場合は残りのプロバイダを試さない
+func (g *FallbackDiaryGenerator) GenerateText(ctx context.Context, prompt string) (DiaryResult, error) {
+	var errs []error
+	for _, p := range g.providers {
+		textGenerator, ok := p.Generator.(TextGenerator)
+		if !ok {
+			continue
+		}
+		start := time.Now()
+		result, err := textGenerator.GenerateText(ctx, prompt)
+		if err == nil {
+			result.Provider = p.Name
+			result.Latency = time.Since(start)
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return DiaryResult{}, fmt.Errorf("text generation with %s canceled: %w", p.Name, err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+		log.Printf("WARN: text generation with provider %s failed: %v", p.Name, err)
+	}
+	if len(errs) == 0 {
+		return DiaryResult{}, fmt.Errorf("no diary provider supports text generation")
+	}
+	return DiaryResult{}, fmt.Errorf("all diary providers failed to generate text: %w", errors.Join(errs...))
+}
+
+// recordSuccess は指定位置のプロバイダの成功回数を加算する
+func (g *FallbackDiaryGenerator) recordSuccess(i int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stats[i].Successes++
+}
+
+// recordFailure は指定位置のプロバイダの失敗回数を加算し、エラー内容を記録する
+func (g *FallbackDiaryGenerator) recordFailure(i int, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stats[i].Failures++
+	g.stats[i].LastError = err.Error()
+	g.stats[i].LastFailureAt = g.now()
+}
+
+// ProviderStats はプロバイダごとの成功・失敗回数をプロバイダを試す順に返す
+func (g *FallbackDiaryGenerator) ProviderStats() []ProviderStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	stats := make([]ProviderStats, len(g.stats))
+	copy(stats, g.stats)
+	return stats
+}
+
+// NewDiaryGeneratorFromEnv は環境変数 DIARY_PROVIDERS にカンマ区切りで指定した順（例: gemini,ollama,template）に
+// プロバイダを試す FallbackDiaryGenerator を生成する。DIARY_PROVIDERS が未設定の場合は、
+// OPENAI_BASE_URL・OLLAMA_BASE_URL・GEMINI_API_KEY のうち最初に設定されているもの、いずれもなければモックを使う
+func NewDiaryGeneratorFromEnv() (*FallbackDiaryGenerator, error) {
+	var names []string
+	if v := os.Getenv("DIARY_PROVIDERS"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("DIARY_PROVIDERS must list at least one provider: %q", v)
+		}
+	} else {
+		// ローカルで動かすOpenAI 互換 API・Ollama の指定を Gemini より優先する
+		switch {
+		case os.Getenv("OPENAI_BASE_URL") != "":
+			names = []string{ProviderOpenAI}
+		case os.Getenv("OLLAMA_BASE_URL") != "":
+			names = []string{ProviderOllama}
+		case os.Getenv("GEMINI_API_KEY") != "":
+			names = []string{ProviderGemini}
+		default:
+			names = []string{ProviderMock}
+		}
+	}
+
+	providers := make([]DiaryProvider, 0, len(names))
+	for _, name := range names {
+		generator, err := newDiaryProviderGenerator(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize diary provider %s: %w", name, err)
+		}
+		providers = append(providers, DiaryProvider{Name: name, Generator: generator})
+	}
+	return NewFallbackDiaryGenerator(providers...)
+}
+
+// newDiaryProviderGenerator はプロバイダ名に対応するgeneratorを、各プロバイダの環境変数の設定で生成する
+func newDiaryProviderGenerator(name string) (DiaryGenerator, error) {
+	switch name {
+	case ProviderOpenAI:
+		config, err := LoadOpenAIConfig()
+		if err != nil {
+			return nil, err
+		}
+		return NewOpenAIDiaryGenerator(config)
+	case ProviderOllama:
+		config, err := LoadOllamaConfig()
+		if err != nil {
+			return nil, err
+		}
+		return NewOllamaDiaryGenerator(config)
+	case ProviderGemini:
+		return NewGeminiDiaryGenerator()
+	case ProviderTemplate:
+		return &TemplateDiaryGenerator{}, nil
+	case ProviderMock:
+		return &MockDiaryGenerator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown diary provider (must be one of %s, %s, %s, %s, %s)",
+			ProviderOpenAI, ProviderOllama, ProviderGemini, ProviderTemplate, ProviderMock)
+	}
+}
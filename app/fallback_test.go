@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixedDiaryGenerator は固定の本文を返すテスト用のDiaryGenerator。受け取ったプロンプトを記録する
+type fixedDiaryGenerator struct {
+	content    string
+	lastPrompt string
+}
+
+func (g *fixedDiaryGenerator) Generate(ctx context.Context, req DiaryRequest) (DiaryResult, error) {
+	g.lastPrompt = req.Prompt
+	return DiaryResult{Content: g.content}, nil
+}
+
+func TestFallbackDiaryGenerator_FallsBackInOrder(t *testing.T) {
+	primary := &failingDiaryGenerator{}
+	secondary := &fixedDiaryGenerator{content: "ローカルモデルの日記"}
+	generator, err := NewFallbackDiaryGenerator(
+		DiaryProvider{Name: ProviderGemini, Generator: primary},
+		DiaryProvider{Name: ProviderOllama, Generator: secondary},
+		DiaryProvider{Name: ProviderTemplate, Generator: &TemplateDiaryGenerator{}},
+	)
+	if err != nil {
+		t.Fatalf("NewFallbackDiaryGenerator failed: %v", err)
+	}
+	failedAt := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	generator.now = func() time.Time { return failedAt }
+
+	generated, err := generator.Generate(t.Context(), DiaryRequest{ImagePath: "/path/to/image.jpg", Prompt: "観察してください"})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if generated.Content != "ローカルモデルの日記" || generated.Provider != ProviderOllama || generated.Observation != nil {
+		t.Errorf("unexpected result: %+v", generated)
+	}
+	if secondary.lastPrompt != "観察してください" {
+		t.Errorf("expected prompt to be passed to fallback provider, got %q", secondary.lastPrompt)
+	}
+	if primary.calls != 1 {
+		t.Errorf("expected primary provider to be called once, got %d", primary.calls)
+	}
+
+	want := []ProviderStats{
+		{Name: ProviderGemini, Failures: 1, LastError: "generation failed", LastFailureAt: failedAt},
+		{Name: ProviderOllama, Successes: 1},
+		{Name: ProviderTemplate},
+	}
+	if got := generator.ProviderStats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ProviderStats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFallbackDiaryGenerator_AllProvidersFail(t *testing.T) {
+	generator, err := NewFallbackDiaryGenerator(
+		DiaryProvider{Name: ProviderGemini, Generator: &failingDiaryGenerator{}},
+		DiaryProvider{Name: ProviderOllama, Generator: &failingDiaryGenerator{}},
+	)
+	if err != nil {
+		t.Fatalf("NewFallbackDiaryGenerator failed: %v", err)
+	}
+
+	_, err = generator.Generate(t.Context(), DiaryRequest{ImagePath: "/path/to/image.jpg"})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	for _, name := range []string{ProviderGemini, ProviderOllama} {
+		if !strings.Contains(err.Error(), name+": generation failed") {
+			t.Errorf("expected error to contain failure of %s, got %v", name, err)
+		}
+	}
+	for _, st := range generator.ProviderStats() {
+		if st.Failures != 1 || st.Successes != 0 {
+			t.Errorf("unexpected stats: %+v", st)
+		}
+	}
+}
+
+func TestFallbackDiaryGenerator_Canceled(t *testing.T) {
+	primary := &failingDiaryGenerator{}
+	secondary := &fixedDiaryGenerator{content: "ローカルモデルの日記"}
+	generator, err := NewFallbackDiaryGenerator(
+		DiaryProvider{Name: ProviderGemini, Generator: primary},
+		DiaryProvider{Name: ProviderOllama, Generator: secondary},
+	)
+	if err != nil {
+		t.Fatalf("NewFallbackDiaryGenerator failed: %v", err)
+	}
+
+	// キャンセルされた後は残りのプロバイダを試さず、失敗としても数えない
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := generator.Generate(ctx, DiaryRequest{ImagePath: "/path/to/image.jpg", Prompt: "観察してください"}); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if primary.calls != 1 || secondary.lastPrompt != "" {
+		t.Errorf("expected only primary provider to be called, got primary %d calls, secondary prompt %q", primary.calls, secondary.lastPrompt)
+	}
+	for _, st := range generator.ProviderStats() {
+		if st.Failures != 0 || st.Successes != 0 {
+			t.Errorf("unexpected stats: %+v", st)
+		}
+	}
+}
+
+func TestNewFallbackDiaryGenerator_InvalidProviders(t *testing.T) {
+	if _, err := NewFallbackDiaryGenerator(); err == nil {
+		t.Error("expected error for no providers, got nil")
+	}
+	if _, err := NewFallbackDiaryGenerator(
+		DiaryProvider{Name: ProviderMock, Generator: &MockDiaryGenerator{}},
+		DiaryProvider{Name: ProviderMock, Generator: &MockDiaryGenerator{}},
+	); err == nil {
+		t.Error("expected error for duplicate providers, got nil")
+	}
+}
+
+func TestNewDiaryGeneratorFromEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		providers string
+		ollamaURL string
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name: "未設定でプロバイダの設定もない場合はモック",
+			want: []string{ProviderMock},
+		},
+		{
+			name:      "未設定の場合は設定済みのプロバイダ",
+			ollamaURL: "http://localhost:11434",
+			want:      []string{ProviderOllama},
+		},
+		{
+			name:      "指定した順に試す",
+			providers: "ollama, template",
+			ollamaURL: "http://localhost:11434",
+			want:      []string{ProviderOllama, ProviderTemplate},
+		},
+		{
+			name:      "未知のプロバイダ",
+			providers: "ollama,unknown",
+			ollamaURL: "http://localhost:11434",
+			wantErr:   true,
+		},
+		{
+			name:      "プロバイダの設定がない",
+			providers: "gemini,template",
+			wantErr:   true,
+		},
+		{
+			name:      "プロバイダが空",
+			providers: " , ",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DIARY_PROVIDERS", tt.providers)
+			t.Setenv("OPENAI_BASE_URL", "")
+			t.Setenv("GEMINI_API_KEY", "")
+			t.Setenv("OLLAMA_BASE_URL", tt.ollamaURL)
+			t.Setenv("OLLAMA_MODEL", "llava")
+			t.Setenv("OLLAMA_TIMEOUT", "")
+			t.Setenv("OLLAMA_KEEP_ALIVE", "")
+
+			generator, err := NewDiaryGeneratorFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewDiaryGeneratorFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := generator.ProviderNames(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ProviderNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFallbackDiaryGenerator_GenerateText(t *testing.T) {
+	summarizer := &countingTextGenerator{}
+	generator, err := NewFallbackDiaryGenerator(
+		DiaryProvider{Name: ProviderTemplate, Generator: &TemplateDiaryGenerator{}},
+		DiaryProvider{Name: ProviderOllama, Generator: &struct {
+			DiaryGenerator
+			TextGenerator
+		}{&TemplateDiaryGenerator{}, summarizer}},
+	)
+	if err != nil {
+		t.Fatalf("NewFallbackDiaryGenerator failed: %v", err)
+	}
+
+	// テキスト生成に対応していないプロバイダは飛ばす
+	result, err := generator.GenerateText(t.Context(), "要約してください")
+	if err != nil {
+		t.Fatalf("GenerateText failed: %v", err)
+	}
+	if result.Content != "要約1" || len(summarizer.prompts) != 1 || summarizer.prompts[0] != "要約してください" {
+		t.Errorf("unexpected text %q (prompts: %v)", result.Content, summarizer.prompts)
+	}
+	if result.Provider != ProviderOllama {
+		t.Errorf("expected provider %s, got %q", ProviderOllama, result.Provider)
+	}
+	// 日記の生成結果の集計には含めない
+	for _, stats := range generator.ProviderStats() {
+		if stats.Successes != 0 || stats.Failures != 0 {
+			t.Errorf("expected no diary stats for text generation, got %+v", stats)
+		}
+	}
+
+	// 対応したプロバイダがない場合はエラー
+	templateOnly, err := NewFallbackDiaryGenerator(DiaryProvider{Name: ProviderTemplate, Generator: &TemplateDiaryGenerator{}})
+	if err != nil {
+		t.Fatalf("NewFallbackDiaryGenerator failed: %v", err)
+	}
+	if _, err := templateOnly.GenerateText(t.Context(), "要約してください"); err == nil {
+		t.Error("expected error without text generation provider")
+	}
+}
@@ -2,18 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"google.golang.org/genai"
 )
 
 const (
-	geminiModel         = "gemini-2.5-flash"
-	geminiTimeout       = 30 * time.Second
-	geminiDefaultPrompt = "この植物の写真を見て、成長の様子や変化を観察してください。親しみやすい口調で、200文字程度の観察日記を書いてください。"
+	geminiModel   = "gemini-2.5-flash"
+	geminiTimeout = 30 * time.Second
 )
 
 // GeminiDiaryGenerator は Gemini API を使って画像から日記を生成する。
@@ -31,21 +32,99 @@ func NewGeminiDiaryGenerator() (*GeminiDiaryGenerator, error) {
 	return &GeminiDiaryGenerator{apiKey: apiKey}, nil
 }
 
-// GenerateDiary は画像ファイルを読み込み、Gemini API で観察日記を生成する。
-func (g *GeminiDiaryGenerator) GenerateDiary(imagePath string) (string, error) {
-	return g.GenerateDiaryWithPrompt(imagePath, geminiDefaultPrompt)
+// Generate は写真とプロンプト（参照画像がある場合はあわせて）を Gemini API に渡し、構造化出力（ResponseSchema）で
+// 観察日記と写真から推定した植物の状態を生成する。レスポンスの UsageMetadata から消費トークン数をあわせて返す。
+func (g *GeminiDiaryGenerator) Generate(ctx context.Context, req DiaryRequest) (DiaryResult, error) {
+	text, usage, err := g.generate(ctx, req.ImagePath, req.promptOrBase(), req.References, &genai.GenerateContentConfig{
+		ResponseMIMEType: "application/json",
+		ResponseSchema:   geminiObservationSchema,
+	})
+	if err != nil {
+		return DiaryResult{}, err
+	}
+	content, observation, err := parseStructuredDiary(text)
+	if err != nil {
+		return DiaryResult{}, err
+	}
+	return DiaryResult{Content: content, Observation: observation, Usage: usage}, nil
+}
+
+// geminiObservationSchema は構造化出力のスキーマ。structuredDiary のJSONに対応する
+var geminiObservationSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"diary": {
+			Type:        genai.TypeString,
+			Description: "指示に従って書いた観察日記の本文",
+		},
+		"health_score": {
+			Type:        genai.TypeInteger,
+			Description: "写真から推定した植物の健康状態（1: 枯れかけている 〜 5: とても元気）",
+			Minimum:     genai.Ptr(1.0),
+			Maximum:     genai.Ptr(float64(maxHealthScore)),
+		},
+		"leaf_count": {
+			Type:        genai.TypeInteger,
+			Description: "写真に写っている葉のおおよその枚数（数えられない場合は0）",
+			Minimum:     genai.Ptr(0.0),
+		},
+		"flowering": {
+			Type:        genai.TypeBoolean,
+			Description: "花やつぼみがあるか",
+		},
+		"fruiting": {
+			Type:        genai.TypeBoolean,
+			Description: "実がなっているか",
+		},
+		"issues": {
+			Type:        genai.TypeArray,
+			Description: "写真から分かる問題（問題がなければ空）",
+			Items:       &genai.Schema{Type: genai.TypeString, Enum: diaryIssues},
+		},
+		"tags": {
+			Type:        genai.TypeArray,
+			Description: "写真の特徴を表す短い日本語のタグ（例: 新芽、つぼみ、植え替え）",
+			Items:       &genai.Schema{Type: genai.TypeString},
+			MaxItems:    genai.Ptr(int64(maxObservationTags)),
+		},
+	},
+	Required:         []string{"diary", "health_score", "leaf_count", "flowering", "fruiting", "issues", "tags"},
+	PropertyOrdering: []string{"diary", "health_score", "leaf_count", "flowering", "fruiting", "issues", "tags"},
+}
+
+// GenerateText は画像を使わずにプロンプトのみから Gemini API でテキストを生成し、消費トークン数をあわせて返す。過去日記の要約に使う
+func (g *GeminiDiaryGenerator) GenerateText(ctx context.Context, prompt string) (DiaryResult, error) {
+	text, usage, err := g.generateContent(ctx, []*genai.Part{{Text: prompt}}, nil)
+	if err != nil {
+		return DiaryResult{}, err
+	}
+	return DiaryResult{Content: text, Usage: usage}, nil
 }
 
-// GenerateDiaryWithPrompt は画像ファイルと動的プロンプトを使用して、Gemini API で観察日記を生成する。
-func (g *GeminiDiaryGenerator) GenerateDiaryWithPrompt(imagePath string, prompt string) (string, error) {
-	imageBytes, err := os.ReadFile(imagePath)
+// generate は画像ファイル（参照画像がある場合はその後に続けて）とプロンプトを Gemini API に送信し、レスポンスのテキストと消費トークン数を返す。
+// configがnilの場合は既定の設定を使う
+func (g *GeminiDiaryGenerator) generate(ctx context.Context, imagePath string, prompt string, references []ReferenceImage, config *genai.GenerateContentConfig) (string, *GenerationUsage, error) {
+	images, err := readDiaryImages(imagePath, references)
 	if err != nil {
-		return "", fmt.Errorf("画像ファイルの読み込みに失敗: %w", err)
+		return "", nil, err
 	}
 
-	mimeType := http.DetectContentType(imageBytes)
+	parts := []*genai.Part{
+		{Text: referenceImagesPrompt(prompt, references)},
+	}
+	for _, imageBytes := range images {
+		parts = append(parts, &genai.Part{InlineData: &genai.Blob{
+			Data:     imageBytes,
+			MIMEType: http.DetectContentType(imageBytes),
+		}})
+	}
+	return g.generateContent(ctx, parts, config)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), geminiTimeout)
+// generateContent はパートを Gemini API に送信し、レスポンスのテキストと消費トークン数（UsageMetadata がない場合はnil）を返す。
+// configがnilの場合は既定の設定を使う。API のエラーはリトライできる失敗かどうかを分類して返す
+func (g *GeminiDiaryGenerator) generateContent(ctx context.Context, parts []*genai.Part, config *genai.GenerateContentConfig) (string, *GenerationUsage, error) {
+	ctx, cancel := context.WithTimeout(ctx, geminiTimeout)
 	defer cancel()
 
 	client, err := genai.NewClient(ctx, &genai.ClientConfig{
@@ -53,29 +132,55 @@ func (g *GeminiDiaryGenerator) GenerateDiaryWithPrompt(imagePath string, prompt
 		Backend: genai.BackendGeminiAPI,
 	})
 	if err != nil {
-		return "", fmt.Errorf("Gemini クライアントの作成に失敗: %w", err)
+		return "", nil, Permanent(fmt.Errorf("Gemini クライアントの作成に失敗: %w", err))
 	}
 
-	parts := []*genai.Part{
-		{Text: prompt},
-		{InlineData: &genai.Blob{
-			Data:     imageBytes,
-			MIMEType: mimeType,
-		}},
-	}
 	contents := []*genai.Content{
 		{Parts: parts, Role: "user"},
 	}
 
-	resp, err := client.Models.GenerateContent(ctx, geminiModel, contents, nil)
+	resp, err := client.Models.GenerateContent(ctx, geminiModel, contents, config)
 	if err != nil {
-		return "", fmt.Errorf("Gemini API の呼び出しに失敗: %w", err)
+		return "", nil, classifyGeminiError(fmt.Errorf("Gemini API の呼び出しに失敗: %w", err))
 	}
 
 	text := resp.Text()
 	if text == "" {
-		return "", fmt.Errorf("Gemini API から空のレスポンスが返されました")
+		return "", nil, fmt.Errorf("Gemini API から空のレスポンスが返されました")
+	}
+
+	var usage *GenerationUsage
+	if m := resp.UsageMetadata; m != nil {
+		// gemini-2.5-flash の思考に使ったトークンは出力として課金される
+		usage = &GenerationUsage{
+			Model:          geminiModel,
+			PromptTokens:   int(m.PromptTokenCount),
+			ResponseTokens: int(m.CandidatesTokenCount + m.ThoughtsTokenCount),
+		}
 	}
+	return text, usage, nil
+}
 
-	return text, nil
+// classifyGeminiError は Gemini API のエラーをHTTPステータスで分類する（classifyHTTPError）。
+// 429 の場合は、エラーの詳細（google.rpc.RetryInfo）の retryDelay を再試行までの待ち時間とする
+func classifyGeminiError(err error) error {
+	var apiErr genai.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	classified := classifyHTTPError(apiErr.Code, nil, err)
+	var retryAfterErr *RetryAfterError
+	if errors.As(classified, &retryAfterErr) {
+		for _, detail := range apiErr.Details {
+			if t, _ := detail["@type"].(string); !strings.HasSuffix(t, "google.rpc.RetryInfo") {
+				continue
+			}
+			if delay, _ := detail["retryDelay"].(string); delay != "" {
+				if d, err := time.ParseDuration(delay); err == nil && d >= 0 {
+					retryAfterErr.After = d
+				}
+			}
+		}
+	}
+	return classified
 }
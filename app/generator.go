@@ -1,24 +1,108 @@
 package main
 
-// DiaryGenerator は画像から日記を生成するインターフェース。
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DiaryGenerator は写真から日記を生成するインターフェース。
 type DiaryGenerator interface {
-	GenerateDiary(imagePath string) (string, error)
+	// Generate は写真から日記を生成する。参照画像・植物の状態・消費トークン数に対応していないgeneratorでは、
+	// 参照画像を使わず、結果の植物の状態・消費トークン数はnilになる
+	Generate(ctx context.Context, req DiaryRequest) (DiaryResult, error)
+}
+
+// DiaryRequest は日記の生成に使う写真とプロンプト
+type DiaryRequest struct {
+	ImagePath  string
+	Prompt     string           // 動的プロンプト（空の場合は basePrompt）
+	References []ReferenceImage // 今回の写真と見比べるためにモデルへ渡す参照画像（対応していないgeneratorでは使わない）
+}
+
+// promptOrBase はリクエストのプロンプトを返す。指定がない場合は basePrompt を返す
+func (r DiaryRequest) promptOrBase() string {
+	if r.Prompt == "" {
+		return basePrompt
+	}
+	return r.Prompt
+}
+
+// ReferenceImage は今回の写真と見比べるためにモデルへ渡す、同じ植物の過去の写真（参照画像）
+type ReferenceImage struct {
+	Path       string
+	CapturedAt time.Time
+	DaysAgo    int // 今回の撮影日の何日前に撮影した写真か
+}
+
+// TextGenerator は画像を使わずにプロンプトのみからテキストを生成するインターフェース。過去日記の要約に使う。
+// 結果の Content に生成したテキストを、Usage にモデルが報告した消費トークン数を設定して返す（Observation は使わない）
+type TextGenerator interface {
+	GenerateText(ctx context.Context, prompt string) (DiaryResult, error)
 }
 
-// DiaryGeneratorWithPrompt は動的プロンプトをサポートするインターフェース。
-type DiaryGeneratorWithPrompt interface {
-	DiaryGenerator
-	GenerateDiaryWithPrompt(imagePath string, prompt string) (string, error)
+// GenerationUsage は1回の生成でモデルが報告した、生成に使ったモデルと消費トークン数
+type GenerationUsage struct {
+	Model          string
+	PromptTokens   int // 入力（プロンプトと画像）のトークン数
+	ResponseTokens int // 出力のトークン数（思考に使ったトークンを含む）
+}
+
+// DiaryResult は日記の生成結果
+type DiaryResult struct {
+	Content     string
+	Provider    string            // 本文を生成したプロバイダ名（FallbackDiaryGenerator 以外では空）
+	Observation *DiaryObservation // 写真から推定した植物の状態（構造化出力に対応していない場合はnil）
+	Usage       *GenerationUsage  // モデルが報告した消費トークン数（報告しないgeneratorではnil）
+	Latency     time.Duration     // 成功した生成の呼び出しにかかった時間（リトライの待ち時間や失敗した試行・プロバイダの時間は含まない）
+}
+
+// readDiaryImages は今回の写真、参照画像の順に画像ファイルを読み込む。
+// 読み込めない場合はリトライしない失敗（ReadImageFile を参照）を返す
+func readDiaryImages(imagePath string, references []ReferenceImage) ([][]byte, error) {
+	images := make([][]byte, 0, len(references)+1)
+	imageBytes, err := ReadImageFile(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("画像ファイルの読み込みに失敗: %w", err)
+	}
+	images = append(images, imageBytes)
+	for _, ref := range references {
+		imageBytes, err := ReadImageFile(ref.Path)
+		if err != nil {
+			return nil, fmt.Errorf("参照画像の読み込みに失敗: %w", err)
+		}
+		images = append(images, imageBytes)
+	}
+	return images, nil
+}
+
+// templateDiaryContent は TemplateDiaryGenerator が返す定型文
+const templateDiaryContent = "今日の様子を写真に記録しました。（日記の自動生成ができなかったため定型文で記録しています。日記の詳細ページから再生成できます）"
+
+// TemplateDiaryGenerator は画像を解析せずに定型文を返す実装。
+// 全てのプロバイダが使えない場合でも写真の記録を残すため、プロバイダの最後の候補として使う
+type TemplateDiaryGenerator struct{}
+
+func (g *TemplateDiaryGenerator) Generate(ctx context.Context, req DiaryRequest) (DiaryResult, error) {
+	return DiaryResult{Content: templateDiaryContent}, nil
 }
 
 // MockDiaryGenerator はテスト用のモック実装。
 type MockDiaryGenerator struct{}
 
-func (m *MockDiaryGenerator) GenerateDiary(imagePath string) (string, error) {
-	return "この植物は順調に成長しています。葉の色が鮮やかで、新しい芽も見られます。", nil
+func (m *MockDiaryGenerator) Generate(ctx context.Context, req DiaryRequest) (DiaryResult, error) {
+	// プロンプトは無視して固定文字列と、画面の確認用に固定の植物の状態を返す
+	return DiaryResult{
+		Content: "この植物は順調に成長しています。葉の色が鮮やかで、新しい芽も見られます。",
+		Observation: &DiaryObservation{
+			HealthScore: 4,
+			LeafCount:   8,
+			Tags:        []string{"新芽"},
+		},
+	}, nil
 }
 
-func (m *MockDiaryGenerator) GenerateDiaryWithPrompt(imagePath string, prompt string) (string, error) {
+func (m *MockDiaryGenerator) GenerateText(ctx context.Context, prompt string) (DiaryResult, error) {
 	// プロンプトは無視して固定文字列を返す
-	return "この植物は順調に成長しています。葉の色が鮮やかで、新しい芽も見られます。", nil
+	return DiaryResult{Content: "この期間は葉が順調に増え、新しい芽も育っていました。"}, nil
 }
@@ -4,21 +4,33 @@ import (
 	"testing"
 )
 
-func TestMockDiaryGenerator_GenerateDiary(t *testing.T) {
+func TestMockDiaryGenerator_Generate(t *testing.T) {
 	generator := &MockDiaryGenerator{}
 
-	content, err := generator.GenerateDiary("/path/to/image.jpg")
+	result, err := generator.Generate(t.Context(), DiaryRequest{ImagePath: "/path/to/image.jpg"})
 	if err != nil {
-		t.Fatalf("GenerateDiary failed: %v", err)
+		t.Fatalf("Generate failed: %v", err)
 	}
 
-	if content == "" {
+	if result.Content == "" {
 		t.Error("expected non-empty content, got empty string")
 	}
 
-	// モック実装は固定の文字列を返すことを確認
+	// モック実装は固定の文字列と植物の状態を返すことを確認
 	expected := "この植物は順調に成長しています。葉の色が鮮やかで、新しい芽も見られます。"
-	if content != expected {
-		t.Errorf("expected content '%s', got '%s'", expected, content)
+	if result.Content != expected {
+		t.Errorf("expected content '%s', got '%s'", expected, result.Content)
+	}
+	if result.Observation == nil || result.Observation.HealthScore != 4 {
+		t.Errorf("expected fixed observation, got %+v", result.Observation)
+	}
+}
+
+func TestDiaryRequest_PromptOrBase(t *testing.T) {
+	if got := (DiaryRequest{}).promptOrBase(); got != basePrompt {
+		t.Errorf("expected base prompt, got %q", got)
+	}
+	if got := (DiaryRequest{Prompt: "観察してください"}).promptOrBase(); got != "観察してください" {
+		t.Errorf("expected request prompt, got %q", got)
 	}
 }
@@ -7,6 +7,7 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.34
 	github.com/oapi-codegen/runtime v1.2.0
 	golang.org/x/crypto v0.45.0
+	golang.org/x/image v0.33.0
 	google.golang.org/genai v1.46.0
 )
 
@@ -14,6 +15,7 @@ require (
 	cloud.google.com/go v0.121.6 // indirect
 	cloud.google.com/go/auth v0.16.4 // indirect
 	cloud.google.com/go/compute/metadata v0.8.0 // indirect
+	github.com/apapsch/go-jsonmerge/v2 v2.0.0 // indirect
 	github.com/dprotaso/go-yit v0.0.0-20220510233725-9ba8df137936 // indirect
 	github.com/felixge/httpsnoop v1.0.4 // indirect
 	github.com/getkin/kin-openapi v0.133.0 // indirect
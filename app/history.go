@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 過去日記の要約の期間
+const (
+	SummaryPeriodWeek  = "week"  // 月の1〜7日・8〜14日・15〜21日・22日〜月末
+	SummaryPeriodMonth = "month" // 月の1日〜月末
+)
+
+const (
+	// defaultPromptContextTokens はプロンプトに含める過去日記と要約のデフォルトの推定トークン数の上限
+	defaultPromptContextTokens = 2000
+	// recentDiaryDays は要約せずに日記をそのまま含める期間（撮影日の何日前まで）の目安
+	recentDiaryDays = 7
+	// maxSummaryMonths は月ごとの要約を含める最大の月数
+	maxSummaryMonths = 12
+	// promptEntryOverheadTokens は過去日記・要約1件ごとの見出し（日付や期間）の推定トークン数
+	promptEntryOverheadTokens = 20
+	// maxSummariesPerPrompt は1回のプロンプトの組み立てで新たに生成する要約の最大数。残りは次回以降に生成する
+	maxSummariesPerPrompt = 3
+)
+
+// LoadPromptContextTokens は環境変数 PROMPT_CONTEXT_TOKENS から、プロンプトに含める過去日記と要約の推定トークン数の上限を読み込む。
+// 未設定の場合はデフォルト値を使用する
+func LoadPromptContextTokens() (int, error) {
+	v := os.Getenv("PROMPT_CONTEXT_TOKENS")
+	if v == "" {
+		return defaultPromptContextTokens, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("PROMPT_CONTEXT_TOKENS must be a positive integer: %q", v)
+	}
+	return n, nil
+}
+
+// estimateTokens はテキストのトークン数を推定する。モデルごとのトークナイザは使わず、
+// 日本語などの非ASCII文字は1文字1トークン、ASCII文字は4文字1トークンとして多めに見積もる
+func estimateTokens(s string) int {
+	ascii, other := 0, 0
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return other + (ascii+3)/4
+}
+
+// jstDate は日時のJSTでの日付を、UTCの0時として返す。日付の比較や日数の計算に使う
+func jstDate(t time.Time) time.Time {
+	t = t.In(time.FixedZone("Asia/Tokyo", 9*60*60))
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// monthStart は日付（jstDate の値）を含む月の1日を返す
+func monthStart(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// weekOfMonthStart は日付（jstDate の値）を含む週の初日を返す。
+// 週は月の1〜7日・8〜14日・15〜21日・22日〜月末で区切り、月をまたがないようにする
+func weekOfMonthStart(date time.Time) time.Time {
+	day := min((date.Day()-1)/7*7+1, 22)
+	return time.Date(date.Year(), date.Month(), day, 0, 0, 0, 0, time.UTC)
+}
+
+// summaryPeriod は要約する期間。Start・End は jstDate の値で、End は期間の翌日
+type summaryPeriod struct {
+	Period string
+	Start  time.Time
+	End    time.Time
+}
+
+// Label は期間の表示名（例: 2026年02月01日〜02月07日、2026年01月）を返す
+func (p summaryPeriod) Label() string {
+	if p.Period == SummaryPeriodMonth {
+		return p.Start.Format("2006年01月")
+	}
+	return p.Start.Format("2006年01月02日") + "〜" + p.End.AddDate(0, 0, -1).Format("01月02日")
+}
+
+// historyPeriods は撮影日（jstDate の値）について、日記をそのまま含める期間の初日と、要約する期間を新しい順に返す。
+// 撮影日の約1週間前の週からは日記をそのまま含め、その前月の1日までは週ごと、さらに前の maxSummaryMonths ヶ月は月ごとに要約する
+func historyPeriods(today time.Time) (time.Time, []summaryPeriod) {
+	recentStart := weekOfMonthStart(today.AddDate(0, 0, -recentDiaryDays))
+	weeklyStart := monthStart(recentStart).AddDate(0, -1, 0)
+
+	var periods []summaryPeriod
+	for end := recentStart; end.After(weeklyStart); {
+		start := weekOfMonthStart(end.AddDate(0, 0, -1))
+		periods = append(periods, summaryPeriod{Period: SummaryPeriodWeek, Start: start, End: end})
+		end = start
+	}
+	for i := 0; i < maxSummaryMonths; i++ {
+		end := weeklyStart.AddDate(0, -i, 0)
+		periods = append(periods, summaryPeriod{Period: SummaryPeriodMonth, Start: end.AddDate(0, -1, 0), End: end})
+	}
+	return recentStart, periods
+}
+
+// dedupDailyDiaries は過去日記（古い順）から、同じ日（JST）の同じ植物の日記のうち最も新しいものだけを古い順に返す。
+// 1日に何枚も撮影した場合に、ほぼ同じ内容の日記でプロンプトが埋まらないようにする
+func dedupDailyDiaries(diaries []Diary) []Diary {
+	type key struct {
+		date    time.Time
+		plantID int
+	}
+	seen := make(map[key]bool)
+	var deduped []Diary
+	for i := len(diaries) - 1; i >= 0; i-- {
+		k := key{date: jstDate(diaries[i].CreatedAt), plantID: diaries[i].PlantID}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		deduped = append(deduped, diaries[i])
+	}
+	slices.Reverse(deduped)
+	return deduped
+}
+
+// fitRecentDiaries は過去日記（古い順）を新しいものから推定トークン数の上限まで選び、古い順のプロンプト用の値と使ったトークン数を返す
+func fitRecentDiaries(diaries []Diary, budget int) ([]PromptDiary, int) {
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+
+	used := 0
+	first := len(diaries)
+	for i := len(diaries) - 1; i >= 0; i-- {
+		cost := estimateTokens(diaries[i].Content) + promptEntryOverheadTokens
+		if used+cost > budget {
+			break
+		}
+		used += cost
+		first = i
+	}
+
+	var prompt []PromptDiary
+	for _, diary := range diaries[first:] {
+		prompt = append(prompt, PromptDiary{
+			Date:    diary.CreatedAt.In(jst).Format("2006年01月02日"),
+			Content: diary.Content,
+		})
+	}
+	return prompt, used
+}
+
+// summarySourceHash は要約する日記のIDと本文から、要約を作り直す必要があるかの判定に使うハッシュを返す
+func summarySourceHash(diaries []Diary) string {
+	h := sha256.New()
+	for _, d := range diaries {
+		fmt.Fprintf(h, "%d\n%s\n", d.ID, d.Content)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// summaryLength は要約の目安の文字数を返す
+func summaryLength(period string) int {
+	if period == SummaryPeriodMonth {
+		return 250
+	}
+	return 150
+}
+
+// buildSummaryPrompt は期間の日記（古い順）を要約するためのプロンプトを生成する
+func buildSummaryPrompt(period summaryPeriod, diaries []Diary) string {
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "以下は植物の観察日記の%sの記録です。", period.Label())
+	fmt.Fprintf(&builder, "成長の様子や変化、気になる症状など、今後の観察の参考になる点を%d文字程度の文章に要約してください。要約の本文のみを出力してください。\n\n", summaryLength(period.Period))
+	for _, d := range diaries {
+		fmt.Fprintf(&builder, "【%s】\n%s\n\n", d.CreatedAt.In(jst).Format("2006年01月02日"), d.Content)
+	}
+	return strings.TrimSpace(builder.String())
+}
+
+// DiaryHistoryBuilder はプロンプトに含める過去日記を推定トークン数の上限内で組み立てる。
+// 直近の日記はそのまま含め、それより前の期間は週・月ごとの要約（生成した要約は保存して再利用する）で代える
+type DiaryHistoryBuilder struct {
+	repo        DiaryRepository
+	summaryRepo DiarySummaryRepository
+	summarizer  TextGenerator // nilの場合は要約を生成せず、保存済みの要約のみを使う
+	usage       *UsageTracker // 要約の生成の使用量の記録と予算の確認に使う（nilの場合は記録も確認もしない）
+	tokenBudget int
+}
+
+// NewDiaryHistoryBuilder は新しいDiaryHistoryBuilderを生成する。tokenBudgetが0以下の場合はデフォルト値を使う
+func NewDiaryHistoryBuilder(repo DiaryRepository, summaryRepo DiarySummaryRepository, summarizer TextGenerator, usage *UsageTracker, tokenBudget int) *DiaryHistoryBuilder {
+	if tokenBudget <= 0 {
+		tokenBudget = defaultPromptContextTokens
+	}
+	return &DiaryHistoryBuilder{repo: repo, summaryRepo: summaryRepo, summarizer: summarizer, usage: usage, tokenBudget: tokenBudget}
+}
+
+// Build は撮影日前日までのユーザーの過去日記（plantIDを指定した場合はその植物の日記）から、プロンプトに含める要約と直近の日記をそれぞれ古い順に返す。
+// 直近の日記を新しいものから優先して上限まで含め、残りのトークン数で新しい期間から要約を含める。
+// summarizeがfalseの場合（プレビューなど）は要約を生成せず、保存済みで日記が変わっていない要約のみを使う。
+// 要約の生成はユーザーの使用量として記録し、予算の上限に達している場合は生成せずに保存済みの要約のみを使う。
+// 要約の生成に失敗した期間は含めないが、ctxがキャンセルされた場合はエラーを返す
+func (h *DiaryHistoryBuilder) Build(ctx context.Context, userID, plantID int, capturedAt time.Time, summarize bool) ([]PromptSummary, []PromptDiary, error) {
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	today := jstDate(capturedAt)
+	recentStart, periods := historyPeriods(today)
+	oldest := periods[len(periods)-1].Start
+
+	from := time.Date(oldest.Year(), oldest.Month(), oldest.Day(), 0, 0, 0, 0, jst)
+	to := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, jst).Add(-time.Nanosecond)
+	diaries, err := h.repo.GetDiariesInDateRange(ctx, OwnerScope{UserID: userID, PlantID: plantID}, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get past diaries: %w", err)
+	}
+	diaries = dedupDailyDiaries(diaries)
+
+	var recent []Diary
+	byPeriod := make(map[time.Time][]Diary) // 期間の初日ごとの日記
+	for _, d := range diaries {
+		date := jstDate(d.CreatedAt)
+		if !date.Before(recentStart) {
+			recent = append(recent, d)
+			continue
+		}
+		for _, p := range periods {
+			if !date.Before(p.Start) && date.Before(p.End) {
+				byPeriod[p.Start] = append(byPeriod[p.Start], d)
+				break
+			}
+		}
+	}
+
+	recentPrompt, used := fitRecentDiaries(recent, h.tokenBudget)
+	if len(recentPrompt) < len(recent) || len(byPeriod) == 0 {
+		return nil, recentPrompt, nil
+	}
+	remaining := h.tokenBudget - used
+
+	stored, err := h.summaryRepo.GetDiarySummaries(ctx, userID, plantID, oldest.Format("2006-01-02"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get diary summaries: %w", err)
+	}
+	storedByKey := make(map[string]DiarySummary)
+	for _, s := range stored {
+		storedByKey[s.Period+"/"+s.StartDate] = s
+	}
+
+	var summaries []PromptSummary
+	generated := 0
+	canSummarize := summarize && h.summarizer != nil
+	for _, p := range periods {
+		periodDiaries := byPeriod[p.Start]
+		if len(periodDiaries) == 0 {
+			continue
+		}
+		startDate := p.Start.Format("2006-01-02")
+		hash := summarySourceHash(periodDiaries)
+
+		content := ""
+		if s, ok := storedByKey[p.Period+"/"+startDate]; ok && s.SourceHash == hash {
+			content = s.Content
+		} else if canSummarize && generated < maxSummariesPerPrompt {
+			// 生成前に目安の文字数で上限を確認し、含められない要約は生成しない
+			if summaryLength(p.Period)+promptEntryOverheadTokens > remaining {
+				break
+			}
+			if err := h.usage.CheckBudget(ctx, userID); err != nil {
+				if errors.Is(err, ErrBudgetExceeded) {
+					log.Printf("INFO: skipped summarizing diaries of user %d, plant %d: %v", userID, plantID, err)
+				} else {
+					log.Printf("WARN: failed to check usage budget of user %d for summary: %v", userID, err)
+				}
+				canSummarize = false
+				continue
+			}
+			generated++
+			start := time.Now()
+			result, err := h.summarizer.GenerateText(ctx, buildSummaryPrompt(p, periodDiaries))
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, nil, fmt.Errorf("failed to summarize diaries: %w", err)
+				}
+				log.Printf("WARN: failed to summarize diaries of user %d, plant %d (%s %s): %v", userID, plantID, p.Period, startDate, err)
+				continue
+			}
+			if result.Latency == 0 {
+				result.Latency = time.Since(start)
+			}
+			if err := h.usage.Record(ctx, userID, 0, result); err != nil {
+				log.Printf("WARN: failed to record usage of diary summary of user %d, plant %d: %v", userID, plantID, err)
+			}
+			content = strings.TrimSpace(result.Content)
+			if err := h.summaryRepo.SaveDiarySummary(ctx, userID, plantID, p.Period, startDate, hash, content); err != nil {
+				log.Printf("WARN: failed to save diary summary of user %d, plant %d (%s %s): %v", userID, plantID, p.Period, startDate, err)
+			}
+		}
+		if content == "" {
+			continue
+		}
+
+		cost := estimateTokens(content) + promptEntryOverheadTokens
+		if cost > remaining {
+			break
+		}
+		remaining -= cost
+		summaries = append(summaries, PromptSummary{Period: p.Label(), Content: content})
+	}
+
+	slices.Reverse(summaries)
+	return summaries, recentPrompt, nil
+}
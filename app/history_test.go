@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{name: "空", text: "", want: 0},
+		{name: "日本語は1文字1トークン", text: "新しい葉", want: 4},
+		{name: "ASCIIは4文字1トークン（切り上げ）", text: "leaves", want: 2},
+		{name: "混在", text: "葉が3枚", want: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimateTokens(tt.text); got != tt.want {
+				t.Errorf("estimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeekOfMonthStart(t *testing.T) {
+	tests := []struct {
+		name string
+		date time.Time
+		want int
+	}{
+		{name: "1日", date: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), want: 1},
+		{name: "7日", date: time.Date(2026, 2, 7, 0, 0, 0, 0, time.UTC), want: 1},
+		{name: "8日", date: time.Date(2026, 2, 8, 0, 0, 0, 0, time.UTC), want: 8},
+		{name: "21日", date: time.Date(2026, 2, 21, 0, 0, 0, 0, time.UTC), want: 15},
+		{name: "月末は22日からの週", date: time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC), want: 22},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := weekOfMonthStart(tt.date)
+			if got.Month() != tt.date.Month() || got.Day() != tt.want {
+				t.Errorf("weekOfMonthStart(%s) = %s, want day %d", tt.date.Format("2006-01-02"), got.Format("2006-01-02"), tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryPeriods(t *testing.T) {
+	recentStart, periods := historyPeriods(time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC))
+
+	// 7日前（03-13）を含む週から日記をそのまま含める
+	if got := recentStart.Format("2006-01-02"); got != "2026-03-08" {
+		t.Errorf("recentStart = %s, want 2026-03-08", got)
+	}
+
+	var got []string
+	for _, p := range periods {
+		got = append(got, p.Period+" "+p.Label())
+	}
+	// 前月の1日までは週ごと、その前の12ヶ月は月ごとに新しい順
+	want := []string{
+		"week 2026年03月01日〜03月07日",
+		"week 2026年02月22日〜02月28日",
+		"week 2026年02月15日〜02月21日",
+		"week 2026年02月08日〜02月14日",
+		"week 2026年02月01日〜02月07日",
+		"month 2026年01月",
+	}
+	if len(got) != 5+maxSummaryMonths || !reflect.DeepEqual(got[:len(want)], want) {
+		t.Errorf("unexpected periods: %v", got)
+	}
+	if last := got[len(got)-1]; last != "month 2025年02月" {
+		t.Errorf("expected oldest period 2025年02月, got %s", last)
+	}
+}
+
+func TestLoadPromptContextTokens(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    int
+		wantErr bool
+	}{
+		{name: "未設定の場合はデフォルト値", env: "", want: defaultPromptContextTokens},
+		{name: "指定した値", env: "4000", want: 4000},
+		{name: "0はエラー", env: "0", wantErr: true},
+		{name: "数値でない場合はエラー", env: "many", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PROMPT_CONTEXT_TOKENS", tt.env)
+			got, err := LoadPromptContextTokens()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadPromptContextTokens() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("LoadPromptContextTokens() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// countingTextGenerator は呼び出し回数を数え、要約したプロンプトを記録するテスト用のTextGenerator。
+// usageを設定した場合は消費トークン数として返す
+type countingTextGenerator struct {
+	prompts []string
+	usage   *GenerationUsage
+}
+
+func (g *countingTextGenerator) GenerateText(ctx context.Context, prompt string) (DiaryResult, error) {
+	g.prompts = append(g.prompts, prompt)
+	return DiaryResult{Content: fmt.Sprintf("要約%d", len(g.prompts)), Usage: g.usage}, nil
+}
+
+func TestDiaryHistoryBuilder_Build(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+	summaryRepo := NewSQLiteDiarySummaryRepository(db)
+
+	// 撮影日はJSTで 2026-03-20。日時はいずれもJSTの12時
+	jstNoon := func(month time.Month, day int) time.Time {
+		return time.Date(2026, month, day, 3, 0, 0, 0, time.UTC)
+	}
+	for _, d := range []struct {
+		createdAt time.Time
+		content   string
+	}{
+		{jstNoon(1, 15), "1月の日記"},
+		{jstNoon(2, 10), "2月の日記"},
+		{jstNoon(3, 18).Add(-time.Hour), "同じ日の前の日記"},
+		{jstNoon(3, 18), "最近の日記"},
+		{jstNoon(3, 20), "撮影日当日の日記"},
+	} {
+		if err := repo.CreateDiaryForUser(t.Context(), 1, 0, "/path/"+d.content+".jpg", d.content, d.createdAt); err != nil {
+			t.Fatalf("CreateDiaryForUser failed: %v", err)
+		}
+	}
+	capturedAt := jstNoon(3, 20)
+	summarizer := &countingTextGenerator{}
+	history := NewDiaryHistoryBuilder(repo, summaryRepo, summarizer, nil, 0)
+
+	// 古い期間は要約し、直近の日記は同じ日の最新の1件をそのまま含める
+	summaries, recent, err := history.Build(t.Context(), 1, 0, capturedAt, true)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	wantSummaries := []PromptSummary{
+		{Period: "2026年01月", Content: "要約2"},
+		{Period: "2026年02月08日〜02月14日", Content: "要約1"},
+	}
+	if !reflect.DeepEqual(summaries, wantSummaries) {
+		t.Errorf("unexpected summaries: %+v", summaries)
+	}
+	if want := []PromptDiary{{Date: "2026年03月18日", Content: "最近の日記"}}; !reflect.DeepEqual(recent, want) {
+		t.Errorf("unexpected recent diaries: %+v", recent)
+	}
+	if len(summarizer.prompts) != 2 || !strings.Contains(summarizer.prompts[0], "2月の日記") {
+		t.Fatalf("unexpected summarize prompts: %v", summarizer.prompts)
+	}
+
+	// 日記が変わっていない期間は保存済みの要約を再利用する
+	if _, _, err := history.Build(t.Context(), 1, 0, capturedAt, true); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if len(summarizer.prompts) != 2 {
+		t.Errorf("expected stored summaries to be reused, got %d calls", len(summarizer.prompts))
+	}
+
+	// 日記を編集した期間のみ作り直す
+	if err := repo.UpdateDiaryContent(t.Context(), 2, "編集した2月の日記"); err != nil {
+		t.Fatalf("UpdateDiaryContent failed: %v", err)
+	}
+	summaries, _, err = history.Build(t.Context(), 1, 0, capturedAt, true)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if len(summarizer.prompts) != 3 || !strings.Contains(summarizer.prompts[2], "編集した2月の日記") || summaries[1].Content != "要約3" {
+		t.Errorf("expected edited period to be summarized again, got summaries %+v", summaries)
+	}
+
+	// 要約を生成しない場合は、日記が変わった期間の要約を含めない
+	if err := repo.UpdateDiaryContent(t.Context(), 1, "編集した1月の日記"); err != nil {
+		t.Fatalf("UpdateDiaryContent failed: %v", err)
+	}
+	summaries, _, err = history.Build(t.Context(), 1, 0, capturedAt, false)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if len(summarizer.prompts) != 3 || len(summaries) != 1 || summaries[0].Period != "2026年02月08日〜02月14日" {
+		t.Errorf("expected only up-to-date summary without summarizing, got %+v", summaries)
+	}
+
+	// 直近の日記で上限に達する場合は要約を生成しない
+	small := NewDiaryHistoryBuilder(repo, summaryRepo, summarizer, nil, 30)
+	summaries, recent, err = small.Build(t.Context(), 1, 0, capturedAt, true)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if len(summaries) != 0 || len(recent) != 1 || len(summarizer.prompts) != 3 {
+		t.Errorf("expected no summaries within small budget, got summaries %+v, recent %+v", summaries, recent)
+	}
+}
+
+func TestDiaryPromptBuilder_Build_WithHistory(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+	if err := repo.CreateDiaryForUser(t.Context(), 1, 0, "/path/1.jpg", "1月の日記", time.Date(2026, 1, 15, 3, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	history := NewDiaryHistoryBuilder(repo, NewSQLiteDiarySummaryRepository(db), &MockDiaryGenerator{}, nil, 0)
+	builder := NewDiaryPromptBuilder(repo, NewSQLitePromptTemplateRepository(db), NewSQLitePlantRepository(db), history, nil)
+
+	prompt := builder.Build(t.Context(), 1, 0, nil, time.Date(2026, 3, 20, 3, 0, 0, 0, time.UTC))
+	if !strings.Contains(prompt, "【2026年01月のまとめ】\nこの期間は葉が順調に増え") {
+		t.Errorf("expected prompt to contain summary, got %q", prompt)
+	}
+}
+
+func TestDiaryHistoryBuilder_Build_SeparatesPlants(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+	summaryRepo := NewSQLiteDiarySummaryRepository(db)
+	plants := createTestPlants(t, NewSQLitePlantRepository(db), 1, "ミニトマト", "バジル")
+
+	// 撮影日はJSTで 2026-03-20。1月は要約し、3月18日はそのまま含める
+	for _, d := range []struct {
+		plantID   int
+		createdAt time.Time
+		content   string
+	}{
+		{plants[0], time.Date(2026, 1, 15, 3, 0, 0, 0, time.UTC), "1月のトマトの日記"},
+		{plants[1], time.Date(2026, 1, 16, 3, 0, 0, 0, time.UTC), "1月のバジルの日記"},
+		{plants[0], time.Date(2026, 3, 18, 3, 0, 0, 0, time.UTC), "最近のトマトの日記"},
+		{plants[1], time.Date(2026, 3, 18, 3, 0, 0, 0, time.UTC), "最近のバジルの日記"},
+	} {
+		if err := repo.CreateDiaryForUser(t.Context(), 1, d.plantID, "/path/"+d.content+".jpg", d.content, d.createdAt); err != nil {
+			t.Fatalf("CreateDiaryForUser failed: %v", err)
+		}
+	}
+	summarizer := &countingTextGenerator{}
+	history := NewDiaryHistoryBuilder(repo, summaryRepo, summarizer, nil, 0)
+	capturedAt := time.Date(2026, 3, 20, 3, 0, 0, 0, time.UTC)
+
+	for i, tt := range []struct {
+		plantID int
+		want    string
+		exclude string
+	}{
+		{plants[0], "トマト", "バジル"},
+		{plants[1], "バジル", "トマト"},
+	} {
+		summaries, recent, err := history.Build(t.Context(), 1, tt.plantID, capturedAt, true)
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		if len(recent) != 1 || !strings.Contains(recent[0].Content, tt.want) {
+			t.Errorf("plant %d: unexpected recent diaries: %+v", tt.plantID, recent)
+		}
+		// 植物ごとに要約を生成し、他の植物の日記を含めない
+		if len(summaries) != 1 || len(summarizer.prompts) != i+1 {
+			t.Fatalf("plant %d: unexpected summaries %+v, prompts %v", tt.plantID, summaries, summarizer.prompts)
+		}
+		prompt := summarizer.prompts[i]
+		if !strings.Contains(prompt, tt.want) || strings.Contains(prompt, tt.exclude) {
+			t.Errorf("plant %d: unexpected summarize prompt: %q", tt.plantID, prompt)
+		}
+
+		stored, err := summaryRepo.GetDiarySummaries(t.Context(), 1, tt.plantID, "2026-01-01")
+		if err != nil {
+			t.Fatalf("GetDiarySummaries failed: %v", err)
+		}
+		if len(stored) != 1 || stored[0].PlantID != tt.plantID {
+			t.Errorf("plant %d: unexpected stored summaries: %+v", tt.plantID, stored)
+		}
+	}
+}
+
+func TestDiaryHistoryBuilder_Build_RecordsSummaryUsage(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+	usageRepo := NewSQLiteUsageRepository(db)
+	tracker := NewUsageTracker(usageRepo, map[string]ModelPrice{"test-model": {Input: 1, Output: 4}})
+	for _, month := range []time.Month{1, 2} {
+		content := fmt.Sprintf("%d月の日記", month)
+		if err := repo.CreateDiaryForUser(t.Context(), 1, 0, "/path/"+content+".jpg", content, time.Date(2026, month, 15, 3, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatalf("CreateDiaryForUser failed: %v", err)
+		}
+	}
+	summarizer := &countingTextGenerator{usage: &GenerationUsage{Model: "test-model", PromptTokens: 1000, ResponseTokens: 500}}
+	history := NewDiaryHistoryBuilder(repo, NewSQLiteDiarySummaryRepository(db), summarizer, tracker, 0)
+	capturedAt := time.Date(2026, 3, 20, 3, 0, 0, 0, time.UTC)
+
+	// 要約の生成ごとに、日記を持たない使用量として記録する
+	if _, _, err := history.Build(t.Context(), 1, 0, capturedAt, true); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	records, err := usageRepo.GetUsageRecords(t.Context(), OwnerScope{UserID: 1}, time.Time{}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetUsageRecords failed: %v", err)
+	}
+	if len(records) != len(summarizer.prompts) || len(records) == 0 {
+		t.Fatalf("expected %d usage records, got %+v", len(summarizer.prompts), records)
+	}
+	for _, r := range records {
+		if r.DiaryID != 0 || r.Model != "test-model" || r.PromptTokens != 1000 || r.Cost <= 0 || r.Latency < 0 {
+			t.Errorf("unexpected usage record: %+v", r)
+		}
+	}
+
+	// 予算の上限に達している場合は要約を生成しない
+	if err := usageRepo.SaveUsageBudget(t.Context(), 1, 0.001, 0); err != nil {
+		t.Fatalf("SaveUsageBudget failed: %v", err)
+	}
+	if err := repo.UpdateDiaryContent(t.Context(), 1, "編集した1月の日記"); err != nil {
+		t.Fatalf("UpdateDiaryContent failed: %v", err)
+	}
+	calls := len(summarizer.prompts)
+	summaries, _, err := history.Build(t.Context(), 1, 0, capturedAt, true)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if len(summarizer.prompts) != calls {
+		t.Errorf("expected no summary generation over budget, got %d calls", len(summarizer.prompts)-calls)
+	}
+	// 日記が変わっていない期間の保存済みの要約は使う
+	if len(summaries) != calls-1 {
+		t.Errorf("expected stored summaries only, got %+v", summaries)
+	}
+}
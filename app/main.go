@@ -3,38 +3,53 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
 
+const (
+	// defaultPhotosDir は写真ディレクトリ
+	defaultPhotosDir = "data/photos"
+	// defaultDBPath はSQLiteのDBファイル
+	defaultDBPath = "data/plant_log.db"
+	// defaultMigrationsPath はマイグレーションファイルのディレクトリ
+	defaultMigrationsPath = "migrations"
+)
+
 func main() {
+	// サブコマンドを指定した場合はサーバーを起動せず、そのコマンドを実行して終了する
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "backfill-derivatives":
+			backfillPhotoDerivatives()
+		case "backfill-photo-hashes":
+			backfillPhotoHashes()
+		default:
+			log.Fatalf("FATAL: unknown command %q (available: backfill-derivatives, backfill-photo-hashes)", os.Args[1])
+		}
+		return
+	}
+
 	log.Println("INFO: Starting Plant Diary System...")
 
 	// DB初期化とマイグレーション実行
-	dbPath := "data/plant_log.db"
-	migrationsPath := "migrations"
-	db, err := InitDB(dbPath, migrationsPath)
+	db, err := InitDB(defaultDBPath, defaultMigrationsPath)
 	if err != nil {
 		log.Fatalf("FATAL: failed to initialize database: %v", err)
 	}
 	defer db.Close()
 
-	// DiaryGenerator の初期化
-	var generator DiaryGenerator
-	if apiKey := os.Getenv("GEMINI_API_KEY"); apiKey != "" {
-		geminiGen, err := NewGeminiDiaryGenerator()
-		if err != nil {
-			log.Fatalf("FATAL: failed to initialize Gemini API: %v", err)
-		}
-		generator = geminiGen
-		log.Println("INFO: Using GeminiDiaryGenerator")
-	} else {
-		generator = &MockDiaryGenerator{}
-		log.Println("INFO: Using MockDiaryGenerator (GEMINI_API_KEY not set)")
+	// DiaryGenerator の初期化（DIARY_PROVIDERS で指定した順にプロバイダを試す）
+	generator, err := NewDiaryGeneratorFromEnv()
+	if err != nil {
+		log.Fatalf("FATAL: failed to initialize diary generator: %v", err)
 	}
+	log.Printf("INFO: Using diary providers: %s", strings.Join(generator.ProviderNames(), " -> "))
 
 	// DiaryRepository の初期化（SQLite実装）
 	repo := NewSQLiteDiaryRepository(db)
@@ -46,16 +61,99 @@ func main() {
 	// SessionRepository の初期化（SQLite実装）
 	sessionRepo := NewSQLiteSessionRepository(db)
 
+	// JobRepository の初期化（SQLite実装）
+	jobRepo := NewSQLiteJobRepository(db)
+
+	// DiaryRevisionRepository の初期化（SQLite実装）
+	revisionRepo := NewSQLiteDiaryRevisionRepository(db)
+
+	// PlantRepository の初期化（SQLite実装）
+	plantRepo := NewSQLitePlantRepository(db)
+
+	// APITokenRepository の初期化（SQLite実装）
+	apiTokenRepo := NewSQLiteAPITokenRepository(db)
+
+	// PromptTemplateRepository の初期化（SQLite実装）
+	promptRepo := NewSQLitePromptTemplateRepository(db)
+
+	// DiarySummaryRepository の初期化（SQLite実装）
+	summaryRepo := NewSQLiteDiarySummaryRepository(db)
+
+	// UsageRepository の初期化（SQLite実装）
+	usageRepo := NewSQLiteUsageRepository(db)
+
+	// PhotoMetadataRepository の初期化（SQLite実装）
+	photoMetaRepo := NewSQLitePhotoMetadataRepository(db)
+
+	// UploadIdempotencyKeyRepository の初期化（SQLite実装）
+	uploadKeyRepo := NewSQLiteUploadIdempotencyKeyRepository(db)
+
+	// PhotoHashRepository の初期化（SQLite実装）
+	photoHashRepo := NewSQLitePhotoHashRepository(db)
+
+	// 日記の生成の使用量の記録と予算の確認（料金は USAGE_PRICES で上書きできる）
+	prices, err := LoadModelPrices()
+	if err != nil {
+		log.Fatalf("FATAL: invalid usage prices: %v", err)
+	}
+	usage := NewUsageTracker(usageRepo, prices)
+
+	// プロンプトに含める過去日記（PROMPT_CONTEXT_TOKENS の推定トークン数まで。古い期間は日記生成プロバイダで要約する）
+	contextTokens, err := LoadPromptContextTokens()
+	if err != nil {
+		log.Fatalf("FATAL: invalid prompt context config: %v", err)
+	}
+	history := NewDiaryHistoryBuilder(repo, summaryRepo, generator, usage, contextTokens)
+
+	// 天気の取得（WEATHER_LATITUDE / WEATHER_LONGITUDE が未設定の場合はプロンプトに天気を含めない）
+	weather, err := NewWeatherProviderFromEnv()
+	if err != nil {
+		log.Fatalf("FATAL: invalid weather config: %v", err)
+	}
+	if weather != nil {
+		log.Println("INFO: Using Open-Meteo weather for diary prompts")
+	}
+	prompts := NewDiaryPromptBuilder(repo, promptRepo, plantRepo, history, weather)
+
+	// 日記生成Workerプールの起動（HTTPサーバー起動前に未処理のジョブをキューへ投入する）
+	workerConfig, err := LoadDiaryWorkerConfig()
+	if err != nil {
+		log.Fatalf("FATAL: invalid worker config: %v", err)
+	}
+	worker := NewDiaryWorker(repo, jobRepo, generator, prompts, usage, workerConfig)
+	worker.Start()
+
+	// 写真ディレクトリのポーリング開始（撮影スクリプトが直接保存した写真をジョブとして登録する）
+	pollInterval, err := LoadPhotoPollInterval()
+	if err != nil {
+		log.Fatalf("FATAL: invalid photo poll interval: %v", err)
+	}
+	pollerCtx, pollerCancel := context.WithCancel(context.Background())
+	pollerDone := make(chan struct{})
+	if pollInterval > 0 {
+		poller := NewPhotoPoller(defaultPhotosDir, pollInterval, repo, userRepo, jobRepo, photoHashRepo, photoMetaRepo, worker)
+		go func() {
+			defer close(pollerDone)
+			poller.Run(pollerCtx)
+		}()
+		log.Printf("INFO: Polling %s every %v", defaultPhotosDir, pollInterval)
+	} else {
+		close(pollerDone)
+		log.Println("INFO: Photo polling disabled (PHOTO_POLL_INTERVAL=0)")
+	}
+
 	// HTTPサーバーの初期化と起動
-	photosDir := "data/photos"
-	srv, err := NewServer(repo, userRepo, sessionRepo, generator, photosDir)
+	srv, err := NewServer(repo, userRepo, sessionRepo, jobRepo, revisionRepo, plantRepo, apiTokenRepo, promptRepo, usageRepo, photoMetaRepo, uploadKeyRepo, photoHashRepo, generator, prompts, usage, worker, defaultPhotosDir)
 	if err != nil {
 		log.Fatalf("FATAL: failed to initialize server: %v", err)
 	}
 
+	// リクエストのcontextの親。Graceful Shutdown の後にキャンセルし、終わらなかったリクエストの生成やDBの操作を中断する
+	baseCtx, baseCancel := context.WithCancel(context.Background())
 	httpServer := &http.Server{
-		Addr:    ":8080",
-		Handler: srv,
+		Addr:        ":8080",
+		Handler:     srv,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
 	}
 
 	go func() {
@@ -79,6 +177,52 @@ func main() {
 	if err := httpServer.Shutdown(shutdownCtx); err != nil {
 		log.Printf("ERROR: HTTP server shutdown error: %v", err)
 	}
+	baseCancel()
+
+	// ポーリングの停止
+	pollerCancel()
+	<-pollerDone
+
+	// Workerプールの停止（処理中のジョブの完了を最大30秒待つ。時間内に終わらないジョブはキャンセルし、次回起動時に再実行される）
+	workerShutdownCtx, workerShutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer workerShutdownCancel()
+
+	if err := worker.Shutdown(workerShutdownCtx); err != nil {
+		log.Printf("WARN: worker shutdown did not complete: %v; unfinished jobs will be resumed on next start", err)
+	}
 
 	log.Println("INFO: Plant Diary System stopped")
 }
+
+// backfillPhotoDerivatives は既存の写真のうち、縮小画像がないものについて縮小画像を生成する
+func backfillPhotoDerivatives() {
+	log.Printf("INFO: Generating photo derivatives under %s...", defaultPhotosDir)
+	generated, failed, err := BackfillPhotoDerivatives(defaultPhotosDir)
+	if err != nil {
+		log.Fatalf("FATAL: failed to backfill photo derivatives: %v", err)
+	}
+	log.Printf("INFO: Generated photo derivatives for %d photo(s), %d failed", generated, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// backfillPhotoHashes は既存の日記の写真のうち、知覚ハッシュが未記録のものについて知覚ハッシュを記録する
+func backfillPhotoHashes() {
+	db, err := InitDB(defaultDBPath, defaultMigrationsPath)
+	if err != nil {
+		log.Fatalf("FATAL: failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	log.Println("INFO: Computing photo hashes of diaries...")
+	saved, failed, err := BackfillPhotoHashes(context.Background(), NewSQLiteDiaryRepository(db), NewSQLitePhotoHashRepository(db))
+	if err != nil {
+		log.Fatalf("FATAL: failed to backfill photo hashes: %v", err)
+	}
+	log.Printf("INFO: Saved photo hashes for %d photo(s), %d failed", saved, failed)
+	if failed > 0 {
+		db.Close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_jobs_status_created_at;
+DROP TABLE IF EXISTS jobs;
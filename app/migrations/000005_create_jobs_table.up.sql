@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id          TEXT PRIMARY KEY,       -- UUID（ハイフンなし32文字、APIのjob_id）
+    user_id     INTEGER NOT NULL REFERENCES users(id),
+    image_path  TEXT NOT NULL,
+    captured_at DATETIME NOT NULL,
+    status      TEXT NOT NULL DEFAULT 'queued', -- queued / running / succeeded / failed
+    attempts    INTEGER NOT NULL DEFAULT 0,
+    last_error  TEXT,
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_jobs_status_created_at ON jobs(status, created_at);
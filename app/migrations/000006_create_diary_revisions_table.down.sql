@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_diary_revisions_diary_id;
+DROP TABLE IF EXISTS diary_revisions;
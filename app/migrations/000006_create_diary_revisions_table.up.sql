@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS diary_revisions (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    diary_id   INTEGER NOT NULL REFERENCES diary(id),
+    content    TEXT NOT NULL,
+    source     TEXT NOT NULL,            -- generation / original / regeneration / restore
+    user_id    INTEGER REFERENCES users(id), -- 変更したユーザー（API・Workerによる変更はNULL）
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_diary_revisions_diary_id ON diary_revisions(diary_id, id DESC);
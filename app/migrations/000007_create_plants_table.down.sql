@@ -0,0 +1,5 @@
+ALTER TABLE jobs DROP COLUMN plant_id;
+DROP INDEX IF EXISTS idx_diary_plant_id;
+ALTER TABLE diary DROP COLUMN plant_id;
+DROP INDEX IF EXISTS idx_plants_user_id;
+DROP TABLE IF EXISTS plants;
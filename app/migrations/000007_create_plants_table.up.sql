@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS plants (
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    uuid           TEXT NOT NULL UNIQUE,
+    user_id        INTEGER NOT NULL REFERENCES users(id),
+    name           TEXT NOT NULL,
+    species        TEXT NOT NULL DEFAULT '',
+    location       TEXT NOT NULL DEFAULT '',
+    acquired_on    DATE,                          -- 入手日（不明の場合はNULL）
+    cover_diary_id INTEGER REFERENCES diary(id),  -- 表紙の写真（NULLの場合は最新の日記の写真）
+    created_at     DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_plants_user_id ON plants(user_id);
+
+-- plant_idカラム追加（NULL許容、植物未指定の日記・ジョブはNULL）
+ALTER TABLE diary ADD COLUMN plant_id INTEGER REFERENCES plants(id);
+CREATE INDEX IF NOT EXISTS idx_diary_plant_id ON diary(plant_id, created_at DESC);
+ALTER TABLE jobs ADD COLUMN plant_id INTEGER REFERENCES plants(id);
@@ -0,0 +1,2 @@
+-- 日記の公開設定（1: 未ログインの閲覧者にも公開、0: 本人のみ）。既存ユーザーは従来どおり公開
+ALTER TABLE users ADD COLUMN diaries_public INTEGER NOT NULL DEFAULT 1;
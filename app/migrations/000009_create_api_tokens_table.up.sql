@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id      INTEGER NOT NULL REFERENCES users(id),
+    name         TEXT NOT NULL,
+    token_hash   TEXT NOT NULL UNIQUE,   -- トークンのSHA-256（平文は保存しない）
+    prefix       TEXT NOT NULL,          -- 一覧表示用のトークン先頭部分
+    scope        TEXT NOT NULL,          -- upload / read / admin
+    last_used_at DATETIME,
+    revoked_at   DATETIME,               -- 失効日時（NULLの場合は有効）
+    created_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
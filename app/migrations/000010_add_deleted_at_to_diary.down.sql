@@ -0,0 +1 @@
+ALTER TABLE diary DROP COLUMN deleted_at;
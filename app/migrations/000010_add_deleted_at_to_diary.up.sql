@@ -0,0 +1,2 @@
+-- ゴミ箱へ移動した日時（NULLの場合は通常の日記）。ゴミ箱の日記は一覧・検索・日記生成の文脈から除外する
+ALTER TABLE diary ADD COLUMN deleted_at DATETIME;
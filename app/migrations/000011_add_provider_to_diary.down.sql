@@ -0,0 +1 @@
+ALTER TABLE diary DROP COLUMN provider;
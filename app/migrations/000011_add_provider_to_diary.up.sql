@@ -0,0 +1,2 @@
+-- 日記本文を生成したプロバイダ名（gemini、ollama、template など）。不明な場合は空文字
+ALTER TABLE diary ADD COLUMN provider TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS diary_tags;
+DROP TABLE IF EXISTS diary_issues;
+DROP TABLE IF EXISTS diary_observations;
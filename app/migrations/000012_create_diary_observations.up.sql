@@ -0,0 +1,20 @@
+-- 日記の生成時に写真から推定した植物の状態（構造化出力に対応したプロバイダで生成した日記のみ）
+CREATE TABLE IF NOT EXISTS diary_observations (
+    diary_id     INTEGER PRIMARY KEY REFERENCES diary(id),
+    health_score INTEGER NOT NULL DEFAULT 0,  -- 健康状態（1〜5、不明の場合0）
+    leaf_count   INTEGER NOT NULL DEFAULT 0,  -- 葉の枚数（不明の場合0）
+    flowering    INTEGER NOT NULL DEFAULT 0,  -- 花が咲いているか
+    fruiting     INTEGER NOT NULL DEFAULT 0   -- 実がなっているか
+);
+-- 写真から検出した問題（yellowing / pests など）
+CREATE TABLE IF NOT EXISTS diary_issues (
+    diary_id INTEGER NOT NULL REFERENCES diary(id),
+    issue    TEXT NOT NULL,
+    PRIMARY KEY (diary_id, issue)
+);
+-- 写真の特徴を表すタグ（新芽、つぼみ など）
+CREATE TABLE IF NOT EXISTS diary_tags (
+    diary_id INTEGER NOT NULL REFERENCES diary(id),
+    tag      TEXT NOT NULL,
+    PRIMARY KEY (diary_id, tag)
+);
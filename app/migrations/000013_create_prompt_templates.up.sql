@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS prompt_templates (
+    user_id    INTEGER NOT NULL REFERENCES users(id),
+    plant_id   INTEGER NOT NULL DEFAULT 0,   -- 設定した植物（0の場合はユーザー全体の設定）
+    persona    TEXT NOT NULL,                -- standard / child / botanist / haiku / custom
+    template   TEXT NOT NULL DEFAULT '',     -- personaがcustomの場合に使うtext/template形式のテンプレート
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, plant_id)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS usage_budgets;
+DROP INDEX IF EXISTS idx_generation_usage_user_created_at;
+DROP TABLE IF EXISTS generation_usage;
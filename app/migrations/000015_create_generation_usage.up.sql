@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS generation_usage (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id         INTEGER NOT NULL REFERENCES users(id),
+    diary_id        INTEGER,                      -- 生成した日記（削除済みの場合も使用量は残す）
+    provider        TEXT NOT NULL DEFAULT '',
+    model           TEXT NOT NULL DEFAULT '',
+    prompt_tokens   INTEGER NOT NULL DEFAULT 0,
+    response_tokens INTEGER NOT NULL DEFAULT 0,
+    cost            REAL NOT NULL DEFAULT 0,      -- 記録時の料金設定で計算した料金（USD）
+    latency_ms      INTEGER NOT NULL DEFAULT 0,
+    created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_generation_usage_user_created_at ON generation_usage(user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS usage_budgets (
+    user_id       INTEGER PRIMARY KEY,            -- 0は全ユーザーの既定の上限
+    daily_limit   REAL NOT NULL DEFAULT 0,        -- 1日の料金の上限（USD、0は上限なし）
+    monthly_limit REAL NOT NULL DEFAULT 0,        -- 1ヶ月の料金の上限（USD、0は上限なし）
+    updated_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS photo_metadata (
+    image_path    TEXT PRIMARY KEY,               -- diary.image_path / jobs.image_path と同じパス
+    captured_at   DATETIME,                       -- EXIFの撮影日時（DateTimeOriginal）
+    orientation   INTEGER NOT NULL DEFAULT 0,     -- EXIFの向き（1〜8、保存した写真は回転済み）
+    camera_make   TEXT NOT NULL DEFAULT '',
+    camera_model  TEXT NOT NULL DEFAULT '',
+    exposure_time TEXT NOT NULL DEFAULT '',       -- 露出時間（秒、「1/125」形式）
+    f_number      REAL NOT NULL DEFAULT 0,
+    iso           INTEGER NOT NULL DEFAULT 0,
+    focal_length  REAL NOT NULL DEFAULT 0,        -- 焦点距離（mm）
+    latitude      REAL,
+    longitude     REAL,
+    created_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS upload_idempotency_keys (
+    user_id     INTEGER NOT NULL REFERENCES users(id),
+    key         TEXT NOT NULL,                    -- Idempotency-Key ヘッダーの値
+    fingerprint TEXT NOT NULL,                    -- リクエストの内容（写真・plant_uuid・captured_at）のハッシュ
+    job_id      TEXT NOT NULL DEFAULT '',         -- 登録したジョブ（空の場合は処理中）
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, key)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS photo_hashes (
+    image_path   TEXT PRIMARY KEY,                  -- 写真のパス（diary.image_path と対応）
+    user_id      INTEGER NOT NULL REFERENCES users(id),
+    plant_id     INTEGER NOT NULL DEFAULT 0,        -- 植物未指定の場合は0
+    hash         TEXT NOT NULL,                     -- 写真の知覚ハッシュ（dHash、16桁の16進数）
+    captured_at  DATETIME NOT NULL,
+    duplicate_of TEXT NOT NULL DEFAULT '',          -- 重複として統合した写真の場合、統合先の写真のパス
+    created_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_photo_hashes_user_captured_at ON photo_hashes(user_id, plant_id, captured_at);
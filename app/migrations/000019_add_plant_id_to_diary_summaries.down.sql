@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS diary_summaries;
+CREATE TABLE IF NOT EXISTS diary_summaries (
+    user_id     INTEGER NOT NULL REFERENCES users(id),
+    period      TEXT NOT NULL,                -- week / month
+    start_date  TEXT NOT NULL,                -- 期間の初日（JST、YYYY-MM-DD）
+    source_hash TEXT NOT NULL,                -- 要約した日記のハッシュ（一致しない場合は作り直す）
+    content     TEXT NOT NULL,
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, period, start_date)
+);
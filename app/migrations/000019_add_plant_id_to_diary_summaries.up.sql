@@ -0,0 +1,13 @@
+-- 要約を植物ごとに分けるため、主キーに植物を加えて作り直す。
+-- 既存の要約は複数の植物の日記が混ざっているため破棄する（次回の日記生成時に作り直す）
+DROP TABLE IF EXISTS diary_summaries;
+CREATE TABLE IF NOT EXISTS diary_summaries (
+    user_id     INTEGER NOT NULL REFERENCES users(id),
+    plant_id    INTEGER NOT NULL DEFAULT 0,   -- 要約した日記の植物（0の場合はユーザーの全ての日記）
+    period      TEXT NOT NULL,                -- week / month
+    start_date  TEXT NOT NULL,                -- 期間の初日（JST、YYYY-MM-DD）
+    source_hash TEXT NOT NULL,                -- 要約した日記のハッシュ（一致しない場合は作り直す）
+    content     TEXT NOT NULL,
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, plant_id, period, start_date)
+);
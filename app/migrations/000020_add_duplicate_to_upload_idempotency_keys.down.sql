@@ -0,0 +1,4 @@
+ALTER TABLE upload_idempotency_keys DROP COLUMN image_path;
+ALTER TABLE upload_idempotency_keys DROP COLUMN distance;
+ALTER TABLE upload_idempotency_keys DROP COLUMN duplicate_of;
+ALTER TABLE upload_idempotency_keys DROP COLUMN action;
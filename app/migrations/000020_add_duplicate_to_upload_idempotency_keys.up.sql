@@ -0,0 +1,5 @@
+-- 重複する写真としてスキップ・統合したリクエストも、同じキーの再送に同じ結果を返せるよう記録する
+ALTER TABLE upload_idempotency_keys ADD COLUMN action TEXT NOT NULL DEFAULT '';        -- 重複する写真の処理（skipped / merged、ジョブを登録した場合は空）
+ALTER TABLE upload_idempotency_keys ADD COLUMN duplicate_of TEXT NOT NULL DEFAULT '';  -- 重複する写真のパス
+ALTER TABLE upload_idempotency_keys ADD COLUMN distance INTEGER NOT NULL DEFAULT 0;    -- 重複する写真との知覚ハッシュの距離
+ALTER TABLE upload_idempotency_keys ADD COLUMN image_path TEXT NOT NULL DEFAULT '';    -- 統合して保存した写真のパス（merged の場合のみ）
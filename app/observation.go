@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// 写真から検出する問題の種類。構造化出力のスキーマで選択肢として指定し、一覧ページの絞り込みに使う
+const (
+	IssueYellowing     = "yellowing"     // 葉の黄変
+	IssueBrowning      = "browning"      // 葉先・葉の褐変
+	IssueWilting       = "wilting"       // しおれ
+	IssuePests         = "pests"         // 害虫
+	IssueDisease       = "disease"       // 病気（斑点・カビなど）
+	IssueOverwatering  = "overwatering"  // 水のやりすぎ
+	IssueUnderwatering = "underwatering" // 水不足
+	IssueSunburn       = "sunburn"       // 葉焼け
+)
+
+// diaryIssues は問題の種類を表示順に並べたもの
+var diaryIssues = []string{
+	IssueYellowing, IssueBrowning, IssueWilting, IssuePests,
+	IssueDisease, IssueOverwatering, IssueUnderwatering, IssueSunburn,
+}
+
+// diaryIssueLabels は問題の種類の表示名
+var diaryIssueLabels = map[string]string{
+	IssueYellowing:     "葉の黄変",
+	IssueBrowning:      "葉の褐変",
+	IssueWilting:       "しおれ",
+	IssuePests:         "害虫",
+	IssueDisease:       "病気",
+	IssueOverwatering:  "水のやりすぎ",
+	IssueUnderwatering: "水不足",
+	IssueSunburn:       "葉焼け",
+}
+
+const (
+	maxHealthScore     = 5  // 健康状態の最高値（1〜5で推定する）
+	maxObservationTags = 5  // 1件の日記に記録するタグの最大数
+	maxTagLength       = 20 // タグの最大文字数
+)
+
+// issueLabel は問題の種類の表示名を返す。未知の種類はそのまま返す
+func issueLabel(issue string) string {
+	if label, ok := diaryIssueLabels[issue]; ok {
+		return label
+	}
+	return issue
+}
+
+// structuredDiary は構造化出力で受け取る日記本文と植物の状態
+type structuredDiary struct {
+	Diary       string   `json:"diary"`
+	HealthScore int      `json:"health_score"`
+	LeafCount   int      `json:"leaf_count"`
+	Flowering   bool     `json:"flowering"`
+	Fruiting    bool     `json:"fruiting"`
+	Issues      []string `json:"issues"`
+	Tags        []string `json:"tags"`
+}
+
+// parseStructuredDiary は構造化出力のJSONを日記本文と植物の状態に変換する。
+// 推定値はモデルがスキーマの範囲を守らない場合に備えて、範囲外の値や未知の問題を除いて記録する
+func parseStructuredDiary(text string) (string, *DiaryObservation, error) {
+	var sd structuredDiary
+	if err := json.Unmarshal([]byte(text), &sd); err != nil {
+		return "", nil, fmt.Errorf("構造化出力の解析に失敗: %w", err)
+	}
+	content := strings.TrimSpace(sd.Diary)
+	if content == "" {
+		return "", nil, fmt.Errorf("構造化出力の日記本文が空です")
+	}
+
+	obs := &DiaryObservation{
+		Flowering: sd.Flowering,
+		Fruiting:  sd.Fruiting,
+	}
+	if sd.HealthScore >= 1 && sd.HealthScore <= maxHealthScore {
+		obs.HealthScore = sd.HealthScore
+	}
+	if sd.LeafCount > 0 {
+		obs.LeafCount = sd.LeafCount
+	}
+
+	seen := make(map[string]bool)
+	for _, issue := range sd.Issues {
+		issue = strings.ToLower(strings.TrimSpace(issue))
+		if _, ok := diaryIssueLabels[issue]; ok && !seen[issue] {
+			seen[issue] = true
+			obs.Issues = append(obs.Issues, issue)
+		}
+	}
+	seen = make(map[string]bool)
+	for _, tag := range sd.Tags {
+		tag = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+		if tag == "" || len([]rune(tag)) > maxTagLength || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		obs.Tags = append(obs.Tags, tag)
+		if len(obs.Tags) == maxObservationTags {
+			break
+		}
+	}
+
+	return content, obs, nil
+}
+
+// ObservationFilter は一覧ページで植物の状態により日記を絞り込む条件。ゼロ値は絞り込みなし
+type ObservationFilter struct {
+	Tag       string
+	Issue     string
+	Flowering bool
+	Fruiting  bool
+}
+
+// IsZero は絞り込み条件が指定されていないかどうかを返す
+func (f ObservationFilter) IsZero() bool {
+	return f == ObservationFilter{}
+}
+
+// Match は植物の状態が絞り込み条件を満たすかどうかを返す。植物の状態がない日記（observationがnil）は条件を満たさない
+func (f ObservationFilter) Match(observation *DiaryObservation) bool {
+	if f.IsZero() {
+		return true
+	}
+	if observation == nil {
+		return false
+	}
+	if f.Tag != "" && !slices.Contains(observation.Tags, f.Tag) {
+		return false
+	}
+	if f.Issue != "" && !slices.Contains(observation.Issues, f.Issue) {
+		return false
+	}
+	return (!f.Flowering || observation.Flowering) && (!f.Fruiting || observation.Fruiting)
+}
+
+// observationLabels は植物の状態に含まれるタグ（件数の多い順）と問題（diaryIssues の順）を重複なく返す
+func observationLabels(observations map[int]DiaryObservation) ([]string, []string) {
+	tagCounts := make(map[string]int)
+	issueFound := make(map[string]bool)
+	for _, o := range observations {
+		for _, tag := range o.Tags {
+			tagCounts[tag]++
+		}
+		for _, issue := range o.Issues {
+			issueFound[issue] = true
+		}
+	}
+
+	tags := make([]string, 0, len(tagCounts))
+	for tag := range tagCounts {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tagCounts[tags[i]] != tagCounts[tags[j]] {
+			return tagCounts[tags[i]] > tagCounts[tags[j]]
+		}
+		return tags[i] < tags[j]
+	})
+
+	var issues []string
+	for _, issue := range diaryIssues {
+		if issueFound[issue] {
+			issues = append(issues, issue)
+		}
+	}
+	return tags, issues
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStructuredDiary(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		wantContent string
+		want        *DiaryObservation
+		wantErr     bool
+	}{
+		{
+			name: "全ての項目",
+			text: `{"diary":" 花が咲きました。\n","health_score":5,"leaf_count":20,"flowering":true,"fruiting":false,` +
+				`"issues":["yellowing","pests"],"tags":["開花","つぼみ"]}`,
+			wantContent: "花が咲きました。",
+			want: &DiaryObservation{
+				HealthScore: 5,
+				LeafCount:   20,
+				Flowering:   true,
+				Issues:      []string{IssueYellowing, IssuePests},
+				Tags:        []string{"開花", "つぼみ"},
+			},
+		},
+		{
+			name: "範囲外の値や未知の問題は記録しない",
+			text: `{"diary":"元気です。","health_score":9,"leaf_count":-1,"issues":["Pests","unknown","pests"],` +
+				`"tags":["#新芽"," ","新芽","とても長いタグとても長いタグとても長いタグ","a","b","c","d","e"]}`,
+			wantContent: "元気です。",
+			want: &DiaryObservation{
+				Issues: []string{IssuePests},
+				Tags:   []string{"新芽", "a", "b", "c", "d"},
+			},
+		},
+		{
+			name:    "本文が空",
+			text:    `{"diary":"","health_score":3}`,
+			wantErr: true,
+		},
+		{
+			name:    "JSONでない",
+			text:    "今日も元気です。",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, got, err := parseStructuredDiary(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStructuredDiary() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if content != tt.wantContent {
+				t.Errorf("content = %q, want %q", content, tt.wantContent)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("observation = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
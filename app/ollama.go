@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const ollamaDefaultTimeout = 120 * time.Second // 初回はモデルの読み込みに時間がかかるため長めにする
+
+// OllamaConfig は Ollama への接続設定を保持する。
+type OllamaConfig struct {
+	BaseURL   string        // OllamaのURL（例: http://localhost:11434）
+	Model     string        // 使用するモデル名（画像入力に対応したモデル）
+	Timeout   time.Duration // 1回の生成リクエストのタイムアウト
+	KeepAlive string        // 生成後にモデルをメモリに保持する時間（空の場合はOllamaのデフォルト）
+}
+
+// LoadOllamaConfig は環境変数 OLLAMA_BASE_URL / OLLAMA_MODEL / OLLAMA_TIMEOUT / OLLAMA_KEEP_ALIVE から
+// 接続設定を読み込む。OLLAMA_TIMEOUT が未設定の場合はデフォルト値を使用する
+func LoadOllamaConfig() (OllamaConfig, error) {
+	config := OllamaConfig{
+		BaseURL: os.Getenv("OLLAMA_BASE_URL"),
+		Model:   os.Getenv("OLLAMA_MODEL"),
+		Timeout: ollamaDefaultTimeout,
+	}
+	if v := os.Getenv("OLLAMA_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return config, fmt.Errorf("OLLAMA_TIMEOUT must be a positive duration: %q", v)
+		}
+		config.Timeout = d
+	}
+	if v := os.Getenv("OLLAMA_KEEP_ALIVE"); v != "" {
+		// 負の値はモデルを常に保持、0は生成後すぐに解放する
+		if _, err := time.ParseDuration(v); err != nil {
+			return config, fmt.Errorf("OLLAMA_KEEP_ALIVE must be a duration: %q", v)
+		}
+		config.KeepAlive = v
+	}
+	return config, nil
+}
+
+// OllamaDiaryGenerator は Ollama の /api/generate を使って、ローカルのモデルで画像から日記を生成する。
+type OllamaDiaryGenerator struct {
+	config OllamaConfig
+	client *providerHTTPClient
+}
+
+// NewOllamaDiaryGenerator は接続設定を検証して OllamaDiaryGenerator を生成する。
+func NewOllamaDiaryGenerator(config OllamaConfig) (*OllamaDiaryGenerator, error) {
+	if config.BaseURL == "" {
+		return nil, fmt.Errorf("環境変数 OLLAMA_BASE_URL が設定されていません")
+	}
+	if config.Model == "" {
+		return nil, fmt.Errorf("環境変数 OLLAMA_MODEL が設定されていません")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = ollamaDefaultTimeout
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	return &OllamaDiaryGenerator{config: config, client: newProviderHTTPClient("Ollama", config.Timeout)}, nil
+}
+
+// ollamaGenerateRequest は /api/generate のリクエストボディ
+type ollamaGenerateRequest struct {
+	Model     string   `json:"model"`
+	Prompt    string   `json:"prompt"`
+	Images    []string `json:"images"`
+	Stream    bool     `json:"stream"`
+	KeepAlive string   `json:"keep_alive,omitempty"`
+}
+
+// ollamaGenerateResponse は /api/generate（stream: false）のレスポンスボディ
+type ollamaGenerateResponse struct {
+	Response        string `json:"response"`
+	Error           string `json:"error"`
+	PromptEvalCount int    `json:"prompt_eval_count"` // 入力のトークン数
+	EvalCount       int    `json:"eval_count"`        // 出力のトークン数
+}
+
+// Generate は写真とプロンプト（参照画像がある場合はあわせて）を Ollama に渡して観察日記を生成し、
+// レスポンスの prompt_eval_count / eval_count から消費トークン数をあわせて返す。
+// 複数の画像の入力に対応していないモデルでは、参照画像が無視されることがある
+func (g *OllamaDiaryGenerator) Generate(ctx context.Context, req DiaryRequest) (DiaryResult, error) {
+	images, err := readDiaryImages(req.ImagePath, req.References)
+	if err != nil {
+		return DiaryResult{}, err
+	}
+	encoded := make([]string, len(images))
+	for i, imageBytes := range images {
+		encoded[i] = base64.StdEncoding.EncodeToString(imageBytes)
+	}
+	text, usage, err := g.generate(ctx, referenceImagesPrompt(req.promptOrBase(), req.References), encoded)
+	if err != nil {
+		return DiaryResult{}, err
+	}
+	return DiaryResult{Content: text, Usage: usage}, nil
+}
+
+// GenerateText は画像を使わずにプロンプトのみから Ollama でテキストを生成し、消費トークン数をあわせて返す。過去日記の要約に使う
+func (g *OllamaDiaryGenerator) GenerateText(ctx context.Context, prompt string) (DiaryResult, error) {
+	text, usage, err := g.generate(ctx, prompt, nil)
+	if err != nil {
+		return DiaryResult{}, err
+	}
+	return DiaryResult{Content: text, Usage: usage}, nil
+}
+
+// generate はプロンプトとbase64エンコードした画像を /api/generate に送信し、レスポンスのテキストと消費トークン数を返す
+// エラーレスポンスはHTTPステータスからリトライできる失敗かどうかを分類して返す
+func (g *OllamaDiaryGenerator) generate(ctx context.Context, prompt string, images []string) (string, *GenerationUsage, error) {
+	resp, respBody, err := g.client.postJSON(ctx, g.config.BaseURL+"/api/generate", nil, ollamaGenerateRequest{
+		Model:     g.config.Model,
+		Prompt:    prompt,
+		Images:    images,
+		Stream:    false,
+		KeepAlive: g.config.KeepAlive,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	var genResp ollamaGenerateResponse
+	jsonErr := json.Unmarshal(respBody, &genResp)
+	if resp.StatusCode != http.StatusOK {
+		message := genResp.Error
+		if jsonErr != nil || message == "" {
+			message = providerErrorBody(respBody)
+		}
+		return "", nil, classifyHTTPError(resp.StatusCode, resp.Header, fmt.Errorf("Ollama がエラーを返しました（HTTP %d）: %s", resp.StatusCode, message))
+	}
+	if jsonErr != nil {
+		return "", nil, fmt.Errorf("Ollama のレスポンスの解析に失敗: %w", jsonErr)
+	}
+
+	text := strings.TrimSpace(genResp.Response)
+	if text == "" {
+		return "", nil, fmt.Errorf("Ollama から空のレスポンスが返されました")
+	}
+
+	usage := &GenerationUsage{Model: g.config.Model, PromptTokens: genResp.PromptEvalCount, ResponseTokens: genResp.EvalCount}
+	return text, usage, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOllamaDiaryGenerator_Generate(t *testing.T) {
+	var got ollamaGenerateRequest
+	var gotPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"llava","response":"\n新しい芽が出ました。","done":true}`))
+	}))
+	defer srv.Close()
+
+	generator, err := NewOllamaDiaryGenerator(OllamaConfig{BaseURL: srv.URL + "/", Model: "llava", KeepAlive: "10m"})
+	if err != nil {
+		t.Fatalf("NewOllamaDiaryGenerator failed: %v", err)
+	}
+
+	imagePath := tempTestJPEG(t)
+	result, err := generator.Generate(t.Context(), DiaryRequest{ImagePath: imagePath, Prompt: "観察してください"})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if result.Content != "新しい芽が出ました。" {
+		t.Errorf("unexpected content: %q", result.Content)
+	}
+
+	if gotPath != "/api/generate" {
+		t.Errorf("unexpected path: %s", gotPath)
+	}
+	imageBytes, _ := os.ReadFile(imagePath)
+	want := ollamaGenerateRequest{
+		Model:     "llava",
+		Prompt:    "観察してください",
+		Images:    []string{base64.StdEncoding.EncodeToString(imageBytes)},
+		Stream:    false,
+		KeepAlive: "10m",
+	}
+	if got.Model != want.Model || got.Prompt != want.Prompt || got.Stream != want.Stream || got.KeepAlive != want.KeepAlive {
+		t.Errorf("unexpected request: %+v", got)
+	}
+	if len(got.Images) != 1 || got.Images[0] != want.Images[0] {
+		t.Errorf("unexpected images: %v", got.Images)
+	}
+}
+
+func TestOllamaDiaryGenerator_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantErrMsg string
+	}{
+		{
+			name:       "エラーレスポンスはメッセージを含める",
+			status:     http.StatusNotFound,
+			body:       `{"error":"model \"llava\" not found, try pulling it first"}`,
+			wantErrMsg: "try pulling it first",
+		},
+		{
+			name:       "JSONでないエラーはボディを含める",
+			status:     http.StatusBadGateway,
+			body:       "bad gateway",
+			wantErrMsg: "bad gateway",
+		},
+		{
+			name:       "本文が空",
+			status:     http.StatusOK,
+			body:       `{"response":"","done":true}`,
+			wantErrMsg: "空のレスポンス",
+		},
+		{
+			name:       "JSONでないレスポンス",
+			status:     http.StatusOK,
+			body:       "not json",
+			wantErrMsg: "解析に失敗",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			generator, err := NewOllamaDiaryGenerator(OllamaConfig{BaseURL: srv.URL, Model: "llava"})
+			if err != nil {
+				t.Fatalf("NewOllamaDiaryGenerator failed: %v", err)
+			}
+			_, err = generator.Generate(t.Context(), DiaryRequest{ImagePath: tempTestJPEG(t)})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErrMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErrMsg, err)
+			}
+		})
+	}
+}
+
+func TestOllamaDiaryGenerator_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	generator, err := NewOllamaDiaryGenerator(OllamaConfig{BaseURL: srv.URL, Model: "llava", Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewOllamaDiaryGenerator failed: %v", err)
+	}
+	if _, err := generator.Generate(t.Context(), DiaryRequest{ImagePath: tempTestJPEG(t)}); err == nil {
+		t.Error("expected timeout error, got nil")
+	}
+}
+
+func TestLoadOllamaConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		timeout   string
+		keepAlive string
+		want      OllamaConfig
+		wantErr   bool
+	}{
+		{
+			name: "未設定時はデフォルト値",
+			want: OllamaConfig{BaseURL: "http://localhost:11434", Model: "llava", Timeout: ollamaDefaultTimeout},
+		},
+		{
+			name:      "環境変数で指定",
+			timeout:   "5m",
+			keepAlive: "-1m",
+			want:      OllamaConfig{BaseURL: "http://localhost:11434", Model: "llava", Timeout: 5 * time.Minute, KeepAlive: "-1m"},
+		},
+		{
+			name:    "タイムアウトが期間の形式でない",
+			timeout: "abc",
+			wantErr: true,
+		},
+		{
+			name:      "保持時間が期間の形式でない",
+			keepAlive: "forever",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_BASE_URL", "http://localhost:11434")
+			t.Setenv("OLLAMA_MODEL", "llava")
+			t.Setenv("OLLAMA_TIMEOUT", tt.timeout)
+			t.Setenv("OLLAMA_KEEP_ALIVE", tt.keepAlive)
+
+			got, err := LoadOllamaConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadOllamaConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("LoadOllamaConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOllamaDiaryGenerator_GenerateUsage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"llava","response":"新しい芽が出ました。","done":true,"prompt_eval_count":640,"eval_count":45}`))
+	}))
+	defer srv.Close()
+
+	generator, err := NewOllamaDiaryGenerator(OllamaConfig{BaseURL: srv.URL, Model: "llava"})
+	if err != nil {
+		t.Fatalf("NewOllamaDiaryGenerator failed: %v", err)
+	}
+
+	generated, err := generator.Generate(t.Context(), DiaryRequest{ImagePath: tempTestJPEG(t), Prompt: "観察してください"})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	want := GenerationUsage{Model: "llava", PromptTokens: 640, ResponseTokens: 45}
+	if generated.Usage == nil || *generated.Usage != want {
+		t.Errorf("unexpected usage: %+v", generated.Usage)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const openAIDefaultTimeout = 120 * time.Second // ローカルの推論サーバーは応答が遅いため Gemini より長めにする
+
+// OpenAIConfig は OpenAI 互換の chat completions API への接続設定を保持する。
+type OpenAIConfig struct {
+	BaseURL string        // APIのベースURL（例: http://localhost:8000/v1）
+	APIKey  string        // Authorization ヘッダーに付けるAPIキー（不要なサーバーでは空）
+	Model   string        // 使用するモデル名
+	Timeout time.Duration // 1回の生成リクエストのタイムアウト
+}
+
+// LoadOpenAIConfig は環境変数 OPENAI_BASE_URL / OPENAI_API_KEY / OPENAI_MODEL / OPENAI_TIMEOUT から
+// 接続設定を読み込む。OPENAI_TIMEOUT が未設定の場合はデフォルト値を使用する
+func LoadOpenAIConfig() (OpenAIConfig, error) {
+	config := OpenAIConfig{
+		BaseURL: os.Getenv("OPENAI_BASE_URL"),
+		APIKey:  os.Getenv("OPENAI_API_KEY"),
+		Model:   os.Getenv("OPENAI_MODEL"),
+		Timeout: openAIDefaultTimeout,
+	}
+	if v := os.Getenv("OPENAI_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return config, fmt.Errorf("OPENAI_TIMEOUT must be a positive duration: %q", v)
+		}
+		config.Timeout = d
+	}
+	return config, nil
+}
+
+// OpenAIDiaryGenerator は OpenAI 互換の chat completions API（llama.cpp、vLLM、LM Studio など）を使って
+// 画像から日記を生成する。
+type OpenAIDiaryGenerator struct {
+	config OpenAIConfig
+	client *providerHTTPClient
+}
+
+// NewOpenAIDiaryGenerator は接続設定を検証して OpenAIDiaryGenerator を生成する。
+func NewOpenAIDiaryGenerator(config OpenAIConfig) (*OpenAIDiaryGenerator, error) {
+	if config.BaseURL == "" {
+		return nil, fmt.Errorf("環境変数 OPENAI_BASE_URL が設定されていません")
+	}
+	if config.Model == "" {
+		return nil, fmt.Errorf("環境変数 OPENAI_MODEL が設定されていません")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = openAIDefaultTimeout
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	return &OpenAIDiaryGenerator{config: config, client: newProviderHTTPClient("OpenAI 互換 API", config.Timeout)}, nil
+}
+
+// openAIChatRequest は chat completions API のリクエストボディ
+type openAIChatRequest struct {
+	Model    string              `json:"model"`
+	Messages []openAIChatMessage `json:"messages"`
+}
+
+// openAIChatMessage は chat completions API のメッセージ。画像を含めるため content は複数のパートで構成する
+type openAIChatMessage struct {
+	Role    string              `json:"role"`
+	Content []openAIContentPart `json:"content"`
+}
+
+// openAIContentPart はメッセージのテキストまたは画像のパート
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+// openAIImageURL は画像のURL。画像ファイルは data URL として埋め込む
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+// openAIChatResponse は chat completions API のレスポンスボディのうち、日記の生成と使用量の記録に使う部分
+type openAIChatResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// openAIErrorResponse は chat completions API のエラーレスポンスボディ
+type openAIErrorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Generate は写真とプロンプト（参照画像がある場合はあわせて）を OpenAI 互換 API に渡して観察日記を生成し、
+// レスポンスの usage から消費トークン数をあわせて返す。usage を返さないサーバーでは消費トークン数はnil
+func (g *OpenAIDiaryGenerator) Generate(ctx context.Context, req DiaryRequest) (DiaryResult, error) {
+	images, err := readDiaryImages(req.ImagePath, req.References)
+	if err != nil {
+		return DiaryResult{}, err
+	}
+
+	content := []openAIContentPart{
+		{Type: "text", Text: referenceImagesPrompt(req.promptOrBase(), req.References)},
+	}
+	for _, imageBytes := range images {
+		dataURL := "data:" + http.DetectContentType(imageBytes) + ";base64," + base64.StdEncoding.EncodeToString(imageBytes)
+		content = append(content, openAIContentPart{Type: "image_url", ImageURL: &openAIImageURL{URL: dataURL}})
+	}
+	text, usage, err := g.chat(ctx, content)
+	if err != nil {
+		return DiaryResult{}, err
+	}
+	return DiaryResult{Content: text, Usage: usage}, nil
+}
+
+// GenerateText は画像を使わずにプロンプトのみから OpenAI 互換 API でテキストを生成し、消費トークン数をあわせて返す。過去日記の要約に使う
+func (g *OpenAIDiaryGenerator) GenerateText(ctx context.Context, prompt string) (DiaryResult, error) {
+	text, usage, err := g.chat(ctx, []openAIContentPart{{Type: "text", Text: prompt}})
+	if err != nil {
+		return DiaryResult{}, err
+	}
+	return DiaryResult{Content: text, Usage: usage}, nil
+}
+
+// chat はパートで構成した1件のユーザーメッセージを chat completions API に送信し、レスポンスのテキストと消費トークン数を返す
+// エラーレスポンスはHTTPステータスからリトライできる失敗かどうかを分類して返す
+func (g *OpenAIDiaryGenerator) chat(ctx context.Context, content []openAIContentPart) (string, *GenerationUsage, error) {
+	header := http.Header{}
+	if g.config.APIKey != "" {
+		header.Set("Authorization", "Bearer "+g.config.APIKey)
+	}
+	resp, respBody, err := g.client.postJSON(ctx, g.config.BaseURL+"/chat/completions", header, openAIChatRequest{
+		Model:    g.config.Model,
+		Messages: []openAIChatMessage{{Role: "user", Content: content}},
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, classifyHTTPError(resp.StatusCode, resp.Header, fmt.Errorf("OpenAI 互換 API がエラーを返しました（HTTP %d）: %s", resp.StatusCode, openAIErrorMessage(respBody)))
+	}
+
+	var chatResp openAIChatResponse
+	if err := json.Unmarshal(respBody, &chatResp); err != nil {
+		return "", nil, fmt.Errorf("OpenAI 互換 API のレスポンスの解析に失敗: %w", err)
+	}
+	if len(chatResp.Choices) == 0 {
+		return "", nil, fmt.Errorf("OpenAI 互換 API から空のレスポンスが返されました")
+	}
+
+	text := strings.TrimSpace(chatResp.Choices[0].Message.Content)
+	if text == "" {
+		return "", nil, fmt.Errorf("OpenAI 互換 API から空のレスポンスが返されました")
+	}
+
+	var usage *GenerationUsage
+	if chatResp.Usage != nil {
+		// 料金（USAGE_PRICES）と対応付けられるよう、レスポンスのモデル名（日付付きの版など）ではなく設定したモデル名を記録する
+		usage = &GenerationUsage{Model: g.config.Model, PromptTokens: chatResp.Usage.PromptTokens, ResponseTokens: chatResp.Usage.CompletionTokens}
+	}
+	return text, usage, nil
+}
+
+// openAIErrorMessage はエラーレスポンスのボディからエラー内容を取り出す。
+// OpenAI 形式でない場合はボディの先頭部分をそのまま返す
+func openAIErrorMessage(body []byte) string {
+	var errResp openAIErrorResponse
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error.Message != "" {
+		return errResp.Error.Message
+	}
+	return providerErrorBody(body)
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenAIDiaryGenerator_Generate(t *testing.T) {
+	var got openAIChatRequest
+	var gotPath, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"  葉が大きくなりました。\n"}}]}`))
+	}))
+	defer srv.Close()
+
+	generator, err := NewOpenAIDiaryGenerator(OpenAIConfig{BaseURL: srv.URL + "/v1/", APIKey: "secret", Model: "llava"})
+	if err != nil {
+		t.Fatalf("NewOpenAIDiaryGenerator failed: %v", err)
+	}
+
+	result, err := generator.Generate(t.Context(), DiaryRequest{ImagePath: tempTestJPEG(t), Prompt: "観察してください"})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if result.Content != "葉が大きくなりました。" {
+		t.Errorf("unexpected content: %q", result.Content)
+	}
+
+	if gotPath != "/v1/chat/completions" {
+		t.Errorf("unexpected path: %s", gotPath)
+	}
+	if gotAuth != "Bearer secret" {
+		t.Errorf("unexpected Authorization header: %q", gotAuth)
+	}
+	if got.Model != "llava" {
+		t.Errorf("unexpected model: %q", got.Model)
+	}
+	if len(got.Messages) != 1 || len(got.Messages[0].Content) != 2 {
+		t.Fatalf("unexpected messages: %+v", got.Messages)
+	}
+	parts := got.Messages[0].Content
+	if parts[0].Type != "text" || parts[0].Text != "観察してください" {
+		t.Errorf("unexpected text part: %+v", parts[0])
+	}
+	if parts[1].Type != "image_url" || parts[1].ImageURL == nil ||
+		!strings.HasPrefix(parts[1].ImageURL.URL, "data:image/jpeg;base64,") {
+		t.Errorf("unexpected image part: %+v", parts[1])
+	}
+}
+
+func TestOpenAIDiaryGenerator_GenerateWithReferences(t *testing.T) {
+	var got openAIChatRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"昨日より葉が1枚増えました。"}}]}`))
+	}))
+	defer srv.Close()
+
+	generator, err := NewOpenAIDiaryGenerator(OpenAIConfig{BaseURL: srv.URL, Model: "llava"})
+	if err != nil {
+		t.Fatalf("NewOpenAIDiaryGenerator failed: %v", err)
+	}
+
+	references := []ReferenceImage{
+		{Path: tempTestJPEG(t), CapturedAt: time.Date(2026, 3, 9, 3, 0, 0, 0, time.UTC), DaysAgo: 1},
+		{Path: tempTestJPEG(t), CapturedAt: time.Date(2026, 3, 3, 3, 0, 0, 0, time.UTC), DaysAgo: 7},
+	}
+	result, err := generator.Generate(t.Context(), DiaryRequest{ImagePath: tempTestJPEG(t), Prompt: "観察してください", References: references})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if result.Content != "昨日より葉が1枚増えました。" {
+		t.Errorf("unexpected content: %q", result.Content)
+	}
+
+	// テキストの後に今回の写真、参照画像の順で画像が続く
+	if len(got.Messages) != 1 || len(got.Messages[0].Content) != 4 {
+		t.Fatalf("unexpected messages: %+v", got.Messages)
+	}
+	parts := got.Messages[0].Content
+	if parts[0].Type != "text" || parts[0].Text != referenceImagesPrompt("観察してください", references) {
+		t.Errorf("unexpected text part: %+v", parts[0])
+	}
+	for _, part := range parts[1:] {
+		if part.Type != "image_url" || part.ImageURL == nil {
+			t.Errorf("unexpected image part: %+v", part)
+		}
+	}
+
+	// 参照画像が読み込めない場合はエラー
+	references[1].Path = filepath.Join(t.TempDir(), "missing.jpg")
+	if _, err := generator.Generate(t.Context(), DiaryRequest{ImagePath: tempTestJPEG(t), Prompt: "観察してください", References: references}); err == nil {
+		t.Error("expected error for missing reference image, got nil")
+	}
+}
+
+func TestOpenAIDiaryGenerator_Errors(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		retryAfter     string
+		body           string
+		wantErrMsg     string
+		wantRetryable  bool
+		wantRetryAfter time.Duration
+	}{
+		{
+			name:       "エラーレスポンスはメッセージを含め、リトライしない",
+			status:     http.StatusBadRequest,
+			body:       `{"error":{"message":"model not found"}}`,
+			wantErrMsg: "model not found",
+		},
+		{
+			name:          "OpenAI形式でないエラーはボディを含め、5xxはリトライする",
+			status:        http.StatusInternalServerError,
+			body:          "server crashed",
+			wantErrMsg:    "server crashed",
+			wantRetryable: true,
+		},
+		{
+			name:           "429はRetry-Afterの待ち時間を返す",
+			status:         http.StatusTooManyRequests,
+			retryAfter:     "30",
+			body:           `{"error":{"message":"rate limit exceeded"}}`,
+			wantErrMsg:     "rate limit exceeded",
+			wantRetryable:  true,
+			wantRetryAfter: 30 * time.Second,
+		},
+		{
+			name:          "候補が空",
+			status:        http.StatusOK,
+			body:          `{"choices":[]}`,
+			wantErrMsg:    "空のレスポンス",
+			wantRetryable: true,
+		},
+		{
+			name:          "本文が空",
+			status:        http.StatusOK,
+			body:          `{"choices":[{"message":{"content":""}}]}`,
+			wantErrMsg:    "空のレスポンス",
+			wantRetryable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			generator, err := NewOpenAIDiaryGenerator(OpenAIConfig{BaseURL: srv.URL, Model: "llava"})
+			if err != nil {
+				t.Fatalf("NewOpenAIDiaryGenerator failed: %v", err)
+			}
+			_, err = generator.Generate(t.Context(), DiaryRequest{ImagePath: tempTestJPEG(t)})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErrMsg) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErrMsg, err)
+			}
+			if got := IsRetryable(err); got != tt.wantRetryable {
+				t.Errorf("IsRetryable() = %v, want %v", got, tt.wantRetryable)
+			}
+			if after, _ := RetryAfter(err); after != tt.wantRetryAfter {
+				t.Errorf("RetryAfter() = %v, want %v", after, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestOpenAIDiaryGenerator_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	generator, err := NewOpenAIDiaryGenerator(OpenAIConfig{BaseURL: srv.URL, Model: "llava", Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewOpenAIDiaryGenerator failed: %v", err)
+	}
+	if _, err := generator.Generate(t.Context(), DiaryRequest{ImagePath: tempTestJPEG(t)}); err == nil {
+		t.Error("expected timeout error, got nil")
+	}
+}
+
+func TestOpenAIDiaryGenerator_Canceled(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	generator, err := NewOpenAIDiaryGenerator(OpenAIConfig{BaseURL: srv.URL, Model: "llava", Timeout: time.Minute})
+	if err != nil {
+		t.Fatalf("NewOpenAIDiaryGenerator failed: %v", err)
+	}
+
+	// タイムアウトより前でも、呼び出し元のctxがキャンセルされたら中断する
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := generator.Generate(ctx, DiaryRequest{ImagePath: tempTestJPEG(t)}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestNewOpenAIDiaryGenerator_InvalidConfig(t *testing.T) {
+	if _, err := NewOpenAIDiaryGenerator(OpenAIConfig{Model: "llava"}); err == nil {
+		t.Error("expected error for missing base URL, got nil")
+	}
+	if _, err := NewOpenAIDiaryGenerator(OpenAIConfig{BaseURL: "http://localhost:8000/v1"}); err == nil {
+		t.Error("expected error for missing model, got nil")
+	}
+}
+
+func TestLoadOpenAIConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout string
+		want    time.Duration
+		wantErr bool
+	}{
+		{
+			name: "未設定時はデフォルト値",
+			want: openAIDefaultTimeout,
+		},
+		{
+			name:    "環境変数で指定",
+			timeout: "30s",
+			want:    30 * time.Second,
+		},
+		{
+			name:    "期間の形式でない",
+			timeout: "abc",
+			wantErr: true,
+		},
+		{
+			name:    "0秒",
+			timeout: "0s",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OPENAI_BASE_URL", "http://localhost:8000/v1")
+			t.Setenv("OPENAI_API_KEY", "")
+			t.Setenv("OPENAI_MODEL", "llava")
+			t.Setenv("OPENAI_TIMEOUT", tt.timeout)
+
+			got, err := LoadOpenAIConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadOpenAIConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			want := OpenAIConfig{BaseURL: "http://localhost:8000/v1", Model: "llava", Timeout: tt.want}
+			if got != want {
+				t.Errorf("LoadOpenAIConfig() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestOpenAIDiaryGenerator_GenerateUsage(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantUsage *GenerationUsage
+	}{
+		{
+			name:      "usageから消費トークン数を返す（モデル名は設定したもの）",
+			body:      `{"model":"llava-1.6","choices":[{"message":{"content":"花が咲きました。"}}],"usage":{"prompt_tokens":1200,"completion_tokens":80}}`,
+			wantUsage: &GenerationUsage{Model: "llava", PromptTokens: 1200, ResponseTokens: 80},
+		},
+		{
+			name: "usageがない場合はnil",
+			body: `{"choices":[{"message":{"content":"花が咲きました。"}}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			generator, err := NewOpenAIDiaryGenerator(OpenAIConfig{BaseURL: srv.URL, Model: "llava"})
+			if err != nil {
+				t.Fatalf("NewOpenAIDiaryGenerator failed: %v", err)
+			}
+			generated, err := generator.Generate(t.Context(), DiaryRequest{ImagePath: tempTestJPEG(t), Prompt: "観察してください"})
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			if generated.Content != "花が咲きました。" {
+				t.Errorf("unexpected content: %q", generated.Content)
+			}
+			if !reflect.DeepEqual(generated.Usage, tt.wantUsage) {
+				t.Errorf("unexpected usage: %+v", generated.Usage)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"math/bits"
+	"sort"
+)
+
+const (
+	// nearDuplicatePhotoDistance は写真アップロードAPIで重複とみなす知覚ハッシュの距離（異なるビット数）の上限
+	nearDuplicatePhotoDistance = 4
+	// similarPhotoDistance は詳細ページの「似ている写真」に表示する知覚ハッシュの距離の上限
+	similarPhotoDistance = 10
+	// maxSimilarPhotos は詳細ページの「似ている写真」に表示する写真の数の上限
+	maxSimilarPhotos = 12
+	// photoHashSamples は知覚ハッシュの計算で、1マスあたり縦横に読み込む画素の数
+	photoHashSamples = 16
+)
+
+// computePhotoHash は写真の知覚ハッシュ（dHash）を返す。写真を横9×縦8マスのグレースケールに縮小し、
+// 横に隣り合うマスの明るさの大小を64ビットにする。縮小や再圧縮、わずかな明るさの違いではほとんど変わらない
+func computePhotoHash(img image.Image) uint64 {
+	const w, h = 9, 8
+	b := img.Bounds()
+	var gray [h][w]float64
+	for cy := 0; cy < h; cy++ {
+		y0, y1 := b.Min.Y+cy*b.Dy()/h, b.Min.Y+(cy+1)*b.Dy()/h
+		for cx := 0; cx < w; cx++ {
+			x0, x1 := b.Min.X+cx*b.Dx()/w, b.Min.X+(cx+1)*b.Dx()/w
+			// 大きな写真でも速く計算できるよう、マスの中の画素を間引いて平均する
+			var sum float64
+			var n int
+			for sy := 0; sy < photoHashSamples; sy++ {
+				y := y0 + (2*sy+1)*(y1-y0)/(2*photoHashSamples)
+				for sx := 0; sx < photoHashSamples; sx++ {
+					x := x0 + (2*sx+1)*(x1-x0)/(2*photoHashSamples)
+					r, g, bl, _ := img.At(x, y).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+					n++
+				}
+			}
+			gray[cy][cx] = sum / float64(n)
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if gray[y][x] < gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// photoHashDistance は2つの知覚ハッシュの距離（異なるビット数、0〜64）を返す。小さいほど似ている
+func photoHashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// formatPhotoHash は知覚ハッシュを16桁の16進数にする
+func formatPhotoHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// readPhotoHash は保存済みの写真の知覚ハッシュを計算する
+func readPhotoHash(imagePath string) (uint64, error) {
+	img, err := decodePhoto(imagePath)
+	if err != nil {
+		return 0, err
+	}
+	return computePhotoHash(img), nil
+}
+
+// computeJPEGPhotoHash はJPEGの写真のデータから知覚ハッシュを計算する
+func computeJPEGPhotoHash(data []byte) (uint64, error) {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode photo: %w", err)
+	}
+	return computePhotoHash(img), nil
+}
+
+// findSimilarPhotos はcandidatesのうち、targetとの知覚ハッシュの距離がmaxDistance以下の写真を距離の近い順（同じ距離は撮影日時の近い順）に返す。
+// target自身は含まない
+func findSimilarPhotos(target PhotoHash, candidates []PhotoHash, maxDistance int) []SimilarPhoto {
+	var similar []SimilarPhoto
+	for _, c := range candidates {
+		if c.ImagePath == target.ImagePath {
+			continue
+		}
+		if d := photoHashDistance(target.Hash, c.Hash); d <= maxDistance {
+			similar = append(similar, SimilarPhoto{PhotoHash: c, Distance: d})
+		}
+	}
+	sort.SliceStable(similar, func(i, j int) bool {
+		if similar[i].Distance != similar[j].Distance {
+			return similar[i].Distance < similar[j].Distance
+		}
+		return similar[i].CapturedAt.Sub(target.CapturedAt).Abs() < similar[j].CapturedAt.Sub(target.CapturedAt).Abs()
+	})
+	return similar
+}
+
+// BackfillPhotoHashes は知覚ハッシュが未記録の日記の写真について、知覚ハッシュを計算して記録する。
+// 写真を読み込めない日記は失敗として数え、処理を続ける
+func BackfillPhotoHashes(ctx context.Context, repo DiaryRepository, hashRepo PhotoHashRepository) (saved, failed int, err error) {
+	diaries, err := repo.GetAllDiaries(ctx, OwnerScope{})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get diaries: %w", err)
+	}
+	for _, d := range diaries {
+		existing, err := hashRepo.GetPhotoHash(ctx, d.ImagePath)
+		if err != nil {
+			return saved, failed, fmt.Errorf("failed to get photo hash of %s: %w", d.ImagePath, err)
+		}
+		if existing != nil {
+			continue
+		}
+		hash, err := readPhotoHash(d.ImagePath)
+		if err != nil {
+			log.Printf("WARN: failed to compute photo hash of diary %d: %v", d.ID, err)
+			failed++
+			continue
+		}
+		if err := hashRepo.SavePhotoHash(ctx, PhotoHash{
+			ImagePath: d.ImagePath, UserID: d.UserID, PlantID: d.PlantID, Hash: hash, CapturedAt: d.CreatedAt,
+		}); err != nil {
+			return saved, failed, fmt.Errorf("failed to save photo hash of %s: %w", d.ImagePath, err)
+		}
+		saved++
+	}
+	return saved, failed, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestComputePhotoHash(t *testing.T) {
+	src := testPatternImage(640, 480, 1)
+	hash := computePhotoHash(src)
+
+	// 縮小・再圧縮・わずかな明るさの違いでは、ほとんど変わらない
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 70}); err != nil {
+		t.Fatalf("failed to encode jpeg: %v", err)
+	}
+	reencoded, err := computeJPEGPhotoHash(buf.Bytes())
+	if err != nil {
+		t.Fatalf("computeJPEGPhotoHash failed: %v", err)
+	}
+	brighter := image.NewRGBA(src.Bounds())
+	for i, v := range src.Pix {
+		brighter.Pix[i] = uint8(min(255, int(v)+8))
+	}
+	for name, got := range map[string]uint64{
+		"resized":    computePhotoHash(resizePhoto(src, 160)),
+		"reencoded":  reencoded,
+		"brighter":   computePhotoHash(brighter),
+		"same photo": computePhotoHash(testPatternImage(640, 480, 1)),
+	} {
+		if d := photoHashDistance(hash, got); d > nearDuplicatePhotoDistance {
+			t.Errorf("%s: expected near duplicate, got distance %d", name, d)
+		}
+	}
+
+	// 別の写真は大きく異なる
+	if d := photoHashDistance(hash, computePhotoHash(testPatternImage(640, 480, 2))); d <= similarPhotoDistance {
+		t.Errorf("expected different hash for different photo, got distance %d", d)
+	}
+}
+
+func TestComputeJPEGPhotoHash_InvalidPhoto(t *testing.T) {
+	if _, err := computeJPEGPhotoHash([]byte("not a jpeg")); err == nil {
+		t.Error("expected error for invalid photo")
+	}
+}
+
+func TestPhotoHashDistance(t *testing.T) {
+	tests := []struct {
+		a, b uint64
+		want int
+	}{
+		{0, 0, 0},
+		{0b1011, 0b0001, 2},
+		{0, ^uint64(0), 64},
+	}
+	for _, tt := range tests {
+		if got := photoHashDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("photoHashDistance(%x, %x) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+	if got := formatPhotoHash(0xab); got != "00000000000000ab" {
+		t.Errorf("formatPhotoHash = %s", got)
+	}
+}
+
+func TestFindSimilarPhotos(t *testing.T) {
+	base := time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC)
+	target := PhotoHash{ImagePath: "/photos/target.jpg", Hash: 0, CapturedAt: base}
+	candidates := []PhotoHash{
+		target,
+		{ImagePath: "/photos/far.jpg", Hash: 0b111, CapturedAt: base.Add(-time.Hour)},
+		{ImagePath: "/photos/near-late.jpg", Hash: 0b1, CapturedAt: base.Add(2 * time.Hour)},
+		{ImagePath: "/photos/near-early.jpg", Hash: 0b10, CapturedAt: base.Add(-time.Hour)},
+		{ImagePath: "/photos/different.jpg", Hash: 0xff, CapturedAt: base},
+	}
+
+	got := findSimilarPhotos(target, candidates, 3)
+	want := []string{"/photos/near-early.jpg", "/photos/near-late.jpg", "/photos/far.jpg"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d similar photos, got %+v", len(want), got)
+	}
+	for i, path := range want {
+		if got[i].ImagePath != path {
+			t.Errorf("similar[%d] = %s, want %s", i, got[i].ImagePath, path)
+		}
+	}
+	if got[2].Distance != 3 {
+		t.Errorf("expected distance 3, got %d", got[2].Distance)
+	}
+}
+
+func TestBackfillPhotoHashes(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+	hashRepo := NewSQLitePhotoHashRepository(db)
+
+	dir := t.TempDir()
+	photo := filepath.Join(dir, "photo.jpg")
+	writeTestJPEG(t, photo, 64, 48)
+	capturedAt := time.Date(2026, 2, 1, 3, 4, 5, 0, time.UTC)
+	if err := repo.CreateDiaryForUser(t.Context(), 1, 2, photo, "日記", capturedAt); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	if err := repo.CreateDiaryForUser(t.Context(), 1, 2, filepath.Join(dir, "missing.jpg"), "日記", capturedAt); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+
+	saved, failed, err := BackfillPhotoHashes(t.Context(), repo, hashRepo)
+	if err != nil {
+		t.Fatalf("BackfillPhotoHashes failed: %v", err)
+	}
+	if saved != 1 || failed != 1 {
+		t.Errorf("expected 1 saved and 1 failed, got %d saved and %d failed", saved, failed)
+	}
+	hash, err := hashRepo.GetPhotoHash(t.Context(), photo)
+	if err != nil || hash == nil {
+		t.Fatalf("expected photo hash, got %+v, %v", hash, err)
+	}
+	if hash.UserID != 1 || hash.PlantID != 2 || !hash.CapturedAt.Equal(capturedAt) {
+		t.Errorf("unexpected photo hash: %+v", hash)
+	}
+
+	// 記録済みの写真は計算し直さない
+	saved, failed, err = BackfillPhotoHashes(t.Context(), repo, hashRepo)
+	if err != nil || saved != 0 || failed != 1 {
+		t.Errorf("expected only the missing photo to fail, got %d saved, %d failed, %v", saved, failed, err)
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// photoDerivativesDir は写真ディレクトリ配下の、縮小画像を保存するディレクトリ名（ポーリングの対象外）
+	photoDerivativesDir = ".derivatives"
+	// photoDerivativeQuality は縮小画像のJPEGの品質
+	photoDerivativeQuality = 80
+	// photoEncodeQuality はアップロード時に変換・回転した写真のJPEGの品質
+	photoEncodeQuality = 92
+
+	// maxUploadPhotoBytes はアップロードできる写真のファイルサイズの上限
+	maxUploadPhotoBytes = 20 << 20
+	// maxUploadPhotoEdge はアップロードできる写真の長辺のピクセル数の上限
+	maxUploadPhotoEdge = 8192
+	// maxUploadPhotoPixels はアップロードできる写真の画素数の上限（デコード時のメモリ使用量を抑える）
+	maxUploadPhotoPixels = 40_000_000
+	// maxPhotoNameAttempts は写真の保存先の名前が既存のファイルと重複した場合に、連番を付けて試す回数の上限
+	maxPhotoNameAttempts = 100
+)
+
+var (
+	// ErrUnsupportedPhotoFormat はアップロードされた写真がJPEG・PNG・WebP・GIF以外の形式であることを表す
+	ErrUnsupportedPhotoFormat = errors.New("unsupported photo format")
+	// ErrPhotoTooLarge はアップロードされた写真のファイルサイズか縦横のピクセル数が上限を超えていることを表す
+	ErrPhotoTooLarge = errors.New("photo too large")
+	// ErrInvalidPhoto はアップロードされた写真が壊れていてデコードできないことを表す
+	ErrInvalidPhoto = errors.New("invalid photo")
+)
+
+// PhotoSize は一覧やスライドショーで使う写真の縮小画像のサイズ
+type PhotoSize struct {
+	Name    string // ?size= で指定する名前
+	MaxEdge int    // 長辺のピクセル数の上限
+}
+
+// photoSizes は生成する縮小画像のサイズ（サムネイルは一覧、中サイズは詳細・比較・スライドショーに使う）
+var photoSizes = []PhotoSize{
+	{Name: "thumb", MaxEdge: 320},
+	{Name: "medium", MaxEdge: 960},
+}
+
+// lookupPhotoSize は名前に対応する縮小画像のサイズを返す
+func lookupPhotoSize(name string) (PhotoSize, bool) {
+	for _, size := range photoSizes {
+		if size.Name == name {
+			return size, true
+		}
+	}
+	return PhotoSize{}, false
+}
+
+// photoDerivativePath は写真ディレクトリ配下の写真の縮小画像のパス（.derivatives/サイズ名/写真ディレクトリからの相対パス）を返す。
+// 写真ディレクトリ外の写真や、縮小画像自体のパスはエラーとする
+func photoDerivativePath(photosDir, imagePath string, size PhotoSize) (string, error) {
+	rel, err := filepath.Rel(photosDir, imagePath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("photo %s is outside of photos dir %s", imagePath, photosDir)
+	}
+	if rel == photoDerivativesDir || strings.HasPrefix(rel, photoDerivativesDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("photo %s is a derivative", imagePath)
+	}
+	return filepath.Join(photosDir, photoDerivativesDir, size.Name, rel), nil
+}
+
+// GeneratePhotoDerivatives は写真の全てのサイズの縮小画像を生成する。写真より新しい縮小画像がある場合は作り直さない
+func GeneratePhotoDerivatives(photosDir, imagePath string) error {
+	var src image.Image
+	for _, size := range photoSizes {
+		path, fresh, err := photoDerivativeStatus(photosDir, imagePath, size)
+		if err != nil {
+			return err
+		}
+		if fresh {
+			continue
+		}
+		if src == nil {
+			if src, err = decodePhoto(imagePath); err != nil {
+				return err
+			}
+		}
+		if err := writePhotoDerivative(path, resizePhoto(src, size.MaxEdge)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensurePhotoDerivative は写真の指定サイズの縮小画像のパスを返す。縮小画像がないか写真より古い場合は生成する
+func ensurePhotoDerivative(photosDir, imagePath string, size PhotoSize) (string, error) {
+	path, fresh, err := photoDerivativeStatus(photosDir, imagePath, size)
+	if err != nil || fresh {
+		return path, err
+	}
+	src, err := decodePhoto(imagePath)
+	if err != nil {
+		return "", err
+	}
+	if err := writePhotoDerivative(path, resizePhoto(src, size.MaxEdge)); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// photoDerivativeStatus は縮小画像のパスと、写真より新しい縮小画像が既にあるかどうかを返す
+func photoDerivativeStatus(photosDir, imagePath string, size PhotoSize) (string, bool, error) {
+	path, err := photoDerivativePath(photosDir, imagePath, size)
+	if err != nil {
+		return "", false, err
+	}
+	srcInfo, err := os.Stat(imagePath)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to access photo %s: %w", imagePath, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return path, false, nil
+		}
+		return "", false, fmt.Errorf("failed to access photo derivative %s: %w", path, err)
+	}
+	return path, !info.ModTime().Before(srcInfo.ModTime()), nil
+}
+
+// decodePhoto はJPEGの写真を読み込む
+func decodePhoto(imagePath string) (image.Image, error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open photo %s: %w", imagePath, err)
+	}
+	defer f.Close()
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode photo %s: %w", imagePath, err)
+	}
+	return img, nil
+}
+
+// writePhotoDerivative は縮小画像をJPEGで保存する。
+// 同じ縮小画像を同時に生成しても壊れたファイルを配信しないよう、一時ファイルに書き込んでから置き換える
+func writePhotoDerivative(path string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create photo derivative dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "_tmp_*.jpg")
+	if err != nil {
+		return fmt.Errorf("failed to create photo derivative %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := jpeg.Encode(tmp, img, &jpeg.Options{Quality: photoDerivativeQuality}); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to encode photo derivative %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write photo derivative %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save photo derivative %s: %w", path, err)
+	}
+	return nil
+}
+
+// NormalizeUploadedPhoto はアップロードされた写真（JPEG・PNG・WebP・GIF）を検証し、保存するJPEGに変換する。
+// JPEGはEXIFの向きに従って回転が必要な場合のみ再エンコードし、それ以外は位置情報（GPS・XMP）を除いてそのまま保存する。
+// JPEG以外は白背景に合成してJPEGに変換する（GIFは最初のフレーム）。あわせてEXIFの撮影情報を返す（JPEG以外とEXIFのない写真はnil）
+func NormalizeUploadedPhoto(data []byte) ([]byte, *PhotoMetadata, error) {
+	if len(data) > maxUploadPhotoBytes {
+		return nil, nil, fmt.Errorf("%w: %d bytes", ErrPhotoTooLarge, len(data))
+	}
+	// デコードする前に形式と縦横のピクセル数を確かめる
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, nil, ErrUnsupportedPhotoFormat
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidPhoto, err)
+	}
+	switch format {
+	case "jpeg", "png", "webp", "gif":
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedPhotoFormat, format)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, nil, fmt.Errorf("%w: empty image", ErrInvalidPhoto)
+	}
+	if max(cfg.Width, cfg.Height) > maxUploadPhotoEdge || cfg.Width*cfg.Height > maxUploadPhotoPixels {
+		return nil, nil, fmt.Errorf("%w: %dx%d", ErrPhotoTooLarge, cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidPhoto, err)
+	}
+
+	if format != "jpeg" {
+		out, err := encodePhotoJPEG(flattenPhoto(img))
+		return out, nil, err
+	}
+
+	meta, err := ReadPhotoMetadata(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("WARN: failed to read exif of uploaded photo: %v", err)
+		meta = nil
+	}
+	if meta != nil && meta.Orientation >= 2 {
+		// 再エンコードしたJPEGはEXIFを含まないため、ブラウザや縮小画像で二重に回転しない
+		out, err := encodePhotoJPEG(orientPhoto(img, meta.Orientation))
+		return out, meta, err
+	}
+	out, err := stripEXIFLocation(data)
+	if err != nil {
+		// 位置情報を確実に除くため、EXIFを含めずに保存し直す
+		log.Printf("WARN: failed to strip location from uploaded photo, re-encoding: %v", err)
+		out, err = encodePhotoJPEG(img)
+	}
+	return out, meta, err
+}
+
+// photoFileName はアップロードされた写真の保存先のファイル名（拡張子なし）を返す。
+// 撮影日時（YYYYMMDD_HHMMSS_UTC）に内容のハッシュを付け、同じ秒に撮影された別の写真と重複しないようにする
+func photoFileName(capturedAt time.Time, data []byte) string {
+	sum := sha256.Sum256(data)
+	return capturedAt.UTC().Format("20060102_150405") + "_UTC_" + hex.EncodeToString(sum[:4])
+}
+
+// storePhoto は写真をdirに「name.jpg」として保存し、保存したパスを返す。既存のファイルは上書きせず、
+// 同じ名前のファイルがある場合は「name_2.jpg」「name_3.jpg」…とする。一時ファイルに書き込んでから置き換えるため、
+// 書き込み途中の写真をポーリングや配信が読むことはない
+func storePhoto(dir, name string, data []byte) (string, error) {
+	tmpPath, err := writeTempPhoto(dir, data)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpPath)
+
+	for i := 1; i <= maxPhotoNameAttempts; i++ {
+		path := filepath.Join(dir, name+".jpg")
+		if i > 1 {
+			path = filepath.Join(dir, fmt.Sprintf("%s_%d.jpg", name, i))
+		}
+		// os.Renameは既存のファイルを上書きするため、先に空のファイルで名前を確保してから置き換える
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to create photo %s: %w", path, err)
+		}
+		f.Close()
+		if err := os.Rename(tmpPath, path); err != nil {
+			os.Remove(path)
+			return "", fmt.Errorf("failed to save photo %s: %w", path, err)
+		}
+		return path, nil
+	}
+	return "", fmt.Errorf("no available file name for photo %s", name)
+}
+
+// replacePhoto は保存済みの写真をdataで置き換える。一時ファイルに書き込んでから置き換えるため、
+// 書き込み途中の写真を配信や日記の生成が読むことはない
+func replacePhoto(path string, data []byte) error {
+	tmpPath, err := writeTempPhoto(filepath.Dir(path), data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace photo %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeTempPhoto は写真をdirの一時ファイル（ポーリングの対象外の _tmp_*.jpg）に書き込み、そのパスを返す
+func writeTempPhoto(dir string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(dir, "_tmp_*.jpg")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp photo: %w", err)
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write temp photo: %w", err)
+	}
+	return tmp.Name(), nil
+}
+
+// flattenPhoto は透過のある写真を白背景に合成する（JPEGは透過を持てないため）
+func flattenPhoto(src image.Image) image.Image {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Over)
+	return dst
+}
+
+// encodePhotoJPEG は写真をJPEGにエンコードする
+func encodePhotoJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: photoEncodeQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode photo: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// resizePhoto は長辺がmaxEdge以下になるよう、縦横比を保って写真を縮小する（面積平均法）。既に小さい写真はそのまま返す
+func resizePhoto(src image.Image, maxEdge int) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw <= maxEdge && sh <= maxEdge {
+		return src
+	}
+	dw, dh := maxEdge, max(1, sh*maxEdge/sw)
+	if sh > sw {
+		dw, dh = max(1, sw*maxEdge/sh), maxEdge
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := dy*sh/dh, (dy+1)*sh/dh
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := dx*sw/dw, (dx+1)*sw/dw
+			var r, g, bl, a, n int
+			for y := y0; y < y1; y++ {
+				row := rgba.Pix[y*rgba.Stride:]
+				for x := x0; x < x1; x++ {
+					p := row[x*4 : x*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					bl += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+			i := dst.PixOffset(dx, dy)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(bl / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// removePhotoDerivatives は写真の全てのサイズの縮小画像を削除する。既にファイルがない場合は何もしない
+func removePhotoDerivatives(photosDir, imagePath string) error {
+	var errs []error
+	for _, size := range photoSizes {
+		path, err := photoDerivativePath(photosDir, imagePath, size)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("failed to remove photo derivative %s: %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// BackfillPhotoDerivatives は写真ディレクトリ配下（縮小画像のディレクトリを除く）の全てのJPEGの写真について、
+// まだない縮小画像を生成し、生成した写真と失敗した写真の数を返す。撮影スクリプトの一時ファイルや隠しファイルは対象外
+func BackfillPhotoDerivatives(photosDir string) (generated, failed int, err error) {
+	err = filepath.WalkDir(photosDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if path != photosDir && strings.HasPrefix(name, ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.EqualFold(filepath.Ext(name), ".jpg") || strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+			return nil
+		}
+
+		fresh := true
+		for _, size := range photoSizes {
+			_, ok, err := photoDerivativeStatus(photosDir, path, size)
+			if err != nil {
+				return err
+			}
+			fresh = fresh && ok
+		}
+		if fresh {
+			return nil
+		}
+		if err := GeneratePhotoDerivatives(photosDir, path); err != nil {
+			log.Printf("WARN: failed to generate photo derivatives of %s: %v", path, err)
+			failed++
+			return nil
+		}
+		generated++
+		return nil
+	})
+	if err != nil {
+		return generated, failed, fmt.Errorf("failed to walk photos dir %s: %w", photosDir, err)
+	}
+	return generated, failed, nil
+}
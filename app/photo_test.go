@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodeTestJPEG はJPEGの写真を読み込み、幅と高さを返す
+func decodeTestJPEG(t *testing.T, path string) (int, int) {
+	t.Helper()
+	img, err := decodePhoto(path)
+	if err != nil {
+		t.Fatalf("decodePhoto failed: %v", err)
+	}
+	return img.Bounds().Dx(), img.Bounds().Dy()
+}
+
+func TestResizePhoto(t *testing.T) {
+	tests := []struct {
+		name         string
+		w, h         int
+		maxEdge      int
+		wantW, wantH int
+	}{
+		{name: "横長", w: 1000, h: 500, maxEdge: 320, wantW: 320, wantH: 160},
+		{name: "縦長", w: 300, h: 900, maxEdge: 300, wantW: 100, wantH: 300},
+		{name: "既に小さい", w: 200, h: 100, maxEdge: 320, wantW: 200, wantH: 100},
+		{name: "極端に細長い", w: 2000, h: 1, maxEdge: 320, wantW: 320, wantH: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewRGBA(image.Rect(0, 0, tt.w, tt.h))
+			got := resizePhoto(src, tt.maxEdge)
+			if got.Bounds().Dx() != tt.wantW || got.Bounds().Dy() != tt.wantH {
+				t.Errorf("expected %dx%d, got %dx%d", tt.wantW, tt.wantH, got.Bounds().Dx(), got.Bounds().Dy())
+			}
+		})
+	}
+}
+
+func TestResizePhoto_AveragesColors(t *testing.T) {
+	// 左半分が黒・右半分が白の写真を1ピクセルに縮小すると灰色になる
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			c := color.RGBA{A: 255}
+			if x >= 2 {
+				c = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+	got := resizePhoto(src, 1)
+	r, g, b, _ := got.At(0, 0).RGBA()
+	if r>>8 != 127 || g>>8 != 127 || b>>8 != 127 {
+		t.Errorf("expected gray, got (%d, %d, %d)", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestPhotoDerivativePath(t *testing.T) {
+	photosDir := filepath.Join("data", "photos")
+	thumb, _ := lookupPhotoSize("thumb")
+
+	got, err := photoDerivativePath(photosDir, filepath.Join(photosDir, "user", "a.jpg"), thumb)
+	if err != nil {
+		t.Fatalf("photoDerivativePath failed: %v", err)
+	}
+	if want := filepath.Join(photosDir, photoDerivativesDir, "thumb", "user", "a.jpg"); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	for _, imagePath := range []string{
+		filepath.Join("data", "other", "a.jpg"),
+		photosDir,
+		filepath.Join(photosDir, photoDerivativesDir, "thumb", "a.jpg"),
+	} {
+		if _, err := photoDerivativePath(photosDir, imagePath, thumb); err == nil {
+			t.Errorf("expected error for %s", imagePath)
+		}
+	}
+}
+
+func TestGeneratePhotoDerivatives(t *testing.T) {
+	photosDir := t.TempDir()
+	imagePath := filepath.Join(photosDir, "user", "20260201_1100_UTC.jpg")
+	writeTestJPEG(t, imagePath, 1600, 1200)
+
+	if err := GeneratePhotoDerivatives(photosDir, imagePath); err != nil {
+		t.Fatalf("GeneratePhotoDerivatives failed: %v", err)
+	}
+	for _, size := range photoSizes {
+		path, err := photoDerivativePath(photosDir, imagePath, size)
+		if err != nil {
+			t.Fatalf("photoDerivativePath failed: %v", err)
+		}
+		w, h := decodeTestJPEG(t, path)
+		if w != size.MaxEdge || h != size.MaxEdge*3/4 {
+			t.Errorf("%s: expected %dx%d, got %dx%d", size.Name, size.MaxEdge, size.MaxEdge*3/4, w, h)
+		}
+	}
+
+	// 写真を差し替えると、縮小画像は古くなり作り直す
+	thumb, _ := lookupPhotoSize("thumb")
+	writeTestJPEG(t, imagePath, 600, 1200)
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(imagePath, later, later); err != nil {
+		t.Fatalf("failed to set mod time: %v", err)
+	}
+	path, err := ensurePhotoDerivative(photosDir, imagePath, thumb)
+	if err != nil {
+		t.Fatalf("ensurePhotoDerivative failed: %v", err)
+	}
+	if w, h := decodeTestJPEG(t, path); w != 160 || h != 320 {
+		t.Errorf("expected regenerated 160x320, got %dx%d", w, h)
+	}
+
+	if err := removePhotoDerivatives(photosDir, imagePath); err != nil {
+		t.Fatalf("removePhotoDerivatives failed: %v", err)
+	}
+	for _, size := range photoSizes {
+		path, _ := photoDerivativePath(photosDir, imagePath, size)
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s: expected derivative to be removed, got %v", size.Name, err)
+		}
+	}
+	// 既に削除済みでもエラーにしない
+	if err := removePhotoDerivatives(photosDir, imagePath); err != nil {
+		t.Errorf("expected no error for removed derivatives, got %v", err)
+	}
+}
+
+func TestGeneratePhotoDerivatives_InvalidPhoto(t *testing.T) {
+	photosDir := t.TempDir()
+	imagePath := filepath.Join(photosDir, "broken.jpg")
+	writeInvalidTestPhoto(t, imagePath, time.Now())
+
+	if err := GeneratePhotoDerivatives(photosDir, imagePath); err == nil {
+		t.Fatal("expected error for invalid photo")
+	}
+}
+
+func TestBackfillPhotoDerivatives(t *testing.T) {
+	photosDir := t.TempDir()
+	rootPhoto := filepath.Join(photosDir, "20260201_1100_UTC.jpg")
+	userPhoto := filepath.Join(photosDir, "user", "20260201_110000_UTC.jpg")
+	writeTestJPEG(t, rootPhoto, 800, 600)
+	writeTestJPEG(t, userPhoto, 800, 600)
+	writeInvalidTestPhoto(t, filepath.Join(photosDir, "broken.jpg"), time.Now())
+	// 対象外: 一時ファイル、jpg以外、縮小画像のディレクトリ
+	writeTestJPEG(t, filepath.Join(photosDir, "_tmp_123_1_20260201_1100_UTC.jpg"), 800, 600)
+	writeInvalidTestPhoto(t, filepath.Join(photosDir, "capture.log"), time.Now())
+	writeTestJPEG(t, filepath.Join(photosDir, photoDerivativesDir, "stale.jpg"), 800, 600)
+
+	generated, failed, err := BackfillPhotoDerivatives(photosDir)
+	if err != nil {
+		t.Fatalf("BackfillPhotoDerivatives failed: %v", err)
+	}
+	if generated != 2 || failed != 1 {
+		t.Errorf("expected generated=2 failed=1, got generated=%d failed=%d", generated, failed)
+	}
+	for _, imagePath := range []string{rootPhoto, userPhoto} {
+		for _, size := range photoSizes {
+			path, _ := photoDerivativePath(photosDir, imagePath, size)
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("expected derivative %s: %v", path, err)
+			}
+		}
+	}
+	tmpThumb := filepath.Join(photosDir, photoDerivativesDir, "thumb", "_tmp_123_1_20260201_1100_UTC.jpg")
+	if _, err := os.Stat(tmpThumb); !os.IsNotExist(err) {
+		t.Errorf("expected no derivative for temp file, got %v", err)
+	}
+
+	// 2回目は生成済みの写真を作り直さない
+	generated, _, err = BackfillPhotoDerivatives(photosDir)
+	if err != nil {
+		t.Fatalf("second BackfillPhotoDerivatives failed: %v", err)
+	}
+	if generated != 0 {
+		t.Errorf("expected no regenerated photos, got %d", generated)
+	}
+}
+
+func TestNormalizeUploadedPhoto(t *testing.T) {
+	var order exifTestByteOrder = binary.LittleEndian
+	gpsEXIF := buildTestEXIF(order,
+		[]exifTestTag{exifTestASCII(exifTagModel, "EOS")},
+		[]exifTestTag{exifTestASCII(exifTagDateTimeOriginal, "2026:02:01 12:34:56")},
+		[]exifTestTag{
+			exifTestASCII(exifTagGPSLatitudeRef, "N"),
+			exifTestRationals(order, exifTagGPSLatitude, 35, 1, 40, 1, 5232, 100),
+			exifTestASCII(exifTagGPSLongitudeRef, "E"),
+			exifTestRationals(order, exifTagGPSLongitude, 139, 1, 46, 1, 156, 100),
+		},
+	)
+	rotatedEXIF := buildTestEXIF(order, []exifTestTag{exifTestShort(order, exifTagOrientation, 6)}, nil, nil)
+
+	var pngBuf bytes.Buffer
+	transparent := image.NewNRGBA(image.Rect(0, 0, 30, 20))
+	if err := png.Encode(&pngBuf, transparent); err != nil {
+		t.Fatalf("failed to encode png: %v", err)
+	}
+	var gifBuf bytes.Buffer
+	if err := gif.Encode(&gifBuf, image.NewRGBA(image.Rect(0, 0, 30, 20)), nil); err != nil {
+		t.Fatalf("failed to encode gif: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		data         []byte
+		wantW, wantH int
+		wantMeta     bool
+	}{
+		{name: "JPEG", data: encodeTestJPEG(t, 30, 20, gpsEXIF), wantW: 30, wantH: 20, wantMeta: true},
+		{name: "回転が必要なJPEG", data: encodeTestJPEG(t, 30, 20, rotatedEXIF), wantW: 20, wantH: 30, wantMeta: true},
+		{name: "PNG", data: pngBuf.Bytes(), wantW: 30, wantH: 20},
+		{name: "GIF", data: gifBuf.Bytes(), wantW: 30, wantH: 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, meta, err := NormalizeUploadedPhoto(tt.data)
+			if err != nil {
+				t.Fatalf("NormalizeUploadedPhoto failed: %v", err)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(got))
+			if err != nil {
+				t.Fatalf("expected jpeg: %v", err)
+			}
+			if img.Bounds().Dx() != tt.wantW || img.Bounds().Dy() != tt.wantH {
+				t.Errorf("expected %dx%d, got %dx%d", tt.wantW, tt.wantH, img.Bounds().Dx(), img.Bounds().Dy())
+			}
+			if (meta != nil) != tt.wantMeta {
+				t.Errorf("unexpected metadata: %+v", meta)
+			}
+			// 保存する写真には位置情報を残さない
+			if saved, err := ReadPhotoMetadata(bytes.NewReader(got)); err != nil || (saved != nil && saved.Coordinates() != "") {
+				t.Errorf("expected no location in saved photo, got %+v, %v", saved, err)
+			}
+		})
+	}
+
+	// 透過部分は白にする
+	got, _, err := NormalizeUploadedPhoto(pngBuf.Bytes())
+	if err != nil {
+		t.Fatalf("NormalizeUploadedPhoto failed: %v", err)
+	}
+	img, _ := jpeg.Decode(bytes.NewReader(got))
+	if r, g, b, _ := img.At(0, 0).RGBA(); r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+		t.Errorf("expected white background, got (%d, %d, %d)", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestNormalizeUploadedPhoto_Errors(t *testing.T) {
+	var tooWide bytes.Buffer
+	if err := png.Encode(&tooWide, image.NewGray(image.Rect(0, 0, maxUploadPhotoEdge+1, 1))); err != nil {
+		t.Fatalf("failed to encode png: %v", err)
+	}
+	valid := encodeTestJPEG(t, 8, 8, nil)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "画像以外", data: []byte("plain text"), wantErr: ErrUnsupportedPhotoFormat},
+		{name: "BMP", data: append([]byte("BM"), make([]byte, 64)...), wantErr: ErrUnsupportedPhotoFormat},
+		{name: "ファイルサイズが上限超過", data: make([]byte, maxUploadPhotoBytes+1), wantErr: ErrPhotoTooLarge},
+		{name: "幅が上限超過", data: tooWide.Bytes(), wantErr: ErrPhotoTooLarge},
+		{name: "途中で切れたJPEG", data: valid[:len(valid)/2], wantErr: ErrInvalidPhoto},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := NormalizeUploadedPhoto(tt.data); !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPhotoFileName(t *testing.T) {
+	capturedAt := time.Date(2026, 2, 1, 12, 34, 56, 0, time.FixedZone("JST", 9*60*60))
+	name := photoFileName(capturedAt, []byte("photo-1"))
+	if !strings.HasPrefix(name, "20260201_033456_UTC_") || len(name) != len("20260201_033456_UTC_")+8 {
+		t.Errorf("unexpected file name: %s", name)
+	}
+	// 同じ撮影日時でも内容が異なれば別の名前にする
+	if other := photoFileName(capturedAt, []byte("photo-2")); other == name {
+		t.Errorf("expected different names for different photos, got %s", other)
+	}
+	// 撮影日時は保存後のポーリングでもファイル名から読み取れる
+	if got := parseCapturedAt(name+"_2.jpg", time.Time{}); !got.Equal(capturedAt) {
+		t.Errorf("parseCapturedAt(%s_2.jpg) = %v", name, got)
+	}
+}
+
+func TestStorePhoto(t *testing.T) {
+	dir := t.TempDir()
+
+	path1, err := storePhoto(dir, "20260201_033456_UTC_abcd", []byte("first"))
+	if err != nil {
+		t.Fatalf("storePhoto failed: %v", err)
+	}
+	if path1 != filepath.Join(dir, "20260201_033456_UTC_abcd.jpg") {
+		t.Errorf("unexpected path: %s", path1)
+	}
+
+	// 同じ名前のファイルがある場合は上書きせず、連番を付ける
+	path2, err := storePhoto(dir, "20260201_033456_UTC_abcd", []byte("second"))
+	if err != nil {
+		t.Fatalf("storePhoto failed: %v", err)
+	}
+	if path2 != filepath.Join(dir, "20260201_033456_UTC_abcd_2.jpg") {
+		t.Errorf("unexpected path: %s", path2)
+	}
+	if data, _ := os.ReadFile(path1); string(data) != "first" {
+		t.Errorf("expected first photo to be kept, got %q", data)
+	}
+	if data, _ := os.ReadFile(path2); string(data) != "second" {
+		t.Errorf("expected second photo, got %q", data)
+	}
+
+	// 一時ファイルは残さない
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected 2 files, got %d", len(entries))
+	}
+	if info, err := os.Stat(path2); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("expected mode 0644, got %v, %v", info, err)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// systemUserUUID は写真ディレクトリ直下の写真を割り当てるsystemユーザーのUUID（000004マイグレーションで作成）
+	systemUserUUID = "00000000000000000000000000000000"
+	// defaultPhotoPollInterval は写真ディレクトリのデフォルトのポーリング間隔
+	defaultPhotoPollInterval = time.Minute
+	// photoMinAge は書き込み途中のファイルを拾わないため、最終更新から処理対象とするまでの待ち時間
+	photoMinAge = 10 * time.Second
+)
+
+// LoadPhotoPollInterval は環境変数 PHOTO_POLL_INTERVAL（例: "1m", "30s"）からポーリング間隔を読み込む。
+// 未設定の場合はデフォルト値、"0" の場合はポーリング無効として0を返す
+func LoadPhotoPollInterval() (time.Duration, error) {
+	v := os.Getenv("PHOTO_POLL_INTERVAL")
+	if v == "" {
+		return defaultPhotoPollInterval, nil
+	}
+	if v == "0" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("PHOTO_POLL_INTERVAL must be a non-negative duration: %q", v)
+	}
+	return d, nil
+}
+
+// PhotoPoller は写真ディレクトリを定期的に走査し、日記が未作成の写真を日記生成ジョブとして登録する。
+// 直下の写真はsystemユーザー、{user_uuid}/ サブディレクトリ配下の写真は該当ユーザーの日記になる
+type PhotoPoller struct {
+	photosDir string
+	interval  time.Duration
+	repo      DiaryRepository
+	userRepo  UserRepository
+	jobRepo   JobRepository
+	hashRepo  PhotoHashRepository
+	metaRepo  PhotoMetadataRepository
+	worker    *DiaryWorker
+	now       func() time.Time // テスト用に差し替え可能
+}
+
+// NewPhotoPoller は新しいPhotoPollerを生成する
+func NewPhotoPoller(photosDir string, interval time.Duration, repo DiaryRepository, userRepo UserRepository, jobRepo JobRepository, hashRepo PhotoHashRepository, metaRepo PhotoMetadataRepository, worker *DiaryWorker) *PhotoPoller {
+	return &PhotoPoller{
+		photosDir: photosDir,
+		interval:  interval,
+		repo:      repo,
+		userRepo:  userRepo,
+		jobRepo:   jobRepo,
+		hashRepo:  hashRepo,
+		metaRepo:  metaRepo,
+		worker:    worker,
+		now:       time.Now,
+	}
+}
+
+// Run はctxがキャンセルされるまで、起動直後とinterval毎に写真ディレクトリを走査する
+func (p *PhotoPoller) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.poll(ctx); err != nil {
+			log.Printf("ERROR: photo polling failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll は写真ディレクトリ直下とユーザーごとのサブディレクトリを走査し、未処理の写真をジョブとして登録する
+func (p *PhotoPoller) poll(ctx context.Context) error {
+	entries, err := os.ReadDir(p.photosDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read photos dir %s: %w", p.photosDir, err)
+	}
+
+	systemUser, err := p.userRepo.GetUserByUUID(ctx, systemUserUUID)
+	if err != nil {
+		return fmt.Errorf("failed to get system user: %w", err)
+	}
+
+	for _, entry := range entries {
+		var err error
+		if entry.IsDir() {
+			err = p.pollUserDir(ctx, entry.Name())
+		} else if systemUser != nil {
+			err = p.enqueueIfUnprocessed(ctx, systemUser.ID, filepath.Join(p.photosDir, entry.Name()))
+		}
+		if errors.Is(err, ErrQueueFull) || errors.Is(err, ErrWorkerStopped) {
+			// 残りは次回のポーリングで処理する
+			log.Printf("INFO: photo polling paused: %v", err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pollUserDir はユーザーUUID名のサブディレクトリ配下の未処理の写真をジョブとして登録する
+func (p *PhotoPoller) pollUserDir(ctx context.Context, userUUID string) error {
+	user, err := p.userRepo.GetUserByUUID(ctx, userUUID)
+	if err != nil {
+		return fmt.Errorf("failed to get user by UUID %s: %w", userUUID, err)
+	}
+	if user == nil {
+		// ユーザーに対応しないディレクトリは対象外
+		return nil
+	}
+
+	userDir := filepath.Join(p.photosDir, userUUID)
+	entries, err := os.ReadDir(userDir)
+	if err != nil {
+		return fmt.Errorf("failed to read user photo dir %s: %w", userDir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := p.enqueueIfUnprocessed(ctx, user.ID, filepath.Join(userDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueueIfUnprocessed は日記もジョブも存在しない写真を日記生成ジョブとして登録する。
+// 失敗したジョブは自動では再登録しない（状態は GET /api/jobs/{job_id} で確認できる）。重複として統合した写真は登録しない
+func (p *PhotoPoller) enqueueIfUnprocessed(ctx context.Context, userID int, imagePath string) error {
+	name := filepath.Base(imagePath)
+	// 撮影スクリプトの一時ファイル（_tmp_*）や隠しファイルは対象外
+	if !strings.EqualFold(filepath.Ext(name), ".jpg") || strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+		return nil
+	}
+
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", imagePath, err)
+	}
+	if p.now().Sub(info.ModTime()) < photoMinAge {
+		return nil
+	}
+
+	processed, err := p.repo.IsImageProcessed(ctx, imagePath)
+	if err != nil {
+		return fmt.Errorf("failed to check processed image %s: %w", imagePath, err)
+	}
+	if processed {
+		return nil
+	}
+	hasJob, err := p.jobRepo.HasJobForImage(ctx, imagePath)
+	if err != nil {
+		return fmt.Errorf("failed to check job for %s: %w", imagePath, err)
+	}
+	if hasJob {
+		return nil
+	}
+	hash, err := p.hashRepo.GetPhotoHash(ctx, imagePath)
+	if err != nil {
+		return fmt.Errorf("failed to get photo hash of %s: %w", imagePath, err)
+	}
+	if hash != nil && hash.DuplicateOf != "" {
+		return nil
+	}
+
+	// アップロードAPIと同じく写真を検証し、位置情報を除いて向きを補正したJPEGに置き換える。
+	// 写真として読み込めない・大きすぎるファイルは日記を作らない
+	meta, err := p.normalizePhoto(ctx, imagePath)
+	if err != nil {
+		if errors.Is(err, ErrInvalidPhoto) || errors.Is(err, ErrUnsupportedPhotoFormat) || errors.Is(err, ErrPhotoTooLarge) {
+			log.Printf("WARN: skipped invalid photo %s: %v", imagePath, err)
+			return nil
+		}
+		return err
+	}
+
+	// 撮影日時はファイル名、EXIFの撮影日時、更新日時の順に取得する
+	fallback := info.ModTime()
+	if meta != nil && meta.CapturedAt != nil {
+		fallback = *meta.CapturedAt
+	}
+	capturedAt := parseCapturedAt(name, fallback)
+	jobID, err := p.worker.Enqueue(ctx, userID, 0, imagePath, capturedAt)
+	if err != nil {
+		return err
+	}
+	log.Printf("INFO: enqueued unprocessed photo %s (job_id: %s)", imagePath, jobID)
+
+	// 一覧やスライドショーで使う縮小画像を生成する。失敗しても配信時に生成し直す
+	if err := GeneratePhotoDerivatives(p.photosDir, imagePath); err != nil {
+		log.Printf("WARN: failed to generate photo derivatives of %s: %v", imagePath, err)
+	}
+
+	// 似ている写真の検出に使う知覚ハッシュを記録する。失敗しても日記の生成には影響しない
+	if hash == nil {
+		if err := p.saveHash(ctx, userID, imagePath, capturedAt); err != nil {
+			log.Printf("WARN: failed to save photo hash of %s: %v", imagePath, err)
+		}
+	}
+	return nil
+}
+
+// normalizePhoto は写真を NormalizeUploadedPhoto で検証・変換し、内容が変わる場合は写真を置き換える。
+// EXIFの撮影情報は記録して返す。置き換え済みの写真（向きの補正でEXIFを含まない写真など）は記録済みの撮影情報を返す
+func (p *PhotoPoller) normalizePhoto(ctx context.Context, imagePath string) (*PhotoMetadata, error) {
+	data, err := os.ReadFile(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read photo %s: %w", imagePath, err)
+	}
+	photo, meta, err := NormalizeUploadedPhoto(data)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(photo, data) {
+		if err := replacePhoto(imagePath, photo); err != nil {
+			return nil, err
+		}
+		log.Printf("INFO: normalized photo %s (location removed)", imagePath)
+	}
+
+	if meta == nil {
+		saved, err := p.metaRepo.GetPhotoMetadata(ctx, imagePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get photo metadata of %s: %w", imagePath, err)
+		}
+		return saved, nil
+	}
+	// ジョブを登録できずに次回のポーリングで処理し直す場合に備え、ジョブを登録する前に記録する
+	meta.ImagePath = imagePath
+	if err := p.metaRepo.SavePhotoMetadata(ctx, *meta); err != nil {
+		return nil, fmt.Errorf("failed to save photo metadata of %s: %w", imagePath, err)
+	}
+	return meta, nil
+}
+
+// saveHash は写真の知覚ハッシュを計算して記録する
+func (p *PhotoPoller) saveHash(ctx context.Context, userID int, imagePath string, capturedAt time.Time) error {
+	hash, err := readPhotoHash(imagePath)
+	if err != nil {
+		return err
+	}
+	return p.hashRepo.SavePhotoHash(ctx, PhotoHash{ImagePath: imagePath, UserID: userID, Hash: hash, CapturedAt: capturedAt})
+}
+
+// parseCapturedAt は撮影スクリプトやアップロードAPIのファイル名（YYYYMMDD_HHMM[SS]_UTC*.jpg）から撮影日時を取得する。
+// ファイル名から取得できない場合はfallbackを返す
+func parseCapturedAt(filename string, fallback time.Time) time.Time {
+	idx := strings.Index(filename, "_UTC")
+	if idx < 0 {
+		return fallback.UTC()
+	}
+	prefix := filename[:idx]
+	for _, layout := range []string{"20060102_150405", "20060102_1504"} {
+		if t, err := time.ParseInLocation(layout, prefix, time.UTC); err == nil {
+			return t
+		}
+	}
+	return fallback.UTC()
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPhotoPoller_Poll(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMockDiaryRepository()
+	userRepo := NewSQLiteUserRepository(db)
+	jobRepo := NewSQLiteJobRepository(db)
+	worker := NewDiaryWorker(repo, jobRepo, &MockDiaryGenerator{}, nil, nil, DiaryWorkerConfig{Workers: 1, QueueSize: 10})
+
+	if err := userRepo.CreateUser(t.Context(), systemUserUUID, "system", "DISABLED"); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	userUUID := "550e8400e29b41d4a716446655440000"
+	if err := userRepo.CreateUser(t.Context(), userUUID, "alice", "hash"); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	photosDir := t.TempDir()
+	now := time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-time.Hour)
+
+	rootPhoto := filepath.Join(photosDir, "20260201_1100_UTC.jpg")
+	userPhoto := filepath.Join(photosDir, userUUID, "20260201_110000_UTC.jpg")
+	processedPhoto := filepath.Join(photosDir, "20260131_1100_UTC.jpg")
+	writeTestJPEGAt(t, rootPhoto, old)
+	writeTestJPEGAt(t, userPhoto, old)
+	writeTestJPEGAt(t, processedPhoto, old)
+	// 対象外: 書き込み直後、一時ファイル、jpg以外、未登録ユーザーのディレクトリ
+	writeInvalidTestPhoto(t, filepath.Join(photosDir, "20260201_1159_UTC.jpg"), now)
+	writeInvalidTestPhoto(t, filepath.Join(photosDir, "_tmp_123_1_20260201_1100_UTC.jpg"), old)
+	writeInvalidTestPhoto(t, filepath.Join(photosDir, "capture.log"), old)
+	writeInvalidTestPhoto(t, filepath.Join(photosDir, "ffffffffffffffffffffffffffffffff", "20260201_1100_UTC.jpg"), old)
+
+	if err := repo.CreateDiary(t.Context(), processedPhoto, "処理済み", old); err != nil {
+		t.Fatalf("CreateDiary failed: %v", err)
+	}
+
+	poller := NewPhotoPoller(photosDir, time.Minute, repo, userRepo, jobRepo, NewSQLitePhotoHashRepository(db), NewSQLitePhotoMetadataRepository(db), worker)
+	poller.now = func() time.Time { return now }
+
+	if err := poller.poll(t.Context()); err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+	// 2回目のポーリングで同じ写真を重複登録しない
+	if err := poller.poll(t.Context()); err != nil {
+		t.Fatalf("second poll failed: %v", err)
+	}
+
+	jobs, err := jobRepo.GetQueuedJobs(t.Context())
+	if err != nil {
+		t.Fatalf("GetQueuedJobs failed: %v", err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("expected 2 queued jobs, got %d: %+v", len(jobs), jobs)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ImagePath < jobs[j].ImagePath })
+
+	systemUser, err := userRepo.GetUserByUUID(t.Context(), systemUserUUID)
+	if err != nil {
+		t.Fatalf("GetUserByUUID failed: %v", err)
+	}
+	user, err := userRepo.GetUserByUUID(t.Context(), userUUID)
+	if err != nil {
+		t.Fatalf("GetUserByUUID failed: %v", err)
+	}
+
+	if jobs[0].ImagePath != rootPhoto || jobs[0].UserID != systemUser.ID {
+		t.Errorf("unexpected root photo job: %+v", jobs[0])
+	}
+	if !jobs[0].CapturedAt.Equal(time.Date(2026, 2, 1, 11, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected captured_at for root photo: %v", jobs[0].CapturedAt)
+	}
+	if jobs[1].ImagePath != userPhoto || jobs[1].UserID != user.ID {
+		t.Errorf("unexpected user photo job: %+v", jobs[1])
+	}
+}
+
+func TestPhotoPoller_Poll_PhotoHash(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMockDiaryRepository()
+	userRepo := NewSQLiteUserRepository(db)
+	jobRepo := NewSQLiteJobRepository(db)
+	hashRepo := NewSQLitePhotoHashRepository(db)
+	worker := NewDiaryWorker(repo, jobRepo, &MockDiaryGenerator{}, nil, nil, DiaryWorkerConfig{Workers: 1, QueueSize: 10})
+
+	if err := userRepo.CreateUser(t.Context(), systemUserUUID, "system", "DISABLED"); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	systemUser, err := userRepo.GetUserByUUID(t.Context(), systemUserUUID)
+	if err != nil {
+		t.Fatalf("GetUserByUUID failed: %v", err)
+	}
+
+	photosDir := t.TempDir()
+	now := time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-time.Hour)
+	photo := filepath.Join(photosDir, "20260201_1100_UTC.jpg")
+	mergedPhoto := filepath.Join(photosDir, "20260201_1101_UTC.jpg")
+	for _, path := range []string{photo, mergedPhoto} {
+		writeTestJPEGAt(t, path, old)
+	}
+	// 重複として統合した写真は日記を作らない
+	if err := hashRepo.SavePhotoHash(t.Context(), PhotoHash{
+		ImagePath: mergedPhoto, UserID: systemUser.ID, Hash: 1, CapturedAt: old, DuplicateOf: photo,
+	}); err != nil {
+		t.Fatalf("SavePhotoHash failed: %v", err)
+	}
+
+	poller := NewPhotoPoller(photosDir, time.Minute, repo, userRepo, jobRepo, hashRepo, NewSQLitePhotoMetadataRepository(db), worker)
+	poller.now = func() time.Time { return now }
+	if err := poller.poll(t.Context()); err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+
+	jobs, err := jobRepo.GetQueuedJobs(t.Context())
+	if err != nil {
+		t.Fatalf("GetQueuedJobs failed: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].ImagePath != photo {
+		t.Fatalf("expected only %s to be queued, got %+v", photo, jobs)
+	}
+
+	// 登録した写真の知覚ハッシュを記録する
+	hash, err := hashRepo.GetPhotoHash(t.Context(), photo)
+	if err != nil || hash == nil {
+		t.Fatalf("expected photo hash, got %+v, %v", hash, err)
+	}
+	if hash.UserID != systemUser.ID || !hash.CapturedAt.Equal(time.Date(2026, 2, 1, 11, 0, 0, 0, time.UTC)) || hash.DuplicateOf != "" {
+		t.Errorf("unexpected photo hash: %+v", hash)
+	}
+}
+
+func TestPhotoPoller_Poll_NormalizesPhoto(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMockDiaryRepository()
+	userRepo := NewSQLiteUserRepository(db)
+	jobRepo := NewSQLiteJobRepository(db)
+	metaRepo := NewSQLitePhotoMetadataRepository(db)
+	worker := NewDiaryWorker(repo, jobRepo, &MockDiaryGenerator{}, nil, nil, DiaryWorkerConfig{Workers: 1, QueueSize: 10})
+
+	if err := userRepo.CreateUser(t.Context(), systemUserUUID, "system", "DISABLED"); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	photosDir := t.TempDir()
+	now := time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-time.Hour)
+
+	// ファイル名に撮影日時のない、位置情報を含む写真
+	var order exifTestByteOrder = binary.LittleEndian
+	exif := buildTestEXIF(order,
+		[]exifTestTag{exifTestASCII(exifTagModel, "EOS")},
+		[]exifTestTag{exifTestASCII(exifTagDateTimeOriginal, "2026:02:01 12:34:56")},
+		[]exifTestTag{
+			exifTestASCII(exifTagGPSLatitudeRef, "N"),
+			exifTestRationals(order, exifTagGPSLatitude, 35, 1, 40, 1, 5232, 100),
+			exifTestASCII(exifTagGPSLongitudeRef, "E"),
+			exifTestRationals(order, exifTagGPSLongitude, 139, 1, 46, 1, 156, 100),
+		},
+	)
+	photo := filepath.Join(photosDir, "capture.jpg")
+	brokenPhoto := filepath.Join(photosDir, "broken.jpg")
+	for path, data := range map[string][]byte{photo: encodeTestJPEG(t, 30, 20, exif), brokenPhoto: []byte("fake image data")} {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("failed to write photo: %v", err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatalf("failed to set mod time: %v", err)
+		}
+	}
+
+	poller := NewPhotoPoller(photosDir, time.Minute, repo, userRepo, jobRepo, NewSQLitePhotoHashRepository(db), metaRepo, worker)
+	poller.now = func() time.Time { return now }
+	if err := poller.poll(t.Context()); err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+
+	// 写真として読み込めないファイルは登録しない
+	jobs, err := jobRepo.GetQueuedJobs(t.Context())
+	if err != nil {
+		t.Fatalf("GetQueuedJobs failed: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].ImagePath != photo {
+		t.Fatalf("expected only %s to be queued, got %+v", photo, jobs)
+	}
+	// 撮影日時はEXIFから取得する（タイムゾーンがない場合は日本時間）
+	wantCapturedAt := time.Date(2026, 2, 1, 3, 34, 56, 0, time.UTC)
+	if !jobs[0].CapturedAt.Equal(wantCapturedAt) {
+		t.Errorf("expected captured_at %v, got %v", wantCapturedAt, jobs[0].CapturedAt)
+	}
+
+	// 保存した写真からは位置情報を除き、撮影情報は記録する
+	f, err := os.Open(photo)
+	if err != nil {
+		t.Fatalf("failed to open photo: %v", err)
+	}
+	defer f.Close()
+	if saved, err := ReadPhotoMetadata(f); err != nil || saved == nil || saved.Coordinates() != "" {
+		t.Errorf("expected no location in saved photo, got %+v, %v", saved, err)
+	}
+	meta, err := metaRepo.GetPhotoMetadata(t.Context(), photo)
+	if err != nil || meta == nil {
+		t.Fatalf("expected photo metadata, got %+v, %v", meta, err)
+	}
+	if meta.CameraModel != "EOS" || meta.CapturedAt == nil || !meta.CapturedAt.Equal(wantCapturedAt) {
+		t.Errorf("unexpected photo metadata: %+v", meta)
+	}
+}
+
+func TestPhotoPoller_Poll_MissingDir(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMockDiaryRepository()
+	jobRepo := NewSQLiteJobRepository(db)
+	worker := NewDiaryWorker(repo, jobRepo, &MockDiaryGenerator{}, nil, nil, DefaultDiaryWorkerConfig())
+
+	poller := NewPhotoPoller(filepath.Join(t.TempDir(), "missing"), time.Minute, repo, NewSQLiteUserRepository(db), jobRepo, NewSQLitePhotoHashRepository(db), NewSQLitePhotoMetadataRepository(db), worker)
+	if err := poller.poll(t.Context()); err != nil {
+		t.Errorf("expected no error for missing photos dir, got %v", err)
+	}
+}
+
+func TestParseCapturedAt(t *testing.T) {
+	fallback := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		filename string
+		want     time.Time
+	}{
+		{
+			name:     "capture.sh形式",
+			filename: "20260201_1100_UTC.jpg",
+			want:     time.Date(2026, 2, 1, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "capture_auto.shの重複回避形式",
+			filename: "20260201_1100_UTC_4321.jpg",
+			want:     time.Date(2026, 2, 1, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "アップロードAPI形式",
+			filename: "20260201_110023_UTC.jpg",
+			want:     time.Date(2026, 2, 1, 11, 0, 23, 0, time.UTC),
+		},
+		{
+			name:     "タイムゾーン不明の形式",
+			filename: "20260201_1100.jpg",
+			want:     fallback,
+		},
+		{
+			name:     "日時を含まない",
+			filename: "photo.jpg",
+			want:     fallback,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseCapturedAt(tt.filename, fallback)
+			if !got.Equal(tt.want) {
+				t.Errorf("parseCapturedAt(%q) = %v, want %v", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,41 +1,319 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"os"
 	"strings"
+	"text/template"
 	"time"
 )
 
+const basePrompt = "この植物の写真を見て、成長の様子や変化を観察してください。親しみやすい口調で、200文字程度の観察日記を書いてください。"
+
+// referenceImageDaysAgo は参照画像として選ぶ過去の写真の撮影日（今回の撮影日の1日前・1週間前・1ヶ月前）
+var referenceImageDaysAgo = []int{1, 7, 30}
+
+// 組み込みのペルソナ（日記の書き手）の名前。PersonaCustom はユーザーが編集したテンプレートを使う
 const (
-	basePrompt             = "この植物の写真を見て、成長の様子や変化を観察してください。親しみやすい口調で、200文字程度の観察日記を書いてください。"
-	maxPastDiariesInPrompt = 30 // プロンプトに含める過去日記の最大件数
+	PersonaStandard = "standard"
+	PersonaChild    = "child"
+	PersonaBotanist = "botanist"
+	PersonaHaiku    = "haiku"
+	PersonaCustom   = "custom"
 )
 
-// buildDiaryPrompt は過去日記を含む動的プロンプトを生成する。
-func buildDiaryPrompt(pastDiaries []Diary) string {
-	if len(pastDiaries) == 0 {
-		return basePrompt
+// maxPromptTemplateLength はユーザーが編集するテンプレートの最大文字数
+const maxPromptTemplateLength = 4000
+
+// PromptPersona は組み込みのペルソナ
+type PromptPersona struct {
+	Name        string
+	Label       string
+	Instruction string // 写真の観察と日記の書き方の指示
+}
+
+// promptPersonas は組み込みのペルソナを表示順に並べたもの
+var promptPersonas = []PromptPersona{
+	{Name: PersonaStandard, Label: "標準", Instruction: basePrompt},
+	{Name: PersonaChild, Label: "子ども向け", Instruction: "この植物の写真を見て、成長の様子や変化を観察してください。小学生にも分かるやさしい言葉で、むずかしい漢字を使わずに、150文字程度の観察日記を書いてください。"},
+	{Name: PersonaBotanist, Label: "植物学者", Instruction: "植物学者の視点でこの植物の写真を観察してください。葉の形や色、新芽やつぼみなどの生育段階、気になる症状を専門用語も交えて具体的に、300文字程度の観察記録を書いてください。"},
+	{Name: PersonaHaiku, Label: "俳句", Instruction: "この植物の写真を見て、今日の様子を季語を入れた五・七・五の俳句で一句詠んでください。俳句のあとに、句に込めた観察のポイントを100文字程度で添えてください。"},
+}
+
+// promptContextTemplate は全てのペルソナで指示のあとに続ける、植物の名前・天気・過去日記の部分のテンプレート
+const promptContextTemplate = `{{if .PlantName}}
+
+この植物の名前は「{{.PlantName}}」です。{{end}}{{if .Weather}}
+
+撮影日（{{.Date}}）の天気は{{.Weather}}でした。{{end}}{{if or .Summaries .PastDiaries}}
+
+参考までに、これまでの観察記録を以下に示します：
+
+{{range .Summaries}}【{{.Period}}のまとめ】
+{{.Content}}
+
+{{end}}{{range .PastDiaries}}【{{.Date}}】
+{{.Content}}
+
+{{end}}これまでの観察記録を踏まえて、今回の写真から見られる成長の変化や特徴を記述してください。{{end}}`
+
+// PromptData はプロンプトテンプレートに渡す値
+type PromptData struct {
+	PlantName   string          // 植物の名前（植物未指定の場合は空）
+	Date        string          // 撮影日（JST、例: 2026年03月10日）
+	Weather     string          // 撮影日の天気（取得できない場合は空）
+	Summaries   []PromptSummary // 直近より前の期間の過去日記の要約（古い順）
+	PastDiaries []PromptDiary   // 撮影日前日までの直近の過去日記（古い順、同じ日の同じ植物の日記は最新の1件）
+}
+
+// PromptSummary はプロンプトテンプレートに渡す、週・月ごとの過去日記の要約
+type PromptSummary struct {
+	Period  string // 期間（例: 2026年02月01日〜02月07日、2026年01月）
+	Content string
+}
+
+// PromptDiary はプロンプトテンプレートに渡す過去日記
+type PromptDiary struct {
+	Date    string // 撮影日（JST、例: 2026年03月09日）
+	Content string
+}
+
+// personaTemplate はペルソナのテンプレートを返す。未知のペルソナの場合は標準のテンプレートを返す
+func personaTemplate(name string) string {
+	for _, p := range promptPersonas {
+		if p.Name == name {
+			return p.Instruction + promptContextTemplate
+		}
 	}
+	return basePrompt + promptContextTemplate
+}
 
-	// プロンプトに含める日記を最新のN件に制限（古い順にソートされているため、最後のN件を取得）
-	diariesToInclude := pastDiaries
-	if len(pastDiaries) > maxPastDiariesInPrompt {
-		diariesToInclude = pastDiaries[len(pastDiaries)-maxPastDiariesInPrompt:]
+// isPromptPersona は組み込みのペルソナまたは PersonaCustom かどうかを返す
+func isPromptPersona(name string) bool {
+	if name == PersonaCustom {
+		return true
+	}
+	for _, p := range promptPersonas {
+		if p.Name == name {
+			return true
+		}
 	}
+	return false
+}
 
-	// JSTに変換するためのヘルパー
+// newPromptData は撮影日時と過去日記（古い順）からテンプレートに渡す値を組み立てる。
+// 過去日記は同じ日の同じ植物の日記を最新の1件にまとめ、新しいものから defaultPromptContextTokens の推定トークン数まで含める
+func newPromptData(plantName, weather string, pastDiaries []Diary, capturedAt time.Time) PromptData {
 	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	recent, _ := fitRecentDiaries(dedupDailyDiaries(pastDiaries), defaultPromptContextTokens)
+	return PromptData{
+		PlantName:   plantName,
+		Date:        capturedAt.In(jst).Format("2006年01月02日"),
+		Weather:     weather,
+		PastDiaries: recent,
+	}
+}
 
+// renderPrompt は text/template 形式のテンプレートに値を埋め込んでプロンプトを生成する
+func renderPrompt(text string, data PromptData) (string, error) {
+	tmpl, err := template.New("prompt").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("テンプレートの解析に失敗: %w", err)
+	}
 	var builder strings.Builder
-	builder.WriteString(basePrompt)
-	builder.WriteString("\n\n参考までに、過去1ヶ月の観察記録を以下に示します：\n\n")
+	if err := tmpl.Execute(&builder, data); err != nil {
+		return "", fmt.Errorf("テンプレートの適用に失敗: %w", err)
+	}
+	prompt := strings.TrimSpace(builder.String())
+	if prompt == "" {
+		return "", fmt.Errorf("テンプレートから生成したプロンプトが空です")
+	}
+	return prompt, nil
+}
 
-	for _, diary := range diariesToInclude {
-		jstTime := diary.CreatedAt.In(jst)
-		fmt.Fprintf(&builder, "【%s】\n%s\n\n", jstTime.Format("2006年01月02日"), diary.Content)
+// validatePromptTemplate はユーザーが編集したテンプレートが保存できるかどうかを、文字数と見本の値への適用で確認する
+func validatePromptTemplate(text string) error {
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("テンプレートを入力してください")
+	}
+	if n := len([]rune(text)); n > maxPromptTemplateLength {
+		return fmt.Errorf("テンプレートは%d文字以内で入力してください（%d文字）", maxPromptTemplateLength, n)
+	}
+	sample := PromptData{
+		PlantName:   "モンステラ",
+		Date:        "2026年03月10日",
+		Weather:     "晴れ（最高18.0℃／最低8.0℃）",
+		Summaries:   []PromptSummary{{Period: "2026年02月", Content: "新しい葉が2枚増え、茎もしっかりしてきました。"}},
+		PastDiaries: []PromptDiary{{Date: "2026年03月09日", Content: "新しい葉が開きはじめました。"}},
 	}
+	_, err := renderPrompt(text, sample)
+	return err
+}
 
-	builder.WriteString("これまでの観察記録を踏まえて、今回の写真から見られる成長の変化や特徴を記述してください。")
+// buildDiaryPrompt は標準のペルソナで、過去日記を含む動的プロンプトを生成する。
+func buildDiaryPrompt(pastDiaries []Diary) string {
+	prompt, err := renderPrompt(personaTemplate(PersonaStandard), newPromptData("", "", pastDiaries, time.Now()))
+	if err != nil {
+		// 組み込みのテンプレートは常に適用できる
+		log.Printf("ERROR: failed to render standard prompt: %v", err)
+		return basePrompt
+	}
+	return prompt
+}
+
+// DiaryPromptBuilder はユーザー・植物ごとのプロンプトテンプレートの設定に従って、日記生成のプロンプトを組み立てる
+type DiaryPromptBuilder struct {
+	repo       DiaryRepository
+	promptRepo PromptTemplateRepository
+	plantRepo  PlantRepository
+	history    *DiaryHistoryBuilder // nilの場合は渡された過去日記のみを使い、要約は使わない
+	weather    WeatherProvider      // nilの場合は天気をプロンプトに含めない
+}
+
+// NewDiaryPromptBuilder は新しいDiaryPromptBuilderを生成する。historyがnilの場合は過去日記の要約を使わず、weatherがnilの場合は天気を使わない
+func NewDiaryPromptBuilder(repo DiaryRepository, promptRepo PromptTemplateRepository, plantRepo PlantRepository, history *DiaryHistoryBuilder, weather WeatherProvider) *DiaryPromptBuilder {
+	return &DiaryPromptBuilder{repo: repo, promptRepo: promptRepo, plantRepo: plantRepo, history: history, weather: weather}
+}
+
+// Setting は植物、ユーザー全体の順に設定を探し、日記生成に使うプロンプトの設定を返す。
+// いずれの設定もない場合はnilを返す（標準のペルソナを使う）
+func (b *DiaryPromptBuilder) Setting(ctx context.Context, userID, plantID int) (*PromptTemplate, error) {
+	if plantID != 0 {
+		setting, err := b.promptRepo.GetPromptTemplate(ctx, userID, plantID)
+		if err != nil || setting != nil {
+			return setting, err
+		}
+	}
+	return b.promptRepo.GetPromptTemplate(ctx, userID, 0)
+}
+
+// Build は設定したテンプレートに植物の名前・撮影日・天気・過去日記（古い順）を埋め込んで、日記生成のプロンプトを返す。
+// 過去日記は history がある場合はその要約と直近の日記（必要に応じて要約を生成する）、ない場合や失敗した場合は pastDiaries を使う。
+// 設定の取得やテンプレートの適用に失敗した場合は標準のペルソナで生成する。bがnilの場合は標準のペルソナで過去日記のみを使う
+func (b *DiaryPromptBuilder) Build(ctx context.Context, userID, plantID int, pastDiaries []Diary, capturedAt time.Time) string {
+	if b == nil {
+		return buildDiaryPrompt(pastDiaries)
+	}
+
+	text := personaTemplate(PersonaStandard)
+	setting, err := b.Setting(ctx, userID, plantID)
+	if err != nil {
+		log.Printf("WARN: failed to get prompt template of user %d: %v, using standard prompt", userID, err)
+	} else if setting != nil {
+		text = setting.Text()
+	}
+
+	data := b.data(ctx, userID, plantID, pastDiaries, capturedAt, true)
+	prompt, err := renderPrompt(text, data)
+	if err != nil {
+		log.Printf("WARN: failed to render prompt template of user %d: %v, using standard prompt", userID, err)
+		if prompt, err = renderPrompt(personaTemplate(PersonaStandard), data); err != nil {
+			log.Printf("ERROR: failed to render standard prompt: %v", err)
+			return basePrompt
+		}
+	}
+	return prompt
+}
+
+// Preview はテンプレートに、撮影日時をnowとした場合の植物の名前・天気・過去日記を埋め込んだプロンプトを返す。
+// 編集中のテンプレートを保存前に確認するために使う。要約は新たに生成せず、保存済みのものだけを使う
+func (b *DiaryPromptBuilder) Preview(ctx context.Context, userID, plantID int, text string, now time.Time) (string, error) {
+	var pastDiaries []Diary
+	if b.history == nil {
+		startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		var err error
+		pastDiaries, err = b.repo.GetDiariesInDateRange(ctx, OwnerScope{UserID: userID, PlantID: plantID}, startOfDay.AddDate(0, -1, 0), startOfDay.Add(-time.Nanosecond))
+		if err != nil {
+			return "", fmt.Errorf("failed to get past diaries: %w", err)
+		}
+	}
+	return renderPrompt(text, b.data(ctx, userID, plantID, pastDiaries, now, false))
+}
+
+// data はテンプレートに渡す値を組み立てる。植物の名前や天気を取得できない場合は空として続ける。
+// summarizeは history で要約を新たに生成するかどうか
+func (b *DiaryPromptBuilder) data(ctx context.Context, userID, plantID int, pastDiaries []Diary, capturedAt time.Time, summarize bool) PromptData {
+	var plantName string
+	if plantID != 0 {
+		plant, err := b.plantRepo.GetPlantByID(ctx, plantID)
+		if err != nil {
+			log.Printf("WARN: failed to get plant %d for prompt: %v", plantID, err)
+		} else if plant != nil {
+			plantName = plant.Name
+		}
+	}
+
+	var weather string
+	if b.weather != nil {
+		w, err := b.weather.Weather(ctx, capturedAt)
+		if err != nil {
+			log.Printf("WARN: failed to get weather for prompt: %v", err)
+		} else {
+			weather = w
+		}
+	}
+
+	data := newPromptData(plantName, weather, pastDiaries, capturedAt)
+	if b.history != nil {
+		summaries, recent, err := b.history.Build(ctx, userID, plantID, capturedAt, summarize)
+		if err != nil {
+			log.Printf("WARN: failed to build diary history of user %d for prompt: %v, using recent diaries only", userID, err)
+		} else {
+			data.Summaries, data.PastDiaries = summaries, recent
+		}
+	}
+	return data
+}
+
+// selectReferenceImages は過去日記（古い順）から、今回と同じ植物の1日前・1週間前・1ヶ月前の写真を参照画像として新しい順に選ぶ。
+// その日の写真がない場合はそれより前で最も新しい写真を選び、同じ写真は重複して選ばない。画像ファイルが残っていない日記は使わない
+func selectReferenceImages(pastDiaries []Diary, plantID int, capturedAt time.Time) []ReferenceImage {
+	// 撮影日の差はJSTの日付で数える
+	today := jstDate(capturedAt)
+
+	var references []ReferenceImage
+	used := make(map[int]bool)
+	for _, daysAgo := range referenceImageDaysAgo {
+		target := today.AddDate(0, 0, -daysAgo)
+		for i := len(pastDiaries) - 1; i >= 0; i-- {
+			diary := pastDiaries[i]
+			date := jstDate(diary.CreatedAt)
+			if diary.PlantID != plantID || used[diary.ID] || date.After(target) {
+				continue
+			}
+			if _, err := os.Stat(diary.ImagePath); err != nil {
+				continue
+			}
+			used[diary.ID] = true
+			references = append(references, ReferenceImage{
+				Path:       diary.ImagePath,
+				CapturedAt: diary.CreatedAt,
+				DaysAgo:    int(today.Sub(date).Hours() / 24),
+			})
+			break
+		}
+	}
+	return references
+}
+
+// referenceImagesPrompt は参照画像をあわせてモデルに渡す場合に、画像の並び順と見比べ方の指示をプロンプトに追記する。
+// 参照画像がない場合はプロンプトをそのまま返す
+func referenceImagesPrompt(prompt string, references []ReferenceImage) string {
+	if len(references) == 0 {
+		return prompt
+	}
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+
+	var builder strings.Builder
+	builder.WriteString(prompt)
+	builder.WriteString("\n\n添付した1枚目の画像が今回の写真です。2枚目以降は比較のための同じ植物の過去の写真です：\n\n")
+	for i, ref := range references {
+		fmt.Fprintf(&builder, "%d枚目: %s（%d日前）\n", i+2, ref.CapturedAt.In(jst).Format("2006年01月02日"), ref.DaysAgo)
+	}
+	builder.WriteString("\n過去の写真と見比べて、葉の枚数や大きさ、色、つぼみなど、写真から実際に見て取れる変化を記述してください。")
 
 	return builder.String()
 }
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -37,7 +41,7 @@ func TestBuildDiaryPrompt_WithPastDiaries(t *testing.T) {
 	}
 
 	// 過去日記の説明が含まれることを確認
-	if !strings.Contains(prompt, "過去1ヶ月の観察記録") {
+	if !strings.Contains(prompt, "これまでの観察記録を以下に示します") {
 		t.Error("expected prompt to contain past diary description")
 	}
 
@@ -65,35 +69,233 @@ func TestBuildDiaryPrompt_WithPastDiaries(t *testing.T) {
 	}
 }
 
-func TestBuildDiaryPrompt_ExceedsMaxEntries(t *testing.T) {
-	// 40件の過去日記を作成（maxPastDiariesInPromptを超える）
+func TestBuildDiaryPrompt_ExceedsTokenBudget(t *testing.T) {
+	// 1件あたり約120トークン（本文100文字＋見出し）の過去日記を40件作成（defaultPromptContextTokensを超える）
 	pastDiaries := make([]Diary, 40)
 	baseTime := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
 	for i := 0; i < 40; i++ {
+		content := fmt.Sprintf("日記%d番目。", i+1)
 		pastDiaries[i] = Diary{
 			ID:        i + 1,
 			ImagePath: fmt.Sprintf("/path/%d.jpg", i+1),
-			Content:   fmt.Sprintf("日記%d番目", i+1),
+			Content:   content + strings.Repeat("葉", 100-len([]rune(content))),
 			CreatedAt: baseTime.AddDate(0, 0, i),
 		}
 	}
 
 	prompt := buildDiaryPrompt(pastDiaries)
 
-	// 最新の30件のみが含まれることを確認
-	// 最も古い10件（日記1番目〜日記10番目）は含まれないはず
-	if strings.Contains(prompt, "日記1番目") {
-		t.Error("expected oldest entry (日記1番目) to be excluded")
+	// 新しい日記から上限までの16件（日記25番目〜日記40番目）のみが含まれることを確認
+	if strings.Contains(prompt, "日記24番目") {
+		t.Error("expected old entry (日記24番目) to be excluded")
 	}
-	if strings.Contains(prompt, "日記10番目") {
-		t.Error("expected old entry (日記10番目) to be excluded")
-	}
-
-	// 最新の30件（日記11番目〜日記40番目）は含まれるはず
-	if !strings.Contains(prompt, "日記11番目") {
-		t.Error("expected entry (日記11番目) to be included")
+	if !strings.Contains(prompt, "日記25番目") {
+		t.Error("expected entry (日記25番目) to be included")
 	}
 	if !strings.Contains(prompt, "日記40番目") {
 		t.Error("expected latest entry (日記40番目) to be included")
 	}
 }
+
+func TestBuildDiaryPrompt_DedupsSameDay(t *testing.T) {
+	// JSTで同じ日（2026-02-01）に撮影した同じ植物の日記は最新の1件のみ、別の植物の日記は含める
+	pastDiaries := []Diary{
+		{ID: 1, PlantID: 1, Content: "朝の日記", CreatedAt: time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC)},
+		{ID: 2, PlantID: 2, Content: "別の植物の日記", CreatedAt: time.Date(2026, 2, 1, 2, 0, 0, 0, time.UTC)},
+		{ID: 3, PlantID: 1, Content: "夕方の日記", CreatedAt: time.Date(2026, 2, 1, 8, 0, 0, 0, time.UTC)},
+	}
+
+	prompt := buildDiaryPrompt(pastDiaries)
+
+	if strings.Contains(prompt, "朝の日記") {
+		t.Error("expected earlier diary of the same day to be excluded")
+	}
+	if !strings.Contains(prompt, "別の植物の日記") || !strings.Contains(prompt, "夕方の日記") {
+		t.Errorf("expected latest diaries of each plant, got %q", prompt)
+	}
+}
+
+func TestSelectReferenceImages(t *testing.T) {
+	dir := t.TempDir()
+	writeImage := func(name string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("\xff\xd8\xff\xe0dummy"), 0644); err != nil {
+			t.Fatalf("failed to write test image: %v", err)
+		}
+		return path
+	}
+
+	// 撮影日はJSTで 2026-03-10
+	capturedAt := time.Date(2026, 3, 10, 1, 0, 0, 0, time.UTC)
+	pastDiaries := []Diary{
+		{ID: 1, PlantID: 1, ImagePath: writeImage("1.jpg"), CreatedAt: time.Date(2026, 2, 9, 3, 0, 0, 0, time.UTC)},               // 29日前
+		{ID: 2, PlantID: 1, ImagePath: writeImage("2.jpg"), CreatedAt: time.Date(2026, 2, 28, 3, 0, 0, 0, time.UTC)},              // 10日前
+		{ID: 3, PlantID: 1, ImagePath: filepath.Join(dir, "missing.jpg"), CreatedAt: time.Date(2026, 3, 2, 3, 0, 0, 0, time.UTC)}, // 画像なし
+		{ID: 4, PlantID: 2, ImagePath: writeImage("4.jpg"), CreatedAt: time.Date(2026, 3, 5, 3, 0, 0, 0, time.UTC)},               // 別の植物
+		{ID: 5, PlantID: 1, ImagePath: writeImage("5.jpg"), CreatedAt: time.Date(2026, 3, 8, 16, 0, 0, 0, time.UTC)},              // JST: 03-09（1日前）
+	}
+
+	got := selectReferenceImages(pastDiaries, 1, capturedAt)
+
+	// 1日前はその日の写真、1週間前はその日の写真がないためそれより前で最も新しい写真を選ぶ。1ヶ月前（02-08）以前の写真はないため選ばない
+	want := []ReferenceImage{
+		{Path: pastDiaries[4].ImagePath, CapturedAt: pastDiaries[4].CreatedAt, DaysAgo: 1},
+		{Path: pastDiaries[1].ImagePath, CapturedAt: pastDiaries[1].CreatedAt, DaysAgo: 10},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("selectReferenceImages() = %+v, want %+v", got, want)
+	}
+
+	if got := selectReferenceImages(pastDiaries, 3, capturedAt); len(got) != 0 {
+		t.Errorf("expected no references for plant without diaries, got %+v", got)
+	}
+}
+
+func TestReferenceImagesPrompt(t *testing.T) {
+	if got := referenceImagesPrompt(basePrompt, nil); got != basePrompt {
+		t.Errorf("expected prompt unchanged without references, got %q", got)
+	}
+
+	prompt := referenceImagesPrompt(basePrompt, []ReferenceImage{
+		{Path: "/path/1.jpg", CapturedAt: time.Date(2026, 3, 8, 16, 0, 0, 0, time.UTC), DaysAgo: 1},
+		{Path: "/path/2.jpg", CapturedAt: time.Date(2026, 2, 28, 3, 0, 0, 0, time.UTC), DaysAgo: 10},
+	})
+	for _, want := range []string{basePrompt, "2枚目: 2026年03月09日（1日前）", "3枚目: 2026年02月28日（10日前）", "過去の写真と見比べて"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("expected prompt to contain %q, got %q", want, prompt)
+		}
+	}
+}
+
+func TestRenderPrompt_Personas(t *testing.T) {
+	data := newPromptData("モンステラ", "晴れ（最高18.0℃／最低8.0℃）", []Diary{
+		{ID: 1, Content: "新しい葉が開きはじめました。", CreatedAt: time.Date(2026, 3, 8, 16, 0, 0, 0, time.UTC)},
+	}, time.Date(2026, 3, 10, 1, 0, 0, 0, time.UTC))
+
+	for _, persona := range promptPersonas {
+		t.Run(persona.Name, func(t *testing.T) {
+			prompt, err := renderPrompt(personaTemplate(persona.Name), data)
+			if err != nil {
+				t.Fatalf("renderPrompt failed: %v", err)
+			}
+			for _, want := range []string{persona.Instruction, "「モンステラ」", "撮影日（2026年03月10日）の天気は晴れ（最高18.0℃／最低8.0℃）", "【2026年03月09日】\n新しい葉が開きはじめました。"} {
+				if !strings.Contains(prompt, want) {
+					t.Errorf("expected prompt to contain %q, got %q", want, prompt)
+				}
+			}
+		})
+	}
+
+	// 植物の名前・天気・過去日記がない場合は指示のみ
+	prompt, err := renderPrompt(personaTemplate(PersonaHaiku), PromptData{Date: "2026年03月10日"})
+	if err != nil {
+		t.Fatalf("renderPrompt failed: %v", err)
+	}
+	if prompt != promptPersonas[3].Instruction {
+		t.Errorf("expected instruction only, got %q", prompt)
+	}
+}
+
+func TestValidatePromptTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  bool
+	}{
+		{name: "値を埋め込むテンプレート", template: "{{.PlantName}}の{{.Date}}の日記を書いてください。{{range .PastDiaries}}{{.Content}}{{end}}"},
+		{name: "要約を埋め込むテンプレート", template: "{{range .Summaries}}{{.Period}}: {{.Content}}{{end}}"},
+		{name: "空", template: "  \n", wantErr: true},
+		{name: "構文の誤り", template: "{{if .PlantName}}閉じていない", wantErr: true},
+		{name: "存在しない値", template: "{{.Species}}の日記", wantErr: true},
+		{name: "文字数の上限を超える", template: strings.Repeat("あ", maxPromptTemplateLength+1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validatePromptTemplate(tt.template); (err != nil) != tt.wantErr {
+				t.Errorf("validatePromptTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fixedWeatherProvider は固定の天気を返すテスト用のWeatherProvider
+type fixedWeatherProvider struct {
+	weather string
+}
+
+func (p *fixedWeatherProvider) Weather(ctx context.Context, date time.Time) (string, error) {
+	return p.weather, nil
+}
+
+func TestDiaryPromptBuilder_Build(t *testing.T) {
+	db := setupTestDB(t)
+	promptRepo := NewSQLitePromptTemplateRepository(db)
+	plantRepo := NewSQLitePlantRepository(db)
+	if err := plantRepo.CreatePlant(t.Context(), "plant1", 1, "ミニトマト", "", "", time.Time{}); err != nil {
+		t.Fatalf("CreatePlant failed: %v", err)
+	}
+	plant, err := plantRepo.GetPlantByUUID(t.Context(), "plant1")
+	if err != nil || plant == nil {
+		t.Fatalf("GetPlantByUUID failed: %v", err)
+	}
+	builder := NewDiaryPromptBuilder(NewSQLiteDiaryRepository(db), promptRepo, plantRepo, nil, &fixedWeatherProvider{weather: "曇り"})
+	capturedAt := time.Date(2026, 3, 10, 1, 0, 0, 0, time.UTC)
+
+	// 設定がない場合は標準のペルソナ
+	prompt := builder.Build(t.Context(), 1, plant.ID, nil, capturedAt)
+	if !strings.HasPrefix(prompt, basePrompt) || !strings.Contains(prompt, "「ミニトマト」") || !strings.Contains(prompt, "天気は曇り") {
+		t.Errorf("unexpected standard prompt: %q", prompt)
+	}
+
+	// ユーザー全体の設定より植物の設定を優先する
+	if err := promptRepo.SavePromptTemplate(t.Context(), 1, 0, PersonaHaiku, ""); err != nil {
+		t.Fatalf("SavePromptTemplate failed: %v", err)
+	}
+	if err := promptRepo.SavePromptTemplate(t.Context(), 1, plant.ID, PersonaCustom, "{{.PlantName}}（{{.Date}}、{{.Weather}}）"); err != nil {
+		t.Fatalf("SavePromptTemplate failed: %v", err)
+	}
+	if got := builder.Build(t.Context(), 1, plant.ID, nil, capturedAt); got != "ミニトマト（2026年03月10日、曇り）" {
+		t.Errorf("unexpected plant prompt: %q", got)
+	}
+	if got := builder.Build(t.Context(), 1, 0, nil, capturedAt); !strings.HasPrefix(got, promptPersonas[3].Instruction) {
+		t.Errorf("expected user persona for diary without plant, got %q", got)
+	}
+
+	// 保存済みのテンプレートを適用できない場合は標準のペルソナ
+	if err := promptRepo.SavePromptTemplate(t.Context(), 1, plant.ID, PersonaCustom, "{{.Unknown}}"); err != nil {
+		t.Fatalf("SavePromptTemplate failed: %v", err)
+	}
+	if got := builder.Build(t.Context(), 1, plant.ID, nil, capturedAt); !strings.HasPrefix(got, basePrompt) {
+		t.Errorf("expected standard prompt for broken template, got %q", got)
+	}
+
+	// nilの場合は標準のペルソナで過去日記のみを使う
+	var nilBuilder *DiaryPromptBuilder
+	if got := nilBuilder.Build(t.Context(), 1, plant.ID, nil, capturedAt); got != basePrompt {
+		t.Errorf("expected base prompt for nil builder, got %q", got)
+	}
+}
+
+func TestDiaryPromptBuilder_Preview_PastDiariesOfSamePlant(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+	plantRepo := NewSQLitePlantRepository(db)
+	plants := createTestPlants(t, plantRepo, 1, "ミニトマト", "バジル")
+	now := time.Date(2026, 3, 10, 1, 0, 0, 0, time.UTC)
+	if err := repo.CreateDiaryForUser(t.Context(), 1, plants[0], "/photos/tomato.jpg", "トマトの実が赤くなりました。", now.AddDate(0, 0, -1)); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	if err := repo.CreateDiaryForUser(t.Context(), 1, plants[1], "/photos/basil.jpg", "バジルの葉を摘みました。", now.AddDate(0, 0, -1)); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	builder := NewDiaryPromptBuilder(repo, NewSQLitePromptTemplateRepository(db), plantRepo, nil, nil)
+
+	got, err := builder.Preview(t.Context(), 1, plants[1], "{{range .PastDiaries}}{{.Content}}{{end}}", now)
+	if err != nil {
+		t.Fatalf("Preview failed: %v", err)
+	}
+	if got != "バジルの葉を摘みました。" {
+		t.Errorf("expected only basil diary, got %q", got)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	maxProviderResponseBody = 4 << 20 // プロバイダのAPIのレスポンスボディとして読み込む最大バイト数
+	maxProviderErrorBody    = 512     // エラーメッセージに含めるレスポンスボディの最大バイト数
+)
+
+// providerHTTPClient は HTTP API で日記を生成するプロバイダ（OpenAI 互換 API・Ollama）の呼び出しに使う設定を保持する
+type providerHTTPClient struct {
+	name    string        // エラーメッセージに使うプロバイダ名
+	timeout time.Duration // 1回のリクエストのタイムアウト
+	client  *http.Client
+}
+
+// newProviderHTTPClient は providerHTTPClient を生成する。タイムアウトはリクエストごとにctxで設定する
+func newProviderHTTPClient(name string, timeout time.Duration) *providerHTTPClient {
+	return &providerHTTPClient{name: name, timeout: timeout, client: &http.Client{}}
+}
+
+// postJSON はpayloadをJSONにしてurlへPOSTし、レスポンスのHTTPステータス・ヘッダーとボディを返す。
+// ボディは maxProviderResponseBody までしか読み込まない。成功（200）のボディが上限を超える場合はエラーを返し、
+// エラーレスポンスのボディは上限で切り詰めて返す
+func (c *providerHTTPClient) postJSON(ctx context.Context, url string, header http.Header, payload any) (*http.Response, []byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, nil, Permanent(fmt.Errorf("リクエストの作成に失敗: %w", err))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, Permanent(fmt.Errorf("リクエストの作成に失敗: %w", err))
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s の呼び出しに失敗: %w", c.name, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxProviderResponseBody+1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s のレスポンスの読み込みに失敗: %w", c.name, err)
+	}
+	if len(respBody) > maxProviderResponseBody {
+		if resp.StatusCode == http.StatusOK {
+			return nil, nil, fmt.Errorf("%s のレスポンスが大きすぎます（%dバイト超）", c.name, maxProviderResponseBody)
+		}
+		respBody = respBody[:maxProviderResponseBody]
+	}
+	return resp, respBody, nil
+}
+
+// providerErrorBody はエラーメッセージに含めるため、レスポンスボディの先頭部分を返す
+func providerErrorBody(body []byte) string {
+	if len(body) > maxProviderErrorBody {
+		body = body[:maxProviderErrorBody]
+	}
+	return strings.TrimSpace(string(body))
+}
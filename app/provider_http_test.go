@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProviderHTTPClient_PostJSON(t *testing.T) {
+	var gotAuth, gotContentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotContentType = r.Header.Get("Content-Type")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	client := newProviderHTTPClient("テスト", time.Minute)
+	resp, body, err := client.postJSON(t.Context(), srv.URL, http.Header{"Authorization": {"Bearer secret"}}, map[string]string{"model": "llava"})
+	if err != nil {
+		t.Fatalf("postJSON failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != `{"ok":true}` {
+		t.Errorf("unexpected response: %d %q", resp.StatusCode, body)
+	}
+	if gotAuth != "Bearer secret" || gotContentType != "application/json" {
+		t.Errorf("unexpected request headers: Authorization=%q, Content-Type=%q", gotAuth, gotContentType)
+	}
+}
+
+func TestProviderHTTPClient_PostJSON_LargeBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "成功のボディが上限超過", status: http.StatusOK, wantErr: true},
+		{name: "エラーのボディは切り詰める", status: http.StatusBadGateway, wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(strings.Repeat("x", maxProviderResponseBody+1024)))
+			}))
+			defer srv.Close()
+
+			resp, body, err := newProviderHTTPClient("テスト", time.Minute).postJSON(t.Context(), srv.URL, nil, struct{}{})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "大きすぎます") {
+					t.Errorf("expected too large error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("postJSON failed: %v", err)
+			}
+			if resp.StatusCode != tt.status || len(body) != maxProviderResponseBody {
+				t.Errorf("expected HTTP %d with %d bytes, got HTTP %d with %d bytes", tt.status, maxProviderResponseBody, resp.StatusCode, len(body))
+			}
+		})
+	}
+}
+
+func TestProviderHTTPClient_PostJSON_InvalidPayload(t *testing.T) {
+	_, _, err := newProviderHTTPClient("テスト", time.Minute).postJSON(t.Context(), "http://localhost", nil, func() {})
+	var perm *PermanentError
+	if !errors.As(err, &perm) {
+		t.Errorf("expected permanent error, got %v", err)
+	}
+}
+
+func TestProviderErrorBody(t *testing.T) {
+	if got := providerErrorBody([]byte("  bad gateway\n")); got != "bad gateway" {
+		t.Errorf("expected trimmed body, got %q", got)
+	}
+	if got := providerErrorBody([]byte(strings.Repeat("x", maxProviderErrorBody+1))); len(got) != maxProviderErrorBody {
+		t.Errorf("expected body truncated to %d bytes, got %d", maxProviderErrorBody, len(got))
+	}
+}
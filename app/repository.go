@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strings"
@@ -14,29 +15,81 @@ type YearMonth struct {
 	Month int
 }
 
-// Diary は日記エントリを表す構造体
+// OwnerScope は日記・植物を取得する範囲を所有ユーザーで絞り込む条件を表す。
+// UserIDを指定した場合はそのユーザーのもの、PublicOnlyの場合は日記を公開しているユーザーのものに限る。
+// PlantIDを指定した場合は、日記をさらにその植物のものに限る（日記の取得でのみ使う）。
+// ゼロ値は絞り込みなし（Workerなど内部処理用）
+type OwnerScope struct {
+	UserID     int
+	PublicOnly bool
+	PlantID    int
+}
+
+// Diary は日記エントリを表す構造体。UserIDは所有ユーザーが未設定の場合0、PlantIDは植物未指定の場合0
 type Diary struct {
 	ID        int
+	UserID    int
+	PlantID   int
 	ImagePath string
 	Content   string
 	CreatedAt time.Time
+	DeletedAt time.Time // ゴミ箱へ移動した日時（ゴミ箱にない場合はゼロ値）
+	Provider  string    // 本文を生成したプロバイダ名（不明な場合は空）
+}
+
+// DiaryObservation は日記の生成時に写真から推定した植物の状態。推定値が不明な項目はゼロ値
+type DiaryObservation struct {
+	DiaryID     int
+	HealthScore int      // 健康状態（1〜5）
+	LeafCount   int      // 葉の枚数
+	Flowering   bool     // 花が咲いているか
+	Fruiting    bool     // 実がなっているか
+	Issues      []string // 検出した問題（IssueYellowing などの種類）
+	Tags        []string // 写真の特徴を表すタグ
+}
+
+// Plant は観察対象の植物を表す構造体
+type Plant struct {
+	ID             int
+	UUID           string
+	UserID         int
+	Name           string
+	Species        string
+	Location       string
+	AcquiredOn     time.Time // 入手日（不明の場合はゼロ値）
+	CoverDiaryID   int       // 表紙に指定した日記のID（未指定の場合0）
+	CoverImagePath string    // 表紙の写真。未指定の場合は最新の日記の写真、日記がなければ空
+	CreatedAt      time.Time
 }
 
-// User はユーザーを表す構造体
+// PlantRepository は植物データへのアクセスを定義するインターフェース
+type PlantRepository interface {
+	CreatePlant(ctx context.Context, uuid string, userID int, name, species, location string, acquiredOn time.Time) error
+	GetPlantByID(ctx context.Context, id int) (*Plant, error)
+	GetPlantByUUID(ctx context.Context, uuid string) (*Plant, error)
+	GetAllPlants(ctx context.Context, scope OwnerScope) ([]Plant, error)
+	SetPlantCover(ctx context.Context, id, diaryID int) error
+}
+
+// User はユーザーを表す構造体。DiariesPublicがfalseの場合、日記は本人にのみ表示される
 type User struct {
-	ID           int
-	UUID         string
-	Username     string
-	PasswordHash string
-	CreatedAt    time.Time
+	ID            int
+	UUID          string
+	Username      string
+	PasswordHash  string
+	DiariesPublic bool
+	CreatedAt     time.Time
 }
 
 // UserRepository はユーザーデータへのアクセスを定義するインターフェース
 type UserRepository interface {
-	CreateUser(uuid, username, passwordHash string) error
-	GetUserByUsername(username string) (*User, error)
-	GetUserByID(id int) (*User, error)
-	GetUserByUUID(uuid string) (*User, error)
+	CreateUser(ctx context.Context, uuid, username, passwordHash string) error
+	GetUserByUsername(ctx context.Context, username string) (*User, error)
+	GetUserByID(ctx context.Context, id int) (*User, error)
+	GetUserByUUID(ctx context.Context, uuid string) (*User, error)
+	GetAllUsers(ctx context.Context) ([]User, error)
+	SetDiariesPublic(ctx context.Context, id int, public bool) error
+	HasLoginUser(ctx context.Context) (bool, error)
 }
 
 // Session はセッションを表す構造体
@@ -49,49 +102,335 @@ type Session struct {
 
 // SessionRepository はセッションデータへのアクセスを定義するインターフェース
 type SessionRepository interface {
-	CreateSession(id string, userID int, expiresAt time.Time) error
-	GetSessionByID(id string) (*Session, error)
-	DeleteSession(id string) error
+	CreateSession(ctx context.Context, id string, userID int, expiresAt time.Time) error
+	GetSessionByID(ctx context.Context, id string) (*Session, error)
+	DeleteSession(ctx context.Context, id string) error
+}
+
+// APITokenScope はAPIトークンで許可する操作の範囲を表す
+type APITokenScope string
+
+const (
+	APITokenScopeUpload APITokenScope = "upload" // 写真のアップロードとジョブ状態の取得
+	APITokenScopeRead   APITokenScope = "read"   // 日記・ジョブの参照のみ
+	APITokenScopeWrite  APITokenScope = "write"  // 日記の参照と、所有ユーザーの日記の更新・再生成・削除
+	APITokenScopeAdmin  APITokenScope = "admin"  // ユーザー・植物の作成を含む全ての操作
+)
+
+// APIToken はユーザーごとの個人用APIトークンを表す構造体。平文のトークンは保持せず、ハッシュ値のみを持つ
+type APIToken struct {
+	ID         int
+	UserID     int
+	Name       string
+	TokenHash  string
+	Prefix     string // 一覧表示用のトークン先頭部分
+	Scope      APITokenScope
+	LastUsedAt time.Time // 最終使用日時（未使用の場合はゼロ値）
+	CreatedAt  time.Time
+}
+
+// APITokenRepository はAPIトークンへのアクセスを定義するインターフェース。失効済みのトークンは取得対象外
+type APITokenRepository interface {
+	CreateAPIToken(ctx context.Context, userID int, name, tokenHash, prefix string, scope APITokenScope) error
+	GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error)
+	GetAPITokensByUserID(ctx context.Context, userID int) ([]APIToken, error)
+	RevokeAPIToken(ctx context.Context, id, userID int) error
+	TouchAPIToken(ctx context.Context, id int, usedAt time.Time) error
+}
+
+// PromptTemplate はユーザー全体または植物ごとの日記生成プロンプトの設定
+type PromptTemplate struct {
+	UserID    int
+	PlantID   int    // 設定した植物（0の場合はユーザー全体の設定）
+	Persona   string // 組み込みのペルソナ（PersonaCustom の場合は Template を使う）
+	Template  string // text/template 形式のユーザーが編集したテンプレート
+	UpdatedAt time.Time
+}
+
+// Text は設定で使うテンプレートを返す
+func (t PromptTemplate) Text() string {
+	if t.Persona == PersonaCustom {
+		return t.Template
+	}
+	return personaTemplate(t.Persona)
+}
+
+// PromptTemplateRepository はプロンプトテンプレートの設定へのアクセスを定義するインターフェース。plantIDが0の設定はユーザー全体の設定
+type PromptTemplateRepository interface {
+	GetPromptTemplate(ctx context.Context, userID, plantID int) (*PromptTemplate, error)
+	GetPromptTemplatesByUserID(ctx context.Context, userID int) ([]PromptTemplate, error)
+	SavePromptTemplate(ctx context.Context, userID, plantID int, persona, template string) error
+	DeletePromptTemplate(ctx context.Context, userID, plantID int) error
+}
+
+// DiarySummary は過去日記を週・月ごとにまとめた要約。プロンプトに含める過去日記のうち古い期間の代わりに使う
+type DiarySummary struct {
+	UserID     int
+	PlantID    int    // 要約した日記の植物（0の場合はユーザーの全ての日記）
+	Period     string // SummaryPeriodWeek / SummaryPeriodMonth
+	StartDate  string // 期間の初日（JST、YYYY-MM-DD）
+	SourceHash string // 要約した日記のハッシュ。日記が追加・編集・削除された場合は一致しなくなる
+	Content    string
+	CreatedAt  time.Time
+}
+
+// DiarySummaryRepository は過去日記の要約へのアクセスを定義するインターフェース
+type DiarySummaryRepository interface {
+	GetDiarySummaries(ctx context.Context, userID, plantID int, since string) ([]DiarySummary, error)
+	SaveDiarySummary(ctx context.Context, userID, plantID int, period, startDate, sourceHash, content string) error
+}
+
+// PhotoMetadata はアップロードされた写真のEXIFから読み込んだ撮影情報。値がない項目はゼロ値（nil）
+type PhotoMetadata struct {
+	ImagePath    string
+	CapturedAt   *time.Time // 撮影日時（DateTimeOriginal）
+	Orientation  int        // EXIFの向き（1〜8）。保存した写真は回転済み
+	CameraMake   string
+	CameraModel  string
+	ExposureTime string  // 露出時間（秒、「1/125」形式）
+	FNumber      float64 // F値
+	ISO          int
+	FocalLength  float64  // 焦点距離（mm）
+	Latitude     *float64 // 撮影位置の緯度（所有ユーザーにのみ表示する）
+	Longitude    *float64
+}
+
+// Coordinates は撮影位置を「緯度, 経度」の文字列で返す。撮影位置がない場合は空を返す
+func (m *PhotoMetadata) Coordinates() string {
+	if m.Latitude == nil || m.Longitude == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.5f, %.5f", *m.Latitude, *m.Longitude)
+}
+
+// PhotoMetadataRepository は写真の撮影情報へのアクセスを定義するインターフェース
+type PhotoMetadataRepository interface {
+	SavePhotoMetadata(ctx context.Context, meta PhotoMetadata) error
+	GetPhotoMetadata(ctx context.Context, imagePath string) (*PhotoMetadata, error)
+}
+
+// PhotoHash は写真の知覚ハッシュ。重複・似ている写真の検出に使う
+type PhotoHash struct {
+	ImagePath   string
+	UserID      int
+	PlantID     int // 植物未指定の場合0
+	Hash        uint64
+	CapturedAt  time.Time
+	DuplicateOf string // 重複として統合した写真の場合、統合先の写真のパス（日記は作らない）
+	DiaryID     int    // 写真の日記のID（取得時のみ。日記が未作成の場合0）
+}
+
+// SimilarPhoto は似ている写真と、その知覚ハッシュの距離
+type SimilarPhoto struct {
+	PhotoHash
+	Distance int
+}
+
+// PhotoHashRepository は写真の知覚ハッシュへのアクセスを定義するインターフェース
+type PhotoHashRepository interface {
+	SavePhotoHash(ctx context.Context, hash PhotoHash) error
+	GetPhotoHash(ctx context.Context, imagePath string) (*PhotoHash, error)
+	FindPhotoHashes(ctx context.Context, userID, plantID int, from, to time.Time) ([]PhotoHash, error)
+}
+
+// UploadIdempotencyKey は写真アップロードAPIの Idempotency-Key ヘッダーの値と、そのキーで登録したジョブ、
+// または重複する写真としてスキップ・統合した結果
+type UploadIdempotencyKey struct {
+	UserID      int
+	Key         string
+	Fingerprint string // リクエストの内容のハッシュ。同じキーで内容の異なるリクエストを区別する
+	JobID       string // 登録したジョブのID
+	Action      string // 重複する写真の処理（skipped / merged。ジョブを登録した場合は空）
+	DuplicateOf string // 重複する写真のパス（Action が空の場合は空）
+	Distance    int    // 重複する写真との知覚ハッシュの距離
+	ImagePath   string // 統合して保存した写真のパス（merged の場合のみ）
+	CreatedAt   time.Time
+}
+
+// Pending は最初のリクエストを処理中（ジョブも重複する写真の処理も記録されていない）かどうかを返す
+func (k *UploadIdempotencyKey) Pending() bool {
+	return k.JobID == "" && k.Action == ""
+}
+
+// UploadIdempotencyKeyRepository は写真アップロードAPIの Idempotency-Key へのアクセスを定義するインターフェース
+type UploadIdempotencyKeyRepository interface {
+	ReserveUploadIdempotencyKey(ctx context.Context, userID int, key, fingerprint string, expiredBefore time.Time) (*UploadIdempotencyKey, error)
+	CompleteUploadIdempotencyKey(ctx context.Context, userID int, key, jobID string) error
+	CompleteUploadIdempotencyKeyDuplicate(ctx context.Context, userID int, key, action, duplicateOf string, distance int, imagePath string) error
+	DeleteUploadIdempotencyKey(ctx context.Context, userID int, key string) error
+}
+
+// UsageRecord は1回の日記の生成（再生成を含む）の使用量の記録
+type UsageRecord struct {
+	ID             int
+	UserID         int
+	DiaryID        int // 生成した日記（過去日記の要約の生成の場合は0）
+	Provider       string
+	Model          string // 生成に使ったモデル（モデルが使用量を報告しない場合は空）
+	PromptTokens   int
+	ResponseTokens int
+	Cost           float64       // 記録時の料金設定で計算した料金（USD）
+	Latency        time.Duration // 日記を生成したプロバイダの呼び出しにかかった時間（リトライの待ち時間や失敗した試行を含まない）
+	CreatedAt      time.Time
 }
 
-// DiaryRepository は日記データへのアクセスを定義するインターフェース
+// UsageBudget は日記の生成の料金（USD）の上限。上限が0の場合は上限なし
+type UsageBudget struct {
+	UserID       int // 0の場合は全ユーザーの既定の上限
+	DailyLimit   float64
+	MonthlyLimit float64
+	UpdatedAt    time.Time
+}
+
+// UsageRepository は日記の生成の使用量と予算へのアクセスを定義するインターフェース。userIDが0の予算は全ユーザーの既定の上限
+type UsageRepository interface {
+	CreateUsageRecord(ctx context.Context, record UsageRecord) error
+	GetUsageRecords(ctx context.Context, scope OwnerScope, from, to time.Time) ([]UsageRecord, error)
+	GetUsageCost(ctx context.Context, userID int, from, to time.Time) (float64, error)
+	GetUsageBudget(ctx context.Context, userID int) (*UsageBudget, error)
+	GetUsageBudgets(ctx context.Context) ([]UsageBudget, error)
+	SaveUsageBudget(ctx context.Context, userID int, dailyLimit, monthlyLimit float64) error
+	DeleteUsageBudget(ctx context.Context, userID int) error
+}
+
+// JobStatus は日記生成ジョブの状態を表す
+type JobStatus string
+
+const (
+	JobStatusQueued    JobStatus = "queued"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusSucceeded JobStatus = "succeeded"
+	JobStatusFailed    JobStatus = "failed"
+	JobStatusPaused    JobStatus = "paused" // 使用料金が予算の上限に達したため生成を待っている
+)
+
+// Job は日記生成ジョブを表す構造体
+type Job struct {
+	ID         string
+	UserID     int
+	PlantID    int
+	ImagePath  string
+	CapturedAt time.Time
+	Status     JobStatus
+	Attempts   int
+	LastError  string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// JobRepository は日記生成ジョブへのアクセスを定義するインターフェース
+type JobRepository interface {
+	CreateJob(ctx context.Context, id string, userID, plantID int, imagePath string, capturedAt time.Time) error
+	GetJobByID(ctx context.Context, id string) (*Job, error)
+	GetQueuedJobs(ctx context.Context) ([]Job, error)
+	HasJobForImage(ctx context.Context, imagePath string) (bool, error)
+	MarkJobRunning(ctx context.Context, id string) error
+	MarkJobSucceeded(ctx context.Context, id string, attempts int) error
+	MarkJobFailed(ctx context.Context, id string, attempts int, lastError string) error
+	RequeueRunningJobs(ctx context.Context) (int, error)
+	GetPausedJobs(ctx context.Context) ([]Job, error)
+	MarkJobPaused(ctx context.Context, id string, lastError string) error
+	ResumePausedJob(ctx context.Context, id string) error
+	DeleteJob(ctx context.Context, id string) error
+}
+
+// RevisionSource は日記本文の版がどのように作られたかを表す
+type RevisionSource string
+
+const (
+	RevisionSourceGeneration   RevisionSource = "generation"   // 日記作成時のAI生成
+	RevisionSourceOriginal     RevisionSource = "original"     // 履歴記録開始前に編集済みだった本文
+	RevisionSourceManualEdit   RevisionSource = "manual_edit"  // ユーザーによる手動編集
+	RevisionSourceRegeneration RevisionSource = "regeneration" // AIによる再生成
+	RevisionSourceRestore      RevisionSource = "restore"      // 過去の版への復元
+)
+
+// DiaryQuery は日記一覧APIの絞り込み・ページング条件を表す。
+// Keywordが空の場合、From/Toがゼロ値の場合はその条件を無視する。Limitが0の場合は件数を制限しない
+type DiaryQuery struct {
+	Keyword string
+	From    time.Time
+	To      time.Time
+	Limit   int
+	Offset  int
+}
+
+// DiaryRevision は日記本文の版を表す構造体。UserIDはWorkerによる変更の場合0
+type DiaryRevision struct {
+	ID        int
+	DiaryID   int
+	Content   string
+	Source    RevisionSource
+	UserID    int
+	CreatedAt time.Time
+}
+
+// DiaryRevisionRepository は日記本文の版履歴へのアクセスを定義するインターフェース
+type DiaryRevisionRepository interface {
+	ReplaceDiaryContent(ctx context.Context, diaryID int, content string, source RevisionSource, userID int) (int, error)
+	GetRevisionByID(ctx context.Context, id int) (*DiaryRevision, error)
+	GetRevisionsByDiaryID(ctx context.Context, diaryID int) ([]DiaryRevision, error)
+}
+
+// DiaryRepository は日記データへのアクセスを定義するインターフェース。
+// ゴミ箱の日記はGetDeletedDiaries以外の取得対象外（IsImageProcessedでは処理済みとして扱う）
 type DiaryRepository interface {
-	GetAllDiaries() ([]Diary, error)
-	GetDiaryByID(id int) (*Diary, error)
-	CreateDiary(imagePath, content string, createdAt time.Time) error
-	CreateDiaryForUser(userID int, imagePath, content string, createdAt time.Time) error
-	UpdateDiaryContent(id int, content string) error
-	IsImageProcessed(imagePath string) (bool, error)
-	GetLatestDiaryCreatedAt() (time.Time, error)
-	GetDiariesInDateRange(startDate, endDate time.Time) ([]Diary, error)
-	GetAvailableYearMonths() ([]YearMonth, error)
-	SearchDiaries(keyword string) ([]Diary, error)
-	GetDiariesAsc(from, to time.Time) ([]Diary, error)
-}
-
-// MockDiaryRepository はメモリ上でデータを保持するモック実装
+	GetAllDiaries(ctx context.Context, scope OwnerScope) ([]Diary, error)
+	GetDiaryByID(ctx context.Context, id int) (*Diary, error)
+	CreateDiary(ctx context.Context, imagePath, content string, createdAt time.Time) error
+	CreateDiaryForUser(ctx context.Context, userID, plantID int, imagePath, content string, createdAt time.Time) error
+	CreateGeneratedDiary(ctx context.Context, userID, plantID int, imagePath, content, provider string, observation *DiaryObservation, createdAt time.Time) (int, error)
+	UpdateDiaryContent(ctx context.Context, id int, content string) error
+	UpdateDiaryGeneration(ctx context.Context, id int, provider string, observation *DiaryObservation) error
+	GetDiaryObservations(ctx context.Context, diaryIDs []int) (map[int]DiaryObservation, error)
+	IsImageProcessed(ctx context.Context, imagePath string) (bool, error)
+	GetLatestDiaryCreatedAt(ctx context.Context) (time.Time, error)
+	GetDiariesInDateRange(ctx context.Context, scope OwnerScope, startDate, endDate time.Time) ([]Diary, error)
+	GetAvailableYearMonths(ctx context.Context, scope OwnerScope) ([]YearMonth, error)
+	SearchDiaries(ctx context.Context, scope OwnerScope, keyword string) ([]Diary, error)
+	GetDiariesAsc(ctx context.Context, scope OwnerScope, from, to time.Time) ([]Diary, error)
+	GetDiariesByPlantID(ctx context.Context, plantID int) ([]Diary, error)
+	QueryDiaries(ctx context.Context, scope OwnerScope, q DiaryQuery) ([]Diary, int, error)
+	DeleteDiary(ctx context.Context, id int) error
+	GetDeletedDiaries(ctx context.Context, userID int) ([]Diary, error)
+	RestoreDiary(ctx context.Context, id int) error
+	PurgeDiary(ctx context.Context, id int) ([]string, error)
+}
+
+// MockDiaryRepository はメモリ上でデータを保持するモック実装。
+// ユーザー情報を持たないため、OwnerScopeのPublicOnlyは全ユーザーを公開として扱う
 type MockDiaryRepository struct {
-	mu      sync.RWMutex
-	diaries map[int]*Diary
-	nextID  int
+	mu           sync.RWMutex
+	diaries      map[int]*Diary
+	observations map[int]DiaryObservation
+	nextID       int
 }
 
 // NewMockDiaryRepository は新しいMockDiaryRepositoryを生成する
 func NewMockDiaryRepository() *MockDiaryRepository {
 	return &MockDiaryRepository{
-		diaries: make(map[int]*Diary),
-		nextID:  1,
+		diaries:      make(map[int]*Diary),
+		observations: make(map[int]DiaryObservation),
+		nextID:       1,
 	}
 }
 
-// GetAllDiaries は全ての日記を新着順で返す
-func (r *MockDiaryRepository) GetAllDiaries() ([]Diary, error) {
+// inScope は日記が取得範囲に含まれるかどうかを返す。ゴミ箱の日記は含まない
+func (r *MockDiaryRepository) inScope(d *Diary, scope OwnerScope) bool {
+	return d.DeletedAt.IsZero() && (scope.UserID == 0 || d.UserID == scope.UserID) &&
+		(scope.PlantID == 0 || d.PlantID == scope.PlantID)
+}
+
+// GetAllDiaries は範囲内の全ての日記を新着順で返す
+func (r *MockDiaryRepository) GetAllDiaries(ctx context.Context, scope OwnerScope) ([]Diary, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	result := make([]Diary, 0, len(r.diaries))
 	for _, d := range r.diaries {
-		result = append(result, *d)
+		if r.inScope(d, scope) {
+			result = append(result, *d)
+		}
 	}
 
 	// 新着順（CreatedAt降順）でソート
@@ -106,13 +445,13 @@ func (r *MockDiaryRepository) GetAllDiaries() ([]Diary, error) {
 	return result, nil
 }
 
-// GetDiaryByID は指定IDの日記を返す。見つからない場合はnilを返す
-func (r *MockDiaryRepository) GetDiaryByID(id int) (*Diary, error) {
+// GetDiaryByID は指定IDの日記を返す。見つからない場合やゴミ箱の日記の場合はnilを返す
+func (r *MockDiaryRepository) GetDiaryByID(ctx context.Context, id int) (*Diary, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	d, ok := r.diaries[id]
-	if !ok {
+	if !ok || !d.DeletedAt.IsZero() {
 		return nil, nil
 	}
 
@@ -121,7 +460,7 @@ func (r *MockDiaryRepository) GetDiaryByID(id int) (*Diary, error) {
 }
 
 // UpdateDiaryContent は指定IDの日記のcontentを更新する。見つからない場合はエラーを返す
-func (r *MockDiaryRepository) UpdateDiaryContent(id int, content string) error {
+func (r *MockDiaryRepository) UpdateDiaryContent(ctx context.Context, id int, content string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -133,13 +472,76 @@ func (r *MockDiaryRepository) UpdateDiaryContent(id int, content string) error {
 	return nil
 }
 
-// CreateDiaryForUser は指定ユーザーの新しい日記エントリを作成する（モックではuserIDを無視）
-func (r *MockDiaryRepository) CreateDiaryForUser(userID int, imagePath, content string, createdAt time.Time) error {
-	return r.CreateDiary(imagePath, content, createdAt)
+// UpdateDiaryGeneration は指定IDの日記の本文を生成したプロバイダ名と植物の状態を更新する。
+// observationがnilの場合は植物の状態を削除する。見つからない場合はエラーを返す
+func (r *MockDiaryRepository) UpdateDiaryGeneration(ctx context.Context, id int, provider string, observation *DiaryObservation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.diaries[id]
+	if !ok {
+		return fmt.Errorf("diary %d not found", id)
+	}
+	d.Provider = provider
+	r.setObservation(id, observation)
+	return nil
+}
+
+// setObservation は指定IDの日記の植物の状態を置き換える。observationがnilの場合は削除する
+func (r *MockDiaryRepository) setObservation(diaryID int, observation *DiaryObservation) {
+	if observation == nil {
+		delete(r.observations, diaryID)
+		return
+	}
+	o := *observation
+	o.DiaryID = diaryID
+	r.observations[diaryID] = o
+}
+
+// GetDiaryObservations は指定IDの日記の植物の状態を日記IDをキーにして返す。植物の状態がない日記は含まない
+func (r *MockDiaryRepository) GetDiaryObservations(ctx context.Context, diaryIDs []int) (map[int]DiaryObservation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make(map[int]DiaryObservation)
+	for _, id := range diaryIDs {
+		if o, ok := r.observations[id]; ok {
+			result[id] = o
+		}
+	}
+	return result, nil
+}
+
+// CreateDiaryForUser は指定ユーザー・植物の新しい日記エントリを作成する
+func (r *MockDiaryRepository) CreateDiaryForUser(ctx context.Context, userID, plantID int, imagePath, content string, createdAt time.Time) error {
+	_, err := r.CreateGeneratedDiary(ctx, userID, plantID, imagePath, content, "", nil, createdAt)
+	return err
+}
+
+// CreateGeneratedDiary は本文を生成したプロバイダ名と写真から推定した植物の状態（nilの場合は記録しない）を記録して、
+// 指定ユーザー・植物の新しい日記エントリを作成し、作成した日記のIDを返す
+func (r *MockDiaryRepository) CreateGeneratedDiary(ctx context.Context, userID, plantID int, imagePath, content, provider string, observation *DiaryObservation, createdAt time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := r.nextID
+	r.diaries[r.nextID] = &Diary{
+		ID:        r.nextID,
+		UserID:    userID,
+		PlantID:   plantID,
+		ImagePath: imagePath,
+		Content:   content,
+		CreatedAt: createdAt,
+		Provider:  provider,
+	}
+	r.setObservation(r.nextID, observation)
+	r.nextID++
+
+	return id, nil
 }
 
 // CreateDiary は新しい日記エントリを作成する
-func (r *MockDiaryRepository) CreateDiary(imagePath, content string, createdAt time.Time) error {
+func (r *MockDiaryRepository) CreateDiary(ctx context.Context, imagePath, content string, createdAt time.Time) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -155,8 +557,8 @@ func (r *MockDiaryRepository) CreateDiary(imagePath, content string, createdAt t
 	return nil
 }
 
-// IsImageProcessed は指定画像パスが既に処理済みかどうかを返す
-func (r *MockDiaryRepository) IsImageProcessed(imagePath string) (bool, error) {
+// IsImageProcessed は指定画像パスが既に処理済みかどうかを返す。ゴミ箱の日記の写真も処理済みとして扱う
+func (r *MockDiaryRepository) IsImageProcessed(ctx context.Context, imagePath string) (bool, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -170,17 +572,13 @@ func (r *MockDiaryRepository) IsImageProcessed(imagePath string) (bool, error) {
 }
 
 // GetLatestDiaryCreatedAt は最新の日記の作成日時を返す。日記が存在しない場合はゼロ値を返す
-func (r *MockDiaryRepository) GetLatestDiaryCreatedAt() (time.Time, error) {
+func (r *MockDiaryRepository) GetLatestDiaryCreatedAt(ctx context.Context) (time.Time, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	if len(r.diaries) == 0 {
-		return time.Time{}, nil
-	}
-
 	var latest time.Time
 	for _, d := range r.diaries {
-		if d.CreatedAt.After(latest) {
+		if d.DeletedAt.IsZero() && d.CreatedAt.After(latest) {
 			latest = d.CreatedAt
 		}
 	}
@@ -188,8 +586,8 @@ func (r *MockDiaryRepository) GetLatestDiaryCreatedAt() (time.Time, error) {
 	return latest, nil
 }
 
-// GetAvailableYearMonths は日記が存在する年月一覧をJST基準で新しい順に返す
-func (r *MockDiaryRepository) GetAvailableYearMonths() ([]YearMonth, error) {
+// GetAvailableYearMonths は範囲内の日記が存在する年月一覧をJST基準で新しい順に返す
+func (r *MockDiaryRepository) GetAvailableYearMonths(ctx context.Context, scope OwnerScope) ([]YearMonth, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -197,6 +595,9 @@ func (r *MockDiaryRepository) GetAvailableYearMonths() ([]YearMonth, error) {
 	seen := make(map[string]bool)
 	var result []YearMonth
 	for _, d := range r.diaries {
+		if !r.inScope(d, scope) {
+			continue
+		}
 		t := d.CreatedAt.In(jst)
 		key := fmt.Sprintf("%d-%02d", t.Year(), int(t.Month()))
 		if !seen[key] {
@@ -215,14 +616,14 @@ func (r *MockDiaryRepository) GetAvailableYearMonths() ([]YearMonth, error) {
 	return result, nil
 }
 
-// SearchDiaries はキーワードを含む日記を新着順で返す
-func (r *MockDiaryRepository) SearchDiaries(keyword string) ([]Diary, error) {
+// SearchDiaries は範囲内でキーワードを含む日記を新着順で返す
+func (r *MockDiaryRepository) SearchDiaries(ctx context.Context, scope OwnerScope, keyword string) ([]Diary, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	result := make([]Diary, 0)
 	for _, d := range r.diaries {
-		if strings.Contains(d.Content, keyword) {
+		if r.inScope(d, scope) && strings.Contains(d.Content, keyword) {
 			result = append(result, *d)
 		}
 	}
@@ -234,13 +635,16 @@ func (r *MockDiaryRepository) SearchDiaries(keyword string) ([]Diary, error) {
 	return result, nil
 }
 
-// GetDiariesAsc は全日記または指定期間の日記を古い順で返す。from/toがゼロ値の場合は全件取得
-func (r *MockDiaryRepository) GetDiariesAsc(from, to time.Time) ([]Diary, error) {
+// GetDiariesAsc は範囲内の全日記または指定期間の日記を古い順で返す。from/toがゼロ値の場合は全件取得
+func (r *MockDiaryRepository) GetDiariesAsc(ctx context.Context, scope OwnerScope, from, to time.Time) ([]Diary, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	result := make([]Diary, 0)
 	for _, d := range r.diaries {
+		if !r.inScope(d, scope) {
+			continue
+		}
 		if from.IsZero() || (d.CreatedAt.Equal(from) || d.CreatedAt.After(from)) {
 			if to.IsZero() || (d.CreatedAt.Equal(to) || d.CreatedAt.Before(to)) {
 				result = append(result, *d)
@@ -255,14 +659,131 @@ func (r *MockDiaryRepository) GetDiariesAsc(from, to time.Time) ([]Diary, error)
 	return result, nil
 }
 
-// GetDiariesInDateRange は指定日付範囲内の日記を古い順で返す
-func (r *MockDiaryRepository) GetDiariesInDateRange(startDate, endDate time.Time) ([]Diary, error) {
+// GetDiariesByPlantID は指定植物の日記を新着順で返す
+func (r *MockDiaryRepository) GetDiariesByPlantID(ctx context.Context, plantID int) ([]Diary, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]Diary, 0)
+	for _, d := range r.diaries {
+		if d.PlantID == plantID && d.DeletedAt.IsZero() {
+			result = append(result, *d)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+
+	return result, nil
+}
+
+// QueryDiaries は範囲内で条件に一致する日記を新着順で返す。2つ目の戻り値はページング前の件数
+func (r *MockDiaryRepository) QueryDiaries(ctx context.Context, scope OwnerScope, q DiaryQuery) ([]Diary, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	kw := strings.ToLower(q.Keyword)
+	result := make([]Diary, 0)
+	for _, d := range r.diaries {
+		if !r.inScope(d, scope) {
+			continue
+		}
+		if kw != "" && !strings.Contains(strings.ToLower(d.Content), kw) {
+			continue
+		}
+		if !q.From.IsZero() && d.CreatedAt.Before(q.From) {
+			continue
+		}
+		if !q.To.IsZero() && d.CreatedAt.After(q.To) {
+			continue
+		}
+		result = append(result, *d)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+
+	total := len(result)
+	if q.Offset >= total {
+		return []Diary{}, total, nil
+	}
+	result = result[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(result) {
+		result = result[:q.Limit]
+	}
+	return result, total, nil
+}
+
+// DeleteDiary は指定IDの日記をゴミ箱へ移動する。見つからない場合や既にゴミ箱にある場合はエラーを返す
+func (r *MockDiaryRepository) DeleteDiary(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.diaries[id]
+	if !ok || !d.DeletedAt.IsZero() {
+		return fmt.Errorf("diary %d not found", id)
+	}
+	d.DeletedAt = time.Now().UTC()
+	return nil
+}
+
+// GetDeletedDiaries は指定ユーザーのゴミ箱の日記を削除日時の新しい順で返す
+func (r *MockDiaryRepository) GetDeletedDiaries(ctx context.Context, userID int) ([]Diary, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]Diary, 0)
+	for _, d := range r.diaries {
+		if d.UserID == userID && !d.DeletedAt.IsZero() {
+			result = append(result, *d)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DeletedAt.After(result[j].DeletedAt)
+	})
+
+	return result, nil
+}
+
+// RestoreDiary は指定IDの日記をゴミ箱から元に戻す。ゴミ箱にない場合はエラーを返す
+func (r *MockDiaryRepository) RestoreDiary(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.diaries[id]
+	if !ok || d.DeletedAt.IsZero() {
+		return fmt.Errorf("deleted diary %d not found", id)
+	}
+	d.DeletedAt = time.Time{}
+	return nil
+}
+
+// PurgeDiary はゴミ箱にある指定IDの日記を完全に削除する。ゴミ箱にない場合はエラーを返す。
+// モックは写真の知覚ハッシュを持たないため、統合した写真のパスは常に空
+func (r *MockDiaryRepository) PurgeDiary(ctx context.Context, id int) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.diaries[id]
+	if !ok || d.DeletedAt.IsZero() {
+		return nil, fmt.Errorf("deleted diary %d not found", id)
+	}
+	delete(r.diaries, id)
+	delete(r.observations, id)
+	return nil, nil
+}
+
+// GetDiariesInDateRange は範囲内で指定日付範囲内の日記を古い順で返す
+func (r *MockDiaryRepository) GetDiariesInDateRange(ctx context.Context, scope OwnerScope, startDate, endDate time.Time) ([]Diary, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	result := make([]Diary, 0)
 	for _, d := range r.diaries {
-		if (d.CreatedAt.Equal(startDate) || d.CreatedAt.After(startDate)) &&
+		if r.inScope(d, scope) && (d.CreatedAt.Equal(startDate) || d.CreatedAt.After(startDate)) &&
 			(d.CreatedAt.Equal(endDate) || d.CreatedAt.Before(endDate)) {
 			result = append(result, *d)
 		}
@@ -8,12 +8,12 @@ import (
 func TestMockDiaryRepository_CreateDiary(t *testing.T) {
 	repo := NewMockDiaryRepository()
 
-	err := repo.CreateDiary("/path/to/image.jpg", "テスト日記", time.Now())
+	err := repo.CreateDiary(t.Context(), "/path/to/image.jpg", "テスト日記", time.Now())
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
-	diaries, err := repo.GetAllDiaries()
+	diaries, err := repo.GetAllDiaries(t.Context(), OwnerScope{})
 	if err != nil {
 		t.Fatalf("GetAllDiaries failed: %v", err)
 	}
@@ -34,12 +34,12 @@ func TestMockDiaryRepository_CreateDiary(t *testing.T) {
 func TestMockDiaryRepository_GetDiaryByID(t *testing.T) {
 	repo := NewMockDiaryRepository()
 
-	err := repo.CreateDiary("/path/to/image.jpg", "テスト日記", time.Now())
+	err := repo.CreateDiary(t.Context(), "/path/to/image.jpg", "テスト日記", time.Now())
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
-	diary, err := repo.GetDiaryByID(1)
+	diary, err := repo.GetDiaryByID(t.Context(), 1)
 	if err != nil {
 		t.Fatalf("GetDiaryByID failed: %v", err)
 	}
@@ -53,7 +53,7 @@ func TestMockDiaryRepository_GetDiaryByID(t *testing.T) {
 	}
 
 	// 存在しないIDを取得
-	diary, err = repo.GetDiaryByID(999)
+	diary, err = repo.GetDiaryByID(t.Context(), 999)
 	if err != nil {
 		t.Fatalf("GetDiaryByID failed: %v", err)
 	}
@@ -67,26 +67,26 @@ func TestMockDiaryRepository_GetAllDiaries_Order(t *testing.T) {
 	repo := NewMockDiaryRepository()
 
 	// 複数の日記を作成（時間をずらす）
-	err := repo.CreateDiary("/path/1.jpg", "日記1", time.Now())
+	err := repo.CreateDiary(t.Context(), "/path/1.jpg", "日記1", time.Now())
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
 	time.Sleep(10 * time.Millisecond)
 
-	err = repo.CreateDiary("/path/2.jpg", "日記2", time.Now())
+	err = repo.CreateDiary(t.Context(), "/path/2.jpg", "日記2", time.Now())
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
 	time.Sleep(10 * time.Millisecond)
 
-	err = repo.CreateDiary("/path/3.jpg", "日記3", time.Now())
+	err = repo.CreateDiary(t.Context(), "/path/3.jpg", "日記3", time.Now())
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
-	diaries, err := repo.GetAllDiaries()
+	diaries, err := repo.GetAllDiaries(t.Context(), OwnerScope{})
 	if err != nil {
 		t.Fatalf("GetAllDiaries failed: %v", err)
 	}
@@ -109,7 +109,7 @@ func TestMockDiaryRepository_IsImageProcessed(t *testing.T) {
 	repo := NewMockDiaryRepository()
 
 	// 未処理の画像
-	processed, err := repo.IsImageProcessed("/path/to/new.jpg")
+	processed, err := repo.IsImageProcessed(t.Context(), "/path/to/new.jpg")
 	if err != nil {
 		t.Fatalf("IsImageProcessed failed: %v", err)
 	}
@@ -119,13 +119,13 @@ func TestMockDiaryRepository_IsImageProcessed(t *testing.T) {
 	}
 
 	// 画像を処理
-	err = repo.CreateDiary("/path/to/new.jpg", "新しい日記", time.Now())
+	err = repo.CreateDiary(t.Context(), "/path/to/new.jpg", "新しい日記", time.Now())
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
 	// 処理済みの画像
-	processed, err = repo.IsImageProcessed("/path/to/new.jpg")
+	processed, err = repo.IsImageProcessed(t.Context(), "/path/to/new.jpg")
 	if err != nil {
 		t.Fatalf("IsImageProcessed failed: %v", err)
 	}
@@ -144,22 +144,22 @@ func TestMockDiaryRepository_GetDiariesInDateRange(t *testing.T) {
 	time3 := time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC)
 	time4 := time.Date(2026, 2, 10, 10, 0, 0, 0, time.UTC)
 
-	err := repo.CreateDiary("/path/1.jpg", "日記1", time1)
+	err := repo.CreateDiary(t.Context(), "/path/1.jpg", "日記1", time1)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
-	err = repo.CreateDiary("/path/2.jpg", "日記2", time2)
+	err = repo.CreateDiary(t.Context(), "/path/2.jpg", "日記2", time2)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
-	err = repo.CreateDiary("/path/3.jpg", "日記3", time3)
+	err = repo.CreateDiary(t.Context(), "/path/3.jpg", "日記3", time3)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
-	err = repo.CreateDiary("/path/4.jpg", "日記4", time4)
+	err = repo.CreateDiary(t.Context(), "/path/4.jpg", "日記4", time4)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
@@ -168,7 +168,7 @@ func TestMockDiaryRepository_GetDiariesInDateRange(t *testing.T) {
 	startDate := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
 	endDate := time.Date(2026, 2, 5, 0, 0, 0, 0, time.UTC)
 
-	diaries, err := repo.GetDiariesInDateRange(startDate, endDate)
+	diaries, err := repo.GetDiariesInDateRange(t.Context(), OwnerScope{}, startDate, endDate)
 	if err != nil {
 		t.Fatalf("GetDiariesInDateRange failed: %v", err)
 	}
@@ -192,7 +192,7 @@ func TestMockDiaryRepository_GetAvailableYearMonths(t *testing.T) {
 	repo := NewMockDiaryRepository()
 
 	// 日記が存在しない場合は空を返す
-	months, err := repo.GetAvailableYearMonths()
+	months, err := repo.GetAvailableYearMonths(t.Context(), OwnerScope{})
 	if err != nil {
 		t.Fatalf("GetAvailableYearMonths failed: %v", err)
 	}
@@ -207,20 +207,20 @@ func TestMockDiaryRepository_GetAvailableYearMonths(t *testing.T) {
 	time3 := time.Date(2026, 2, 5, 12, 0, 0, 0, jst)
 	time4 := time.Date(2025, 12, 15, 12, 0, 0, 0, jst)
 
-	if err := repo.CreateDiary("/path/1.jpg", "日記1", time1); err != nil {
+	if err := repo.CreateDiary(t.Context(), "/path/1.jpg", "日記1", time1); err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
-	if err := repo.CreateDiary("/path/2.jpg", "日記2", time2); err != nil {
+	if err := repo.CreateDiary(t.Context(), "/path/2.jpg", "日記2", time2); err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
-	if err := repo.CreateDiary("/path/3.jpg", "日記3", time3); err != nil {
+	if err := repo.CreateDiary(t.Context(), "/path/3.jpg", "日記3", time3); err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
-	if err := repo.CreateDiary("/path/4.jpg", "日記4", time4); err != nil {
+	if err := repo.CreateDiary(t.Context(), "/path/4.jpg", "日記4", time4); err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
-	months, err = repo.GetAvailableYearMonths()
+	months, err = repo.GetAvailableYearMonths(t.Context(), OwnerScope{})
 	if err != nil {
 		t.Fatalf("GetAvailableYearMonths failed: %v", err)
 	}
@@ -249,21 +249,21 @@ func TestMockDiaryRepository_SearchDiaries(t *testing.T) {
 	time2 := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
 	time3 := time.Date(2026, 1, 3, 10, 0, 0, 0, time.UTC)
 
-	err := repo.CreateDiary("/path/1.jpg", "葉が青くなってきた", time1)
+	err := repo.CreateDiary(t.Context(), "/path/1.jpg", "葉が青くなってきた", time1)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
-	err = repo.CreateDiary("/path/2.jpg", "花が咲いた", time2)
+	err = repo.CreateDiary(t.Context(), "/path/2.jpg", "花が咲いた", time2)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
-	err = repo.CreateDiary("/path/3.jpg", "葉が黄色に変化した", time3)
+	err = repo.CreateDiary(t.Context(), "/path/3.jpg", "葉が黄色に変化した", time3)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
 
 	// キーワードで検索
-	diaries, err := repo.SearchDiaries("葉")
+	diaries, err := repo.SearchDiaries(t.Context(), OwnerScope{}, "葉")
 	if err != nil {
 		t.Fatalf("SearchDiaries failed: %v", err)
 	}
@@ -281,7 +281,7 @@ func TestMockDiaryRepository_SearchDiaries(t *testing.T) {
 	}
 
 	// マッチしないキーワード
-	diaries, err = repo.SearchDiaries("実")
+	diaries, err = repo.SearchDiaries(t.Context(), OwnerScope{}, "実")
 	if err != nil {
 		t.Fatalf("SearchDiaries failed: %v", err)
 	}
@@ -290,7 +290,7 @@ func TestMockDiaryRepository_SearchDiaries(t *testing.T) {
 	}
 
 	// キーワードなし（全件）
-	diaries, err = repo.SearchDiaries("")
+	diaries, err = repo.SearchDiaries(t.Context(), OwnerScope{}, "")
 	if err != nil {
 		t.Fatalf("SearchDiaries failed: %v", err)
 	}
@@ -299,12 +299,93 @@ func TestMockDiaryRepository_SearchDiaries(t *testing.T) {
 	}
 }
 
+func TestMockDiaryRepository_QueryDiaries(t *testing.T) {
+	repo := NewMockDiaryRepository()
+
+	base := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	contents := []string{"葉が出た", "花が咲いた", "葉が増えた"}
+	for i, c := range contents {
+		if err := repo.CreateDiaryForUser(t.Context(), 1, 0, "/path/"+c+".jpg", c, base.AddDate(0, 0, i)); err != nil {
+			t.Fatalf("CreateDiaryForUser failed: %v", err)
+		}
+	}
+	if err := repo.CreateDiaryForUser(t.Context(), 2, 0, "/path/other.jpg", "他のユーザーの葉", base); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+
+	diaries, total, err := repo.QueryDiaries(t.Context(), OwnerScope{UserID: 1}, DiaryQuery{Keyword: "葉", Limit: 1})
+	if err != nil {
+		t.Fatalf("QueryDiaries failed: %v", err)
+	}
+	if total != 2 {
+		t.Errorf("expected total 2, got %d", total)
+	}
+	if len(diaries) != 1 || diaries[0].Content != "葉が増えた" {
+		t.Errorf("expected newest matching diary, got %+v", diaries)
+	}
+
+	diaries, total, err = repo.QueryDiaries(t.Context(), OwnerScope{UserID: 1}, DiaryQuery{From: base.AddDate(0, 0, 1), To: base.AddDate(0, 0, 1)})
+	if err != nil {
+		t.Fatalf("QueryDiaries failed: %v", err)
+	}
+	if total != 1 || len(diaries) != 1 || diaries[0].Content != "花が咲いた" {
+		t.Errorf("expected diary in date range, got total %d, %+v", total, diaries)
+	}
+}
+
+func TestMockDiaryRepository_DeleteRestorePurge(t *testing.T) {
+	repo := NewMockDiaryRepository()
+
+	if err := repo.CreateDiaryForUser(t.Context(), 1, 0, "/path/1.jpg", "日記", time.Now()); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	if err := repo.DeleteDiary(t.Context(), 1); err != nil {
+		t.Fatalf("DeleteDiary failed: %v", err)
+	}
+	diary, err := repo.GetDiaryByID(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("GetDiaryByID failed: %v", err)
+	}
+	if diary != nil {
+		t.Errorf("expected deleted diary to be hidden, got %+v", diary)
+	}
+	all, _ := repo.GetAllDiaries(t.Context(), OwnerScope{})
+	if len(all) != 0 {
+		t.Errorf("expected no diaries, got %d", len(all))
+	}
+	deleted, _ := repo.GetDeletedDiaries(t.Context(), 1)
+	if len(deleted) != 1 {
+		t.Fatalf("expected 1 diary in trash, got %d", len(deleted))
+	}
+
+	if err := repo.RestoreDiary(t.Context(), 1); err != nil {
+		t.Fatalf("RestoreDiary failed: %v", err)
+	}
+	if diary, _ := repo.GetDiaryByID(t.Context(), 1); diary == nil {
+		t.Error("expected restored diary")
+	}
+	if _, err := repo.PurgeDiary(t.Context(), 1); err == nil {
+		t.Error("expected error for diary not in trash, got nil")
+	}
+
+	if err := repo.DeleteDiary(t.Context(), 1); err != nil {
+		t.Fatalf("DeleteDiary failed: %v", err)
+	}
+	if _, err := repo.PurgeDiary(t.Context(), 1); err != nil {
+		t.Fatalf("PurgeDiary failed: %v", err)
+	}
+	deleted, _ = repo.GetDeletedDiaries(t.Context(), 1)
+	if len(deleted) != 0 {
+		t.Errorf("expected empty trash, got %d", len(deleted))
+	}
+}
+
 func TestMockDiaryRepository_GetDiariesInDateRange_Empty(t *testing.T) {
 	repo := NewMockDiaryRepository()
 
 	// 日記を1件作成
 	time1 := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
-	err := repo.CreateDiary("/path/1.jpg", "日記1", time1)
+	err := repo.CreateDiary(t.Context(), "/path/1.jpg", "日記1", time1)
 	if err != nil {
 		t.Fatalf("CreateDiary failed: %v", err)
 	}
@@ -313,7 +394,7 @@ func TestMockDiaryRepository_GetDiariesInDateRange_Empty(t *testing.T) {
 	startDate := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
 	endDate := time.Date(2026, 2, 28, 0, 0, 0, 0, time.UTC)
 
-	diaries, err := repo.GetDiariesInDateRange(startDate, endDate)
+	diaries, err := repo.GetDiariesInDateRange(t.Context(), OwnerScope{}, startDate, endDate)
 	if err != nil {
 		t.Fatalf("GetDiariesInDateRange failed: %v", err)
 	}
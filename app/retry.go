@@ -1,20 +1,37 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand/v2"
+	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 )
 
+// RetryOperationGenerateDiary は日記の生成（Workerのジョブと再生成）のリトライの操作名
+const RetryOperationGenerateDiary = "generate diary"
+
 // RetryConfig はリトライ処理の設定を保持する。
 type RetryConfig struct {
 	MaxRetries int
 	Intervals  []time.Duration
-	SleepFunc  func(time.Duration) // テスト用に差し替え可能
+	// Jitter は待ち時間をランダムにずらす割合（0.2の場合は±20%）。同時に失敗したジョブのリトライが重ならないようにする
+	Jitter float64
+	// MaxRetryAfter はプロバイダが指定した待ち時間（HTTP 429 の Retry-After など）を待つ上限。
+	// これより長い待ち時間を指定された場合はリトライしない。0の場合は上限なし
+	MaxRetryAfter time.Duration
+	SleepFunc     func(ctx context.Context, d time.Duration) error // テスト用に差し替え可能
+	Metrics       *RetryMetrics                                    // nilの場合は集計しない
 }
 
-// DefaultRetryConfig はデフォルトのリトライ設定（最大3回、指数バックオフ: 1秒, 2秒, 4秒）を返す。
+// DefaultRetryConfig はデフォルトのリトライ設定（最大3回、指数バックオフ: 1秒, 2秒, 4秒に±20%のジッター）を返す。
+// プロバイダが指定した待ち時間は60秒まで待ち、操作ごとの集計は retryMetrics に記録する。
 func DefaultRetryConfig() RetryConfig {
 	return RetryConfig{
 		MaxRetries: 3,
@@ -23,59 +40,300 @@ func DefaultRetryConfig() RetryConfig {
 			2 * time.Second,
 			4 * time.Second,
 		},
-		SleepFunc: time.Sleep,
+		Jitter:        0.2,
+		MaxRetryAfter: 60 * time.Second,
+		SleepFunc:     sleepContext,
+		Metrics:       retryMetrics,
 	}
 }
 
 // Retry は指定された関数をリトライ付きで実行する。
 // 最初の試行が失敗した場合、設定に基づいて最大MaxRetries回リトライする。
-func Retry(config RetryConfig, operation string, fn func() error) error {
+// リトライしても成功しない失敗（PermanentError）やctxのキャンセルではリトライせずに終了し、
+// プロバイダが待ち時間を指定した失敗（RetryAfterError）では、バックオフより長い場合にその時間だけ待つ。
+func Retry(ctx context.Context, config RetryConfig, operation string, fn func() error) error {
 	if config.MaxRetries < 0 {
 		return fmt.Errorf("MaxRetries must be >= 0")
 	}
 	if config.SleepFunc == nil {
-		config.SleepFunc = time.Sleep
+		config.SleepFunc = sleepContext
 	}
 	if len(config.Intervals) < config.MaxRetries {
 		return fmt.Errorf("Intervals length (%d) is less than MaxRetries (%d)", len(config.Intervals), config.MaxRetries)
 	}
 	totalAttempts := 1 + config.MaxRetries
 	for attempt := 1; attempt <= totalAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			config.Metrics.record(operation, attempt-1, retryOutcomeCanceled)
+			return fmt.Errorf("%s canceled before attempt %d: %w", operation, attempt, err)
+		}
+
 		err := fn()
 		if err == nil {
+			config.Metrics.record(operation, attempt, retryOutcomeSucceeded)
 			return nil
 		}
 
-		if attempt < totalAttempts {
-			interval := config.Intervals[attempt-1]
-			log.Printf("ERROR: %s failed (attempt %d/%d): %v. Retrying in %v...", operation, attempt, totalAttempts, err, interval)
-			config.SleepFunc(interval)
-		} else {
+		switch {
+		case ctx.Err() != nil:
+			log.Printf("ERROR: %s failed (attempt %d/%d): %v. Canceled.", operation, attempt, totalAttempts, err)
+			config.Metrics.record(operation, attempt, retryOutcomeCanceled)
+			return fmt.Errorf("%s canceled after %d attempts: %w", operation, attempt, err)
+		case !IsRetryable(err):
+			log.Printf("ERROR: %s failed (attempt %d/%d): %v. Not retryable.", operation, attempt, totalAttempts, err)
+			config.Metrics.record(operation, attempt, retryOutcomePermanent)
+			return fmt.Errorf("%s failed with a permanent error after %d attempts: %w", operation, attempt, err)
+		case attempt == totalAttempts:
 			log.Printf("ERROR: %s failed (attempt %d/%d): %v. No more retries.", operation, attempt, totalAttempts, err)
+			config.Metrics.record(operation, attempt, retryOutcomeExhausted)
 			return fmt.Errorf("%s failed after %d attempts: %w", operation, totalAttempts, err)
 		}
+
+		interval := config.backoff(attempt)
+		if after, ok := RetryAfter(err); ok {
+			config.Metrics.recordRateLimited(operation)
+			if config.MaxRetryAfter > 0 && after > config.MaxRetryAfter {
+				log.Printf("ERROR: %s failed (attempt %d/%d): %v. Retry-After %v exceeds %v, giving up.", operation, attempt, totalAttempts, err, after, config.MaxRetryAfter)
+				config.Metrics.record(operation, attempt, retryOutcomeExhausted)
+				return fmt.Errorf("%s failed after %d attempts (retry after %v): %w", operation, attempt, after, err)
+			}
+			interval = max(interval, after)
+		}
+		log.Printf("ERROR: %s failed (attempt %d/%d): %v. Retrying in %v...", operation, attempt, totalAttempts, err, interval)
+		if sleepErr := config.SleepFunc(ctx, interval); sleepErr != nil {
+			config.Metrics.record(operation, attempt, retryOutcomeCanceled)
+			return fmt.Errorf("%s canceled after %d attempts: %w", operation, attempt, errors.Join(sleepErr, err))
+		}
 	}
 
 	return nil
 }
 
+// backoff はattempt回目の試行に失敗した後の待ち時間を、Intervals にジッターを加えて返す
+func (c RetryConfig) backoff(attempt int) time.Duration {
+	interval := c.Intervals[attempt-1]
+	if c.Jitter <= 0 {
+		return interval
+	}
+	return time.Duration(float64(interval) * (1 + c.Jitter*(2*rand.Float64()-1)))
+}
+
+// sleepContext はdだけ待つ。ctxがキャンセルされた場合は待つのをやめ、ctxのエラーを返す
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// PermanentError はリトライしても成功しない失敗（画像ファイルがない、APIキーが無効など）を表す
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Permanent はerrをリトライしない失敗としてラップする。errがnilの場合はnilを返す
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// RetryAfterError はプロバイダが再試行までの待ち時間を指定した失敗（HTTP 429 の Retry-After など）を表す
+type RetryAfterError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryAfterError) Error() string { return e.Err.Error() }
+func (e *RetryAfterError) Unwrap() error { return e.Err }
+
+// IsRetryable はerrがリトライで成功しうる失敗かどうかを返す。
+// 複数の失敗をまとめたエラー（フォールバックした各プロバイダの失敗など）は、いずれかがリトライできる場合にリトライできるものとする
+func IsRetryable(err error) bool {
+	switch e := err.(type) {
+	case *PermanentError:
+		return false
+	case interface{ Unwrap() []error }:
+		for _, child := range e.Unwrap() {
+			if IsRetryable(child) {
+				return true
+			}
+		}
+		return false
+	case interface{ Unwrap() error }:
+		if inner := e.Unwrap(); inner != nil {
+			return IsRetryable(inner)
+		}
+	}
+	return true
+}
+
+// RetryAfter はerrに含まれる、プロバイダが指定した再試行までの待ち時間を返す
+func RetryAfter(err error) (time.Duration, bool) {
+	var retryAfterErr *RetryAfterError
+	if errors.As(err, &retryAfterErr) {
+		return retryAfterErr.After, true
+	}
+	return 0, false
+}
+
+// classifyHTTPError はプロバイダのAPIが返したHTTPステータスに応じてerrを分類する。
+// 429 は Retry-After ヘッダー（指定がない場合は待ち時間なし）を付けたリトライできる失敗、
+// 408・5xx はリトライできる失敗、それ以外の 4xx（認証エラーや不正なリクエスト）はリトライしない失敗とする
+func classifyHTTPError(statusCode int, header http.Header, err error) error {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		after, _ := parseRetryAfter(header.Get("Retry-After"), time.Now())
+		return &RetryAfterError{Err: err, After: after}
+	case statusCode == http.StatusRequestTimeout || statusCode >= 500:
+		return err
+	case statusCode >= 400:
+		return Permanent(err)
+	}
+	return err
+}
+
+// parseRetryAfter は Retry-After ヘッダーの値（秒数またはHTTP日付）を、nowからの待ち時間に変換する
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// retryOutcome はRetryの1回の呼び出しの結果
+type retryOutcome int
+
+const (
+	retryOutcomeSucceeded retryOutcome = iota
+	retryOutcomeExhausted              // 全ての試行に失敗した（待ち時間が上限を超えた場合を含む）
+	retryOutcomePermanent              // リトライしない失敗で終了した
+	retryOutcomeCanceled               // ctxのキャンセルで終了した
+)
+
+// RetryStats は操作ごとのリトライの集計（サーバー起動後の累計）
+type RetryStats struct {
+	Operation         string
+	Calls             int // Retryの呼び出し回数
+	Attempts          int // 試行回数の合計
+	Successes         int // 成功した呼び出しの数
+	Failures          int // 全ての試行に失敗した呼び出しの数
+	PermanentFailures int // リトライしない失敗で終了した呼び出しの数
+	Canceled          int // キャンセルで終了した呼び出しの数
+	RateLimited       int // プロバイダに待ち時間を指定された失敗の数
+}
+
+// RetryMetrics は操作ごとのリトライの集計を保持する。nilの場合は集計しない
+type RetryMetrics struct {
+	mu    sync.Mutex
+	stats map[string]*RetryStats
+}
+
+// retryMetrics は DefaultRetryConfig のリトライを集計する
+var retryMetrics = NewRetryMetrics()
+
+// NewRetryMetrics は新しいRetryMetricsを生成する
+func NewRetryMetrics() *RetryMetrics {
+	return &RetryMetrics{stats: make(map[string]*RetryStats)}
+}
+
+// Stats は操作ごとの集計を操作名の順に返す
+func (m *RetryMetrics) Stats() []RetryStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make([]RetryStats, 0, len(m.stats))
+	for _, st := range m.stats {
+		stats = append(stats, *st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Operation < stats[j].Operation })
+	return stats
+}
+
+// get は操作の集計を返す。呼び出し元でロックを取ること
+func (m *RetryMetrics) get(operation string) *RetryStats {
+	st, ok := m.stats[operation]
+	if !ok {
+		st = &RetryStats{Operation: operation}
+		m.stats[operation] = st
+	}
+	return st
+}
+
+// record はRetryの1回の呼び出しの試行回数と結果を集計する
+func (m *RetryMetrics) record(operation string, attempts int, outcome retryOutcome) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.get(operation)
+	st.Calls++
+	st.Attempts += attempts
+	switch outcome {
+	case retryOutcomeSucceeded:
+		st.Successes++
+	case retryOutcomeExhausted:
+		st.Failures++
+	case retryOutcomePermanent:
+		st.PermanentFailures++
+	case retryOutcomeCanceled:
+		st.Canceled++
+	}
+}
+
+// recordRateLimited はプロバイダに待ち時間を指定された失敗を集計する
+func (m *RetryMetrics) recordRateLimited(operation string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(operation).RateLimited++
+}
+
+// ImageFileError は日記の写真や参照画像のファイルを読み込めない失敗を表す。
+// 写真自体の問題のため、リトライしても他のプロバイダを試しても成功しない
+type ImageFileError struct {
+	Err error
+}
+
+func (e *ImageFileError) Error() string { return e.Err.Error() }
+func (e *ImageFileError) Unwrap() error { return e.Err }
+
 // ReadImageFile は画像ファイルを読み込み、バイトデータを返す。
-// ファイルが存在しない場合や読み込めない場合はエラーを返す。
+// ファイルが存在しない場合や読み込めない場合は、ImageFileError をリトライしない失敗（PermanentError）としてラップして返す。
 func ReadImageFile(path string) ([]byte, error) {
 	info, err := os.Stat(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to access image file %s: %w", path, err)
+		return nil, Permanent(&ImageFileError{Err: fmt.Errorf("failed to access image file %s: %w", path, err)})
 	}
 	if info.IsDir() {
-		return nil, fmt.Errorf("path is a directory, not an image file: %s", path)
+		return nil, Permanent(&ImageFileError{Err: fmt.Errorf("path is a directory, not an image file: %s", path)})
 	}
 	if info.Size() == 0 {
-		return nil, fmt.Errorf("image file is empty: %s", path)
+		return nil, Permanent(&ImageFileError{Err: fmt.Errorf("image file is empty: %s", path)})
 	}
 
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read image file %s: %w", path, err)
+		return nil, Permanent(&ImageFileError{Err: fmt.Errorf("failed to read image file %s: %w", path, err)})
 	}
 	return data, nil
 }
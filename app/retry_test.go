@@ -1,22 +1,28 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
+
+	"google.golang.org/genai"
 )
 
 func TestRetry_SuccessOnFirstAttempt(t *testing.T) {
 	config := RetryConfig{
 		MaxRetries: 3,
 		Intervals:  []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second},
-		SleepFunc:  func(d time.Duration) {},
+		SleepFunc:  func(ctx context.Context, d time.Duration) error { return nil },
 	}
 
 	callCount := 0
-	err := Retry(config, "test operation", func() error {
+	err := Retry(t.Context(), config, "test operation", func() error {
 		callCount++
 		return nil
 	})
@@ -33,11 +39,11 @@ func TestRetry_SuccessAfterRetries(t *testing.T) {
 	config := RetryConfig{
 		MaxRetries: 3,
 		Intervals:  []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second},
-		SleepFunc:  func(d time.Duration) {},
+		SleepFunc:  func(ctx context.Context, d time.Duration) error { return nil },
 	}
 
 	callCount := 0
-	err := Retry(config, "test operation", func() error {
+	err := Retry(t.Context(), config, "test operation", func() error {
 		callCount++
 		if callCount < 3 {
 			return errors.New("temporary error")
@@ -57,12 +63,12 @@ func TestRetry_AllAttemptsFail(t *testing.T) {
 	config := RetryConfig{
 		MaxRetries: 3,
 		Intervals:  []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second},
-		SleepFunc:  func(d time.Duration) {},
+		SleepFunc:  func(ctx context.Context, d time.Duration) error { return nil },
 	}
 
 	callCount := 0
 	expectedErr := errors.New("persistent error")
-	err := Retry(config, "test operation", func() error {
+	err := Retry(t.Context(), config, "test operation", func() error {
 		callCount++
 		return expectedErr
 	})
@@ -84,12 +90,13 @@ func TestRetry_ExponentialBackoffIntervals(t *testing.T) {
 	config := RetryConfig{
 		MaxRetries: 3,
 		Intervals:  []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second},
-		SleepFunc: func(d time.Duration) {
+		SleepFunc: func(ctx context.Context, d time.Duration) error {
 			sleepDurations = append(sleepDurations, d)
+			return nil
 		},
 	}
 
-	_ = Retry(config, "test operation", func() error {
+	_ = Retry(t.Context(), config, "test operation", func() error {
 		return errors.New("always fail")
 	})
 
@@ -109,12 +116,13 @@ func TestRetry_NoSleepOnSuccess(t *testing.T) {
 	config := RetryConfig{
 		MaxRetries: 3,
 		Intervals:  []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second},
-		SleepFunc: func(d time.Duration) {
+		SleepFunc: func(ctx context.Context, d time.Duration) error {
 			sleepCalled = true
+			return nil
 		},
 	}
 
-	err := Retry(config, "test operation", func() error {
+	err := Retry(t.Context(), config, "test operation", func() error {
 		return nil
 	})
 
@@ -126,6 +134,228 @@ func TestRetry_NoSleepOnSuccess(t *testing.T) {
 	}
 }
 
+func TestRetry_PermanentErrorNotRetried(t *testing.T) {
+	sleepCalled := false
+	config := RetryConfig{
+		MaxRetries: 3,
+		Intervals:  []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second},
+		SleepFunc: func(ctx context.Context, d time.Duration) error {
+			sleepCalled = true
+			return nil
+		},
+	}
+
+	callCount := 0
+	expectedErr := errors.New("invalid API key")
+	err := Retry(t.Context(), config, "test operation", func() error {
+		callCount++
+		return Permanent(expectedErr)
+	})
+
+	if !errors.Is(err, expectedErr) {
+		t.Fatalf("expected wrapped permanent error, got %v", err)
+	}
+	if callCount != 1 || sleepCalled {
+		t.Errorf("expected no retries, got %d calls (slept: %v)", callCount, sleepCalled)
+	}
+}
+
+func TestRetry_RetryAfter(t *testing.T) {
+	var sleepDurations []time.Duration
+	config := RetryConfig{
+		MaxRetries:    3,
+		Intervals:     []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second},
+		MaxRetryAfter: 60 * time.Second,
+		SleepFunc: func(ctx context.Context, d time.Duration) error {
+			sleepDurations = append(sleepDurations, d)
+			return nil
+		},
+	}
+
+	// バックオフより長い待ち時間はその時間だけ待ち、短い場合はバックオフで待つ
+	afters := []time.Duration{30 * time.Second, 500 * time.Millisecond}
+	callCount := 0
+	err := Retry(t.Context(), config, "test operation", func() error {
+		callCount++
+		if callCount <= len(afters) {
+			return &RetryAfterError{Err: errors.New("rate limited"), After: afters[callCount-1]}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	want := []time.Duration{30 * time.Second, 2 * time.Second}
+	if !reflect.DeepEqual(sleepDurations, want) {
+		t.Errorf("expected sleeps %v, got %v", want, sleepDurations)
+	}
+
+	// 上限より長い待ち時間を指定された場合はリトライしない
+	sleepDurations = nil
+	callCount = 0
+	err = Retry(t.Context(), config, "test operation", func() error {
+		callCount++
+		return &RetryAfterError{Err: errors.New("quota exceeded"), After: time.Hour}
+	})
+	if err == nil || callCount != 1 || len(sleepDurations) != 0 {
+		t.Errorf("expected to give up without retries, got %v (%d calls, sleeps %v)", err, callCount, sleepDurations)
+	}
+}
+
+func TestRetry_Jitter(t *testing.T) {
+	var sleepDurations []time.Duration
+	config := RetryConfig{
+		MaxRetries: 3,
+		Intervals:  []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second},
+		Jitter:     0.5,
+		SleepFunc: func(ctx context.Context, d time.Duration) error {
+			sleepDurations = append(sleepDurations, d)
+			return nil
+		},
+	}
+
+	for range 20 {
+		_ = Retry(t.Context(), config, "test operation", func() error {
+			return errors.New("always fail")
+		})
+	}
+
+	for i, d := range sleepDurations {
+		base := config.Intervals[i%len(config.Intervals)]
+		if d < base/2 || d > base*3/2 {
+			t.Errorf("sleep[%d]: expected %v±50%%, got %v", i, base, d)
+		}
+	}
+}
+
+func TestRetry_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	config := RetryConfig{
+		MaxRetries: 3,
+		Intervals:  []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second},
+		SleepFunc:  sleepContext,
+	}
+
+	// 待機中にキャンセルされた場合はすぐにリトライをやめる
+	callCount := 0
+	start := time.Now()
+	err := Retry(ctx, config, "test operation", func() error {
+		callCount++
+		time.AfterFunc(10*time.Millisecond, cancel)
+		return errors.New("temporary error")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if callCount != 1 || time.Since(start) >= time.Second {
+		t.Errorf("expected to stop while waiting, got %d calls in %v", callCount, time.Since(start))
+	}
+
+	// キャンセル済みの場合は試行しない
+	callCount = 0
+	if err := Retry(ctx, config, "test operation", func() error {
+		callCount++
+		return nil
+	}); !errors.Is(err, context.Canceled) || callCount != 0 {
+		t.Errorf("expected no attempts after cancel, got %v (%d calls)", err, callCount)
+	}
+}
+
+func TestRetry_Metrics(t *testing.T) {
+	metrics := NewRetryMetrics()
+	config := RetryConfig{
+		MaxRetries: 1,
+		Intervals:  []time.Duration{time.Second},
+		SleepFunc:  func(ctx context.Context, d time.Duration) error { return nil },
+		Metrics:    metrics,
+	}
+
+	_ = Retry(t.Context(), config, "generate", func() error { return nil })
+	_ = Retry(t.Context(), config, "generate", func() error {
+		return &RetryAfterError{Err: errors.New("rate limited"), After: time.Second}
+	})
+	_ = Retry(t.Context(), config, "generate", func() error { return Permanent(errors.New("missing")) })
+	_ = Retry(t.Context(), config, "summarize", func() error { return nil })
+
+	want := []RetryStats{
+		{Operation: "generate", Calls: 3, Attempts: 4, Successes: 1, Failures: 1, PermanentFailures: 1, RateLimited: 1},
+		{Operation: "summarize", Calls: 1, Attempts: 1, Successes: 1},
+	}
+	if got := metrics.Stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	temporary := errors.New("temporary")
+	permanent := Permanent(errors.New("permanent"))
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "通常のエラー", err: temporary, want: true},
+		{name: "PermanentError", err: permanent, want: false},
+		{name: "ラップしたPermanentError", err: fmt.Errorf("gemini: %w", permanent), want: false},
+		{name: "待ち時間を指定した失敗", err: &RetryAfterError{Err: temporary, After: time.Second}, want: true},
+		{name: "いずれかがリトライできる", err: errors.Join(permanent, temporary), want: true},
+		{name: "全てリトライしない", err: errors.Join(permanent, fmt.Errorf("ollama: %w", permanent)), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 3, 20, 3, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "秒数", value: "30", want: 30 * time.Second, wantOK: true},
+		{name: "HTTP日付", value: "Fri, 20 Mar 2026 03:01:00 GMT", want: time.Minute, wantOK: true},
+		{name: "過去の日付は待たない", value: "Fri, 20 Mar 2026 02:00:00 GMT", want: 0, wantOK: true},
+		{name: "空", value: "", wantOK: false},
+		{name: "負の秒数", value: "-1", wantOK: false},
+		{name: "不正な値", value: "soon", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.value, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("parseRetryAfter(%q) = (%v, %v), want (%v, %v)", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestClassifyGeminiError(t *testing.T) {
+	rateLimited := classifyGeminiError(fmt.Errorf("Gemini API の呼び出しに失敗: %w", genai.APIError{
+		Code: http.StatusTooManyRequests,
+		Details: []map[string]any{
+			{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "17s"},
+		},
+	}))
+	if after, ok := RetryAfter(rateLimited); !ok || after != 17*time.Second || !IsRetryable(rateLimited) {
+		t.Errorf("expected retryable error with 17s delay, got %v (%v)", after, rateLimited)
+	}
+
+	if invalidKey := classifyGeminiError(genai.APIError{Code: http.StatusBadRequest, Message: "API key not valid"}); IsRetryable(invalidKey) {
+		t.Errorf("expected 400 to be permanent, got %v", invalidKey)
+	}
+	if unavailable := classifyGeminiError(genai.APIError{Code: http.StatusServiceUnavailable}); !IsRetryable(unavailable) {
+		t.Errorf("expected 503 to be retryable, got %v", unavailable)
+	}
+}
+
 func TestReadImageFile_ValidFile(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "test.jpg")
@@ -148,6 +378,9 @@ func TestReadImageFile_NonExistentFile(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected error for non-existent file, got nil")
 	}
+	if IsRetryable(err) {
+		t.Error("expected missing image not to be retried")
+	}
 }
 
 func TestReadImageFile_Directory(t *testing.T) {
@@ -191,4 +424,7 @@ func TestDefaultRetryConfig(t *testing.T) {
 	if config.SleepFunc == nil {
 		t.Error("expected SleepFunc to be set")
 	}
+	if config.Jitter != 0.2 || config.MaxRetryAfter != 60*time.Second || config.Metrics == nil {
+		t.Errorf("unexpected jitter %v, max retry after %v, metrics %v", config.Jitter, config.MaxRetryAfter, config.Metrics)
+	}
 }
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -10,6 +14,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -18,19 +23,54 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// uploadRetryAfterSeconds はジョブキュー満杯時に Retry-After ヘッダーで返す再試行までの秒数
+const uploadRetryAfterSeconds = 30
+
+const (
+	// uploadIdempotencyKeyTTL は写真アップロードAPIの Idempotency-Key を覚えておく期間（過ぎたキーは再利用できる）
+	uploadIdempotencyKeyTTL = 24 * time.Hour
+	// uploadIdempotencyPendingTimeout は処理中のままのキーを、中断されたリクエストのものとみなすまでの時間
+	uploadIdempotencyPendingTimeout = 5 * time.Minute
+	// maxIdempotencyKeyLength は Idempotency-Key ヘッダーの長さの上限
+	maxIdempotencyKeyLength = 255
+	// defaultDuplicateWindow は写真アップロードAPIで重複する写真を探す撮影日時の前後の範囲のデフォルト値
+	defaultDuplicateWindow = time.Hour
+	// maxDuplicateWindow は duplicate_window に指定できる範囲の上限
+	maxDuplicateWindow = 7 * 24 * time.Hour
+)
+
+// uploadFormOverheadBytes は写真アップロードAPIのリクエストサイズの上限に、写真の上限に加えて許容するフォームの他の項目の分
+const uploadFormOverheadBytes = 1 << 20
+
 // Server はHTTPサーバーを表す構造体
 type Server struct {
-	repo        DiaryRepository
-	userRepo    UserRepository
-	sessionRepo SessionRepository
-	generator   DiaryGenerator
-	photosDir   string
-	templates   *template.Template
-	mux         *http.ServeMux
+	repo          DiaryRepository
+	userRepo      UserRepository
+	sessionRepo   SessionRepository
+	jobRepo       JobRepository
+	revisionRepo  DiaryRevisionRepository
+	plantRepo     PlantRepository
+	apiTokenRepo  APITokenRepository
+	promptRepo    PromptTemplateRepository
+	generator     DiaryGenerator
+	prompts       *DiaryPromptBuilder
+	usage         *UsageTracker
+	usageRepo     UsageRepository
+	photoMetaRepo PhotoMetadataRepository
+	uploadKeyRepo UploadIdempotencyKeyRepository
+	photoHashRepo PhotoHashRepository
+	adminUsers    map[string]bool
+	setupToken    string // 最初のユーザーの作成に使う SETUP_TOKEN（未設定の場合は空）
+	retryConfig   RetryConfig
+	regenTimeout  time.Duration // 日記の再生成をリクエストの中で待つ時間の上限
+	worker        *DiaryWorker
+	photosDir     string
+	templates     *template.Template
+	mux           *http.ServeMux
 }
 
 // NewServer は新しいServerを生成する
-func NewServer(repo DiaryRepository, userRepo UserRepository, sessionRepo SessionRepository, generator DiaryGenerator, photosDir string) (*Server, error) {
+func NewServer(repo DiaryRepository, userRepo UserRepository, sessionRepo SessionRepository, jobRepo JobRepository, revisionRepo DiaryRevisionRepository, plantRepo PlantRepository, apiTokenRepo APITokenRepository, promptRepo PromptTemplateRepository, usageRepo UsageRepository, photoMetaRepo PhotoMetadataRepository, uploadKeyRepo UploadIdempotencyKeyRepository, photoHashRepo PhotoHashRepository, generator DiaryGenerator, prompts *DiaryPromptBuilder, usage *UsageTracker, worker *DiaryWorker, photosDir string) (*Server, error) {
 	// カスタムテンプレート関数を登録
 	funcMap := template.FuncMap{
 		"truncate": func(s string, length int) string {
@@ -48,6 +88,13 @@ func NewServer(repo DiaryRepository, userRepo UserRepository, sessionRepo Sessio
 			weekdays := []string{"日", "月", "火", "水", "木", "金", "土"}
 			return weekdays[t.Weekday()]
 		},
+		"revisionSourceLabel": revisionSourceLabel,
+		"apiTokenScopeLabel":  apiTokenScopeLabel,
+		"issueLabel":          issueLabel,
+		"personaTemplate":     personaTemplate,
+		"healthStars": func(score int) string {
+			return strings.Repeat("★", score) + strings.Repeat("☆", maxHealthScore-score)
+		},
 	}
 
 	// テンプレートディレクトリの自動検出
@@ -62,22 +109,61 @@ func NewServer(repo DiaryRepository, userRepo UserRepository, sessionRepo Sessio
 	}
 
 	s := &Server{
-		repo:        repo,
-		userRepo:    userRepo,
-		sessionRepo: sessionRepo,
-		generator:   generator,
-		photosDir:   photosDir,
-		templates:   tmpl,
-		mux:         http.NewServeMux(),
+		repo:          repo,
+		userRepo:      userRepo,
+		sessionRepo:   sessionRepo,
+		jobRepo:       jobRepo,
+		revisionRepo:  revisionRepo,
+		plantRepo:     plantRepo,
+		apiTokenRepo:  apiTokenRepo,
+		promptRepo:    promptRepo,
+		generator:     generator,
+		prompts:       prompts,
+		usage:         usage,
+		usageRepo:     usageRepo,
+		photoMetaRepo: photoMetaRepo,
+		uploadKeyRepo: uploadKeyRepo,
+		photoHashRepo: photoHashRepo,
+		adminUsers:    LoadAdminUsers(),
+		setupToken:    os.Getenv("SETUP_TOKEN"),
+		retryConfig:   DefaultRetryConfig(),
+		regenTimeout:  regenerateDiaryTimeout,
+		worker:        worker,
+		photosDir:     photosDir,
+		templates:     tmpl,
+		mux:           http.NewServeMux(),
 	}
 
 	s.mux.HandleFunc("GET /", s.handleIndex)
 	s.mux.HandleFunc("GET /diary/{id}", s.handleDiary)
 	s.mux.HandleFunc("GET /diary/{id}/edit", s.requireLogin(s.handleDiaryEditGet))
 	s.mux.HandleFunc("POST /diary/{id}/edit", s.requireLogin(s.handleDiaryEditPost))
+	s.mux.HandleFunc("POST /diary/{id}/regenerate", s.requireLogin(s.handleDiaryRegenerate))
+	s.mux.HandleFunc("GET /diary/{id}/compare", s.requireLogin(s.handleDiaryCompare))
+	s.mux.HandleFunc("GET /diary/{id}/diff", s.requireLogin(s.handleDiaryDiff))
+	s.mux.HandleFunc("POST /diary/{id}/revisions/{revision_id}/restore", s.requireLogin(s.handleRevisionRestore))
+	s.mux.HandleFunc("POST /diary/{id}/delete", s.requireLogin(s.handleDiaryDelete))
 	s.mux.HandleFunc("GET /photos/{filename}", s.handlePhoto)
 	s.mux.HandleFunc("GET /photos/{user_uuid}/{filename}", s.handlePhotoWithUserUUID)
 	s.mux.HandleFunc("GET /slideshow", s.handleSlideshow)
+	s.mux.HandleFunc("GET /plants", s.handlePlants)
+	s.mux.HandleFunc("GET /plants/{uuid}", s.handlePlant)
+	s.mux.HandleFunc("GET /plants/{uuid}/slideshow", s.handlePlantSlideshow)
+	s.mux.HandleFunc("POST /plants/{uuid}/cover", s.requireLogin(s.handlePlantCover))
+	s.mux.HandleFunc("GET /settings", s.requireLogin(s.handleSettings))
+	s.mux.HandleFunc("POST /settings/visibility", s.requireLogin(s.handleSettingsVisibility))
+	s.mux.HandleFunc("POST /settings/tokens", s.requireLogin(s.handleAPITokenCreate))
+	s.mux.HandleFunc("POST /settings/tokens/{id}/revoke", s.requireLogin(s.handleAPITokenRevoke))
+	s.mux.HandleFunc("GET /settings/prompt", s.requireLogin(s.handlePromptSettings))
+	s.mux.HandleFunc("POST /settings/prompt", s.requireLogin(s.handlePromptSettingsSave))
+	s.mux.HandleFunc("POST /settings/prompt/preview", s.requireLogin(s.handlePromptSettingsPreview))
+	s.mux.HandleFunc("POST /settings/prompt/reset", s.requireLogin(s.handlePromptSettingsReset))
+	s.mux.HandleFunc("GET /admin/usage", s.requireLogin(s.handleAdminUsage))
+	s.mux.HandleFunc("POST /admin/usage/budgets", s.requireLogin(s.handleAdminUsageBudgetSave))
+	s.mux.HandleFunc("POST /admin/usage/budgets/reset", s.requireLogin(s.handleAdminUsageBudgetReset))
+	s.mux.HandleFunc("GET /trash", s.requireLogin(s.handleTrash))
+	s.mux.HandleFunc("POST /trash/{id}/restore", s.requireLogin(s.handleTrashRestore))
+	s.mux.HandleFunc("POST /trash/{id}/purge", s.requireLogin(s.handleTrashPurge))
 	s.mux.HandleFunc("GET /login", s.handleLoginGet)
 	s.mux.HandleFunc("POST /login", s.handleLoginPost)
 	s.mux.HandleFunc("POST /logout", s.handleLogout)
@@ -98,14 +184,14 @@ func (s *Server) getCurrentUser(r *http.Request) (*User, error) {
 	if err != nil {
 		return nil, nil
 	}
-	session, err := s.sessionRepo.GetSessionByID(cookie.Value)
+	session, err := s.sessionRepo.GetSessionByID(r.Context(), cookie.Value)
 	if err != nil {
 		return nil, err
 	}
 	if session == nil {
 		return nil, nil
 	}
-	return s.userRepo.GetUserByID(session.UserID)
+	return s.userRepo.GetUserByID(r.Context(), session.UserID)
 }
 
 // requireLogin は未ログイン時に /login へリダイレクトするミドルウェア
@@ -125,6 +211,62 @@ func (s *Server) requireLogin(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// viewerScope は閲覧者に一覧表示する範囲を返す。ログイン中は本人のもの、未ログインの場合は日記を公開しているユーザーのもの
+func viewerScope(viewer *User) OwnerScope {
+	if viewer != nil {
+		return OwnerScope{UserID: viewer.ID}
+	}
+	return OwnerScope{PublicOnly: true}
+}
+
+// canView は指定ユーザーが所有する日記・植物を閲覧者に表示できるかどうかを返す。
+// 本人か、所有ユーザーが日記を公開している場合に表示できる
+func (s *Server) canView(ctx context.Context, ownerID int, viewer *User) (bool, error) {
+	if viewer != nil && viewer.ID == ownerID {
+		return true, nil
+	}
+	owner, err := s.userRepo.GetUserByID(ctx, ownerID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get owner %d: %w", ownerID, err)
+	}
+	return owner != nil && owner.DiariesPublic, nil
+}
+
+// loadOwnedDiary はパスのidの日記とログイン中のユーザーを取得し、ユーザーが日記の所有者であることを確認する。
+// 日記が見つからない場合は404、所有者でない場合は403のエラーページを表示してfalseを返す
+func (s *Server) loadOwnedDiary(w http.ResponseWriter, r *http.Request) (*Diary, *User, bool) {
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("ERROR: invalid diary id: %s", idStr)
+		s.renderError(w, http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	diary, err := s.repo.GetDiaryByID(r.Context(), id)
+	if err != nil {
+		log.Printf("ERROR: failed to get diary %d: %v", id, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if diary == nil {
+		s.renderError(w, http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	currentUser, err := s.getCurrentUser(r)
+	if err != nil {
+		log.Printf("ERROR: failed to get current user: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if currentUser == nil || diary.UserID != currentUser.ID {
+		s.renderError(w, http.StatusForbidden)
+		return nil, nil, false
+	}
+	return diary, currentUser, true
+}
+
 // handleLoginGet はログインフォームページを表示する
 func (s *Server) handleLoginGet(w http.ResponseWriter, r *http.Request) {
 	data := map[string]interface{}{
@@ -153,7 +295,7 @@ func (s *Server) handleLoginPost(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	user, err := s.userRepo.GetUserByUsername(username)
+	user, err := s.userRepo.GetUserByUsername(r.Context(), username)
 	if err != nil {
 		log.Printf("ERROR: failed to get user: %v", err)
 		s.renderError(w, http.StatusInternalServerError)
@@ -177,7 +319,7 @@ func (s *Server) handleLoginPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	expiresAt := time.Now().Add(7 * 24 * time.Hour)
-	if err := s.sessionRepo.CreateSession(sessionID, user.ID, expiresAt); err != nil {
+	if err := s.sessionRepo.CreateSession(r.Context(), sessionID, user.ID, expiresAt); err != nil {
 		log.Printf("ERROR: failed to create session: %v", err)
 		s.renderError(w, http.StatusInternalServerError)
 		return
@@ -198,7 +340,7 @@ func (s *Server) handleLoginPost(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie("session_id")
 	if err == nil {
-		if err := s.sessionRepo.DeleteSession(cookie.Value); err != nil {
+		if err := s.sessionRepo.DeleteSession(r.Context(), cookie.Value); err != nil {
 			log.Printf("ERROR: failed to delete session: %v", err)
 		}
 	}
@@ -219,9 +361,22 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	yearStr := r.URL.Query().Get("year")
 	monthStr := r.URL.Query().Get("month")
 	keyword := r.URL.Query().Get("q")
+	filter := ObservationFilter{
+		Tag:       r.URL.Query().Get("tag"),
+		Issue:     r.URL.Query().Get("issue"),
+		Flowering: r.URL.Query().Get("flowering") == "1",
+		Fruiting:  r.URL.Query().Get("fruiting") == "1",
+	}
+
+	currentUser, err := s.getCurrentUser(r)
+	if err != nil {
+		log.Printf("ERROR: failed to get current user: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+	scope := viewerScope(currentUser)
 
 	var diaries []Diary
-	var err error
 	selectedYear := 0
 	selectedMonth := 0
 
@@ -232,21 +387,21 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 			jst := time.FixedZone("Asia/Tokyo", 9*60*60)
 			startDateJST := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, jst)
 			endDateJST := startDateJST.AddDate(0, 1, 0).Add(-time.Nanosecond)
-			diaries, err = s.repo.GetDiariesInDateRange(startDateJST.UTC(), endDateJST.UTC())
+			diaries, err = s.repo.GetDiariesInDateRange(r.Context(), scope, startDateJST.UTC(), endDateJST.UTC())
 			selectedYear = year
 			selectedMonth = month
 		} else {
 			if keyword != "" {
-				diaries, err = s.repo.SearchDiaries(keyword)
+				diaries, err = s.repo.SearchDiaries(r.Context(), scope, keyword)
 			} else {
-				diaries, err = s.repo.GetAllDiaries()
+				diaries, err = s.repo.GetAllDiaries(r.Context(), scope)
 			}
 		}
 	} else {
 		if keyword != "" {
-			diaries, err = s.repo.SearchDiaries(keyword)
+			diaries, err = s.repo.SearchDiaries(r.Context(), scope, keyword)
 		} else {
-			diaries, err = s.repo.GetAllDiaries()
+			diaries, err = s.repo.GetAllDiaries(r.Context(), scope)
 		}
 	}
 
@@ -269,7 +424,40 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		diaries = filtered
 	}
 
-	availableMonths, err := s.repo.GetAvailableYearMonths()
+	// 植物の状態による絞り込みもインメモリで行う。選択肢は月別・キーワードで絞り込んだ日記の植物の状態から作る
+	diaryIDs := make([]int, len(diaries))
+	for i, d := range diaries {
+		diaryIDs[i] = d.ID
+	}
+	observations, err := s.repo.GetDiaryObservations(r.Context(), diaryIDs)
+	if err != nil {
+		log.Printf("ERROR: failed to get diary observations: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+	availableTags, availableIssues := observationLabels(observations)
+	// 該当する日記がない条件も選択中として表示できるよう、選択肢に加える
+	if filter.Tag != "" && !slices.Contains(availableTags, filter.Tag) {
+		availableTags = append(availableTags, filter.Tag)
+	}
+	if filter.Issue != "" && !slices.Contains(availableIssues, filter.Issue) {
+		availableIssues = append(availableIssues, filter.Issue)
+	}
+	if !filter.IsZero() {
+		filtered := make([]Diary, 0, len(diaries))
+		for _, d := range diaries {
+			var observation *DiaryObservation
+			if o, ok := observations[d.ID]; ok {
+				observation = &o
+			}
+			if filter.Match(observation) {
+				filtered = append(filtered, d)
+			}
+		}
+		diaries = filtered
+	}
+
+	availableMonths, err := s.repo.GetAvailableYearMonths(r.Context(), scope)
 	if err != nil {
 		log.Printf("ERROR: failed to get available year months: %v", err)
 		s.renderError(w, http.StatusInternalServerError)
@@ -283,16 +471,9 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	// ImagePathをファイル名のみに変換
+	// ImagePathを写真のURLに変換
 	for i := range diaries {
-		diaries[i].ImagePath = filepath.Base(diaries[i].ImagePath)
-	}
-
-	currentUser, err := s.getCurrentUser(r)
-	if err != nil {
-		log.Printf("ERROR: failed to get current user: %v", err)
-		s.renderError(w, http.StatusInternalServerError)
-		return
+		diaries[i].ImagePath = s.photoURL(diaries[i].ImagePath)
 	}
 
 	loggedIn := currentUser != nil
@@ -307,6 +488,9 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		"SelectedYear":    selectedYear,
 		"SelectedMonth":   selectedMonth,
 		"Keyword":         keyword,
+		"Filter":          filter,
+		"AvailableTags":   availableTags,
+		"AvailableIssues": availableIssues,
 		"LoggedIn":        loggedIn,
 		"Username":        username,
 	}
@@ -328,7 +512,7 @@ func (s *Server) handleDiary(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	diary, err := s.repo.GetDiaryByID(id)
+	diary, err := s.repo.GetDiaryByID(r.Context(), id)
 	if err != nil {
 		log.Printf("ERROR: failed to get diary %d: %v", id, err)
 		s.renderError(w, http.StatusInternalServerError)
@@ -339,10 +523,6 @@ func (s *Server) handleDiary(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// ImagePathからファイル名のみを抽出（表示用コピー）
-	diaryView := *diary
-	diaryView.ImagePath = filepath.Base(diary.ImagePath)
-
 	currentUser, err := s.getCurrentUser(r)
 	if err != nil {
 		log.Printf("ERROR: failed to get current user: %v", err)
@@ -350,78 +530,153 @@ func (s *Server) handleDiary(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 非公開ユーザーの日記は本人以外には存在しないものとして扱う
+	visible, err := s.canView(r.Context(), diary.UserID, currentUser)
+	if err != nil {
+		log.Printf("ERROR: failed to check visibility of diary %d: %v", id, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+	if !visible {
+		s.renderError(w, http.StatusNotFound)
+		return
+	}
+
+	// ImagePathを写真のURLに変換（表示用コピー）
+	diaryView := *diary
+	diaryView.ImagePath = s.photoURL(diary.ImagePath)
+
+	var plant *Plant
+	if diary.PlantID != 0 {
+		plant, err = s.plantRepo.GetPlantByID(r.Context(), diary.PlantID)
+		if err != nil {
+			log.Printf("ERROR: failed to get plant %d: %v", diary.PlantID, err)
+			s.renderError(w, http.StatusInternalServerError)
+			return
+		}
+	}
+
 	loggedIn := currentUser != nil
+	isOwner := loggedIn && currentUser.ID == diary.UserID
 	username := ""
 	if currentUser != nil {
 		username = currentUser.Username
 	}
 
-	data := map[string]interface{}{
-		"Diary":    &diaryView,
-		"LoggedIn": loggedIn,
-		"Username": username,
-	}
-
-	if err := s.templates.ExecuteTemplate(w, "detail.html", data); err != nil {
-		log.Printf("ERROR: failed to render detail template for diary %d: %v", id, err)
+	observations, err := s.repo.GetDiaryObservations(r.Context(), []int{id})
+	if err != nil {
+		log.Printf("ERROR: failed to get observation of diary %d: %v", id, err)
 		s.renderError(w, http.StatusInternalServerError)
 		return
 	}
-}
+	var observation *DiaryObservation
+	if o, ok := observations[id]; ok {
+		observation = &o
+	}
 
-// handleDiaryEditGet は日記編集フォームページを表示する
-func (s *Server) handleDiaryEditGet(w http.ResponseWriter, r *http.Request) {
-	idStr := r.PathValue("id")
-	id, err := strconv.Atoi(idStr)
+	photoMeta, err := s.photoMetaRepo.GetPhotoMetadata(r.Context(), diary.ImagePath)
 	if err != nil {
-		log.Printf("ERROR: invalid diary id: %s", idStr)
-		s.renderError(w, http.StatusNotFound)
+		log.Printf("ERROR: failed to get photo metadata of diary %d: %v", id, err)
+		s.renderError(w, http.StatusInternalServerError)
 		return
 	}
 
-	diary, err := s.repo.GetDiaryByID(id)
+	similarPhotos, err := s.similarPhotos(r.Context(), diary)
 	if err != nil {
-		log.Printf("ERROR: failed to get diary %d: %v", id, err)
+		log.Printf("ERROR: failed to get similar photos of diary %d: %v", id, err)
 		s.renderError(w, http.StatusInternalServerError)
 		return
 	}
-	if diary == nil {
-		s.renderError(w, http.StatusNotFound)
-		return
+
+	data := map[string]interface{}{
+		"Diary":         &diaryView,
+		"Plant":         plant,
+		"Observation":   observation,
+		"PhotoMeta":     photoMeta,
+		"SimilarPhotos": similarPhotos,
+		"LoggedIn":      loggedIn,
+		"IsOwner":       isOwner,
+		"Username":      username,
+	}
+
+	// 編集操作と版履歴は所有ユーザーにのみ表示する
+	if isOwner {
+		history, err := s.diaryHistory(r.Context(), id)
+		if err != nil {
+			log.Printf("ERROR: failed to get history of diary %d: %v", id, err)
+			s.renderError(w, http.StatusInternalServerError)
+			return
+		}
+		data["History"] = history
 	}
 
-	currentUser, err := s.getCurrentUser(r)
-	if err != nil {
-		log.Printf("ERROR: failed to get current user: %v", err)
+	if err := s.templates.ExecuteTemplate(w, "detail.html", data); err != nil {
+		log.Printf("ERROR: failed to render detail template for diary %d: %v", id, err)
 		s.renderError(w, http.StatusInternalServerError)
 		return
 	}
+}
 
-	username := ""
-	if currentUser != nil {
-		username = currentUser.Username
+// similarPhotoEntry は詳細ページの「似ている写真」に表示する写真
+type similarPhotoEntry struct {
+	SimilarPhoto
+	URL    string // 写真の配信URL
+	Merged bool   // 重複として統合した写真かどうか（日記はない）
+}
+
+// similarPhotos は日記と同じユーザー・植物の写真のうち、知覚ハッシュの近い写真を近い順に最大 maxSimilarPhotos 件返す。
+// 日記の写真の知覚ハッシュが未記録の場合は空を返す
+func (s *Server) similarPhotos(ctx context.Context, diary *Diary) ([]similarPhotoEntry, error) {
+	target, err := s.photoHashRepo.GetPhotoHash(ctx, diary.ImagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get photo hash: %w", err)
+	}
+	if target == nil {
+		return nil, nil
+	}
+	candidates, err := s.photoHashRepo.FindPhotoHashes(ctx, diary.UserID, diary.PlantID, time.Time{}, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find photo hashes: %w", err)
+	}
+
+	var entries []similarPhotoEntry
+	for _, similar := range findSimilarPhotos(*target, candidates, similarPhotoDistance) {
+		if len(entries) == maxSimilarPhotos {
+			break
+		}
+		entries = append(entries, similarPhotoEntry{
+			SimilarPhoto: similar,
+			URL:          s.photoURL(similar.ImagePath),
+			Merged:       similar.DuplicateOf != "",
+		})
+	}
+	return entries, nil
+}
+
+// handleDiaryEditGet は日記編集フォームページを表示する
+func (s *Server) handleDiaryEditGet(w http.ResponseWriter, r *http.Request) {
+	diary, currentUser, ok := s.loadOwnedDiary(w, r)
+	if !ok {
+		return
 	}
 
 	data := map[string]interface{}{
 		"Diary":    diary,
 		"LoggedIn": true,
-		"Username": username,
+		"Username": currentUser.Username,
 	}
 
 	if err := s.templates.ExecuteTemplate(w, "edit.html", data); err != nil {
-		log.Printf("ERROR: failed to render edit template for diary %d: %v", id, err)
+		log.Printf("ERROR: failed to render edit template for diary %d: %v", diary.ID, err)
 		s.renderError(w, http.StatusInternalServerError)
 		return
 	}
 }
 
-// handleDiaryEditPost は日記のcontentを更新して詳細ページへリダイレクトする
+// handleDiaryEditPost は日記のcontentを手動編集の版として更新し、詳細ページへリダイレクトする
 func (s *Server) handleDiaryEditPost(w http.ResponseWriter, r *http.Request) {
-	idStr := r.PathValue("id")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		log.Printf("ERROR: invalid diary id: %s", idStr)
-		s.renderError(w, http.StatusNotFound)
+	diary, currentUser, ok := s.loadOwnedDiary(w, r)
+	if !ok {
 		return
 	}
 
@@ -431,27 +686,19 @@ func (s *Server) handleDiaryEditPost(w http.ResponseWriter, r *http.Request) {
 	}
 	content := r.FormValue("content")
 
-	diary, err := s.repo.GetDiaryByID(id)
-	if err != nil {
-		log.Printf("ERROR: failed to get diary %d: %v", id, err)
-		s.renderError(w, http.StatusInternalServerError)
-		return
-	}
-	if diary == nil {
-		s.renderError(w, http.StatusNotFound)
-		return
-	}
-
-	if err := s.repo.UpdateDiaryContent(id, content); err != nil {
-		log.Printf("ERROR: failed to update diary %d: %v", id, err)
-		s.renderError(w, http.StatusInternalServerError)
-		return
+	if content != diary.Content {
+		// 編集前の本文を失わないよう版履歴に記録して置き換える
+		if _, err := s.revisionRepo.ReplaceDiaryContent(r.Context(), diary.ID, content, RevisionSourceManualEdit, currentUser.ID); err != nil {
+			log.Printf("ERROR: failed to update diary %d: %v", diary.ID, err)
+			s.renderError(w, http.StatusInternalServerError)
+			return
+		}
 	}
 
-	http.Redirect(w, r, fmt.Sprintf("/diary/%d", id), http.StatusFound)
+	http.Redirect(w, r, fmt.Sprintf("/diary/%d", diary.ID), http.StatusFound)
 }
 
-// handlePhoto は画像ファイルを配信する
+// handlePhoto は画像ファイルを配信する（?size= で縮小画像を指定できる）
 func (s *Server) handlePhoto(w http.ResponseWriter, r *http.Request) {
 	filename := r.PathValue("filename")
 
@@ -462,11 +709,16 @@ func (s *Server) handlePhoto(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 写真ディレクトリ直下の写真はsystemユーザーのもの
+	if !s.checkPhotoVisible(w, r, systemUserUUID) {
+		return
+	}
+
 	filePath := filepath.Join(s.photosDir, filename)
-	http.ServeFile(w, r, filePath)
+	s.servePhoto(w, r, filePath)
 }
 
-// handlePhotoWithUserUUID はユーザーUUID配下の画像ファイルを配信する
+// handlePhotoWithUserUUID はユーザーUUID配下の画像ファイルを配信する（?size= で縮小画像を指定できる）
 func (s *Server) handlePhotoWithUserUUID(w http.ResponseWriter, r *http.Request) {
 	userUUID := r.PathValue("user_uuid")
 	filename := r.PathValue("filename")
@@ -481,16 +733,93 @@ func (s *Server) handlePhotoWithUserUUID(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if !s.checkPhotoVisible(w, r, userUUID) {
+		return
+	}
+
 	filePath := filepath.Join(s.photosDir, userUUID, filename)
-	http.ServeFile(w, r, filePath)
+	s.servePhoto(w, r, filePath)
+}
+
+// checkPhotoVisible は指定UUIDのユーザーの写真を閲覧者に表示できるかどうかを確認する。
+// 日記と同じく、ユーザーが存在しないか非公開ユーザーの写真を本人以外が要求した場合は404を表示してfalseを返す
+func (s *Server) checkPhotoVisible(w http.ResponseWriter, r *http.Request, ownerUUID string) bool {
+	owner, err := s.userRepo.GetUserByUUID(r.Context(), ownerUUID)
+	if err != nil {
+		log.Printf("ERROR: failed to get user by UUID %s: %v", ownerUUID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return false
+	}
+	if owner == nil {
+		s.renderError(w, http.StatusNotFound)
+		return false
+	}
+
+	currentUser, err := s.getCurrentUser(r)
+	if err != nil {
+		log.Printf("ERROR: failed to get current user: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return false
+	}
+	if (currentUser == nil || currentUser.ID != owner.ID) && !owner.DiariesPublic {
+		s.renderError(w, http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// servePhoto は写真を配信する。?size= で縮小画像のサイズ（thumb・medium）を指定した場合は縮小画像を配信し、
+// 縮小画像がなければその場で生成する。生成できない場合は元の写真を配信する
+func (s *Server) servePhoto(w http.ResponseWriter, r *http.Request, filePath string) {
+	name := r.URL.Query().Get("size")
+	if name == "" {
+		http.ServeFile(w, r, filePath)
+		return
+	}
+	size, ok := lookupPhotoSize(name)
+	if !ok {
+		s.renderError(w, http.StatusNotFound)
+		return
+	}
+
+	path, err := ensurePhotoDerivative(s.photosDir, filePath, size)
+	if err != nil {
+		if _, statErr := os.Stat(filePath); statErr == nil {
+			log.Printf("WARN: failed to prepare %s photo of %s: %v, serving original", size.Name, filePath, err)
+		}
+		http.ServeFile(w, r, filePath)
+		return
+	}
+	http.ServeFile(w, r, path)
 }
 
 // handleSlideshow はスライドショーページを表示する
 func (s *Server) handleSlideshow(w http.ResponseWriter, r *http.Request) {
-	fromStr := r.URL.Query().Get("from")
-	toStr := r.URL.Query().Get("to")
+	fromStr, toStr, from, to := parseSlideshowRange(r)
+
+	currentUser, err := s.getCurrentUser(r)
+	if err != nil {
+		log.Printf("ERROR: failed to get current user: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	diaries, err := s.repo.GetDiariesAsc(r.Context(), viewerScope(currentUser), from, to)
+	if err != nil {
+		log.Printf("ERROR: failed to get diaries for slideshow: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	s.renderSlideshow(w, diaries, fromStr, toStr, nil)
+}
+
+// parseSlideshowRange はスライドショーの期間指定（from/to、JSTの日付）を解析する。
+// 指定がないか不正な値の場合、対応する日時はゼロ値になる
+func parseSlideshowRange(r *http.Request) (fromStr, toStr string, from, to time.Time) {
+	fromStr = r.URL.Query().Get("from")
+	toStr = r.URL.Query().Get("to")
 
-	var from, to time.Time
 	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
 
 	if fromStr != "" {
@@ -506,15 +835,12 @@ func (s *Server) handleSlideshow(w http.ResponseWriter, r *http.Request) {
 			to = t.Add(24*time.Hour - time.Nanosecond).UTC()
 		}
 	}
+	return fromStr, toStr, from, to
+}
 
-	diaries, err := s.repo.GetDiariesAsc(from, to)
-	if err != nil {
-		log.Printf("ERROR: failed to get diaries for slideshow: %v", err)
-		s.renderError(w, http.StatusInternalServerError)
-		return
-	}
-
-	// ImagePathをファイル名のみに変換し、JavaScript用データを準備
+// renderSlideshow は古い順に並んだ日記をスライドショーページとして表示する。plantがnilの場合は全日記のスライドショーになる
+func (s *Server) renderSlideshow(w http.ResponseWriter, diaries []Diary, fromStr, toStr string, plant *Plant) {
+	// ImagePathを写真のURLに変換し、JavaScript用データを準備
 	jstZone := time.FixedZone("Asia/Tokyo", 9*60*60)
 	weekdays := []string{"日", "月", "火", "水", "木", "金", "土"}
 	type photoItem struct {
@@ -524,7 +850,7 @@ func (s *Server) handleSlideshow(w http.ResponseWriter, r *http.Request) {
 	}
 	photos := make([]photoItem, 0, len(diaries))
 	for i := range diaries {
-		diaries[i].ImagePath = filepath.Base(diaries[i].ImagePath)
+		diaries[i].ImagePath = s.photoURL(diaries[i].ImagePath)
 		t := diaries[i].CreatedAt.In(jstZone)
 		dateTime := fmt.Sprintf("%d年%d月%d日（%s）%s",
 			t.Year(), int(t.Month()), t.Day(),
@@ -532,7 +858,7 @@ func (s *Server) handleSlideshow(w http.ResponseWriter, r *http.Request) {
 			t.Format("15:04"),
 		)
 		photos = append(photos, photoItem{
-			URL:      "/photos/" + diaries[i].ImagePath,
+			URL:      diaries[i].ImagePath + "?size=medium",
 			DateTime: dateTime,
 			DiaryID:  diaries[i].ID,
 		})
@@ -544,11 +870,22 @@ func (s *Server) handleSlideshow(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 期間指定フォームの遷移先と戻り先
+	slideshowPath := "/slideshow"
+	backPath := "/"
+	if plant != nil {
+		slideshowPath = "/plants/" + plant.UUID + "/slideshow"
+		backPath = "/plants/" + plant.UUID
+	}
+
 	data := map[string]interface{}{
-		"Diaries":    diaries,
-		"From":       fromStr,
-		"To":         toStr,
-		"PhotosJSON": template.JS(photosJSON),
+		"Diaries":       diaries,
+		"From":          fromStr,
+		"To":            toStr,
+		"PhotosJSON":    template.JS(photosJSON),
+		"Plant":         plant,
+		"SlideshowPath": slideshowPath,
+		"BackPath":      backPath,
 	}
 
 	if err := s.templates.ExecuteTemplate(w, "slideshow.html", data); err != nil {
@@ -558,48 +895,125 @@ func (s *Server) handleSlideshow(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// PostApiPhotos は写真アップロードAPIのハンドラ（POST /api/photos）
-func (s *Server) PostApiPhotos(w http.ResponseWriter, r *http.Request) {
-	// UPLOAD_API_KEY が未設定の場合は 503
-	apiKey := os.Getenv("UPLOAD_API_KEY")
-	if apiKey == "" {
-		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
-		return
+// authenticateAPIToken は X-API-Key ヘッダーの個人用APIトークンを検証し、トークンの所有ユーザーを返す。
+// トークンのスコープがallowedのいずれにも該当しない場合は403とする（adminスコープは常に許可）。
+// 認証できない場合はエラーレスポンスを書き込んでfalseを返す
+func (s *Server) authenticateAPIToken(w http.ResponseWriter, r *http.Request, allowed ...APITokenScope) (*User, bool) {
+	token := r.Header.Get("X-API-Key")
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	// 平文のトークンは保存していないため、ハッシュ値で照合する
+	apiToken, err := s.apiTokenRepo.GetAPITokenByHash(r.Context(), hashAPIToken(token))
+	if err != nil {
+		log.Printf("ERROR: failed to get API token: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if apiToken == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	user, err := s.userRepo.GetUserByID(r.Context(), apiToken.UserID)
+	if err != nil {
+		log.Printf("ERROR: failed to get user %d of API token %d: %v", apiToken.UserID, apiToken.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
 	}
 
-	// X-API-Key ヘッダーの検証（タイミング攻撃防止のため定数時間比較を使用）
-	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-API-Key")), []byte(apiKey)) != 1 {
+	if !allowsAPITokenScope(apiToken.Scope, allowed...) {
+		http.Error(w, "Forbidden: insufficient token scope", http.StatusForbidden)
+		return nil, false
+	}
+	// adminスコープのトークンは、所有ユーザーが ADMIN_USERS から外れた場合は使えない
+	if apiToken.Scope == APITokenScopeAdmin && !s.isAdmin(user) {
+		http.Error(w, "Forbidden: admin token of a non-admin user", http.StatusForbidden)
+		return nil, false
+	}
+
+	// 最終使用日時の記録に失敗してもリクエストは処理する
+	if err := s.apiTokenRepo.TouchAPIToken(r.Context(), apiToken.ID, time.Now()); err != nil {
+		log.Printf("WARN: failed to update last used time of API token %d: %v", apiToken.ID, err)
+	}
+	return user, true
+}
+
+// authenticateSetupToken は X-API-Key ヘッダーが環境変数 SETUP_TOKEN と一致するかどうかを検証する。
+// SETUP_TOKEN が未設定の場合は初期設定を受け付けず503、一致しない場合は401を書き込んでfalseを返す
+func (s *Server) authenticateSetupToken(w http.ResponseWriter, r *http.Request) bool {
+	if s.setupToken == "" {
+		http.Error(w, "Service Unavailable: SETUP_TOKEN is not set", http.StatusServiceUnavailable)
+		return false
+	}
+	// タイミング攻撃防止のため定数時間比較を使用
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-API-Key")), []byte(s.setupToken)) != 1 {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// matchesTokenUser はリクエストで指定されたユーザーUUIDがAPIトークンの所有ユーザーと一致するかどうかを返す。
+// 省略された場合は一致とみなし、一致しない場合は403を書き込んでfalseを返す
+func matchesTokenUser(w http.ResponseWriter, userUUID string, user *User) bool {
+	if userUUID != "" && userUUID != user.UUID {
+		http.Error(w, "Forbidden: user_uuid does not match the API token", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// PostApiPhotos は写真アップロードAPIのハンドラ（POST /api/photos）。
+// Idempotency-Key ヘッダーを指定した場合、同じキーの再送には写真を保存し直さず最初のリクエストのジョブを返す
+func (s *Server) PostApiPhotos(w http.ResponseWriter, r *http.Request, params PostApiPhotosParams) {
+	// 写真はトークンの所有ユーザーの日記として登録する
+	user, ok := s.authenticateAPIToken(w, r, APITokenScopeUpload)
+	if !ok {
 		return
 	}
 
-	// multipart/form-data のパース（最大32MB）
+	// multipart/form-data のパース（メモリに保持するのは最大32MB、リクエスト全体は写真の上限まで）
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadPhotoBytes+uploadFormOverheadBytes)
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
 
-	// user_uuid の取得・検証
-	userUUID := r.FormValue("user_uuid")
-	if len(userUUID) != 32 {
-		http.Error(w, "Bad Request: user_uuid must be 32 characters", http.StatusBadRequest)
+	// user_uuid は互換性のため受け付けるが、トークンのユーザーと一致する必要がある
+	if !matchesTokenUser(w, r.FormValue("user_uuid"), user) {
 		return
 	}
 
-	// UUIDからユーザーを取得
-	user, err := s.userRepo.GetUserByUUID(userUUID)
-	if err != nil {
-		log.Printf("ERROR: failed to get user by UUID: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-	if user == nil {
-		http.Error(w, "Bad Request: user not found", http.StatusBadRequest)
-		return
+	// plant_uuid の検証（省略時は植物未指定）
+	plantID := 0
+	if plantUUID := r.FormValue("plant_uuid"); plantUUID != "" {
+		plant, err := s.plantRepo.GetPlantByUUID(r.Context(), plantUUID)
+		if err != nil {
+			log.Printf("ERROR: failed to get plant by UUID: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if plant == nil || plant.UserID != user.ID {
+			http.Error(w, "Bad Request: plant not found", http.StatusBadRequest)
+			return
+		}
+		plantID = plant.ID
 	}
 
-	// captured_at の解析（省略時はサーバー受信時刻）
-	capturedAt := time.Now().UTC()
+	// captured_at の解析（省略時はEXIFの撮影日時、EXIFにもない場合はサーバー受信時刻）
+	var capturedAt time.Time
 	if capturedAtStr := r.FormValue("captured_at"); capturedAtStr != "" {
 		t, err := time.Parse(time.RFC3339, capturedAtStr)
 		if err != nil {
@@ -609,88 +1023,246 @@ func (s *Server) PostApiPhotos(w http.ResponseWriter, r *http.Request) {
 		capturedAt = t.UTC()
 	}
 
+	// duplicate・duplicate_window の解析（省略時は重複する写真も通常どおり登録する）
+	duplicateMode := Keep
+	if v := r.FormValue("duplicate"); v != "" {
+		duplicateMode = UploadPhotoRequestDuplicate(v)
+		if !duplicateMode.Valid() {
+			http.Error(w, "Bad Request: invalid duplicate", http.StatusBadRequest)
+			return
+		}
+	}
+	duplicateWindow := defaultDuplicateWindow
+	if v := r.FormValue("duplicate_window"); v != "" {
+		sec, err := strconv.Atoi(v)
+		if err != nil || sec < 1 || sec > int(maxDuplicateWindow/time.Second) {
+			http.Error(w, "Bad Request: invalid duplicate_window", http.StatusBadRequest)
+			return
+		}
+		duplicateWindow = time.Duration(sec) * time.Second
+	}
+
 	// 写真ファイルの取得
-	file, _, err := r.FormFile("photo")
+	file, header, err := r.FormFile("photo")
 	if err != nil {
 		http.Error(w, "Bad Request: photo field is required", http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
+	if header.Size > maxUploadPhotoBytes {
+		http.Error(w, "Request Entity Too Large: photo exceeds size limit", http.StatusRequestEntityTooLarge)
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		log.Printf("ERROR: failed to read uploaded photo: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// 同じ Idempotency-Key のリクエストを処理済みの場合は、最初のリクエストと同じ結果を返す
+	idempotencyKey := ""
+	if params.IdempotencyKey != nil {
+		idempotencyKey = *params.IdempotencyKey
+		if !validIdempotencyKey(idempotencyKey) {
+			http.Error(w, "Bad Request: invalid Idempotency-Key", http.StatusBadRequest)
+			return
+		}
+		fingerprint := uploadFingerprint(data, r.FormValue("plant_uuid"), r.FormValue("captured_at"))
+		if !s.reserveUploadIdempotencyKey(w, r, user.ID, idempotencyKey, fingerprint) {
+			return
+		}
+	}
+	completed := false
+	defer func() {
+		if idempotencyKey == "" || completed {
+			return
+		}
+		// 失敗したリクエストは同じキーで再送できるようにする（クライアントが切断していても削除する）
+		if err := s.uploadKeyRepo.DeleteUploadIdempotencyKey(context.WithoutCancel(r.Context()), user.ID, idempotencyKey); err != nil {
+			log.Printf("WARN: failed to release idempotency key of user %d: %v", user.ID, err)
+		}
+	}()
+
+	// 写真の検証とJPEGへの変換（位置情報は除き、EXIFの撮影情報は別に保存する）
+	photo, meta, err := NormalizeUploadedPhoto(data)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnsupportedPhotoFormat):
+			http.Error(w, "Unsupported Media Type: photo must be JPEG, PNG, WebP or GIF", http.StatusUnsupportedMediaType)
+		case errors.Is(err, ErrPhotoTooLarge):
+			http.Error(w, "Request Entity Too Large: photo exceeds size limit", http.StatusRequestEntityTooLarge)
+		case errors.Is(err, ErrInvalidPhoto):
+			http.Error(w, "Bad Request: invalid photo", http.StatusBadRequest)
+		default:
+			log.Printf("ERROR: failed to normalize uploaded photo: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if capturedAt.IsZero() {
+		capturedAt = time.Now().UTC()
+		if meta != nil && meta.CapturedAt != nil {
+			capturedAt = *meta.CapturedAt
+		}
+	}
+
+	// 知覚ハッシュを計算し、指定された場合は同じ植物の撮影日時の近い写真から重複する写真を探す
+	hash, err := computeJPEGPhotoHash(photo)
+	if err != nil {
+		log.Printf("ERROR: failed to compute photo hash: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	var duplicate *SimilarPhoto
+	if duplicateMode != Keep {
+		duplicate, err = s.findDuplicatePhoto(r.Context(), user.ID, plantID, hash, capturedAt, duplicateWindow)
+		if err != nil {
+			log.Printf("ERROR: failed to find duplicate photo: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if duplicate != nil && duplicateMode == Skip {
+			completed = true
+			s.completeUploadIdempotencyKeyDuplicate(r, user.ID, idempotencyKey, Skipped, *duplicate, "")
+			s.writeUploadPhotoDuplicateResponse(w, Skipped, *duplicate, "")
+			return
+		}
+	}
 
 	// 保存先ディレクトリの作成
-	userDir := filepath.Join(s.photosDir, userUUID)
+	userDir := filepath.Join(s.photosDir, user.UUID)
 	if err := os.MkdirAll(userDir, 0755); err != nil {
 		log.Printf("ERROR: failed to create user photo dir %s: %v", userDir, err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	// ファイル名生成（YYYYMMDD_HHMMSS_UTC.jpg）秒単位で衝突を回避
-	filename := capturedAt.Format("20060102_150405") + "_UTC.jpg"
-	imagePath := filepath.Join(userDir, filename)
-
-	// ファイルの保存
-	dst, err := os.Create(imagePath)
+	// ファイルの保存（YYYYMMDD_HHMMSS_UTC_<内容のハッシュ>.jpg）。同じ名前のファイルがあっても上書きしない
+	imagePath, err := storePhoto(userDir, photoFileName(capturedAt, photo), photo)
 	if err != nil {
-		log.Printf("ERROR: failed to create photo file %s: %v", imagePath, err)
+		log.Printf("ERROR: failed to save photo file in %s: %v", userDir, err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	photoHash := PhotoHash{ImagePath: imagePath, UserID: user.ID, PlantID: plantID, Hash: hash, CapturedAt: capturedAt}
 
-	if _, err := io.Copy(dst, file); err != nil {
-		dst.Close()
-		os.Remove(imagePath)
-		log.Printf("ERROR: failed to save photo file %s: %v", imagePath, err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	// 重複する写真に統合する場合は日記を生成しない（ポーリングでも日記を作らないよう、統合先を記録してから応答する）
+	if duplicate != nil {
+		photoHash.DuplicateOf = duplicate.ImagePath
+		if err := s.photoHashRepo.SavePhotoHash(r.Context(), photoHash); err != nil {
+			os.Remove(imagePath)
+			log.Printf("ERROR: failed to save photo hash of %s: %v", imagePath, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		completed = true
+		s.completeUploadIdempotencyKeyDuplicate(r, user.ID, idempotencyKey, Merged, *duplicate, imagePath)
+		s.saveUploadedPhotoDetails(r.Context(), imagePath, meta)
+		s.writeUploadPhotoDuplicateResponse(w, Merged, *duplicate, imagePath)
 		return
 	}
-	dst.Close()
 
-	// ジョブIDを生成
-	jobID, err := generateUUID()
+	// 日記生成ジョブを登録（Workerが非同期に日記を生成・保存する）
+	jobID, err := s.worker.Enqueue(r.Context(), user.ID, plantID, imagePath, capturedAt)
 	if err != nil {
-		log.Printf("ERROR: failed to generate job ID: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		// ジョブを受け付けられなかった写真は日記が作られないため削除する
+		os.Remove(imagePath)
+		switch {
+		case errors.Is(err, ErrQueueFull):
+			log.Printf("WARN: job queue is full, rejected %s", imagePath)
+			w.Header().Set("Retry-After", strconv.Itoa(uploadRetryAfterSeconds))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		case errors.Is(err, ErrWorkerStopped):
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		default:
+			log.Printf("ERROR: failed to enqueue job for %s: %v", imagePath, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	// goroutineで非同期に日記を生成・保存
-	go func() {
-		startOfDay := time.Date(capturedAt.Year(), capturedAt.Month(), capturedAt.Day(), 0, 0, 0, 0, capturedAt.Location())
-		oneMonthAgo := startOfDay.AddDate(0, -1, 0)
-		endOfPrevDay := startOfDay.Add(-time.Nanosecond)
-		pastDiaries, err := s.repo.GetDiariesInDateRange(oneMonthAgo, endOfPrevDay)
-		if err != nil {
-			log.Printf("WARN: failed to get past diaries for %s: %v, continuing with empty history", imagePath, err)
-			pastDiaries = []Diary{}
+	// ジョブを登録した後はキーを解放しない（記録に失敗した場合も、処理中のまま期限切れになるまで再送を受け付けない）
+	completed = true
+	if idempotencyKey != "" {
+		if err := s.uploadKeyRepo.CompleteUploadIdempotencyKey(context.WithoutCancel(r.Context()), user.ID, idempotencyKey, jobID); err != nil {
+			log.Printf("WARN: failed to record idempotency key of user %d: %v", user.ID, err)
 		}
+	}
+
+	// 重複の検出に使う知覚ハッシュを保存する。失敗しても日記の生成には影響しないため、アップロードは成功とする
+	if err := s.photoHashRepo.SavePhotoHash(r.Context(), photoHash); err != nil {
+		log.Printf("WARN: failed to save photo hash of %s: %v", imagePath, err)
+	}
+	s.saveUploadedPhotoDetails(r.Context(), imagePath, meta)
 
-		prompt := buildDiaryPrompt(pastDiaries)
+	writeUploadPhotoResponse(w, jobID)
+}
 
-		var content string
-		retryErr := Retry(DefaultRetryConfig(), fmt.Sprintf("generate diary for %s", imagePath), func() error {
-			var genErr error
-			if genWithPrompt, ok := s.generator.(DiaryGeneratorWithPrompt); ok {
-				content, genErr = genWithPrompt.GenerateDiaryWithPrompt(imagePath, prompt)
-			} else {
-				content, genErr = s.generator.GenerateDiary(imagePath)
-			}
-			return genErr
-		})
-		if retryErr != nil {
-			log.Printf("ERROR: failed to generate diary for %s: %v", imagePath, retryErr)
-			return
+// saveUploadedPhotoDetails はアップロードされた写真の縮小画像を生成し、撮影情報を保存する。
+// 失敗しても配信時に生成し直せる・日記の生成には影響しないため、ログに残して処理を続ける
+func (s *Server) saveUploadedPhotoDetails(ctx context.Context, imagePath string, meta *PhotoMetadata) {
+	// 一覧やスライドショーで使う縮小画像
+	if err := GeneratePhotoDerivatives(s.photosDir, imagePath); err != nil {
+		log.Printf("WARN: failed to generate photo derivatives of %s: %v", imagePath, err)
+	}
+
+	// 詳細ページで表示する撮影情報
+	if meta != nil {
+		meta.ImagePath = imagePath
+		if err := s.photoMetaRepo.SavePhotoMetadata(ctx, *meta); err != nil {
+			log.Printf("WARN: failed to save photo metadata of %s: %v", imagePath, err)
 		}
+	}
+}
 
-		if err := s.repo.CreateDiaryForUser(user.ID, imagePath, content, capturedAt); err != nil {
-			log.Printf("ERROR: failed to save diary for %s: %v", imagePath, err)
-			return
+// findDuplicatePhoto はユーザーの植物の写真のうち、撮影日時がcapturedAtの前後window以内で、知覚ハッシュが重複とみなせるほど近い写真を返す。
+// 最も近い写真が統合済みの写真の場合は統合先の写真を返す。見つからない場合はnilを返す
+func (s *Server) findDuplicatePhoto(ctx context.Context, userID, plantID int, hash uint64, capturedAt time.Time, window time.Duration) (*SimilarPhoto, error) {
+	candidates, err := s.photoHashRepo.FindPhotoHashes(ctx, userID, plantID, capturedAt.Add(-window), capturedAt.Add(window))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find photo hashes: %w", err)
+	}
+	for _, similar := range findSimilarPhotos(PhotoHash{Hash: hash, CapturedAt: capturedAt}, candidates, nearDuplicatePhotoDistance) {
+		if similar.DuplicateOf == "" {
+			return &similar, nil
+		}
+		original, err := s.photoHashRepo.GetPhotoHash(ctx, similar.DuplicateOf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get photo hash of %s: %w", similar.DuplicateOf, err)
 		}
+		// 統合先の写真が削除済みの場合は次に近い写真を探す
+		if original != nil {
+			return &SimilarPhoto{PhotoHash: *original, Distance: photoHashDistance(hash, original.Hash)}, nil
+		}
+	}
+	return nil, nil
+}
 
-		log.Printf("INFO: diary created for %s (job_id: %s)", imagePath, jobID)
-	}()
+// writeUploadPhotoDuplicateResponse は写真アップロードAPIで重複する写真が見つかった場合のレスポンス（200 OK）を返す。
+// imagePathは統合して保存した写真のパス（保存しなかった場合は空）
+func (s *Server) writeUploadPhotoDuplicateResponse(w http.ResponseWriter, action UploadPhotoDuplicateResponseAction, duplicate SimilarPhoto, imagePath string) {
+	resp := UploadPhotoDuplicateResponse{
+		Action:      action,
+		DuplicateOf: s.photoURL(duplicate.ImagePath),
+		Distance:    duplicate.Distance,
+	}
+	if duplicate.DiaryID != 0 {
+		resp.DuplicateOfDiaryId = &duplicate.DiaryID
+	}
+	if imagePath != "" {
+		imageURL := s.photoURL(imagePath)
+		resp.ImageUrl = &imageURL
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("ERROR: failed to encode response: %v", err)
+	}
+}
 
-	// 202 Accepted を返す
+// writeUploadPhotoResponse は写真アップロードAPIの成功時のレスポンス（202 Accepted とジョブID）を返す
+func writeUploadPhotoResponse(w http.ResponseWriter, jobID string) {
 	resp := UploadPhotoResponse{JobId: jobID}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
@@ -699,18 +1271,138 @@ func (s *Server) PostApiPhotos(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// completeUploadIdempotencyKeyDuplicate は Idempotency-Key に重複する写真としてスキップ・統合した結果を記録する。
+// キーが指定されていない場合は何もしない。記録に失敗した場合も、処理中のまま期限切れになるまで再送を受け付けない
+func (s *Server) completeUploadIdempotencyKeyDuplicate(r *http.Request, userID int, key string, action UploadPhotoDuplicateResponseAction, duplicate SimilarPhoto, imagePath string) {
+	if key == "" {
+		return
+	}
+	if err := s.uploadKeyRepo.CompleteUploadIdempotencyKeyDuplicate(context.WithoutCancel(r.Context()), userID, key, string(action), duplicate.ImagePath, duplicate.Distance, imagePath); err != nil {
+		log.Printf("WARN: failed to record idempotency key of user %d: %v", userID, err)
+	}
+}
+
+// replayUploadPhotoDuplicateResponse は Idempotency-Key に記録した、重複する写真としてスキップ・統合した結果のレスポンスを返し直す
+func (s *Server) replayUploadPhotoDuplicateResponse(w http.ResponseWriter, r *http.Request, key *UploadIdempotencyKey) {
+	duplicate := SimilarPhoto{PhotoHash: PhotoHash{ImagePath: key.DuplicateOf}, Distance: key.Distance}
+	// 重複する写真の日記は最初のリクエストの後に作られることがあるため、改めて取得する
+	original, err := s.photoHashRepo.GetPhotoHash(r.Context(), key.DuplicateOf)
+	if err != nil {
+		log.Printf("WARN: failed to get photo hash of %s: %v", key.DuplicateOf, err)
+	} else if original != nil {
+		duplicate.DiaryID = original.DiaryID
+	}
+	s.writeUploadPhotoDuplicateResponse(w, UploadPhotoDuplicateResponseAction(key.Action), duplicate, key.ImagePath)
+}
+
+// reserveUploadIdempotencyKey は Idempotency-Key を処理中として登録し、trueを返す。キーが使用済みの場合はレスポンスを返してfalseを返す。
+// 同じ内容のリクエストを処理済みの場合は最初のリクエストと同じレスポンス（202、または重複する写真の200。Idempotent-Replayed: true）、処理中の場合は409、
+// 内容の異なるリクエストで使用済みの場合は422を返す。処理中のまま一定時間たったキーは、中断されたリクエストのものとして登録し直す
+func (s *Server) reserveUploadIdempotencyKey(w http.ResponseWriter, r *http.Request, userID int, key, fingerprint string) bool {
+	now := time.Now()
+	existing, err := s.uploadKeyRepo.ReserveUploadIdempotencyKey(r.Context(), userID, key, fingerprint, now.Add(-uploadIdempotencyKeyTTL))
+	if err == nil && existing != nil && existing.Pending() && existing.CreatedAt.Before(now.Add(-uploadIdempotencyPendingTimeout)) {
+		if err = s.uploadKeyRepo.DeleteUploadIdempotencyKey(r.Context(), userID, key); err == nil {
+			existing, err = s.uploadKeyRepo.ReserveUploadIdempotencyKey(r.Context(), userID, key, fingerprint, now.Add(-uploadIdempotencyKeyTTL))
+		}
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to reserve idempotency key of user %d: %v", userID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	if existing == nil {
+		return true
+	}
+
+	switch {
+	case existing.Fingerprint != fingerprint:
+		http.Error(w, "Unprocessable Entity: Idempotency-Key was used for a different request", http.StatusUnprocessableEntity)
+	case existing.Pending():
+		http.Error(w, "Conflict: a request with the same Idempotency-Key is in progress", http.StatusConflict)
+	case existing.Action != "":
+		w.Header().Set("Idempotent-Replayed", "true")
+		s.replayUploadPhotoDuplicateResponse(w, r, existing)
+	default:
+		w.Header().Set("Idempotent-Replayed", "true")
+		writeUploadPhotoResponse(w, existing.JobID)
+	}
+	return false
+}
+
+// validIdempotencyKey は Idempotency-Key が1〜255文字の表示可能なASCII（空白を除く）かどうかを返す
+func validIdempotencyKey(key string) bool {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+// uploadFingerprint は写真アップロードAPIのリクエストの内容（写真・plant_uuid・captured_at）のハッシュを返す
+func uploadFingerprint(photo []byte, plantUUID, capturedAt string) string {
+	h := sha256.New()
+	h.Write(photo)
+	h.Write([]byte{0})
+	h.Write([]byte(plantUUID))
+	h.Write([]byte{0})
+	h.Write([]byte(capturedAt))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// GetApiJobsJobId は日記生成ジョブの状態取得APIのハンドラ（GET /api/jobs/{job_id}）
+func (s *Server) GetApiJobsJobId(w http.ResponseWriter, r *http.Request, jobId string) {
+	user, ok := s.authenticateAPIToken(w, r, APITokenScopeUpload, APITokenScopeRead)
+	if !ok {
+		return
+	}
+
+	job, err := s.jobRepo.GetJobByID(r.Context(), jobId)
+	if err != nil {
+		log.Printf("ERROR: failed to get job %s: %v", jobId, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// 他のユーザーのジョブは存在しないものとして扱う
+	if job == nil || job.UserID != user.ID {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	resp := JobResponse{
+		JobId:     job.ID,
+		Status:    JobResponseStatus(job.Status),
+		Attempts:  job.Attempts,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+	if job.LastError != "" {
+		resp.LastError = &job.LastError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("ERROR: failed to encode response: %v", err)
+	}
+}
+
 // PostApiUsers はユーザー作成APIのハンドラ（POST /api/users）
 func (s *Server) PostApiUsers(w http.ResponseWriter, r *http.Request) {
-	// UPLOAD_API_KEY が未設定の場合は 503
-	apiKey := os.Getenv("UPLOAD_API_KEY")
-	if apiKey == "" {
-		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	// 初期設定としてログイン可能なユーザーがまだいない場合のみ、APIトークンの代わりに SETUP_TOKEN で作成できる
+	hasLoginUser, err := s.userRepo.HasLoginUser(r.Context())
+	if err != nil {
+		log.Printf("ERROR: failed to check existing users: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-
-	// X-API-Key ヘッダーの検証（タイミング攻撃防止のため定数時間比較を使用）
-	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-API-Key")), []byte(apiKey)) != 1 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if hasLoginUser {
+		if _, ok := s.authenticateAPIToken(w, r, APITokenScopeAdmin); !ok {
+			return
+		}
+	} else if !s.authenticateSetupToken(w, r) {
 		return
 	}
 
@@ -726,7 +1418,7 @@ func (s *Server) PostApiUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 重複ユーザー名の確認
-	existing, err := s.userRepo.GetUserByUsername(req.Username)
+	existing, err := s.userRepo.GetUserByUsername(r.Context(), req.Username)
 	if err != nil {
 		log.Printf("ERROR: failed to check existing user: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -754,7 +1446,7 @@ func (s *Server) PostApiUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// ユーザー作成
-	if err := s.userRepo.CreateUser(uuid, req.Username, string(hash)); err != nil {
+	if err := s.userRepo.CreateUser(r.Context(), uuid, req.Username, string(hash)); err != nil {
 		log.Printf("ERROR: failed to create user: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -777,8 +1469,16 @@ func (s *Server) renderError(w http.ResponseWriter, statusCode int) {
 
 	var message string
 	switch statusCode {
+	case http.StatusForbidden:
+		message = "この操作を行う権限がありません"
 	case http.StatusNotFound:
 		message = "ページが見つかりません"
+	case http.StatusTooManyRequests:
+		message = "予算の上限に達したため日記を生成できません"
+	case http.StatusBadGateway:
+		message = "日記の生成に失敗しました"
+	case http.StatusGatewayTimeout:
+		message = "日記の生成に時間がかかったため中断しました"
 	case http.StatusInternalServerError:
 		message = "サーバーエラーが発生しました"
 	default:
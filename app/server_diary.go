@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultDiariesPerPage は日記一覧APIで per_page が省略された場合の件数
+	defaultDiariesPerPage = 20
+	// maxDiariesPerPage は日記一覧APIで指定できる per_page の上限
+	maxDiariesPerPage = 100
+)
+
+// photoURL は日記の写真の配信URLを返す。写真ディレクトリ配下のパスはユーザーUUIDのディレクトリを含めたURLにする
+func (s *Server) photoURL(imagePath string) string {
+	rel, err := filepath.Rel(s.photosDir, imagePath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(imagePath)
+	}
+	return "/photos/" + filepath.ToSlash(rel)
+}
+
+// diaryResponse は日記をAPIのレスポンス形式に変換する。plantUUIDsは植物IDからUUIDへの変換表として使い、未取得の植物を追加する。
+// observationsは GetDiaryObservations で取得した植物の状態
+func (s *Server) diaryResponse(ctx context.Context, d Diary, plantUUIDs map[int]string, observations map[int]DiaryObservation) (DiaryResponse, error) {
+	resp := DiaryResponse{
+		Id:        d.ID,
+		Content:   d.Content,
+		ImageUrl:  s.photoURL(d.ImagePath),
+		CreatedAt: d.CreatedAt,
+	}
+	if d.Provider != "" {
+		provider := d.Provider
+		resp.Provider = &provider
+	}
+	if o, ok := observations[d.ID]; ok {
+		resp.Observation = observationResponse(o)
+	}
+	if d.PlantID == 0 {
+		return resp, nil
+	}
+
+	uuid, ok := plantUUIDs[d.PlantID]
+	if !ok {
+		plant, err := s.plantRepo.GetPlantByID(ctx, d.PlantID)
+		if err != nil {
+			return DiaryResponse{}, err
+		}
+		if plant != nil {
+			uuid = plant.UUID
+		}
+		plantUUIDs[d.PlantID] = uuid
+	}
+	if uuid != "" {
+		resp.PlantUuid = &uuid
+	}
+	return resp, nil
+}
+
+// observationResponse は植物の状態をAPIのレスポンス形式に変換する
+func observationResponse(o DiaryObservation) *DiaryObservationResponse {
+	resp := &DiaryObservationResponse{
+		Flowering: o.Flowering,
+		Fruiting:  o.Fruiting,
+		Issues:    append([]string{}, o.Issues...),
+		Tags:      append([]string{}, o.Tags...),
+	}
+	if o.HealthScore != 0 {
+		healthScore := o.HealthScore
+		resp.HealthScore = &healthScore
+	}
+	if o.LeafCount != 0 {
+		leafCount := o.LeafCount
+		resp.LeafCount = &leafCount
+	}
+	return resp
+}
+
+// lookupTokenUserDiary はAPIトークンの所有ユーザーの日記を取得する。
+// 他のユーザーの日記は存在しないものとして404とし、取得できない場合はエラーレスポンスを書き込んでfalseを返す
+func (s *Server) lookupTokenUserDiary(ctx context.Context, w http.ResponseWriter, id int, user *User) (*Diary, bool) {
+	diary, err := s.repo.GetDiaryByID(ctx, id)
+	if err != nil {
+		log.Printf("ERROR: failed to get diary %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if diary == nil || diary.UserID != user.ID {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil, false
+	}
+	return diary, true
+}
+
+// writeDiaryResponse は日記を1件分のJSONレスポンスとして書き込む
+func (s *Server) writeDiaryResponse(ctx context.Context, w http.ResponseWriter, diary *Diary) {
+	observations, err := s.repo.GetDiaryObservations(ctx, []int{diary.ID})
+	if err != nil {
+		log.Printf("ERROR: failed to get observation of diary %d: %v", diary.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	resp, err := s.diaryResponse(ctx, *diary, make(map[int]string), observations)
+	if err != nil {
+		log.Printf("ERROR: failed to build response for diary %d: %v", diary.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("ERROR: failed to encode response: %v", err)
+	}
+}
+
+// GetApiDiaries は日記一覧APIのハンドラ（GET /api/diaries）
+func (s *Server) GetApiDiaries(w http.ResponseWriter, r *http.Request, params GetApiDiariesParams) {
+	user, ok := s.authenticateAPIToken(w, r, APITokenScopeRead, APITokenScopeWrite)
+	if !ok {
+		return
+	}
+
+	page := 1
+	if params.Page != nil {
+		page = *params.Page
+	}
+	perPage := defaultDiariesPerPage
+	if params.PerPage != nil {
+		perPage = *params.PerPage
+	}
+	if page < 1 || perPage < 1 || perPage > maxDiariesPerPage {
+		http.Error(w, "Bad Request: invalid page or per_page", http.StatusBadRequest)
+		return
+	}
+
+	// 日付は一覧ページの月別表示と同じく日本時間の1日単位で扱う
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	q := DiaryQuery{
+		Limit:  perPage,
+		Offset: (page - 1) * perPage,
+	}
+	if params.From != nil {
+		y, m, d := params.From.Date()
+		q.From = time.Date(y, m, d, 0, 0, 0, 0, jst).UTC()
+	}
+	if params.To != nil {
+		y, m, d := params.To.Date()
+		q.To = time.Date(y, m, d, 0, 0, 0, 0, jst).AddDate(0, 0, 1).Add(-time.Nanosecond).UTC()
+	}
+	if params.Q != nil {
+		q.Keyword = strings.TrimSpace(*params.Q)
+	}
+
+	diaries, total, err := s.repo.QueryDiaries(r.Context(), OwnerScope{UserID: user.ID}, q)
+	if err != nil {
+		log.Printf("ERROR: failed to query diaries of user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	diaryIDs := make([]int, len(diaries))
+	for i, d := range diaries {
+		diaryIDs[i] = d.ID
+	}
+	observations, err := s.repo.GetDiaryObservations(r.Context(), diaryIDs)
+	if err != nil {
+		log.Printf("ERROR: failed to get diary observations of user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]DiaryResponse, 0, len(diaries))
+	plantUUIDs := make(map[int]string)
+	for _, d := range diaries {
+		item, err := s.diaryResponse(r.Context(), d, plantUUIDs, observations)
+		if err != nil {
+			log.Printf("ERROR: failed to build response for diary %d: %v", d.ID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		items = append(items, item)
+	}
+
+	resp := DiaryListResponse{
+		Diaries: items,
+		Page:    page,
+		PerPage: perPage,
+		Total:   total,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("ERROR: failed to encode response: %v", err)
+	}
+}
+
+// GetApiDiariesId は日記取得APIのハンドラ（GET /api/diaries/{id}）
+func (s *Server) GetApiDiariesId(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := s.authenticateAPIToken(w, r, APITokenScopeRead, APITokenScopeWrite)
+	if !ok {
+		return
+	}
+
+	diary, ok := s.lookupTokenUserDiary(r.Context(), w, id, user)
+	if !ok {
+		return
+	}
+
+	s.writeDiaryResponse(r.Context(), w, diary)
+}
+
+// PatchApiDiariesId は日記本文の更新APIのハンドラ（PATCH /api/diaries/{id}）
+func (s *Server) PatchApiDiariesId(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := s.authenticateAPIToken(w, r, APITokenScopeWrite)
+	if !ok {
+		return
+	}
+
+	var req UpdateDiaryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		http.Error(w, "Bad Request: content is required", http.StatusBadRequest)
+		return
+	}
+
+	diary, ok := s.lookupTokenUserDiary(r.Context(), w, id, user)
+	if !ok {
+		return
+	}
+
+	if req.Content != diary.Content {
+		// 編集前の本文を失わないよう、画面からの編集と同じく手動編集の版として記録する
+		if _, err := s.revisionRepo.ReplaceDiaryContent(r.Context(), diary.ID, req.Content, RevisionSourceManualEdit, user.ID); err != nil {
+			log.Printf("ERROR: failed to update diary %d: %v", diary.ID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		diary.Content = req.Content
+	}
+
+	s.writeDiaryResponse(r.Context(), w, diary)
+}
+
+// DeleteApiDiariesId は日記削除APIのハンドラ（DELETE /api/diaries/{id}）。日記はゴミ箱へ移動し、写真ファイルは残す
+func (s *Server) DeleteApiDiariesId(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := s.authenticateAPIToken(w, r, APITokenScopeWrite)
+	if !ok {
+		return
+	}
+
+	diary, ok := s.lookupTokenUserDiary(r.Context(), w, id, user)
+	if !ok {
+		return
+	}
+
+	if err := s.repo.DeleteDiary(r.Context(), diary.ID); err != nil {
+		log.Printf("ERROR: failed to delete diary %d: %v", diary.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// GetApiGeneratorProviders は日記生成プロバイダごとの成功・失敗回数を返すAPIのハンドラ（GET /api/generator/providers）
+func (s *Server) GetApiGeneratorProviders(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.authenticateAPIToken(w, r, APITokenScopeAdmin); !ok {
+		return
+	}
+
+	resp := ProviderStatsListResponse{Providers: []ProviderStatsResponse{}}
+	if reporter, ok := s.generator.(ProviderStatsReporter); ok {
+		for _, st := range reporter.ProviderStats() {
+			item := ProviderStatsResponse{
+				Name:      st.Name,
+				Successes: st.Successes,
+				Failures:  st.Failures,
+			}
+			if st.LastError != "" {
+				lastError := st.LastError
+				item.LastError = &lastError
+			}
+			if !st.LastFailureAt.IsZero() {
+				lastFailureAt := st.LastFailureAt
+				item.LastFailureAt = &lastFailureAt
+			}
+			resp.Providers = append(resp.Providers, item)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("ERROR: failed to encode response: %v", err)
+	}
+}
+
+// GetApiGeneratorRetries は操作ごとのリトライの回数を返すAPIのハンドラ（GET /api/generator/retries）
+func (s *Server) GetApiGeneratorRetries(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.authenticateAPIToken(w, r, APITokenScopeAdmin); !ok {
+		return
+	}
+
+	resp := RetryStatsListResponse{Operations: []RetryStatsResponse{}}
+	if s.retryConfig.Metrics != nil {
+		for _, st := range s.retryConfig.Metrics.Stats() {
+			resp.Operations = append(resp.Operations, RetryStatsResponse{
+				Operation:         st.Operation,
+				Calls:             st.Calls,
+				Attempts:          st.Attempts,
+				Successes:         st.Successes,
+				Failures:          st.Failures,
+				PermanentFailures: st.PermanentFailures,
+				Canceled:          st.Canceled,
+				RateLimited:       st.RateLimited,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("ERROR: failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	openapi_types "github.com/oapi-codegen/runtime/types"
+)
+
+// handlePlants は植物一覧ページを表示する
+func (s *Server) handlePlants(w http.ResponseWriter, r *http.Request) {
+	currentUser, err := s.getCurrentUser(r)
+	if err != nil {
+		log.Printf("ERROR: failed to get current user: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	plants, err := s.plantRepo.GetAllPlants(r.Context(), viewerScope(currentUser))
+	if err != nil {
+		log.Printf("ERROR: failed to get plants: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	// 表紙の写真をURLに変換
+	for i := range plants {
+		if plants[i].CoverImagePath != "" {
+			plants[i].CoverImagePath = s.photoURL(plants[i].CoverImagePath)
+		}
+	}
+
+	loggedIn := currentUser != nil
+	username := ""
+	if currentUser != nil {
+		username = currentUser.Username
+	}
+
+	data := map[string]interface{}{
+		"Plants":   plants,
+		"LoggedIn": loggedIn,
+		"Username": username,
+	}
+
+	if err := s.templates.ExecuteTemplate(w, "plants.html", data); err != nil {
+		log.Printf("ERROR: failed to render plants template: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handlePlant は植物ごとの日記一覧ページを表示する
+func (s *Server) handlePlant(w http.ResponseWriter, r *http.Request) {
+	currentUser, err := s.getCurrentUser(r)
+	if err != nil {
+		log.Printf("ERROR: failed to get current user: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	plant, ok := s.lookupVisiblePlant(r.Context(), w, r.PathValue("uuid"), currentUser)
+	if !ok {
+		return
+	}
+
+	diaries, err := s.repo.GetDiariesByPlantID(r.Context(), plant.ID)
+	if err != nil {
+		log.Printf("ERROR: failed to get diaries of plant %d: %v", plant.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	// ImagePathを写真のURLに変換
+	for i := range diaries {
+		diaries[i].ImagePath = s.photoURL(diaries[i].ImagePath)
+	}
+
+	loggedIn := currentUser != nil
+	username := ""
+	if currentUser != nil {
+		username = currentUser.Username
+	}
+
+	data := map[string]interface{}{
+		"Plant":    plant,
+		"Diaries":  diaries,
+		"LoggedIn": loggedIn,
+		"IsOwner":  loggedIn && currentUser.ID == plant.UserID,
+		"Username": username,
+	}
+
+	if err := s.templates.ExecuteTemplate(w, "plant.html", data); err != nil {
+		log.Printf("ERROR: failed to render plant template for plant %d: %v", plant.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handlePlantSlideshow は植物ごとのスライドショーページを表示する
+func (s *Server) handlePlantSlideshow(w http.ResponseWriter, r *http.Request) {
+	currentUser, err := s.getCurrentUser(r)
+	if err != nil {
+		log.Printf("ERROR: failed to get current user: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	plant, ok := s.lookupVisiblePlant(r.Context(), w, r.PathValue("uuid"), currentUser)
+	if !ok {
+		return
+	}
+	fromStr, toStr, from, to := parseSlideshowRange(r)
+
+	diaries, err := s.repo.GetDiariesByPlantID(r.Context(), plant.ID)
+	if err != nil {
+		log.Printf("ERROR: failed to get diaries of plant %d: %v", plant.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	// GetDiariesByPlantIDは新着順のため、期間で絞り込みつつ古い順に並べ替える
+	slides := make([]Diary, 0, len(diaries))
+	for i := len(diaries) - 1; i >= 0; i-- {
+		d := diaries[i]
+		if (!from.IsZero() && d.CreatedAt.Before(from)) || (!to.IsZero() && d.CreatedAt.After(to)) {
+			continue
+		}
+		slides = append(slides, d)
+	}
+
+	s.renderSlideshow(w, slides, fromStr, toStr, plant)
+}
+
+// handlePlantCover は植物の表紙を指定した日記の写真に変更し、植物ページへリダイレクトする
+func (s *Server) handlePlantCover(w http.ResponseWriter, r *http.Request) {
+	plant, ok := s.lookupPlant(r.Context(), w, r.PathValue("uuid"))
+	if !ok {
+		return
+	}
+
+	currentUser, err := s.getCurrentUser(r)
+	if err != nil {
+		log.Printf("ERROR: failed to get current user: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+	if currentUser == nil || plant.UserID != currentUser.ID {
+		s.renderError(w, http.StatusForbidden)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, http.StatusBadRequest)
+		return
+	}
+	diaryID, err := strconv.Atoi(r.FormValue("diary_id"))
+	if err != nil {
+		s.renderError(w, http.StatusBadRequest)
+		return
+	}
+
+	diary, err := s.repo.GetDiaryByID(r.Context(), diaryID)
+	if err != nil {
+		log.Printf("ERROR: failed to get diary %d: %v", diaryID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+	if diary == nil || diary.PlantID != plant.ID {
+		s.renderError(w, http.StatusNotFound)
+		return
+	}
+
+	if err := s.plantRepo.SetPlantCover(r.Context(), plant.ID, diary.ID); err != nil {
+		log.Printf("ERROR: failed to set cover of plant %d: %v", plant.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/plants/"+plant.UUID, http.StatusFound)
+}
+
+// lookupPlant はUUIDから植物を取得する。取得できない場合はエラーページを表示してfalseを返す
+func (s *Server) lookupPlant(ctx context.Context, w http.ResponseWriter, uuid string) (*Plant, bool) {
+	plant, err := s.plantRepo.GetPlantByUUID(ctx, uuid)
+	if err != nil {
+		log.Printf("ERROR: failed to get plant %s: %v", uuid, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return nil, false
+	}
+	if plant == nil {
+		s.renderError(w, http.StatusNotFound)
+		return nil, false
+	}
+	return plant, true
+}
+
+// lookupVisiblePlant はUUIDから閲覧者が表示できる植物を取得する。
+// 非公開ユーザーの植物は本人以外には存在しないものとして扱い、取得できない場合はエラーページを表示してfalseを返す
+func (s *Server) lookupVisiblePlant(ctx context.Context, w http.ResponseWriter, uuid string, viewer *User) (*Plant, bool) {
+	plant, ok := s.lookupPlant(ctx, w, uuid)
+	if !ok {
+		return nil, false
+	}
+
+	visible, err := s.canView(ctx, plant.UserID, viewer)
+	if err != nil {
+		log.Printf("ERROR: failed to check visibility of plant %d: %v", plant.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return nil, false
+	}
+	if !visible {
+		s.renderError(w, http.StatusNotFound)
+		return nil, false
+	}
+	return plant, true
+}
+
+// PostApiPlants は植物登録APIのハンドラ（POST /api/plants）
+func (s *Server) PostApiPlants(w http.ResponseWriter, r *http.Request) {
+	// 植物はトークンの所有ユーザーのものとして登録する
+	user, ok := s.authenticateAPIToken(w, r, APITokenScopeAdmin)
+	if !ok {
+		return
+	}
+
+	// リクエストボディの解析
+	var req CreatePlantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		http.Error(w, "Bad Request: name is required", http.StatusBadRequest)
+		return
+	}
+	// user_uuid は互換性のため受け付けるが、トークンのユーザーと一致する必要がある
+	if req.UserUuid != nil && !matchesTokenUser(w, *req.UserUuid, user) {
+		return
+	}
+
+	var species, location string
+	if req.Species != nil {
+		species = strings.TrimSpace(*req.Species)
+	}
+	if req.Location != nil {
+		location = strings.TrimSpace(*req.Location)
+	}
+	var acquiredOn time.Time
+	if req.AcquiredOn != nil {
+		acquiredOn = req.AcquiredOn.Time
+	}
+
+	// UUID生成（ハイフンなし32文字）
+	uuid, err := generateUUID()
+	if err != nil {
+		log.Printf("ERROR: failed to generate UUID: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.plantRepo.CreatePlant(r.Context(), uuid, user.ID, name, species, location, acquiredOn); err != nil {
+		log.Printf("ERROR: failed to create plant: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	plant, err := s.plantRepo.GetPlantByUUID(r.Context(), uuid)
+	if err != nil || plant == nil {
+		log.Printf("ERROR: failed to get created plant %s: %v", uuid, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := PlantResponse{
+		PlantUuid: plant.UUID,
+		Name:      plant.Name,
+		Species:   plant.Species,
+		Location:  plant.Location,
+		CreatedAt: plant.CreatedAt,
+	}
+	if !plant.AcquiredOn.IsZero() {
+		resp.AcquiredOn = &openapi_types.Date{Time: plant.AcquiredOn}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("ERROR: failed to encode response: %v", err)
+	}
+}
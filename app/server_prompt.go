@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// promptSettingsForm はプロンプト設定ページのフォームの入力値
+type promptSettingsForm struct {
+	Persona  string
+	Template string
+}
+
+// handlePromptSettings はユーザー全体（plantクエリなし）または植物（plantクエリに植物のUUID）のプロンプト設定ページを表示する
+func (s *Server) handlePromptSettings(w http.ResponseWriter, r *http.Request) {
+	currentUser, plant, ok := s.loadPromptSettingsTarget(w, r, r.URL.Query().Get("plant"))
+	if !ok {
+		return
+	}
+
+	setting, err := s.promptRepo.GetPromptTemplate(r.Context(), currentUser.ID, promptSettingsPlantID(plant))
+	if err != nil {
+		log.Printf("ERROR: failed to get prompt template of user %d: %v", currentUser.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	// 未設定の場合や組み込みのペルソナの場合は、編集の起点としてそのペルソナのテンプレートを表示する
+	form := promptSettingsForm{Persona: PersonaStandard, Template: personaTemplate(PersonaStandard)}
+	if setting != nil {
+		form = promptSettingsForm{Persona: setting.Persona, Template: setting.Text()}
+	}
+	s.renderPromptSettings(r.Context(), w, currentUser, plant, setting != nil, form, "", "")
+}
+
+// handlePromptSettingsSave はプロンプトの設定を保存し、設定ページへリダイレクトする。
+// テンプレートに誤りがある場合は保存せず、入力内容とエラーを表示する
+func (s *Server) handlePromptSettingsSave(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, http.StatusBadRequest)
+		return
+	}
+	currentUser, plant, ok := s.loadPromptSettingsTarget(w, r, r.FormValue("plant"))
+	if !ok {
+		return
+	}
+	form, ok := parsePromptSettingsForm(r)
+	if !ok {
+		s.renderError(w, http.StatusBadRequest)
+		return
+	}
+
+	// 組み込みのペルソナの場合、テンプレートは保存しない
+	customTemplate := ""
+	if form.Persona == PersonaCustom {
+		if err := validatePromptTemplate(form.Template); err != nil {
+			saved, ok := s.hasPromptSetting(r.Context(), w, currentUser, plant)
+			if ok {
+				s.renderPromptSettings(r.Context(), w, currentUser, plant, saved, form, "", err.Error())
+			}
+			return
+		}
+		customTemplate = form.Template
+	}
+
+	if err := s.promptRepo.SavePromptTemplate(r.Context(), currentUser.ID, promptSettingsPlantID(plant), form.Persona, customTemplate); err != nil {
+		log.Printf("ERROR: failed to save prompt template of user %d: %v", currentUser.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, promptSettingsURL(plant), http.StatusFound)
+}
+
+// handlePromptSettingsPreview は入力中の設定で、今日撮影した写真の日記を生成する場合のプロンプトを表示する。設定は保存しない
+func (s *Server) handlePromptSettingsPreview(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, http.StatusBadRequest)
+		return
+	}
+	currentUser, plant, ok := s.loadPromptSettingsTarget(w, r, r.FormValue("plant"))
+	if !ok {
+		return
+	}
+	form, ok := parsePromptSettingsForm(r)
+	if !ok {
+		s.renderError(w, http.StatusBadRequest)
+		return
+	}
+	saved, ok := s.hasPromptSetting(r.Context(), w, currentUser, plant)
+	if !ok {
+		return
+	}
+
+	text := personaTemplate(form.Persona)
+	if form.Persona == PersonaCustom {
+		if err := validatePromptTemplate(form.Template); err != nil {
+			s.renderPromptSettings(r.Context(), w, currentUser, plant, saved, form, "", err.Error())
+			return
+		}
+		text = form.Template
+	}
+
+	preview, err := s.prompts.Preview(r.Context(), currentUser.ID, promptSettingsPlantID(plant), text, time.Now())
+	if err != nil {
+		log.Printf("ERROR: failed to preview prompt of user %d: %v", currentUser.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+	s.renderPromptSettings(r.Context(), w, currentUser, plant, saved, form, preview, "")
+}
+
+// handlePromptSettingsReset はプロンプトの設定を削除し、設定ページへリダイレクトする。
+// 植物の設定を削除するとユーザー全体の設定、ユーザー全体の設定を削除すると標準のペルソナを使う
+func (s *Server) handlePromptSettingsReset(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, http.StatusBadRequest)
+		return
+	}
+	currentUser, plant, ok := s.loadPromptSettingsTarget(w, r, r.FormValue("plant"))
+	if !ok {
+		return
+	}
+
+	if err := s.promptRepo.DeletePromptTemplate(r.Context(), currentUser.ID, promptSettingsPlantID(plant)); err != nil {
+		log.Printf("ERROR: failed to delete prompt template of user %d: %v", currentUser.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, promptSettingsURL(plant), http.StatusFound)
+}
+
+// renderPromptSettings はプロンプト設定ページを表示する。savedはこの対象の設定が保存済みかどうか、previewは表示するプロンプト
+func (s *Server) renderPromptSettings(ctx context.Context, w http.ResponseWriter, user *User, plant *Plant, saved bool, form promptSettingsForm, preview, errMsg string) {
+	plants, err := s.plantRepo.GetAllPlants(ctx, OwnerScope{UserID: user.ID})
+	if err != nil {
+		log.Printf("ERROR: failed to get plants of user %d: %v", user.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"LoggedIn":      true,
+		"Username":      user.Username,
+		"Plant":         plant,
+		"Plants":        plants,
+		"Saved":         saved,
+		"Personas":      promptPersonas,
+		"PersonaCustom": PersonaCustom,
+		"Persona":       form.Persona,
+		"Template":      form.Template,
+		"MaxLength":     maxPromptTemplateLength,
+		"Preview":       preview,
+		"Error":         errMsg,
+	}
+
+	if err := s.templates.ExecuteTemplate(w, "prompt.html", data); err != nil {
+		log.Printf("ERROR: failed to render prompt template: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+}
+
+// loadPromptSettingsTarget はログインユーザーと、設定の対象の植物（plantUUIDが空の場合はユーザー全体の設定のためnil）を返す。
+// 他のユーザーの植物は存在しないものとして扱い、取得できない場合はエラーページを表示してfalseを返す
+func (s *Server) loadPromptSettingsTarget(w http.ResponseWriter, r *http.Request, plantUUID string) (*User, *Plant, bool) {
+	currentUser, err := s.getCurrentUser(r)
+	if err != nil || currentUser == nil {
+		log.Printf("ERROR: failed to get current user: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if plantUUID == "" {
+		return currentUser, nil, true
+	}
+
+	plant, ok := s.lookupPlant(r.Context(), w, plantUUID)
+	if !ok {
+		return nil, nil, false
+	}
+	if plant.UserID != currentUser.ID {
+		s.renderError(w, http.StatusNotFound)
+		return nil, nil, false
+	}
+	return currentUser, plant, true
+}
+
+// hasPromptSetting は対象の設定が保存済みかどうかを返す。取得できない場合はエラーページを表示してfalseを返す
+func (s *Server) hasPromptSetting(ctx context.Context, w http.ResponseWriter, user *User, plant *Plant) (bool, bool) {
+	setting, err := s.promptRepo.GetPromptTemplate(ctx, user.ID, promptSettingsPlantID(plant))
+	if err != nil {
+		log.Printf("ERROR: failed to get prompt template of user %d: %v", user.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return false, false
+	}
+	return setting != nil, true
+}
+
+// parsePromptSettingsForm はフォームの入力値を読み込む。ペルソナが不明な場合はfalseを返す
+func parsePromptSettingsForm(r *http.Request) (promptSettingsForm, bool) {
+	form := promptSettingsForm{
+		Persona:  r.FormValue("persona"),
+		Template: r.FormValue("template"),
+	}
+	return form, isPromptPersona(form.Persona)
+}
+
+// promptSettingsPlantID は設定の対象の植物ID（ユーザー全体の設定の場合は0）を返す
+func promptSettingsPlantID(plant *Plant) int {
+	if plant == nil {
+		return 0
+	}
+	return plant.ID
+}
+
+// promptSettingsURL は設定の対象のプロンプト設定ページのURLを返す
+func promptSettingsURL(plant *Plant) string {
+	if plant == nil {
+		return "/settings/prompt"
+	}
+	return "/settings/prompt?plant=" + url.QueryEscape(plant.UUID)
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// regenerateDiaryTimeout は日記の再生成で、生成（リトライを含む）をリクエストの中で待つ時間の上限
+const regenerateDiaryTimeout = 3 * time.Minute
+
+var (
+	// ErrDiaryGenerationFailed は日記本文の生成に失敗したことを表す
+	ErrDiaryGenerationFailed = errors.New("diary generation failed")
+	// ErrDiaryGenerationTimeout は日記本文の生成が時間の上限までに終わらなかったことを表す
+	ErrDiaryGenerationTimeout = errors.New("diary generation timed out")
+)
+
+// regenerateDiary は日記作成時と同じ過去日記の文脈で日記本文を再生成して現在の本文と置き換え、
+// 新しい本文と置き換え前の版IDを返す。生成はWorkerを通さずにリクエストの中で行い、s.regenTimeout で打ち切る。
+// 生成に失敗した場合は ErrDiaryGenerationFailed を、打ち切った場合は ErrDiaryGenerationTimeout を、
+// 日記の所有ユーザーの使用料金が予算の上限に達している場合は ErrBudgetExceeded をラップして返す
+func (s *Server) regenerateDiary(ctx context.Context, diary *Diary, userID int) (string, int, error) {
+	if err := s.usage.CheckBudget(ctx, diary.UserID); err != nil {
+		return "", 0, err
+	}
+
+	genCtx, cancel := context.WithTimeout(ctx, s.regenTimeout)
+	defer cancel()
+	generated, _, err := generateDiaryContent(genCtx, s.repo, s.generator, s.prompts, s.retryConfig, diary.UserID, diary.PlantID, diary.ImagePath, diary.CreatedAt)
+	if err != nil {
+		if errors.Is(genCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			return "", 0, fmt.Errorf("%w: %v", ErrDiaryGenerationTimeout, err)
+		}
+		return "", 0, fmt.Errorf("%w: %v", ErrDiaryGenerationFailed, err)
+	}
+
+	previousRevisionID, err := s.revisionRepo.ReplaceDiaryContent(ctx, diary.ID, generated.Content, RevisionSourceRegeneration, userID)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to replace diary content: %w", err)
+	}
+	// 本文は置き換え済みのため、プロバイダ名・植物の状態の記録に失敗しても再生成は成功として扱う
+	if err := s.repo.UpdateDiaryGeneration(ctx, diary.ID, generated.Provider, generated.Observation); err != nil {
+		log.Printf("WARN: failed to record generation of diary %d: %v", diary.ID, err)
+	}
+	recordUsage(ctx, s.usage, diary.UserID, diary.ID, generated)
+	return generated.Content, previousRevisionID, nil
+}
+
+// handleDiaryRegenerate は日記を再生成し、再生成前後の比較ページへリダイレクトする
+func (s *Server) handleDiaryRegenerate(w http.ResponseWriter, r *http.Request) {
+	diary, currentUser, ok := s.loadOwnedDiary(w, r)
+	if !ok {
+		return
+	}
+	id := diary.ID
+
+	_, previousRevisionID, err := s.regenerateDiary(r.Context(), diary, currentUser.ID)
+	if err != nil {
+		log.Printf("ERROR: failed to regenerate diary %d: %v", id, err)
+		switch {
+		case errors.Is(err, ErrDiaryGenerationFailed):
+			s.renderError(w, http.StatusBadGateway)
+		case errors.Is(err, ErrDiaryGenerationTimeout):
+			s.renderError(w, http.StatusGatewayTimeout)
+		case errors.Is(err, ErrBudgetExceeded):
+			s.renderError(w, http.StatusTooManyRequests)
+		default:
+			s.renderError(w, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/diary/%d/compare?revision=%d", id, previousRevisionID), http.StatusFound)
+}
+
+// handleDiaryCompare は指定した過去の版と現在の本文を並べて表示し、どちらを残すか選択させる
+func (s *Server) handleDiaryCompare(w http.ResponseWriter, r *http.Request) {
+	diary, currentUser, ok := s.loadOwnedDiary(w, r)
+	if !ok {
+		return
+	}
+	id := diary.ID
+
+	revision, ok := s.lookupRevisionParam(r.Context(), w, r.URL.Query().Get("revision"), id)
+	if !ok {
+		return
+	}
+
+	diaryView := *diary
+	diaryView.ImagePath = s.photoURL(diary.ImagePath)
+
+	data := map[string]interface{}{
+		"Diary":    &diaryView,
+		"Revision": revision,
+		"LoggedIn": true,
+		"Username": currentUser.Username,
+	}
+
+	if err := s.templates.ExecuteTemplate(w, "compare.html", data); err != nil {
+		log.Printf("ERROR: failed to render compare template for diary %d: %v", id, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+}
+
+// revisionSourceLabel は版の作成元を表示用の文言に変換する
+func revisionSourceLabel(source RevisionSource) string {
+	switch source {
+	case RevisionSourceGeneration:
+		return "AI生成"
+	case RevisionSourceOriginal:
+		return "履歴記録前の本文"
+	case RevisionSourceManualEdit:
+		return "手動編集"
+	case RevisionSourceRegeneration:
+		return "再生成"
+	case RevisionSourceRestore:
+		return "復元"
+	default:
+		return string(source)
+	}
+}
+
+// diaryHistoryEntry は詳細ページの履歴一覧に表示する版
+type diaryHistoryEntry struct {
+	DiaryRevision
+	Username   string // 変更したユーザー名（Workerによる変更は空）
+	PreviousID int    // 1つ前の版のID（最初の版は0）
+	Current    bool   // 現在の本文の版かどうか
+}
+
+// diaryHistory は日記の版履歴を新しい順に、変更したユーザー名と1つ前の版を添えて返す
+func (s *Server) diaryHistory(ctx context.Context, diaryID int) ([]diaryHistoryEntry, error) {
+	revisions, err := s.revisionRepo.GetRevisionsByDiaryID(ctx, diaryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revisions: %w", err)
+	}
+
+	usernames := make(map[int]string)
+	history := make([]diaryHistoryEntry, 0, len(revisions))
+	for i, rev := range revisions {
+		entry := diaryHistoryEntry{DiaryRevision: rev, Current: i == 0}
+		if i+1 < len(revisions) {
+			entry.PreviousID = revisions[i+1].ID
+		}
+		if rev.UserID != 0 {
+			name, ok := usernames[rev.UserID]
+			if !ok {
+				user, err := s.userRepo.GetUserByID(ctx, rev.UserID)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get user %d: %w", rev.UserID, err)
+				}
+				if user != nil {
+					name = user.Username
+				}
+				usernames[rev.UserID] = name
+			}
+			entry.Username = name
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
+
+// handleDiaryDiff は2つの版の本文の差分を表示する。
+// fromに比較元の版ID、toに比較先の版IDを指定する（toを省略した場合は現在の本文と比較する）
+func (s *Server) handleDiaryDiff(w http.ResponseWriter, r *http.Request) {
+	diary, currentUser, ok := s.loadOwnedDiary(w, r)
+	if !ok {
+		return
+	}
+	id := diary.ID
+
+	from, ok := s.lookupRevisionParam(r.Context(), w, r.URL.Query().Get("from"), id)
+	if !ok {
+		return
+	}
+	var to *DiaryRevision
+	if r.URL.Query().Get("to") != "" {
+		if to, ok = s.lookupRevisionParam(r.Context(), w, r.URL.Query().Get("to"), id); !ok {
+			return
+		}
+	}
+
+	afterContent := diary.Content
+	if to != nil {
+		afterContent = to.Content
+	}
+
+	data := map[string]interface{}{
+		"Diary":    diary,
+		"From":     from,
+		"To":       to,
+		"Segments": diffText(from.Content, afterContent),
+		"LoggedIn": true,
+		"Username": currentUser.Username,
+	}
+
+	if err := s.templates.ExecuteTemplate(w, "diff.html", data); err != nil {
+		log.Printf("ERROR: failed to render diff template for diary %d: %v", id, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+}
+
+// lookupRevisionParam はクエリパラメータの版IDから指定日記の版を取得する。
+// 取得できない場合はエラーページを表示してfalseを返す
+func (s *Server) lookupRevisionParam(ctx context.Context, w http.ResponseWriter, value string, diaryID int) (*DiaryRevision, bool) {
+	revisionID, err := strconv.Atoi(value)
+	if err != nil {
+		s.renderError(w, http.StatusNotFound)
+		return nil, false
+	}
+
+	revision, err := s.revisionRepo.GetRevisionByID(ctx, revisionID)
+	if err != nil {
+		log.Printf("ERROR: failed to get revision %d: %v", revisionID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return nil, false
+	}
+	if revision == nil || revision.DiaryID != diaryID {
+		s.renderError(w, http.StatusNotFound)
+		return nil, false
+	}
+	return revision, true
+}
+
+// handleRevisionRestore は指定した過去の版の本文を現在の本文として復元し、詳細ページへリダイレクトする
+func (s *Server) handleRevisionRestore(w http.ResponseWriter, r *http.Request) {
+	diary, currentUser, ok := s.loadOwnedDiary(w, r)
+	if !ok {
+		return
+	}
+	id := diary.ID
+
+	revisionIDStr := r.PathValue("revision_id")
+	revisionID, err := strconv.Atoi(revisionIDStr)
+	if err != nil {
+		log.Printf("ERROR: invalid revision id: %s", revisionIDStr)
+		s.renderError(w, http.StatusNotFound)
+		return
+	}
+
+	revision, err := s.revisionRepo.GetRevisionByID(r.Context(), revisionID)
+	if err != nil {
+		log.Printf("ERROR: failed to get revision %d: %v", revisionID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+	if revision == nil || revision.DiaryID != id {
+		s.renderError(w, http.StatusNotFound)
+		return
+	}
+
+	if _, err := s.revisionRepo.ReplaceDiaryContent(r.Context(), id, revision.Content, RevisionSourceRestore, currentUser.ID); err != nil {
+		log.Printf("ERROR: failed to restore revision %d of diary %d: %v", revisionID, id, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/diary/%d", id), http.StatusFound)
+}
+
+// PostApiDiariesIdRegenerate は日記再生成APIのハンドラ（POST /api/diaries/{id}/regenerate）
+func (s *Server) PostApiDiariesIdRegenerate(w http.ResponseWriter, r *http.Request, id int) {
+	user, ok := s.authenticateAPIToken(w, r, APITokenScopeWrite)
+	if !ok {
+		return
+	}
+
+	diary, err := s.repo.GetDiaryByID(r.Context(), id)
+	if err != nil {
+		log.Printf("ERROR: failed to get diary %d: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// 他のユーザーの日記は存在しないものとして扱う
+	if diary == nil || diary.UserID != user.ID {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	content, previousRevisionID, err := s.regenerateDiary(r.Context(), diary, user.ID)
+	if err != nil {
+		log.Printf("ERROR: failed to regenerate diary %d: %v", id, err)
+		switch {
+		case errors.Is(err, ErrDiaryGenerationFailed):
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		case errors.Is(err, ErrDiaryGenerationTimeout):
+			http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+		case errors.Is(err, ErrBudgetExceeded):
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		default:
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := RegenerateDiaryResponse{
+		DiaryId:            id,
+		Content:            content,
+		PreviousRevisionId: previousRevisionID,
+		PreviousContent:    diary.Content,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("ERROR: failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// handleSettings はログインユーザーの設定ページを表示する
+func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
+	currentUser, err := s.getCurrentUser(r)
+	if err != nil || currentUser == nil {
+		log.Printf("ERROR: failed to get current user: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	s.renderSettings(r.Context(), w, currentUser, "")
+}
+
+// renderSettings は設定ページを表示する。newTokenには発行直後の平文のAPIトークンを指定する（この画面でのみ表示する）
+func (s *Server) renderSettings(ctx context.Context, w http.ResponseWriter, user *User, newToken string) {
+	tokens, err := s.apiTokenRepo.GetAPITokensByUserID(ctx, user.ID)
+	if err != nil {
+		log.Printf("ERROR: failed to get API tokens of user %d: %v", user.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"LoggedIn":      true,
+		"Username":      user.Username,
+		"UserUUID":      user.UUID,
+		"DiariesPublic": user.DiariesPublic,
+		"Tokens":        tokens,
+		"NewToken":      newToken,
+		"Scopes":        s.issuableAPITokenScopes(user),
+		"IsAdmin":       s.isAdmin(user),
+	}
+
+	if err := s.templates.ExecuteTemplate(w, "settings.html", data); err != nil {
+		log.Printf("ERROR: failed to render settings template: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleSettingsVisibility はログインユーザーの日記の公開設定を更新し、設定ページへリダイレクトする
+func (s *Server) handleSettingsVisibility(w http.ResponseWriter, r *http.Request) {
+	currentUser, err := s.getCurrentUser(r)
+	if err != nil || currentUser == nil {
+		log.Printf("ERROR: failed to get current user: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, http.StatusBadRequest)
+		return
+	}
+	var public bool
+	switch r.FormValue("diaries_public") {
+	case "1":
+		public = true
+	case "0":
+		public = false
+	default:
+		s.renderError(w, http.StatusBadRequest)
+		return
+	}
+
+	if err := s.userRepo.SetDiariesPublic(r.Context(), currentUser.ID, public); err != nil {
+		log.Printf("ERROR: failed to update visibility of user %d: %v", currentUser.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusFound)
+}
+
+// handleAPITokenCreate はログインユーザーのAPIトークンを発行し、平文のトークンを設定ページに一度だけ表示する
+func (s *Server) handleAPITokenCreate(w http.ResponseWriter, r *http.Request) {
+	currentUser, err := s.getCurrentUser(r)
+	if err != nil || currentUser == nil {
+		log.Printf("ERROR: failed to get current user: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	scope, ok := parseAPITokenScope(r.FormValue("scope"))
+	if name == "" || !ok {
+		s.renderError(w, http.StatusBadRequest)
+		return
+	}
+	// adminスコープはユーザーの作成などもできるため、ADMIN_USERS のユーザーのみ発行できる
+	if !slices.Contains(s.issuableAPITokenScopes(currentUser), scope) {
+		s.renderError(w, http.StatusForbidden)
+		return
+	}
+
+	token, err := generateAPIToken()
+	if err != nil {
+		log.Printf("ERROR: failed to generate API token: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+	if err := s.apiTokenRepo.CreateAPIToken(r.Context(), currentUser.ID, name, hashAPIToken(token), token[:apiTokenDisplayLength], scope); err != nil {
+		log.Printf("ERROR: failed to create API token for user %d: %v", currentUser.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	// 平文のトークンは保存しないため、リダイレクトせずにこのレスポンスでのみ表示する
+	w.Header().Set("Cache-Control", "no-store")
+	s.renderSettings(r.Context(), w, currentUser, token)
+}
+
+// issuableAPITokenScopes はユーザーが発行できるAPIトークンのスコープを返す。adminスコープは ADMIN_USERS のユーザーのみ
+func (s *Server) issuableAPITokenScopes(user *User) []APITokenScope {
+	scopes := []APITokenScope{APITokenScopeUpload, APITokenScopeRead, APITokenScopeWrite}
+	if s.isAdmin(user) {
+		scopes = append(scopes, APITokenScopeAdmin)
+	}
+	return scopes
+}
+
+// handleAPITokenRevoke はログインユーザーのAPIトークンを失効させ、設定ページへリダイレクトする
+func (s *Server) handleAPITokenRevoke(w http.ResponseWriter, r *http.Request) {
+	currentUser, err := s.getCurrentUser(r)
+	if err != nil || currentUser == nil {
+		log.Printf("ERROR: failed to get current user: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("ERROR: invalid API token id: %s", idStr)
+		s.renderError(w, http.StatusNotFound)
+		return
+	}
+
+	// 他のユーザーのトークンや失効済みのトークンは存在しないものとして扱う
+	tokens, err := s.apiTokenRepo.GetAPITokensByUserID(r.Context(), currentUser.ID)
+	if err != nil {
+		log.Printf("ERROR: failed to get API tokens of user %d: %v", currentUser.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+	found := false
+	for _, t := range tokens {
+		if t.ID == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		s.renderError(w, http.StatusNotFound)
+		return
+	}
+
+	if err := s.apiTokenRepo.RevokeAPIToken(r.Context(), id, currentUser.ID); err != nil {
+		log.Printf("ERROR: failed to revoke API token %d: %v", id, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusFound)
+}
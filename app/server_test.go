@@ -0,0 +1,857 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer はテスト用のSQLiteのリポジトリを使うServerを生成する。Workerは起動しない（登録したジョブは処理されない）
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+	jobRepo := NewSQLiteJobRepository(db)
+	generator := &MockDiaryGenerator{}
+	worker := NewDiaryWorker(repo, jobRepo, generator, nil, nil, DiaryWorkerConfig{Workers: 1, QueueSize: 10})
+	s, err := NewServer(repo, NewSQLiteUserRepository(db), NewSQLiteSessionRepository(db), jobRepo,
+		NewSQLiteDiaryRevisionRepository(db), NewSQLitePlantRepository(db), NewSQLiteAPITokenRepository(db),
+		NewSQLitePromptTemplateRepository(db), NewSQLiteUsageRepository(db), NewSQLitePhotoMetadataRepository(db),
+		NewSQLiteUploadIdempotencyKeyRepository(db), NewSQLitePhotoHashRepository(db),
+		generator, nil, nil, worker, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	return s
+}
+
+// createTestUser はテスト用のログイン可能なユーザーを作成する
+func createTestUser(t *testing.T, s *Server, username string) *User {
+	t.Helper()
+	uuid, err := generateUUID()
+	if err != nil {
+		t.Fatalf("generateUUID failed: %v", err)
+	}
+	if err := s.userRepo.CreateUser(t.Context(), uuid, username, "hash"); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	user, err := s.userRepo.GetUserByUUID(t.Context(), uuid)
+	if err != nil || user == nil {
+		t.Fatalf("GetUserByUUID failed: %+v, %v", user, err)
+	}
+	return user
+}
+
+// issueTestToken はユーザーのAPIトークンを発行し、平文のトークンを返す
+func issueTestToken(t *testing.T, s *Server, user *User, scope APITokenScope) string {
+	t.Helper()
+	token, err := generateAPIToken()
+	if err != nil {
+		t.Fatalf("generateAPIToken failed: %v", err)
+	}
+	if err := s.apiTokenRepo.CreateAPIToken(t.Context(), user.ID, "test", hashAPIToken(token), token[:apiTokenDisplayLength], scope); err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+	return token
+}
+
+// loginTestUser はユーザーのセッションを作成し、セッションのCookieを返す
+func loginTestUser(t *testing.T, s *Server, user *User) *http.Cookie {
+	t.Helper()
+	id, err := generateUUID()
+	if err != nil {
+		t.Fatalf("generateUUID failed: %v", err)
+	}
+	if err := s.sessionRepo.CreateSession(t.Context(), id, user.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	return &http.Cookie{Name: "session_id", Value: id}
+}
+
+// createTestDiary はユーザーの日記を作成し、日記のIDを返す
+func createTestDiary(t *testing.T, s *Server, user *User, imagePath string) int {
+	t.Helper()
+	id, err := s.repo.CreateGeneratedDiary(t.Context(), user.ID, 0, imagePath, "日記", "", nil, time.Now())
+	if err != nil {
+		t.Fatalf("CreateGeneratedDiary failed: %v", err)
+	}
+	return id
+}
+
+// serveTestRequest はリクエストを処理し、レスポンスを返す
+func serveTestRequest(s *Server, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+// newTestUploadRequest は写真アップロードAPIのリクエストを生成する。idempotencyKeyが空の場合は Idempotency-Key ヘッダーを付けない
+func newTestUploadRequest(t *testing.T, token string, photo []byte, fields map[string]string, idempotencyKey string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("photo", "photo.jpg")
+	if err != nil {
+		t.Fatalf("CreateFormFile failed: %v", err)
+	}
+	part.Write(photo)
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			t.Fatalf("WriteField failed: %v", err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("failed to close multipart writer: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api/photos", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.Header.Set("X-API-Key", token)
+	if idempotencyKey != "" {
+		r.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	return r
+}
+
+func TestHandleAPITokenCreate_AdminScope(t *testing.T) {
+	s := newTestServer(t)
+	s.adminUsers = map[string]bool{"operator": true}
+	alice := createTestUser(t, s, "alice")
+	operator := createTestUser(t, s, "operator")
+
+	tests := []struct {
+		name  string
+		user  *User
+		scope string
+		want  int
+	}{
+		{"user can issue read token", alice, "read", http.StatusOK},
+		{"user cannot issue admin token", alice, "admin", http.StatusForbidden},
+		{"operator can issue admin token", operator, "admin", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"name": {"camera"}, "scope": {tt.scope}}
+			r := httptest.NewRequest(http.MethodPost, "/settings/tokens", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.AddCookie(loginTestUser(t, s, tt.user))
+			if w := serveTestRequest(s, r); w.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	tokens, err := s.apiTokenRepo.GetAPITokensByUserID(t.Context(), alice.ID)
+	if err != nil {
+		t.Fatalf("GetAPITokensByUserID failed: %v", err)
+	}
+	for _, token := range tokens {
+		if token.Scope == APITokenScopeAdmin {
+			t.Errorf("expected no admin token for alice, got %+v", token)
+		}
+	}
+}
+
+func TestHandleSettings_HidesAdminScope(t *testing.T) {
+	s := newTestServer(t)
+	s.adminUsers = map[string]bool{"operator": true}
+
+	for _, tt := range []struct {
+		username  string
+		wantAdmin bool
+	}{
+		{"alice", false},
+		{"operator", true},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/settings", nil)
+		r.AddCookie(loginTestUser(t, s, createTestUser(t, s, tt.username)))
+		w := serveTestRequest(s, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", tt.username, w.Code)
+		}
+		if got := strings.Contains(w.Body.String(), `<option value="admin">`); got != tt.wantAdmin {
+			t.Errorf("%s: admin option shown = %v, want %v", tt.username, got, tt.wantAdmin)
+		}
+	}
+}
+
+func TestAuthenticateAPIToken_AdminTokenOfNonAdmin(t *testing.T) {
+	s := newTestServer(t)
+	alice := createTestUser(t, s, "alice")
+	// ADMIN_USERS から外れたユーザーが以前に発行したadminトークンは使えない
+	token := issueTestToken(t, s, alice, APITokenScopeAdmin)
+
+	body := `{"username": "mallory", "password": "secret"}`
+	r := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-API-Key", token)
+	if w := serveTestRequest(s, r); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403, got %d: %s", w.Code, w.Body.String())
+	}
+	if user, err := s.userRepo.GetUserByUsername(t.Context(), "mallory"); err != nil || user != nil {
+		t.Errorf("expected user not to be created, got %+v, %v", user, err)
+	}
+}
+
+func TestPostApiUsers_FirstUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		setupToken string
+		apiKey     string
+		want       int
+	}{
+		{"setup token not configured", "", "", http.StatusServiceUnavailable},
+		{"missing setup token", "setup-secret", "", http.StatusUnauthorized},
+		{"wrong setup token", "setup-secret", "wrong", http.StatusUnauthorized},
+		{"valid setup token", "setup-secret", "setup-secret", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.setupToken = tt.setupToken
+
+			body := `{"username": "alice", "password": "secret"}`
+			r := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			if tt.apiKey != "" {
+				r.Header.Set("X-API-Key", tt.apiKey)
+			}
+			if w := serveTestRequest(s, r); w.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPostApiUsers_SetupTokenAfterFirstUser(t *testing.T) {
+	s := newTestServer(t)
+	s.setupToken = "setup-secret"
+	createTestUser(t, s, "alice")
+
+	// ログイン可能なユーザーがいる場合、SETUP_TOKEN では作成できない
+	body := `{"username": "bob", "password": "secret"}`
+	r := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-API-Key", "setup-secret")
+	if w := serveTestRequest(s, r); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPatchApiDiariesId_Scope(t *testing.T) {
+	s := newTestServer(t)
+	alice := createTestUser(t, s, "alice")
+	bob := createTestUser(t, s, "bob")
+	aliceDiary := createTestDiary(t, s, alice, filepath.Join(s.photosDir, "alice.jpg"))
+	bobDiary := createTestDiary(t, s, bob, filepath.Join(s.photosDir, "bob.jpg"))
+
+	tests := []struct {
+		name  string
+		scope APITokenScope
+		id    int
+		want  int
+	}{
+		{"write token can update own diary", APITokenScopeWrite, aliceDiary, http.StatusOK},
+		{"admin token can update own diary", APITokenScopeAdmin, aliceDiary, http.StatusOK},
+		{"read token cannot update", APITokenScopeRead, aliceDiary, http.StatusForbidden},
+		{"upload token cannot update", APITokenScopeUpload, aliceDiary, http.StatusForbidden},
+		{"write token cannot update other user's diary", APITokenScopeWrite, bobDiary, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.scope == APITokenScopeAdmin {
+				s.adminUsers = map[string]bool{"alice": true}
+				defer func() { s.adminUsers = nil }()
+			}
+			r := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/api/diaries/%d", tt.id), strings.NewReader(`{"content": "更新"}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("X-API-Key", issueTestToken(t, s, alice, tt.scope))
+			if w := serveTestRequest(s, r); w.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	diary, err := s.repo.GetDiaryByID(t.Context(), bobDiary)
+	if err != nil || diary == nil || diary.Content != "日記" {
+		t.Errorf("expected bob's diary to be unchanged, got %+v, %v", diary, err)
+	}
+}
+
+func TestDeleteApiDiariesId_WriteScope(t *testing.T) {
+	s := newTestServer(t)
+	alice := createTestUser(t, s, "alice")
+	id := createTestDiary(t, s, alice, filepath.Join(s.photosDir, "alice.jpg"))
+	token := issueTestToken(t, s, alice, APITokenScopeWrite)
+
+	r := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/diaries/%d", id), nil)
+	r.Header.Set("X-API-Key", token)
+	if w := serveTestRequest(s, r); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if diary, err := s.repo.GetDiaryByID(t.Context(), id); err != nil || diary != nil {
+		t.Errorf("expected diary to be moved to trash, got %+v, %v", diary, err)
+	}
+
+	// writeスコープではユーザー・植物の作成などの管理操作はできない
+	r = httptest.NewRequest(http.MethodPost, "/api/plants", strings.NewReader(`{"name": "ミント"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-API-Key", token)
+	if w := serveTestRequest(s, r); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOwnerOnlyPages_OtherUser(t *testing.T) {
+	s := newTestServer(t)
+	alice := createTestUser(t, s, "alice")
+	bob := createTestUser(t, s, "bob")
+	id := createTestDiary(t, s, alice, filepath.Join(s.photosDir, alice.UUID, "alice.jpg"))
+	plantUUID, err := generateUUID()
+	if err != nil {
+		t.Fatalf("generateUUID failed: %v", err)
+	}
+	if err := s.plantRepo.CreatePlant(t.Context(), plantUUID, alice.ID, "ミント", "", "", time.Time{}); err != nil {
+		t.Fatalf("CreatePlant failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		form   url.Values
+	}{
+		{"edit page", http.MethodGet, fmt.Sprintf("/diary/%d/edit", id), nil},
+		{"edit", http.MethodPost, fmt.Sprintf("/diary/%d/edit", id), url.Values{"content": {"改ざん"}}},
+		{"regenerate", http.MethodPost, fmt.Sprintf("/diary/%d/regenerate", id), nil},
+		{"delete", http.MethodPost, fmt.Sprintf("/diary/%d/delete", id), nil},
+		{"plant cover", http.MethodPost, fmt.Sprintf("/plants/%s/cover", plantUUID), url.Values{"diary_id": {fmt.Sprint(id)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.AddCookie(loginTestUser(t, s, bob))
+			if w := serveTestRequest(s, r); w.Code != http.StatusForbidden {
+				t.Errorf("expected 403, got %d", w.Code)
+			}
+		})
+	}
+
+	diary, err := s.repo.GetDiaryByID(t.Context(), id)
+	if err != nil || diary == nil || diary.Content != "日記" {
+		t.Errorf("expected alice's diary to be unchanged, got %+v, %v", diary, err)
+	}
+}
+
+func TestPrivateUserResources_OtherUser(t *testing.T) {
+	s := newTestServer(t)
+	alice := createTestUser(t, s, "alice")
+	bob := createTestUser(t, s, "bob")
+	if err := s.userRepo.SetDiariesPublic(t.Context(), alice.ID, false); err != nil {
+		t.Fatalf("SetDiariesPublic failed: %v", err)
+	}
+	imagePath := filepath.Join(s.photosDir, alice.UUID, "alice.jpg")
+	writeTestJPEGAt(t, imagePath, time.Now())
+	id := createTestDiary(t, s, alice, imagePath)
+	if err := s.jobRepo.CreateJob(t.Context(), "alice-job", alice.ID, 0, imagePath, time.Now()); err != nil {
+		t.Fatalf("CreateJob failed: %v", err)
+	}
+
+	// 非公開ユーザーの日記・写真は、本人以外には存在しないものとして扱う
+	pages := []string{
+		fmt.Sprintf("/diary/%d", id),
+		"/photos/" + alice.UUID + "/alice.jpg",
+		"/photos/" + alice.UUID + "/alice.jpg?size=thumb",
+	}
+	for _, path := range pages {
+		for _, tt := range []struct {
+			user *User
+			want int
+		}{
+			{alice, http.StatusOK},
+			{bob, http.StatusNotFound},
+			{nil, http.StatusNotFound},
+		} {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			if tt.user != nil {
+				r.AddCookie(loginTestUser(t, s, tt.user))
+			}
+			if w := serveTestRequest(s, r); w.Code != tt.want {
+				t.Errorf("%s as %+v: expected %d, got %d", path, tt.user, tt.want, w.Code)
+			}
+		}
+	}
+
+	// APIでは他のユーザーの日記・ジョブは存在しないものとして扱う
+	for _, path := range []string{fmt.Sprintf("/api/diaries/%d", id), "/api/jobs/alice-job"} {
+		for _, tt := range []struct {
+			user *User
+			want int
+		}{
+			{alice, http.StatusOK},
+			{bob, http.StatusNotFound},
+		} {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			r.Header.Set("X-API-Key", issueTestToken(t, s, tt.user, APITokenScopeRead))
+			if w := serveTestRequest(s, r); w.Code != tt.want {
+				t.Errorf("%s as %s: expected %d, got %d: %s", path, tt.user.Username, tt.want, w.Code, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestAuthenticateAPIToken_InsufficientScope(t *testing.T) {
+	s := newTestServer(t)
+	alice := createTestUser(t, s, "alice")
+
+	tests := []struct {
+		name        string
+		scope       APITokenScope
+		method      string
+		path        string
+		contentType string
+		body        string
+	}{
+		{"read token cannot upload", APITokenScopeRead, http.MethodPost, "/api/photos", "multipart/form-data; boundary=x", "--x--\r\n"},
+		{"read token cannot create plant", APITokenScopeRead, http.MethodPost, "/api/plants", "application/json", `{"name": "ミント"}`},
+		{"read token cannot create user", APITokenScopeRead, http.MethodPost, "/api/users", "application/json", `{"username": "mallory", "password": "secret"}`},
+		{"read token cannot get provider stats", APITokenScopeRead, http.MethodGet, "/api/generator/providers", "", ""},
+		{"upload token cannot list diaries", APITokenScopeUpload, http.MethodGet, "/api/diaries", "", ""},
+		{"upload token cannot create plant", APITokenScopeUpload, http.MethodPost, "/api/plants", "application/json", `{"name": "ミント"}`},
+		{"write token cannot upload", APITokenScopeWrite, http.MethodPost, "/api/photos", "multipart/form-data; boundary=x", "--x--\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			r.Header.Set("X-API-Key", issueTestToken(t, s, alice, tt.scope))
+			if w := serveTestRequest(s, r); w.Code != http.StatusForbidden {
+				t.Errorf("expected 403, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if user, err := s.userRepo.GetUserByUsername(t.Context(), "mallory"); err != nil || user != nil {
+		t.Errorf("expected user not to be created, got %+v, %v", user, err)
+	}
+}
+
+func TestPhotoURL_UserSubdirectory(t *testing.T) {
+	s := newTestServer(t)
+	alice := createTestUser(t, s, "alice")
+	plantUUID, err := generateUUID()
+	if err != nil {
+		t.Fatalf("generateUUID failed: %v", err)
+	}
+	if err := s.plantRepo.CreatePlant(t.Context(), plantUUID, alice.ID, "ミント", "", "", time.Time{}); err != nil {
+		t.Fatalf("CreatePlant failed: %v", err)
+	}
+	plant, err := s.plantRepo.GetPlantByUUID(t.Context(), plantUUID)
+	if err != nil || plant == nil {
+		t.Fatalf("GetPlantByUUID failed: %+v, %v", plant, err)
+	}
+	id, err := s.repo.CreateGeneratedDiary(t.Context(), alice.ID, plant.ID, filepath.Join(s.photosDir, alice.UUID, "alice.jpg"), "日記", "", nil, time.Now())
+	if err != nil {
+		t.Fatalf("CreateGeneratedDiary failed: %v", err)
+	}
+	deleted := createTestDiary(t, s, alice, filepath.Join(s.photosDir, alice.UUID, "deleted.jpg"))
+	if err := s.repo.DeleteDiary(t.Context(), deleted); err != nil {
+		t.Fatalf("DeleteDiary failed: %v", err)
+	}
+
+	// アップロードした写真はユーザーUUIDのサブディレクトリにあるため、URLにもUUIDを含める
+	want := "/photos/" + alice.UUID + "/"
+	tests := []struct {
+		path string
+		file string
+	}{
+		{"/", "alice.jpg?size=thumb"},
+		{fmt.Sprintf("/diary/%d", id), "alice.jpg?size=medium"},
+		{"/slideshow", "alice.jpg?size=medium"},
+		{"/plants", "alice.jpg?size=thumb"},
+		{"/plants/" + plantUUID, "alice.jpg?size=thumb"},
+		{"/plants/" + plantUUID + "/slideshow", "alice.jpg?size=medium"},
+		{"/trash", "deleted.jpg?size=thumb"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.AddCookie(loginTestUser(t, s, alice))
+		w := serveTestRequest(s, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", tt.path, w.Code)
+		}
+		if body := w.Body.String(); !strings.Contains(body, want+tt.file) {
+			t.Errorf("%s: expected photo URL %s", tt.path, want+tt.file)
+		}
+	}
+}
+
+func TestPostApiDiariesIdRegenerate_Scope(t *testing.T) {
+	s := newTestServer(t)
+	alice := createTestUser(t, s, "alice")
+	bob := createTestUser(t, s, "bob")
+	aliceDiary := createTestDiary(t, s, alice, filepath.Join(s.photosDir, "alice.jpg"))
+	bobDiary := createTestDiary(t, s, bob, filepath.Join(s.photosDir, "bob.jpg"))
+
+	tests := []struct {
+		name  string
+		scope APITokenScope
+		id    int
+		want  int
+	}{
+		{"write token can regenerate own diary", APITokenScopeWrite, aliceDiary, http.StatusOK},
+		{"read token cannot regenerate", APITokenScopeRead, aliceDiary, http.StatusForbidden},
+		{"upload token cannot regenerate", APITokenScopeUpload, aliceDiary, http.StatusForbidden},
+		{"write token cannot regenerate other user's diary", APITokenScopeWrite, bobDiary, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/diaries/%d/regenerate", tt.id), nil)
+			r.Header.Set("X-API-Key", issueTestToken(t, s, alice, tt.scope))
+			if w := serveTestRequest(s, r); w.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	diary, err := s.repo.GetDiaryByID(t.Context(), aliceDiary)
+	if err != nil || diary == nil || diary.Content == "日記" {
+		t.Errorf("expected alice's diary to be regenerated, got %+v, %v", diary, err)
+	}
+	diary, err = s.repo.GetDiaryByID(t.Context(), bobDiary)
+	if err != nil || diary == nil || diary.Content != "日記" {
+		t.Errorf("expected bob's diary to be unchanged, got %+v, %v", diary, err)
+	}
+}
+
+func TestPostApiPhotos_DuplicateIdempotencyReplay(t *testing.T) {
+	for _, mode := range []string{"skip", "merge"} {
+		t.Run(mode, func(t *testing.T) {
+			s := newTestServer(t)
+			alice := createTestUser(t, s, "alice")
+			token := issueTestToken(t, s, alice, APITokenScopeUpload)
+			photo := encodeTestJPEG(t, 64, 48, nil)
+			fields := map[string]string{"captured_at": "2026-02-01T12:00:00Z"}
+
+			// 重複の元になる写真を登録する
+			if w := serveTestRequest(s, newTestUploadRequest(t, token, photo, fields, "")); w.Code != http.StatusAccepted {
+				t.Fatalf("expected 202 for original photo, got %d: %s", w.Code, w.Body.String())
+			}
+
+			fields["duplicate"] = mode
+			first := serveTestRequest(s, newTestUploadRequest(t, token, photo, fields, "key-1"))
+			if first.Code != http.StatusOK {
+				t.Fatalf("expected 200 for duplicate photo, got %d: %s", first.Code, first.Body.String())
+			}
+			stored, _ := filepath.Glob(filepath.Join(s.photosDir, alice.UUID, "*.jpg"))
+
+			// 同じキーの再送には最初のリクエストと同じ結果を返し、写真を保存し直さない
+			replay := serveTestRequest(s, newTestUploadRequest(t, token, photo, fields, "key-1"))
+			if replay.Code != http.StatusOK {
+				t.Fatalf("expected 200 for replay, got %d: %s", replay.Code, replay.Body.String())
+			}
+			if got := replay.Header().Get("Idempotent-Replayed"); got != "true" {
+				t.Errorf("expected Idempotent-Replayed: true, got %q", got)
+			}
+			var want, got UploadPhotoDuplicateResponse
+			if err := json.Unmarshal(first.Body.Bytes(), &want); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if err := json.Unmarshal(replay.Body.Bytes(), &got); err != nil {
+				t.Fatalf("failed to decode replayed response: %v", err)
+			}
+			if got.Action != want.Action || got.DuplicateOf != want.DuplicateOf || got.Distance != want.Distance || (got.ImageUrl == nil) != (want.ImageUrl == nil) || (got.ImageUrl != nil && *got.ImageUrl != *want.ImageUrl) {
+				t.Errorf("expected replayed response %+v, got %+v", want, got)
+			}
+			if after, _ := filepath.Glob(filepath.Join(s.photosDir, alice.UUID, "*.jpg")); len(after) != len(stored) {
+				t.Errorf("expected %d stored photos after replay, got %d", len(stored), len(after))
+			}
+		})
+	}
+}
+
+func TestPostApiPhotos_IdempotencyKey(t *testing.T) {
+	capturedAt := "2026-02-01T12:00:00Z"
+	fields := map[string]string{"captured_at": capturedAt}
+
+	t.Run("replay", func(t *testing.T) {
+		s := newTestServer(t)
+		token := issueTestToken(t, s, createTestUser(t, s, "alice"), APITokenScopeUpload)
+		photo := encodeTestJPEG(t, 64, 48, nil)
+
+		first := serveTestRequest(s, newTestUploadRequest(t, token, photo, fields, "key-1"))
+		if first.Code != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d: %s", first.Code, first.Body.String())
+		}
+		if got := first.Header().Get("Idempotent-Replayed"); got != "" {
+			t.Errorf("expected no Idempotent-Replayed header for first request, got %q", got)
+		}
+		replay := serveTestRequest(s, newTestUploadRequest(t, token, photo, fields, "key-1"))
+		if replay.Code != http.StatusAccepted {
+			t.Fatalf("expected 202 for replay, got %d: %s", replay.Code, replay.Body.String())
+		}
+		if got := replay.Header().Get("Idempotent-Replayed"); got != "true" {
+			t.Errorf("expected Idempotent-Replayed: true, got %q", got)
+		}
+		var want, got UploadPhotoResponse
+		json.Unmarshal(first.Body.Bytes(), &want)
+		json.Unmarshal(replay.Body.Bytes(), &got)
+		if want.JobId == "" || got.JobId != want.JobId {
+			t.Errorf("expected replayed job %q, got %q", want.JobId, got.JobId)
+		}
+	})
+
+	t.Run("in progress", func(t *testing.T) {
+		s := newTestServer(t)
+		alice := createTestUser(t, s, "alice")
+		token := issueTestToken(t, s, alice, APITokenScopeUpload)
+		photo := encodeTestJPEG(t, 64, 48, nil)
+
+		// 最初のリクエストを処理中の状態にする
+		if _, err := s.uploadKeyRepo.ReserveUploadIdempotencyKey(t.Context(), alice.ID, "key-1", uploadFingerprint(photo, "", capturedAt), time.Now().Add(-uploadIdempotencyKeyTTL)); err != nil {
+			t.Fatalf("ReserveUploadIdempotencyKey failed: %v", err)
+		}
+		if w := serveTestRequest(s, newTestUploadRequest(t, token, photo, fields, "key-1")); w.Code != http.StatusConflict {
+			t.Errorf("expected 409, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("different request", func(t *testing.T) {
+		s := newTestServer(t)
+		token := issueTestToken(t, s, createTestUser(t, s, "alice"), APITokenScopeUpload)
+		photo := encodeTestJPEG(t, 64, 48, nil)
+
+		if w := serveTestRequest(s, newTestUploadRequest(t, token, photo, fields, "key-1")); w.Code != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+		}
+		other := map[string]string{"captured_at": "2026-02-02T12:00:00Z"}
+		if w := serveTestRequest(s, newTestUploadRequest(t, token, photo, other, "key-1")); w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected 422, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("released on failure", func(t *testing.T) {
+		s := newTestServer(t)
+		token := issueTestToken(t, s, createTestUser(t, s, "alice"), APITokenScopeUpload)
+
+		if w := serveTestRequest(s, newTestUploadRequest(t, token, []byte("not a photo"), fields, "key-1")); w.Code != http.StatusUnsupportedMediaType {
+			t.Fatalf("expected 415 for unsupported photo, got %d: %s", w.Code, w.Body.String())
+		}
+		// 失敗したリクエストのキーは、内容を変えて再送しても使える
+		if w := serveTestRequest(s, newTestUploadRequest(t, token, encodeTestJPEG(t, 64, 48, nil), fields, "key-1")); w.Code != http.StatusAccepted {
+			t.Errorf("expected 202 after failed request, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("stale pending key", func(t *testing.T) {
+		s := newTestServer(t)
+		alice := createTestUser(t, s, "alice")
+		token := issueTestToken(t, s, alice, APITokenScopeUpload)
+		photo := encodeTestJPEG(t, 64, 48, nil)
+
+		// 処理中のまま一定時間たったキーは、中断されたリクエストのものとして登録し直す
+		if _, err := s.uploadKeyRepo.ReserveUploadIdempotencyKey(t.Context(), alice.ID, "key-1", uploadFingerprint(photo, "", capturedAt), time.Now().Add(-uploadIdempotencyKeyTTL)); err != nil {
+			t.Fatalf("ReserveUploadIdempotencyKey failed: %v", err)
+		}
+		db := s.uploadKeyRepo.(*SQLiteUploadIdempotencyKeyRepository).db
+		if _, err := db.Exec("UPDATE upload_idempotency_keys SET created_at = ?", time.Now().Add(-uploadIdempotencyPendingTimeout-time.Minute)); err != nil {
+			t.Fatalf("failed to age idempotency key: %v", err)
+		}
+		if w := serveTestRequest(s, newTestUploadRequest(t, token, photo, fields, "key-1")); w.Code != http.StatusAccepted {
+			t.Errorf("expected 202 for stale pending key, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestPostApiPhotos_DuplicateAndPurge(t *testing.T) {
+	s := newTestServer(t)
+	alice := createTestUser(t, s, "alice")
+	token := issueTestToken(t, s, alice, APITokenScopeUpload)
+	photo := encodeTestJPEG(t, 64, 48, nil)
+	userDir := filepath.Join(s.photosDir, alice.UUID)
+
+	// 重複の元になる写真を登録し、日記を作成する
+	if w := serveTestRequest(s, newTestUploadRequest(t, token, photo, map[string]string{"captured_at": "2026-02-01T12:00:00Z"}, "")); w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 for original photo, got %d: %s", w.Code, w.Body.String())
+	}
+	stored, _ := filepath.Glob(filepath.Join(userDir, "*.jpg"))
+	if len(stored) != 1 {
+		t.Fatalf("expected 1 stored photo, got %v", stored)
+	}
+	original := stored[0]
+	id := createTestDiary(t, s, alice, original)
+
+	upload := func(mode string) UploadPhotoDuplicateResponse {
+		t.Helper()
+		fields := map[string]string{"captured_at": "2026-02-01T12:30:00Z", "duplicate": mode}
+		w := serveTestRequest(s, newTestUploadRequest(t, token, photo, fields, ""))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 for %s, got %d: %s", mode, w.Code, w.Body.String())
+		}
+		var resp UploadPhotoDuplicateResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if resp.DuplicateOf != s.photoURL(original) || resp.DuplicateOfDiaryId == nil || *resp.DuplicateOfDiaryId != id {
+			t.Errorf("expected duplicate of %s (diary %d), got %+v", s.photoURL(original), id, resp)
+		}
+		return resp
+	}
+
+	// skip は写真を保存しない
+	if resp := upload("skip"); resp.Action != Skipped || resp.ImageUrl != nil {
+		t.Errorf("expected skipped without image, got %+v", resp)
+	}
+	if after, _ := filepath.Glob(filepath.Join(userDir, "*.jpg")); len(after) != 1 {
+		t.Errorf("expected skipped photo not to be stored, got %v", after)
+	}
+
+	// merge は写真を保存し、統合先を記録する（日記生成ジョブは登録しない）
+	resp := upload("merge")
+	if resp.Action != Merged || resp.ImageUrl == nil {
+		t.Fatalf("expected merged with image, got %+v", resp)
+	}
+	after, _ := filepath.Glob(filepath.Join(userDir, "*.jpg"))
+	if len(after) != 2 {
+		t.Fatalf("expected merged photo to be stored, got %v", after)
+	}
+	merged := after[0]
+	if merged == original {
+		merged = after[1]
+	}
+	if *resp.ImageUrl != s.photoURL(merged) {
+		t.Errorf("expected image URL %s, got %s", s.photoURL(merged), *resp.ImageUrl)
+	}
+	if hash, err := s.photoHashRepo.GetPhotoHash(t.Context(), merged); err != nil || hash == nil || hash.DuplicateOf != original {
+		t.Errorf("expected merged photo hash with duplicate_of %s, got %+v, %v", original, hash, err)
+	}
+
+	// 日記を完全に削除すると、統合した写真もファイルと知覚ハッシュごと削除する
+	if err := s.repo.DeleteDiary(t.Context(), id); err != nil {
+		t.Fatalf("DeleteDiary failed: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/trash/%d/purge", id), nil)
+	r.AddCookie(loginTestUser(t, s, alice))
+	if w := serveTestRequest(s, r); w.Code != http.StatusFound {
+		t.Fatalf("expected 302 for purge, got %d: %s", w.Code, w.Body.String())
+	}
+	if left, _ := filepath.Glob(filepath.Join(userDir, "*.jpg")); len(left) != 0 {
+		t.Errorf("expected photos to be removed, got %v", left)
+	}
+	if hash, err := s.photoHashRepo.GetPhotoHash(t.Context(), merged); err != nil || hash != nil {
+		t.Errorf("expected merged photo hash to be removed, got %+v, %v", hash, err)
+	}
+}
+
+func TestPostApiPhotos_InvalidPhoto(t *testing.T) {
+	var tooWide bytes.Buffer
+	if err := png.Encode(&tooWide, image.NewGray(image.Rect(0, 0, maxUploadPhotoEdge+1, 1))); err != nil {
+		t.Fatalf("failed to encode png: %v", err)
+	}
+	valid := encodeTestJPEG(t, 8, 8, nil)
+
+	tests := []struct {
+		name   string
+		photo  []byte
+		fields map[string]string
+		want   int
+	}{
+		{"file size exceeds limit", make([]byte, maxUploadPhotoBytes+1), nil, http.StatusRequestEntityTooLarge},
+		{"request exceeds limit", make([]byte, maxUploadPhotoBytes+uploadFormOverheadBytes+1), nil, http.StatusRequestEntityTooLarge},
+		{"pixels exceed limit", tooWide.Bytes(), nil, http.StatusRequestEntityTooLarge},
+		{"unsupported format", []byte("plain text"), nil, http.StatusUnsupportedMediaType},
+		{"truncated jpeg", valid[:len(valid)/2], nil, http.StatusBadRequest},
+		{"invalid captured_at", valid, map[string]string{"captured_at": "2026-02-01"}, http.StatusBadRequest},
+		{"invalid duplicate", valid, map[string]string{"duplicate": "replace"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			alice := createTestUser(t, s, "alice")
+			token := issueTestToken(t, s, alice, APITokenScopeUpload)
+
+			if w := serveTestRequest(s, newTestUploadRequest(t, token, tt.photo, tt.fields, "")); w.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+			if stored, _ := filepath.Glob(filepath.Join(s.photosDir, alice.UUID, "*")); len(stored) != 0 {
+				t.Errorf("expected no stored photo, got %v", stored)
+			}
+		})
+	}
+}
+
+func TestPostApiPhotos_WorkerUnavailable(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(t *testing.T, s *Server)
+		want       int
+		retryAfter string
+	}{
+		{"queue full", func(t *testing.T, s *Server) {
+			// Workerを起動していないため、キュー長（10）までジョブを積むと満杯になる
+			for i := 0; i < 10; i++ {
+				if _, err := s.worker.Enqueue(t.Context(), 1, 0, fmt.Sprintf("/path/%d.jpg", i), time.Now()); err != nil {
+					t.Fatalf("Enqueue failed: %v", err)
+				}
+			}
+		}, http.StatusTooManyRequests, strconv.Itoa(uploadRetryAfterSeconds)},
+		{"worker stopped", func(t *testing.T, s *Server) {
+			if err := s.worker.Shutdown(t.Context()); err != nil {
+				t.Fatalf("Shutdown failed: %v", err)
+			}
+		}, http.StatusServiceUnavailable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			alice := createTestUser(t, s, "alice")
+			token := issueTestToken(t, s, alice, APITokenScopeUpload)
+			tt.setup(t, s)
+
+			w := serveTestRequest(s, newTestUploadRequest(t, token, encodeTestJPEG(t, 8, 8, nil), nil, ""))
+			if w.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("expected Retry-After %q, got %q", tt.retryAfter, got)
+			}
+			// ジョブを受け付けなかった写真は保存しない
+			if stored, _ := filepath.Glob(filepath.Join(s.photosDir, alice.UUID, "*.jpg")); len(stored) != 0 {
+				t.Errorf("expected rejected photo to be removed, got %v", stored)
+			}
+		})
+	}
+}
+
+func TestPostApiDiariesIdRegenerate_Timeout(t *testing.T) {
+	s := newTestServer(t)
+	s.generator = &blockingDiaryGenerator{started: make(chan struct{})}
+	s.regenTimeout = 10 * time.Millisecond
+	alice := createTestUser(t, s, "alice")
+	id := createTestDiary(t, s, alice, filepath.Join(s.photosDir, "alice.jpg"))
+
+	r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/diaries/%d/regenerate", id), nil)
+	r.Header.Set("X-API-Key", issueTestToken(t, s, alice, APITokenScopeWrite))
+	if w := serveTestRequest(s, r); w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected 504, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// 打ち切った場合は本文を置き換えない
+	diary, err := s.repo.GetDiaryByID(t.Context(), id)
+	if err != nil || diary == nil || diary.Content != "日記" {
+		t.Errorf("expected diary to be unchanged, got %+v, %v", diary, err)
+	}
+}
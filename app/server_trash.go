@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// handleDiaryDelete は日記をゴミ箱へ移動し、ゴミ箱ページへリダイレクトする
+func (s *Server) handleDiaryDelete(w http.ResponseWriter, r *http.Request) {
+	diary, _, ok := s.loadOwnedDiary(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.repo.DeleteDiary(r.Context(), diary.ID); err != nil {
+		log.Printf("ERROR: failed to delete diary %d: %v", diary.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/trash", http.StatusFound)
+}
+
+// handleTrash はログインユーザーのゴミ箱の日記一覧ページを表示する
+func (s *Server) handleTrash(w http.ResponseWriter, r *http.Request) {
+	currentUser, err := s.getCurrentUser(r)
+	if err != nil || currentUser == nil {
+		log.Printf("ERROR: failed to get current user: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	diaries, err := s.repo.GetDeletedDiaries(r.Context(), currentUser.ID)
+	if err != nil {
+		log.Printf("ERROR: failed to get deleted diaries of user %d: %v", currentUser.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	// ImagePathを写真のURLに変換
+	for i := range diaries {
+		diaries[i].ImagePath = s.photoURL(diaries[i].ImagePath)
+	}
+
+	data := map[string]interface{}{
+		"Diaries":  diaries,
+		"LoggedIn": true,
+		"Username": currentUser.Username,
+	}
+
+	if err := s.templates.ExecuteTemplate(w, "trash.html", data); err != nil {
+		log.Printf("ERROR: failed to render trash template: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+}
+
+// lookupDeletedDiary はパスのidの日記をログインユーザーのゴミ箱から取得する。
+// 他のユーザーの日記やゴミ箱にない日記は存在しないものとして404のエラーページを表示してfalseを返す
+func (s *Server) lookupDeletedDiary(w http.ResponseWriter, r *http.Request) (*Diary, bool) {
+	currentUser, err := s.getCurrentUser(r)
+	if err != nil || currentUser == nil {
+		log.Printf("ERROR: failed to get current user: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("ERROR: invalid diary id: %s", idStr)
+		s.renderError(w, http.StatusNotFound)
+		return nil, false
+	}
+
+	diaries, err := s.repo.GetDeletedDiaries(r.Context(), currentUser.ID)
+	if err != nil {
+		log.Printf("ERROR: failed to get deleted diaries of user %d: %v", currentUser.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return nil, false
+	}
+	for i := range diaries {
+		if diaries[i].ID == id {
+			return &diaries[i], true
+		}
+	}
+
+	s.renderError(w, http.StatusNotFound)
+	return nil, false
+}
+
+// handleTrashRestore はゴミ箱の日記を元に戻し、日記詳細ページへリダイレクトする
+func (s *Server) handleTrashRestore(w http.ResponseWriter, r *http.Request) {
+	diary, ok := s.lookupDeletedDiary(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.repo.RestoreDiary(r.Context(), diary.ID); err != nil {
+		log.Printf("ERROR: failed to restore diary %d: %v", diary.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/diary/%d", diary.ID), http.StatusFound)
+}
+
+// handleTrashPurge はゴミ箱の日記を写真ファイル（重複として統合した写真を含む）とともに完全に削除し、ゴミ箱ページへリダイレクトする
+func (s *Server) handleTrashPurge(w http.ResponseWriter, r *http.Request) {
+	diary, ok := s.lookupDeletedDiary(w, r)
+	if !ok {
+		return
+	}
+
+	mergedPaths, err := s.repo.PurgeDiary(r.Context(), diary.ID)
+	if err != nil {
+		log.Printf("ERROR: failed to purge diary %d: %v", diary.ID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	// 日記は削除済みのため、写真ファイルの削除に失敗してもリクエストは成功とする
+	for _, path := range append([]string{diary.ImagePath}, mergedPaths...) {
+		if err := s.removePhoto(path); err != nil {
+			log.Printf("WARN: failed to remove photo of purged diary %d: %v", diary.ID, err)
+		}
+	}
+
+	http.Redirect(w, r, "/trash", http.StatusFound)
+}
+
+// removePhoto は写真ディレクトリ配下の写真ファイルを縮小画像とあわせて削除する。既にファイルがない場合は何もしない。
+// 写真ディレクトリ外のパスは削除しない
+func (s *Server) removePhoto(imagePath string) error {
+	rel, err := filepath.Rel(s.photosDir, imagePath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("photo %s is outside of photos dir %s", imagePath, s.photosDir)
+	}
+	if err := os.Remove(imagePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove photo %s: %w", imagePath, err)
+	}
+	return removePhotoDerivatives(s.photosDir, imagePath)
+}
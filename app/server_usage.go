@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// usageReportDays は使用量を日ごとに集計する日数（今日を含む）
+	usageReportDays = 31
+	// usageReportMonths は使用量を月ごとに集計する月数（今月を含む）
+	usageReportMonths = 12
+)
+
+// usageBudgetStatus はユーザーの今日・今月の使用料金と、適用している予算の状況
+type usageBudgetStatus struct {
+	User         User
+	DailyCost    float64
+	MonthlyCost  float64
+	DailyLimit   float64
+	MonthlyLimit float64
+	Custom       bool // ユーザー個別の予算を設定しているかどうか（falseの場合は既定の予算）
+	Exceeded     bool
+}
+
+// usageReport は使用量の管理ページ・APIで表示する集計結果
+type usageReport struct {
+	Daily     []UsageSummary
+	Monthly   []UsageSummary
+	Budgets   []usageBudgetStatus
+	Default   *UsageBudget // 全ユーザーの既定の予算（未設定の場合はnil）
+	Usernames map[int]string
+}
+
+// usageTableView は使用量の管理ページの、日ごと・月ごとの使用量の表の表示内容
+type usageTableView struct {
+	Rows      []UsageSummary
+	Usernames map[int]string
+}
+
+// isAdmin はユーザーが ADMIN_USERS に含まれ、使用量の管理ページを使えるかどうかを返す
+func (s *Server) isAdmin(user *User) bool {
+	return user != nil && s.adminUsers[user.Username]
+}
+
+// buildUsageReport はusersの直近の日ごと・月ごとの使用量と予算の状況を集計する
+func (s *Server) buildUsageReport(ctx context.Context, scope OwnerScope, users []User, now time.Time) (usageReport, error) {
+	today := jstDate(now)
+	from := jstTime(monthStart(today).AddDate(0, -(usageReportMonths - 1), 0))
+	records, err := s.usageRepo.GetUsageRecords(ctx, scope, from, jstTime(today.AddDate(0, 0, 1)))
+	if err != nil {
+		return usageReport{}, err
+	}
+
+	dailyFrom := jstTime(today.AddDate(0, 0, -(usageReportDays - 1)))
+	var recent []UsageRecord
+	for _, rec := range records {
+		if !rec.CreatedAt.Before(dailyFrom) {
+			recent = append(recent, rec)
+		}
+	}
+	report := usageReport{
+		Daily:     summarizeUsage(recent, usageDayKey),
+		Monthly:   summarizeUsage(records, usageMonthKey),
+		Usernames: make(map[int]string, len(users)),
+	}
+
+	budgets, err := s.usageRepo.GetUsageBudgets(ctx)
+	if err != nil {
+		return usageReport{}, err
+	}
+	userBudgets := make(map[int]UsageBudget, len(budgets))
+	for _, b := range budgets {
+		if b.UserID == 0 {
+			report.Default = &b
+			continue
+		}
+		userBudgets[b.UserID] = b
+	}
+
+	dailyCosts := make(map[int]float64)
+	for _, sum := range report.Daily {
+		if sum.Period == today.Format("2006-01-02") {
+			dailyCosts[sum.UserID] = sum.Cost
+		}
+	}
+	monthlyCosts := make(map[int]float64)
+	for _, sum := range report.Monthly {
+		if sum.Period == today.Format("2006-01") {
+			monthlyCosts[sum.UserID] = sum.Cost
+		}
+	}
+
+	for _, u := range users {
+		report.Usernames[u.ID] = u.Username
+		status := usageBudgetStatus{User: u, DailyCost: dailyCosts[u.ID], MonthlyCost: monthlyCosts[u.ID]}
+		if b, ok := userBudgets[u.ID]; ok {
+			status.DailyLimit, status.MonthlyLimit, status.Custom = b.DailyLimit, b.MonthlyLimit, true
+		} else if report.Default != nil {
+			status.DailyLimit, status.MonthlyLimit = report.Default.DailyLimit, report.Default.MonthlyLimit
+		}
+		status.Exceeded = (status.DailyLimit > 0 && status.DailyCost >= status.DailyLimit) ||
+			(status.MonthlyLimit > 0 && status.MonthlyCost >= status.MonthlyLimit)
+		report.Budgets = append(report.Budgets, status)
+	}
+	return report, nil
+}
+
+// handleAdminUsage は全ユーザーの日ごと・月ごとの使用量と予算の設定ページを表示する
+func (s *Server) handleAdminUsage(w http.ResponseWriter, r *http.Request) {
+	currentUser, ok := s.loadAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	users, err := s.userRepo.GetAllUsers(r.Context())
+	if err != nil {
+		log.Printf("ERROR: failed to get users: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+	report, err := s.buildUsageReport(r.Context(), OwnerScope{}, users, time.Now())
+	if err != nil {
+		log.Printf("ERROR: failed to build usage report: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"LoggedIn": true,
+		"Username": currentUser.Username,
+		"Daily":    usageTableView{Rows: report.Daily, Usernames: report.Usernames},
+		"Monthly":  usageTableView{Rows: report.Monthly, Usernames: report.Usernames},
+		"Budgets":  report.Budgets,
+		"Default":  report.Default,
+		"Days":     usageReportDays,
+		"Months":   usageReportMonths,
+	}
+
+	if err := s.templates.ExecuteTemplate(w, "usage.html", data); err != nil {
+		log.Printf("ERROR: failed to render usage template: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleAdminUsageBudgetSave はユーザー（user_idが0の場合は全ユーザーの既定）の予算を保存し、使用量の管理ページへリダイレクトする。
+// 上限を引き上げた場合に一時停止中のジョブをすぐ再開できるよう、Workerに再開を要求する
+func (s *Server) handleAdminUsageBudgetSave(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.loadAdminUser(w, r); !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, http.StatusBadRequest)
+		return
+	}
+	userID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil || userID < 0 {
+		s.renderError(w, http.StatusBadRequest)
+		return
+	}
+	dailyLimit, dailyOK := parseBudgetLimit(r.FormValue("daily_limit"))
+	monthlyLimit, monthlyOK := parseBudgetLimit(r.FormValue("monthly_limit"))
+	if !dailyOK || !monthlyOK {
+		s.renderError(w, http.StatusBadRequest)
+		return
+	}
+
+	if err := s.usageRepo.SaveUsageBudget(r.Context(), userID, dailyLimit, monthlyLimit); err != nil {
+		log.Printf("ERROR: failed to save usage budget of user %d: %v", userID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+	s.worker.RequestResume()
+
+	http.Redirect(w, r, "/admin/usage", http.StatusFound)
+}
+
+// handleAdminUsageBudgetReset はユーザーの予算を削除し（既定の予算を適用する）、使用量の管理ページへリダイレクトする
+func (s *Server) handleAdminUsageBudgetReset(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.loadAdminUser(w, r); !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, http.StatusBadRequest)
+		return
+	}
+	userID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil || userID < 0 {
+		s.renderError(w, http.StatusBadRequest)
+		return
+	}
+
+	if err := s.usageRepo.DeleteUsageBudget(r.Context(), userID); err != nil {
+		log.Printf("ERROR: failed to delete usage budget of user %d: %v", userID, err)
+		s.renderError(w, http.StatusInternalServerError)
+		return
+	}
+	s.worker.RequestResume()
+
+	http.Redirect(w, r, "/admin/usage", http.StatusFound)
+}
+
+// loadAdminUser はログインユーザーを取得し、ADMIN_USERS に含まれない場合は403のエラーページを表示してfalseを返す
+func (s *Server) loadAdminUser(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	currentUser, err := s.getCurrentUser(r)
+	if err != nil || currentUser == nil {
+		log.Printf("ERROR: failed to get current user: %v", err)
+		s.renderError(w, http.StatusInternalServerError)
+		return nil, false
+	}
+	if !s.isAdmin(currentUser) {
+		s.renderError(w, http.StatusForbidden)
+		return nil, false
+	}
+	return currentUser, true
+}
+
+// parseBudgetLimit はフォームの料金の上限（USD）を読み込む。空の場合は上限なし（0）とし、負の値や数値でない場合はfalseを返す
+func parseBudgetLimit(v string) (float64, bool) {
+	if v == "" {
+		return 0, true
+	}
+	limit, err := strconv.ParseFloat(v, 64)
+	if err != nil || limit < 0 {
+		return 0, false
+	}
+	return limit, true
+}
+
+// GetApiUsage は日記の生成の使用量と予算を返すAPIのハンドラ（GET /api/usage）。
+// トークンの所有ユーザーが ADMIN_USERS に含まれる場合は全ユーザー、含まれない場合は所有ユーザーのみを返す
+func (s *Server) GetApiUsage(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authenticateAPIToken(w, r, APITokenScopeRead, APITokenScopeAdmin)
+	if !ok {
+		return
+	}
+
+	scope := OwnerScope{UserID: user.ID}
+	users := []User{*user}
+	if s.isAdmin(user) {
+		var err error
+		scope = OwnerScope{}
+		users, err = s.userRepo.GetAllUsers(r.Context())
+		if err != nil {
+			log.Printf("ERROR: failed to get users: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	report, err := s.buildUsageReport(r.Context(), scope, users, time.Now())
+	if err != nil {
+		log.Printf("ERROR: failed to build usage report: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := UsageResponse{
+		Daily:   usageSummaryResponses(report.Daily, report.Usernames),
+		Monthly: usageSummaryResponses(report.Monthly, report.Usernames),
+		Budgets: []UsageBudgetStatusResponse{},
+	}
+	for _, b := range report.Budgets {
+		resp.Budgets = append(resp.Budgets, UsageBudgetStatusResponse{
+			Username:     b.User.Username,
+			DailyCost:    b.DailyCost,
+			MonthlyCost:  b.MonthlyCost,
+			DailyLimit:   b.DailyLimit,
+			MonthlyLimit: b.MonthlyLimit,
+			Exceeded:     b.Exceeded,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("ERROR: failed to encode response: %v", err)
+	}
+}
+
+// usageSummaryResponses は集計した使用量をAPIのレスポンスに変換する
+func usageSummaryResponses(summaries []UsageSummary, usernames map[int]string) []UsageSummaryResponse {
+	items := make([]UsageSummaryResponse, 0, len(summaries))
+	for _, sum := range summaries {
+		items = append(items, UsageSummaryResponse{
+			Period:           sum.Period,
+			Username:         usernames[sum.UserID],
+			Count:            sum.Count,
+			PromptTokens:     sum.PromptTokens,
+			ResponseTokens:   sum.ResponseTokens,
+			Cost:             sum.Cost,
+			AverageLatencyMs: int(sum.AverageLatency.Milliseconds()),
+		})
+	}
+	return items
+}
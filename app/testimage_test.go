@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPatternImage はテスト用に、seedごとに異なる濃淡のブロックを並べた幅w・高さhの写真を作成する
+func testPatternImage(w, h int, seed uint64) *image.RGBA {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	const blocks = 12
+	var levels [blocks][blocks]uint8
+	for y := range levels {
+		for x := range levels[y] {
+			levels[y][x] = uint8(rng.IntN(256))
+		}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := levels[y*blocks/h][x*blocks/w]
+			img.Set(x, y, color.RGBA{R: v, G: v / 2, B: 255 - v, A: 255})
+		}
+	}
+	return img
+}
+
+// encodeTestJPEG は幅w・高さhのテスト用のJPEGを作成し、SOIの直後にsegmentを挿入する（nilの場合は挿入しない）
+func encodeTestJPEG(t *testing.T, w, h int, segment []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testPatternImage(w, h, 1), nil); err != nil {
+		t.Fatalf("failed to encode jpeg: %v", err)
+	}
+	b := buf.Bytes()
+	return append(append(append([]byte{}, b[:2]...), segment...), b[2:]...)
+}
+
+// writeTestJPEG はテスト用の幅w・高さhのJPEGの写真をpathに作成する
+func writeTestJPEG(t *testing.T, path string, w, h int) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, encodeTestJPEG(t, w, h, nil), 0644); err != nil {
+		t.Fatalf("failed to write photo: %v", err)
+	}
+}
+
+// writeTestJPEGAt はテスト用のJPEGの写真を作成し、更新日時をmodTimeに設定する
+func writeTestJPEGAt(t *testing.T, path string, modTime time.Time) {
+	t.Helper()
+	writeTestJPEG(t, path, 64, 48)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("failed to set mod time: %v", err)
+	}
+}
+
+// writeInvalidTestPhoto は画像としてデコードできない内容のファイルを作成し、更新日時をmodTimeに設定する。
+// 写真として扱わないファイルや、壊れた写真のテストに使う
+func writeInvalidTestPhoto(t *testing.T, path string, modTime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("fake image data"), 0644); err != nil {
+		t.Fatalf("failed to write photo: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("failed to set mod time: %v", err)
+	}
+}
+
+// tempTestJPEG は一時ディレクトリにテスト用のJPEGの写真を作成し、パスを返す
+func tempTestJPEG(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	writeTestJPEG(t, path, 8, 8)
+	return path
+}
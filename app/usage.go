@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrBudgetExceeded は使用料金が予算の上限に達していて、日記を生成できないことを表す
+var ErrBudgetExceeded = errors.New("usage budget exceeded")
+
+// ModelPrice はモデルの料金（USD / 100万トークン）
+type ModelPrice struct {
+	Input  float64 // 入力（プロンプトと画像）の料金
+	Output float64 // 出力（思考を含む）の料金
+}
+
+// defaultModelPrices は USAGE_PRICES で上書きしない場合のモデルごとの料金
+var defaultModelPrices = map[string]ModelPrice{
+	"gemini-2.5-flash": {Input: 0.30, Output: 2.50},
+}
+
+// LoadModelPrices は環境変数 USAGE_PRICES（「モデル名=入力の料金/出力の料金」のカンマ区切り）からモデルごとの料金を読み込む。
+// 指定しなかったモデルは defaultModelPrices の料金を使い、いずれにもないモデル（Ollamaなど）の料金は0とする
+func LoadModelPrices() (map[string]ModelPrice, error) {
+	prices := make(map[string]ModelPrice, len(defaultModelPrices))
+	for model, price := range defaultModelPrices {
+		prices[model] = price
+	}
+
+	v := os.Getenv("USAGE_PRICES")
+	if v == "" {
+		return prices, nil
+	}
+	for _, entry := range strings.Split(v, ",") {
+		model, rates, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		input, output, ok2 := strings.Cut(rates, "/")
+		if !ok || !ok2 || strings.TrimSpace(model) == "" {
+			return nil, fmt.Errorf("USAGE_PRICES must be a comma-separated list of model=input/output: %q", entry)
+		}
+		inputPrice, err := strconv.ParseFloat(strings.TrimSpace(input), 64)
+		if err != nil || inputPrice < 0 {
+			return nil, fmt.Errorf("USAGE_PRICES has an invalid input price for %s: %q", model, input)
+		}
+		outputPrice, err := strconv.ParseFloat(strings.TrimSpace(output), 64)
+		if err != nil || outputPrice < 0 {
+			return nil, fmt.Errorf("USAGE_PRICES has an invalid output price for %s: %q", model, output)
+		}
+		prices[strings.TrimSpace(model)] = ModelPrice{Input: inputPrice, Output: outputPrice}
+	}
+	return prices, nil
+}
+
+// LoadAdminUsers は環境変数 ADMIN_USERS（カンマ区切りのユーザー名）から、使用量の管理ページを使え、adminスコープのAPIトークンを発行できるユーザー名の集合を読み込む
+func LoadAdminUsers() map[string]bool {
+	admins := make(map[string]bool)
+	for _, name := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			admins[name] = true
+		}
+	}
+	return admins
+}
+
+// UsageTracker は日記の生成の使用量を記録し、ユーザーの予算の上限を確認する。nilの場合は記録も確認もしない
+type UsageTracker struct {
+	repo   UsageRepository
+	prices map[string]ModelPrice
+	now    func() time.Time
+}
+
+// NewUsageTracker は新しいUsageTrackerを生成する。料金はpricesのモデルごとの料金で計算する
+func NewUsageTracker(repo UsageRepository, prices map[string]ModelPrice) *UsageTracker {
+	return &UsageTracker{repo: repo, prices: prices, now: time.Now}
+}
+
+// Cost はモデルの入力・出力のトークン数から料金（USD）を計算する。料金が不明なモデルは0とする
+func (t *UsageTracker) Cost(model string, promptTokens, responseTokens int) float64 {
+	price := t.prices[model]
+	return (float64(promptTokens)*price.Input + float64(responseTokens)*price.Output) / 1_000_000
+}
+
+// Record は日記（diaryID）の生成の使用量を記録する。過去日記の要約の生成はdiaryIDを0として記録する。
+// トークン数を報告しないgeneratorでも、生成にかかった時間は記録する
+func (t *UsageTracker) Record(ctx context.Context, userID, diaryID int, generated DiaryResult) error {
+	if t == nil {
+		return nil
+	}
+	record := UsageRecord{
+		UserID:    userID,
+		DiaryID:   diaryID,
+		Provider:  generated.Provider,
+		Latency:   generated.Latency,
+		CreatedAt: t.now(),
+	}
+	if u := generated.Usage; u != nil {
+		record.Model = u.Model
+		record.PromptTokens = u.PromptTokens
+		record.ResponseTokens = u.ResponseTokens
+		record.Cost = t.Cost(u.Model, u.PromptTokens, u.ResponseTokens)
+	}
+	if err := t.repo.CreateUsageRecord(ctx, record); err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
+	return nil
+}
+
+// Budget はユーザーに適用する予算（ユーザーの予算がない場合は全ユーザーの既定の予算）を返す。どちらもない場合はnilを返す
+func (t *UsageTracker) Budget(ctx context.Context, userID int) (*UsageBudget, error) {
+	budget, err := t.repo.GetUsageBudget(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage budget of user %d: %w", userID, err)
+	}
+	if budget != nil || userID == 0 {
+		return budget, nil
+	}
+	budget, err = t.repo.GetUsageBudget(ctx, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default usage budget: %w", err)
+	}
+	return budget, nil
+}
+
+// CheckBudget はユーザーの今日・今月（JST）の使用料金が予算の上限に達していないかを確認する。
+// 上限に達している場合は ErrBudgetExceeded をラップしたエラーを返す
+func (t *UsageTracker) CheckBudget(ctx context.Context, userID int) error {
+	if t == nil {
+		return nil
+	}
+	budget, err := t.Budget(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if budget == nil {
+		return nil
+	}
+
+	now := t.now()
+	today := jstDate(now)
+	month := monthStart(today)
+	limits := []struct {
+		name  string
+		limit float64
+		from  time.Time
+		to    time.Time
+	}{
+		{name: "daily", limit: budget.DailyLimit, from: jstTime(today), to: jstTime(today.AddDate(0, 0, 1))},
+		{name: "monthly", limit: budget.MonthlyLimit, from: jstTime(month), to: jstTime(month.AddDate(0, 1, 0))},
+	}
+	for _, l := range limits {
+		if l.limit <= 0 {
+			continue
+		}
+		cost, err := t.repo.GetUsageCost(ctx, userID, l.from, l.to)
+		if err != nil {
+			return fmt.Errorf("failed to get usage cost of user %d: %w", userID, err)
+		}
+		if cost >= l.limit {
+			return fmt.Errorf("%w: %s cost $%.4f of user %d reached limit $%.4f", ErrBudgetExceeded, l.name, cost, userID, l.limit)
+		}
+	}
+	return nil
+}
+
+// recordUsage は使用量を記録し、失敗した場合はログに残す。日記は保存済みのため、記録の失敗は生成の失敗として扱わない
+func recordUsage(ctx context.Context, usage *UsageTracker, userID, diaryID int, generated DiaryResult) {
+	if err := usage.Record(ctx, userID, diaryID, generated); err != nil {
+		log.Printf("WARN: failed to record usage of diary %d: %v", diaryID, err)
+	}
+}
+
+// UsageSummary は期間（日または月）ごと・ユーザーごとに集計した使用量
+type UsageSummary struct {
+	Period         string // JSTの日付（YYYY-MM-DD）または月（YYYY-MM）
+	UserID         int
+	Count          int
+	PromptTokens   int
+	ResponseTokens int
+	Cost           float64
+	AverageLatency time.Duration
+}
+
+// summarizeUsage は使用量の記録をperiodKeyで決まる期間ごと・ユーザーごとに集計し、新しい期間から順（同じ期間はユーザーIDの昇順）に返す
+func summarizeUsage(records []UsageRecord, periodKey func(time.Time) string) []UsageSummary {
+	type key struct {
+		period string
+		userID int
+	}
+	totals := make(map[key]*UsageSummary)
+	latencies := make(map[key]time.Duration)
+	for _, r := range records {
+		k := key{period: periodKey(r.CreatedAt), userID: r.UserID}
+		s, ok := totals[k]
+		if !ok {
+			s = &UsageSummary{Period: k.period, UserID: k.userID}
+			totals[k] = s
+		}
+		s.Count++
+		s.PromptTokens += r.PromptTokens
+		s.ResponseTokens += r.ResponseTokens
+		s.Cost += r.Cost
+		latencies[k] += r.Latency
+	}
+
+	summaries := make([]UsageSummary, 0, len(totals))
+	for k, s := range totals {
+		s.AverageLatency = latencies[k] / time.Duration(s.Count)
+		summaries = append(summaries, *s)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Period != summaries[j].Period {
+			return summaries[i].Period > summaries[j].Period
+		}
+		return summaries[i].UserID < summaries[j].UserID
+	})
+	return summaries
+}
+
+// jstTime は日付（jstDate の値）のJSTの0時の時刻を返す
+func jstTime(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.FixedZone("Asia/Tokyo", 9*60*60))
+}
+
+// usageDayKey は記録日時のJSTの日付（YYYY-MM-DD）を返す
+func usageDayKey(t time.Time) string {
+	return jstDate(t).Format("2006-01-02")
+}
+
+// usageMonthKey は記録日時のJSTの月（YYYY-MM）を返す
+func usageMonthKey(t time.Time) string {
+	return jstDate(t).Format("2006-01")
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadModelPrices(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    map[string]ModelPrice
+		wantErr bool
+	}{
+		{name: "未設定の場合はデフォルトの料金", env: "", want: defaultModelPrices},
+		{
+			name: "指定したモデルを追加・上書きする",
+			env:  "gpt-4o-mini=0.15/0.6, gemini-2.5-flash = 0.3/2",
+			want: map[string]ModelPrice{
+				"gemini-2.5-flash": {Input: 0.3, Output: 2},
+				"gpt-4o-mini":      {Input: 0.15, Output: 0.6},
+			},
+		},
+		{name: "区切りがない場合はエラー", env: "gpt-4o-mini=0.15", wantErr: true},
+		{name: "数値でない場合はエラー", env: "gpt-4o-mini=free/0.6", wantErr: true},
+		{name: "負の料金はエラー", env: "gpt-4o-mini=0.15/-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("USAGE_PRICES", tt.env)
+			got, err := LoadModelPrices()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadModelPrices() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadModelPrices() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadAdminUsers(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "taro, hanako,,")
+	got := LoadAdminUsers()
+	if want := map[string]bool{"taro": true, "hanako": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAdminUsers() = %v, want %v", got, want)
+	}
+}
+
+func TestUsageTracker_Record(t *testing.T) {
+	repo := NewSQLiteUsageRepository(setupTestDB(t))
+	tracker := NewUsageTracker(repo, map[string]ModelPrice{"test-model": {Input: 1, Output: 4}})
+	now := time.Date(2026, 3, 10, 3, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	generated := DiaryResult{
+		Provider: ProviderGemini,
+		Usage:    &GenerationUsage{Model: "test-model", PromptTokens: 2000, ResponseTokens: 500},
+		Latency:  2 * time.Second,
+	}
+	if err := tracker.Record(t.Context(), 1, 10, generated); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	// トークン数を報告しないgeneratorでも時間は記録する
+	if err := tracker.Record(t.Context(), 1, 11, DiaryResult{Provider: ProviderTemplate, Latency: time.Millisecond}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	records, err := repo.GetUsageRecords(t.Context(), OwnerScope{UserID: 1}, now, now.Add(time.Second))
+	if err != nil {
+		t.Fatalf("GetUsageRecords failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %+v", records)
+	}
+	if r := records[0]; r.Model != "test-model" || r.PromptTokens != 2000 || r.ResponseTokens != 500 || r.Cost != 0.004 || r.Latency != 2*time.Second {
+		t.Errorf("unexpected record: %+v", r)
+	}
+	if r := records[1]; r.Provider != ProviderTemplate || r.Cost != 0 || r.Latency != time.Millisecond {
+		t.Errorf("unexpected record without usage: %+v", r)
+	}
+
+	// nilの場合は記録しない
+	var disabled *UsageTracker
+	if err := disabled.Record(t.Context(), 1, 12, generated); err != nil {
+		t.Errorf("expected nil tracker to ignore record, got %v", err)
+	}
+}
+
+func TestUsageTracker_CheckBudget(t *testing.T) {
+	repo := NewSQLiteUsageRepository(setupTestDB(t))
+	tracker := NewUsageTracker(repo, nil)
+	// JSTで 2026-03-10 の9時
+	now := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	for _, r := range []UsageRecord{
+		// JSTで前日（2026-03-09 の23時）の記録は今日に含めない
+		{UserID: 1, Cost: 3, CreatedAt: time.Date(2026, 3, 9, 14, 0, 0, 0, time.UTC)},
+		{UserID: 1, Cost: 0.6, CreatedAt: now.Add(-time.Hour)},
+		{UserID: 2, Cost: 0.1, CreatedAt: now.Add(-time.Hour)},
+	} {
+		if err := repo.CreateUsageRecord(t.Context(), r); err != nil {
+			t.Fatalf("CreateUsageRecord failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		budgets      map[int][2]float64 // ユーザーIDごとの1日・1ヶ月の上限
+		userID       int
+		wantExceeded bool
+	}{
+		{name: "予算がない場合は上限なし", userID: 1},
+		{name: "1日の上限に達した", budgets: map[int][2]float64{1: {0.5, 0}}, userID: 1, wantExceeded: true},
+		{name: "前日の料金は1日の上限に含めない", budgets: map[int][2]float64{1: {1, 0}}, userID: 1},
+		{name: "1ヶ月の上限に達した", budgets: map[int][2]float64{1: {0, 3.5}}, userID: 1, wantExceeded: true},
+		{name: "ユーザーの予算がない場合は既定の予算", budgets: map[int][2]float64{0: {0.1, 0}}, userID: 2, wantExceeded: true},
+		{name: "ユーザーの予算を既定の予算より優先する", budgets: map[int][2]float64{0: {0.1, 0}, 2: {1, 0}}, userID: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, userID := range []int{0, 1, 2} {
+				if err := repo.DeleteUsageBudget(t.Context(), userID); err != nil {
+					t.Fatalf("DeleteUsageBudget failed: %v", err)
+				}
+			}
+			for userID, limits := range tt.budgets {
+				if err := repo.SaveUsageBudget(t.Context(), userID, limits[0], limits[1]); err != nil {
+					t.Fatalf("SaveUsageBudget failed: %v", err)
+				}
+			}
+
+			err := tracker.CheckBudget(t.Context(), tt.userID)
+			if tt.wantExceeded {
+				if !errors.Is(err, ErrBudgetExceeded) {
+					t.Errorf("expected ErrBudgetExceeded, got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSummarizeUsage(t *testing.T) {
+	// JSTで 2026-03-10 の日中と、2026-03-11 の0時30分（UTCでは03-10）
+	day := time.Date(2026, 3, 10, 3, 0, 0, 0, time.UTC)
+	records := []UsageRecord{
+		{UserID: 2, PromptTokens: 100, ResponseTokens: 10, Cost: 0.1, Latency: time.Second, CreatedAt: day},
+		{UserID: 1, PromptTokens: 200, ResponseTokens: 20, Cost: 0.2, Latency: 2 * time.Second, CreatedAt: day},
+		{UserID: 1, PromptTokens: 300, ResponseTokens: 30, Cost: 0.3, Latency: 4 * time.Second, CreatedAt: day.Add(time.Hour)},
+		{UserID: 1, PromptTokens: 400, ResponseTokens: 40, Cost: 0.4, Latency: time.Second, CreatedAt: time.Date(2026, 3, 10, 15, 30, 0, 0, time.UTC)},
+	}
+
+	got := summarizeUsage(records, usageDayKey)
+	want := []UsageSummary{
+		{Period: "2026-03-11", UserID: 1, Count: 1, PromptTokens: 400, ResponseTokens: 40, Cost: 0.4, AverageLatency: time.Second},
+		{Period: "2026-03-10", UserID: 1, Count: 2, PromptTokens: 500, ResponseTokens: 50, Cost: 0.5, AverageLatency: 3 * time.Second},
+		{Period: "2026-03-10", UserID: 2, Count: 1, PromptTokens: 100, ResponseTokens: 10, Cost: 0.1, AverageLatency: time.Second},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("summarizeUsage() by day = %+v, want %+v", got, want)
+	}
+
+	monthly := summarizeUsage(records, usageMonthKey)
+	if len(monthly) != 2 || monthly[0].Period != "2026-03" || monthly[0].UserID != 1 || monthly[0].Count != 3 {
+		t.Errorf("unexpected summaries by month: %+v", monthly)
+	}
+}
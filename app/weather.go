@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	openMeteoBaseURL = "https://api.open-meteo.com"
+	openMeteoTimeout = 10 * time.Second
+)
+
+// WeatherProvider は撮影日の天気を返すインターフェース。プロンプトテンプレートの天気に使う
+type WeatherProvider interface {
+	Weather(ctx context.Context, date time.Time) (string, error)
+}
+
+// OpenMeteoWeatherProvider は Open-Meteo の天気予報API（APIキー不要）から、指定した地点の日ごとの天気を取得する。
+// 天気予報APIで取得できるのは直近3ヶ月程度のため、それより古い日付の天気は取得できない
+type OpenMeteoWeatherProvider struct {
+	baseURL   string
+	latitude  float64
+	longitude float64
+	client    *http.Client
+
+	mu    sync.Mutex
+	cache map[string]string // 日付（JST、YYYY-MM-DD）ごとの天気
+}
+
+// NewOpenMeteoWeatherProvider は指定した地点の天気を取得する OpenMeteoWeatherProvider を生成する
+func NewOpenMeteoWeatherProvider(latitude, longitude float64) *OpenMeteoWeatherProvider {
+	return &OpenMeteoWeatherProvider{
+		baseURL:   openMeteoBaseURL,
+		latitude:  latitude,
+		longitude: longitude,
+		client:    &http.Client{Timeout: openMeteoTimeout},
+		cache:     make(map[string]string),
+	}
+}
+
+// NewWeatherProviderFromEnv は環境変数 WEATHER_LATITUDE / WEATHER_LONGITUDE の地点の天気を取得する WeatherProvider を生成する。
+// どちらも未設定の場合は天気を使わないため nil を返す
+func NewWeatherProviderFromEnv() (WeatherProvider, error) {
+	latStr, lonStr := os.Getenv("WEATHER_LATITUDE"), os.Getenv("WEATHER_LONGITUDE")
+	if latStr == "" && lonStr == "" {
+		return nil, nil
+	}
+	latitude, err := strconv.ParseFloat(latStr, 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return nil, fmt.Errorf("WEATHER_LATITUDE must be a latitude between -90 and 90: %q", latStr)
+	}
+	longitude, err := strconv.ParseFloat(lonStr, 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		return nil, fmt.Errorf("WEATHER_LONGITUDE must be a longitude between -180 and 180: %q", lonStr)
+	}
+	return NewOpenMeteoWeatherProvider(latitude, longitude), nil
+}
+
+// openMeteoResponse は天気予報APIのレスポンスボディのうち、日ごとの天気の部分
+type openMeteoResponse struct {
+	Daily struct {
+		WeatherCode    []*int     `json:"weather_code"`
+		TemperatureMax []*float64 `json:"temperature_2m_max"`
+		TemperatureMin []*float64 `json:"temperature_2m_min"`
+	} `json:"daily"`
+	Reason string `json:"reason"` // エラー時のエラー内容
+}
+
+// Weather は撮影日（JST）の天気を「晴れ（最高18.0℃／最低8.0℃）」の形式で返す。同じ日の天気は再取得しない
+func (p *OpenMeteoWeatherProvider) Weather(ctx context.Context, date time.Time) (string, error) {
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	day := date.In(jst).Format("2006-01-02")
+
+	p.mu.Lock()
+	cached, ok := p.cache[day]
+	p.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	query := url.Values{}
+	query.Set("latitude", strconv.FormatFloat(p.latitude, 'f', -1, 64))
+	query.Set("longitude", strconv.FormatFloat(p.longitude, 'f', -1, 64))
+	query.Set("daily", "weather_code,temperature_2m_max,temperature_2m_min")
+	query.Set("timezone", "Asia/Tokyo")
+	query.Set("start_date", day)
+	query.Set("end_date", day)
+
+	ctx, cancel := context.WithTimeout(ctx, openMeteoTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/v1/forecast?"+query.Encode(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create weather request: %w", err)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get weather: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read weather response: %w", err)
+	}
+	var weatherResp openMeteoResponse
+	if err := json.Unmarshal(body, &weatherResp); err != nil {
+		return "", fmt.Errorf("failed to parse weather response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("weather API returned HTTP %d: %s", resp.StatusCode, weatherResp.Reason)
+	}
+
+	daily := weatherResp.Daily
+	if len(daily.WeatherCode) == 0 || daily.WeatherCode[0] == nil {
+		return "", fmt.Errorf("no weather for %s", day)
+	}
+	weather := weatherCodeLabel(*daily.WeatherCode[0])
+	if len(daily.TemperatureMax) > 0 && daily.TemperatureMax[0] != nil && len(daily.TemperatureMin) > 0 && daily.TemperatureMin[0] != nil {
+		weather += fmt.Sprintf("（最高%.1f℃／最低%.1f℃）", *daily.TemperatureMax[0], *daily.TemperatureMin[0])
+	}
+
+	p.mu.Lock()
+	p.cache[day] = weather
+	p.mu.Unlock()
+	return weather, nil
+}
+
+// weatherCodeLabel は WMO の天気コードを天気の表示名に変換する
+func weatherCodeLabel(code int) string {
+	switch {
+	case code == 0:
+		return "快晴"
+	case code == 1:
+		return "晴れ"
+	case code == 2:
+		return "晴れ時々曇り"
+	case code == 3:
+		return "曇り"
+	case code == 45 || code == 48:
+		return "霧"
+	case code >= 51 && code <= 57:
+		return "霧雨"
+	case code >= 61 && code <= 67:
+		return "雨"
+	case code >= 71 && code <= 77:
+		return "雪"
+	case code >= 80 && code <= 82:
+		return "にわか雨"
+	case code == 85 || code == 86:
+		return "にわか雪"
+	case code >= 95:
+		return "雷雨"
+	default:
+		return "不明"
+	}
+}
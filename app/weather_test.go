@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOpenMeteoWeatherProvider_Weather(t *testing.T) {
+	calls := 0
+	var gotQuery string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		gotQuery = r.URL.RawQuery
+		if r.URL.Path != "/v1/forecast" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"daily":{"time":["2026-03-10"],"weather_code":[61],"temperature_2m_max":[12.34],"temperature_2m_min":[5]}}`))
+	}))
+	defer srv.Close()
+
+	provider := NewOpenMeteoWeatherProvider(35.6812, 139.7671)
+	provider.baseURL = srv.URL
+
+	// JSTの日付（2026-03-10）の天気を取得する
+	date := time.Date(2026, 3, 9, 16, 0, 0, 0, time.UTC)
+	weather, err := provider.Weather(t.Context(), date)
+	if err != nil {
+		t.Fatalf("Weather failed: %v", err)
+	}
+	if weather != "雨（最高12.3℃／最低5.0℃）" {
+		t.Errorf("unexpected weather: %q", weather)
+	}
+	want := "daily=weather_code%2Ctemperature_2m_max%2Ctemperature_2m_min&end_date=2026-03-10&latitude=35.6812&longitude=139.7671&start_date=2026-03-10&timezone=Asia%2FTokyo"
+	if gotQuery != want {
+		t.Errorf("unexpected query: %s", gotQuery)
+	}
+
+	// 同じ日の天気は再取得しない
+	if _, err := provider.Weather(t.Context(), date.Add(time.Hour)); err != nil {
+		t.Fatalf("Weather failed: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 request, got %d", calls)
+	}
+}
+
+func TestOpenMeteoWeatherProvider_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":true,"reason":"Parameter 'start_date' is out of allowed range"}`))
+	}))
+	defer srv.Close()
+
+	provider := NewOpenMeteoWeatherProvider(35.6812, 139.7671)
+	provider.baseURL = srv.URL
+
+	if _, err := provider.Weather(t.Context(), time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestNewWeatherProviderFromEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		latitude  string
+		longitude string
+		wantNil   bool
+		wantErr   bool
+	}{
+		{name: "未設定", wantNil: true},
+		{name: "地点を指定", latitude: "35.6812", longitude: "139.7671"},
+		{name: "経度がない", latitude: "35.6812", wantErr: true},
+		{name: "緯度が範囲外", latitude: "91", longitude: "139.7671", wantErr: true},
+		{name: "数値でない", latitude: "tokyo", longitude: "139.7671", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("WEATHER_LATITUDE", tt.latitude)
+			t.Setenv("WEATHER_LONGITUDE", tt.longitude)
+
+			provider, err := NewWeatherProviderFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewWeatherProviderFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (provider == nil) != tt.wantNil {
+				t.Errorf("NewWeatherProviderFromEnv() = %v, wantNil %v", provider, tt.wantNil)
+			}
+		})
+	}
+}
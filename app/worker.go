@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrQueueFull はジョブキューが満杯で新しいジョブを受け付けられないことを表す
+	ErrQueueFull = errors.New("job queue is full")
+	// ErrWorkerStopped はWorkerが停止処理中で新しいジョブを受け付けられないことを表す
+	ErrWorkerStopped = errors.New("worker is stopped")
+)
+
+const (
+	// budgetRecheckInterval は予算の上限で一時停止したジョブを再開できるか確認する間隔（日・月が替わると再開できる）
+	budgetRecheckInterval = 10 * time.Minute
+	// budgetPausedMessage は予算の上限で一時停止したジョブに記録する理由
+	budgetPausedMessage = "予算の上限に達したため日記の生成を一時停止しています"
+)
+
+// DiaryWorkerConfig はWorkerプールの設定を保持する
+type DiaryWorkerConfig struct {
+	Workers   int // 同時に日記を生成するWorker数
+	QueueSize int // 処理待ちとして保持できるジョブの最大数
+}
+
+// DefaultDiaryWorkerConfig はデフォルトのWorkerプール設定（Worker数2、キュー長30）を返す
+func DefaultDiaryWorkerConfig() DiaryWorkerConfig {
+	return DiaryWorkerConfig{
+		Workers:   2,
+		QueueSize: 30,
+	}
+}
+
+// LoadDiaryWorkerConfig は環境変数 DIARY_WORKERS / DIARY_QUEUE_SIZE からWorkerプール設定を読み込む。
+// 未設定の項目はデフォルト値を使用する
+func LoadDiaryWorkerConfig() (DiaryWorkerConfig, error) {
+	config := DefaultDiaryWorkerConfig()
+	if v := os.Getenv("DIARY_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return config, fmt.Errorf("DIARY_WORKERS must be a positive integer: %q", v)
+		}
+		config.Workers = n
+	}
+	if v := os.Getenv("DIARY_QUEUE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return config, fmt.Errorf("DIARY_QUEUE_SIZE must be a positive integer: %q", v)
+		}
+		config.QueueSize = n
+	}
+	return config, nil
+}
+
+// DiaryWorker は日記生成ジョブを有界キュー経由で固定数のgoroutineに割り当てて処理するWorkerプール
+type DiaryWorker struct {
+	repo        DiaryRepository
+	jobRepo     JobRepository
+	generator   DiaryGenerator
+	prompts     *DiaryPromptBuilder
+	usage       *UsageTracker
+	retryConfig RetryConfig
+	config      DiaryWorkerConfig
+	queue       chan Job
+	resume      chan struct{}
+	stopped     atomic.Bool
+	cancel      context.CancelFunc
+	cancelJobs  context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// NewDiaryWorker は新しいDiaryWorkerを生成する。promptsがnilの場合は標準のプロンプトで日記を生成する。
+// usageがnilの場合は使用量を記録せず、予算の上限でジョブを一時停止しない
+func NewDiaryWorker(repo DiaryRepository, jobRepo JobRepository, generator DiaryGenerator, prompts *DiaryPromptBuilder, usage *UsageTracker, config DiaryWorkerConfig) *DiaryWorker {
+	return &DiaryWorker{
+		repo:        repo,
+		jobRepo:     jobRepo,
+		generator:   generator,
+		prompts:     prompts,
+		usage:       usage,
+		retryConfig: DefaultRetryConfig(),
+		config:      config,
+		queue:       make(chan Job, config.QueueSize),
+		resume:      make(chan struct{}, 1),
+	}
+}
+
+// Enqueue は日記生成ジョブをqueued状態で登録してキューに積み、ジョブIDを返す。plantIDが0の場合は植物未指定とする。
+// キューが満杯の場合はジョブを登録せず ErrQueueFull を、停止処理中の場合は ErrWorkerStopped を返す
+func (w *DiaryWorker) Enqueue(ctx context.Context, userID, plantID int, imagePath string, capturedAt time.Time) (string, error) {
+	if w.stopped.Load() {
+		return "", ErrWorkerStopped
+	}
+
+	jobID, err := generateUUID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	if err := w.jobRepo.CreateJob(ctx, jobID, userID, plantID, imagePath, capturedAt); err != nil {
+		return "", fmt.Errorf("failed to create job: %w", err)
+	}
+
+	job := Job{
+		ID:         jobID,
+		UserID:     userID,
+		PlantID:    plantID,
+		ImagePath:  imagePath,
+		CapturedAt: capturedAt,
+		Status:     JobStatusQueued,
+	}
+	select {
+	case w.queue <- job:
+		return jobID, nil
+	default:
+		if err := w.jobRepo.DeleteJob(ctx, jobID); err != nil {
+			log.Printf("ERROR: failed to delete rejected job %s: %v", jobID, err)
+		}
+		return "", ErrQueueFull
+	}
+}
+
+// Start はWorkerプールを起動する。
+// 前回中断されたジョブをqueuedに戻し、DBに残っている未処理ジョブをキューへ投入してから新着ジョブを処理する。
+// 予算の上限で一時停止したジョブは、定期的（または RequestResume の呼び出し時）に上限を確認して再開する。
+// Enqueue との重複投入を避けるため、HTTPサーバーの起動前に呼び出すこと
+func (w *DiaryWorker) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	// 処理中のジョブは停止要求では中断せず、Shutdown の期限を過ぎた場合のみキャンセルする
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	w.cancelJobs = cancelJobs
+
+	requeued, err := w.jobRepo.RequeueRunningJobs(ctx)
+	if err != nil {
+		log.Printf("ERROR: failed to requeue interrupted jobs: %v", err)
+	} else if requeued > 0 {
+		log.Printf("INFO: requeued %d interrupted job(s)", requeued)
+	}
+
+	backlog, err := w.jobRepo.GetQueuedJobs(ctx)
+	if err != nil {
+		log.Printf("ERROR: failed to get queued jobs: %v", err)
+	} else if len(backlog) > 0 {
+		log.Printf("INFO: resuming %d queued job(s)", len(backlog))
+	}
+
+	// 未処理ジョブはキュー長を超えうるため、空きができ次第投入する
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for _, job := range backlog {
+			select {
+			case w.queue <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.runResume(ctx)
+	}()
+
+	for i := 0; i < w.config.Workers; i++ {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.run(ctx, jobCtx)
+		}()
+	}
+	log.Printf("INFO: diary worker pool started (workers: %d, queue size: %d)", w.config.Workers, w.config.QueueSize)
+}
+
+// Shutdown は新規ジョブの受付を停止し、処理中のジョブの完了を待つ。
+// キューに残ったジョブはqueued状態のままDBに残り、次回起動時に処理される。
+// ctxの期限までに処理中のジョブが終わらない場合は、処理中のジョブ（生成やDBの操作）をキャンセルしてctxのエラーを返す。
+// キャンセルしたジョブはrunning状態のまま残り、次回起動時にqueuedに戻して処理し直す
+func (w *DiaryWorker) Shutdown(ctx context.Context) error {
+	w.stopped.Store(true)
+	if w.cancel != nil {
+		w.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		if w.cancelJobs != nil {
+			w.cancelJobs()
+		}
+		return ctx.Err()
+	}
+}
+
+// run はctxがキャンセルされるまでキューからジョブを取り出し、jobCtxで処理する
+func (w *DiaryWorker) run(ctx, jobCtx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-w.queue:
+			// 停止要求と同時に受け取った場合は処理せず、次回起動時に任せる
+			if ctx.Err() != nil {
+				return
+			}
+			w.processJob(jobCtx, job)
+		}
+	}
+}
+
+// RequestResume は予算の上限で一時停止したジョブを再開できるか、すぐに確認するよう要求する。予算の変更時に呼び出す
+func (w *DiaryWorker) RequestResume() {
+	select {
+	case w.resume <- struct{}{}:
+	default:
+	}
+}
+
+// runResume はctxがキャンセルされるまで、定期的に（または再開の要求を受けて）一時停止したジョブを再開する
+func (w *DiaryWorker) runResume(ctx context.Context) {
+	ticker := time.NewTicker(budgetRecheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.resume:
+		}
+		w.resumePausedJobs(ctx)
+	}
+}
+
+// resumePausedJobs は予算の上限に達していないユーザーの一時停止したジョブをqueuedに戻してキューへ投入する
+func (w *DiaryWorker) resumePausedJobs(ctx context.Context) {
+	paused, err := w.jobRepo.GetPausedJobs(ctx)
+	if err != nil {
+		log.Printf("ERROR: failed to get paused jobs: %v", err)
+		return
+	}
+
+	exceeded := make(map[int]bool)
+	for _, job := range paused {
+		over, checked := exceeded[job.UserID]
+		if !checked {
+			err := w.usage.CheckBudget(ctx, job.UserID)
+			if err != nil && !errors.Is(err, ErrBudgetExceeded) {
+				log.Printf("ERROR: failed to check usage budget of user %d: %v", job.UserID, err)
+				continue
+			}
+			over = err != nil
+			exceeded[job.UserID] = over
+		}
+		if over {
+			continue
+		}
+
+		if err := w.jobRepo.ResumePausedJob(ctx, job.ID); err != nil {
+			log.Printf("ERROR: failed to resume job %s: %v", job.ID, err)
+			continue
+		}
+		job.Status = JobStatusQueued
+		select {
+		case w.queue <- job:
+			log.Printf("INFO: resumed paused job %s", job.ID)
+		case <-ctx.Done():
+			// queuedに戻したジョブは次回起動時に処理される
+			return
+		}
+	}
+}
+
+// processJob は1件のジョブについて日記を生成・保存し、結果をジョブの状態に記録する。
+// ctxがキャンセルされて中断した場合は失敗として記録せず、running状態のまま次回起動時の再処理に任せる
+func (w *DiaryWorker) processJob(ctx context.Context, job Job) {
+	if err := w.jobRepo.MarkJobRunning(ctx, job.ID); err != nil {
+		log.Printf("ERROR: failed to mark job %s as running: %v", job.ID, err)
+		return
+	}
+
+	// 日記保存後にジョブ状態を更新する前に停止した場合、再実行時に二重登録しない
+	processed, err := w.repo.IsImageProcessed(ctx, job.ImagePath)
+	if err != nil {
+		w.failJob(ctx, job, 0, fmt.Errorf("failed to check processed image: %w", err))
+		return
+	}
+	if processed {
+		w.succeedJob(ctx, job, 0)
+		return
+	}
+
+	// 予算の上限に達している場合は失敗とせず、上限を下回るまで一時停止する。予算を確認できない場合は生成を続ける
+	if err := w.usage.CheckBudget(ctx, job.UserID); err != nil {
+		if errors.Is(err, ErrBudgetExceeded) {
+			w.pauseJob(ctx, job, err)
+			return
+		}
+		log.Printf("WARN: failed to check usage budget for job %s: %v, continuing", job.ID, err)
+	}
+
+	generated, attempts, err := generateDiaryContent(ctx, w.repo, w.generator, w.prompts, w.retryConfig, job.UserID, job.PlantID, job.ImagePath, job.CapturedAt)
+	if err != nil {
+		w.failJob(ctx, job, attempts, err)
+		return
+	}
+
+	diaryID, err := w.repo.CreateGeneratedDiary(ctx, job.UserID, job.PlantID, job.ImagePath, generated.Content, generated.Provider, generated.Observation, job.CapturedAt)
+	if err != nil {
+		w.failJob(ctx, job, attempts, fmt.Errorf("failed to save diary: %w", err))
+		return
+	}
+	recordUsage(ctx, w.usage, job.UserID, diaryID, generated)
+
+	w.succeedJob(ctx, job, attempts)
+	log.Printf("INFO: diary created for %s (job_id: %s)", job.ImagePath, job.ID)
+}
+
+// succeedJob はジョブをsucceeded状態に更新する
+func (w *DiaryWorker) succeedJob(ctx context.Context, job Job, attempts int) {
+	if err := w.jobRepo.MarkJobSucceeded(ctx, job.ID, attempts); err != nil {
+		log.Printf("ERROR: failed to mark job %s as succeeded: %v", job.ID, err)
+	}
+}
+
+// pauseJob はジョブをpaused状態に更新する
+func (w *DiaryWorker) pauseJob(ctx context.Context, job Job, cause error) {
+	log.Printf("INFO: job %s for %s paused: %v", job.ID, job.ImagePath, cause)
+	if err := w.jobRepo.MarkJobPaused(ctx, job.ID, budgetPausedMessage); err != nil {
+		log.Printf("ERROR: failed to mark job %s as paused: %v", job.ID, err)
+	}
+}
+
+// failJob はジョブをfailed状態に更新し、エラー内容を記録する。ctxがキャンセルされた場合は更新しない
+func (w *DiaryWorker) failJob(ctx context.Context, job Job, attempts int, cause error) {
+	if ctx.Err() != nil {
+		log.Printf("INFO: job %s for %s interrupted: %v", job.ID, job.ImagePath, cause)
+		return
+	}
+	log.Printf("ERROR: job %s for %s failed: %v", job.ID, job.ImagePath, cause)
+	if err := w.jobRepo.MarkJobFailed(ctx, job.ID, attempts, cause.Error()); err != nil {
+		log.Printf("ERROR: failed to mark job %s as failed: %v", job.ID, err)
+	}
+}
+
+// generateDiaryContent は撮影日前日までの同じユーザー（植物を指定した場合は同じ植物）の日記を参照したプロンプトで、リトライ付きで日記本文を生成する。
+// プロンプトはユーザー・植物ごとのテンプレートの設定に従い、古い期間の日記は要約して組み立てる（要約を使えない場合は1ヶ月分の日記）。
+// 同じ植物の過去の写真は参照画像として、対応したgeneratorに今回の写真とあわせて渡す。
+// 生成結果（generatorが FallbackDiaryGenerator でない場合、プロバイダ名は空。成功した生成の呼び出しにかかった時間を含む）とあわせて生成の試行回数を返す。
+func generateDiaryContent(ctx context.Context, repo DiaryRepository, generator DiaryGenerator, prompts *DiaryPromptBuilder, retryConfig RetryConfig, userID, plantID int, imagePath string, capturedAt time.Time) (DiaryResult, int, error) {
+	startOfDay := time.Date(capturedAt.Year(), capturedAt.Month(), capturedAt.Day(), 0, 0, 0, 0, capturedAt.Location())
+	oneMonthAgo := startOfDay.AddDate(0, -1, 0)
+	endOfPrevDay := startOfDay.Add(-time.Nanosecond)
+	pastDiaries, err := repo.GetDiariesInDateRange(ctx, OwnerScope{UserID: userID, PlantID: plantID}, oneMonthAgo, endOfPrevDay)
+	if err != nil {
+		log.Printf("WARN: failed to get past diaries for %s: %v, continuing with empty history", imagePath, err)
+		pastDiaries = []Diary{}
+	}
+
+	prompt := prompts.Build(ctx, userID, plantID, pastDiaries, capturedAt)
+	references := selectReferenceImages(pastDiaries, plantID, capturedAt)
+
+	req := DiaryRequest{ImagePath: imagePath, Prompt: prompt, References: references}
+	var generated DiaryResult
+	attempts := 0
+	retryErr := Retry(ctx, retryConfig, RetryOperationGenerateDiary, func() error {
+		attempts++
+		start := time.Now()
+		var genErr error
+		generated, genErr = generator.Generate(ctx, req)
+		if genErr == nil && generated.Latency == 0 {
+			// FallbackDiaryGenerator 以外では、成功した試行の呼び出しにかかった時間を記録する
+			generated.Latency = time.Since(start)
+		}
+		return genErr
+	})
+	if retryErr != nil {
+		return DiaryResult{}, attempts, retryErr
+	}
+	return generated, attempts, nil
+}
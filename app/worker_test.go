@@ -0,0 +1,575 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingDiaryGenerator は常にエラーを返すテスト用のDiaryGenerator
+type failingDiaryGenerator struct {
+	calls int
+}
+
+func (g *failingDiaryGenerator) Generate(ctx context.Context, req DiaryRequest) (DiaryResult, error) {
+	g.calls++
+	return DiaryResult{}, errors.New("generation failed")
+}
+
+// newTestDiaryWorker はSQLiteのジョブリポジトリとスリープしないリトライ設定を持つテスト用Workerを生成する
+func newTestDiaryWorker(t *testing.T, generator DiaryGenerator) (*DiaryWorker, *MockDiaryRepository, *SQLiteJobRepository) {
+	t.Helper()
+	repo := NewMockDiaryRepository()
+	jobRepo := NewSQLiteJobRepository(setupTestDB(t))
+	worker := NewDiaryWorker(repo, jobRepo, generator, nil, nil, DiaryWorkerConfig{Workers: 1, QueueSize: 1})
+	worker.retryConfig.SleepFunc = func(ctx context.Context, d time.Duration) error { return nil }
+	return worker, repo, jobRepo
+}
+
+func TestDiaryWorker_ProcessJob_Success(t *testing.T) {
+	worker, repo, jobRepo := newTestDiaryWorker(t, &MockDiaryGenerator{})
+
+	jobID, err := worker.Enqueue(t.Context(), 1, 2, "/path/to/image.jpg", time.Now())
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	worker.processJob(t.Context(), <-worker.queue)
+
+	job, err := jobRepo.GetJobByID(t.Context(), jobID)
+	if err != nil {
+		t.Fatalf("GetJobByID failed: %v", err)
+	}
+	if job.Status != JobStatusSucceeded {
+		t.Errorf("expected status %q, got %q", JobStatusSucceeded, job.Status)
+	}
+	if job.Attempts != 1 {
+		t.Errorf("expected 1 attempt, got %d", job.Attempts)
+	}
+
+	diaries, err := repo.GetDiariesByPlantID(t.Context(), 2)
+	if err != nil {
+		t.Fatalf("GetDiariesByPlantID failed: %v", err)
+	}
+	if len(diaries) != 1 || diaries[0].ImagePath != "/path/to/image.jpg" {
+		t.Fatalf("expected diary to be created for plant 2, got %+v", diaries)
+	}
+
+	// 構造化出力に対応したgeneratorの植物の状態もあわせて保存する
+	observations, err := repo.GetDiaryObservations(t.Context(), []int{diaries[0].ID})
+	if err != nil {
+		t.Fatalf("GetDiaryObservations failed: %v", err)
+	}
+	if o, ok := observations[diaries[0].ID]; !ok || o.HealthScore == 0 {
+		t.Errorf("expected observation to be saved, got %+v", observations)
+	}
+}
+
+func TestDiaryWorker_ProcessJob_RecordsProvider(t *testing.T) {
+	generator, err := NewFallbackDiaryGenerator(
+		DiaryProvider{Name: ProviderGemini, Generator: &failingDiaryGenerator{}},
+		DiaryProvider{Name: ProviderTemplate, Generator: &TemplateDiaryGenerator{}},
+	)
+	if err != nil {
+		t.Fatalf("NewFallbackDiaryGenerator failed: %v", err)
+	}
+	worker, repo, jobRepo := newTestDiaryWorker(t, generator)
+
+	jobID, err := worker.Enqueue(t.Context(), 1, 0, "/path/to/image.jpg", time.Now())
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	worker.processJob(t.Context(), <-worker.queue)
+
+	// 先頭のプロバイダが失敗しても、リトライせずに次のプロバイダで日記を作成する
+	job, err := jobRepo.GetJobByID(t.Context(), jobID)
+	if err != nil {
+		t.Fatalf("GetJobByID failed: %v", err)
+	}
+	if job.Status != JobStatusSucceeded || job.Attempts != 1 {
+		t.Errorf("expected job to succeed in 1 attempt, got status %q, attempts %d", job.Status, job.Attempts)
+	}
+
+	diaries, err := repo.GetAllDiaries(t.Context(), OwnerScope{})
+	if err != nil {
+		t.Fatalf("GetAllDiaries failed: %v", err)
+	}
+	if len(diaries) != 1 || diaries[0].Provider != ProviderTemplate || diaries[0].Content != templateDiaryContent {
+		t.Errorf("expected template diary to be created, got %+v", diaries)
+	}
+}
+
+func TestDiaryWorker_ProcessJob_GenerationFailure(t *testing.T) {
+	generator := &failingDiaryGenerator{}
+	worker, repo, jobRepo := newTestDiaryWorker(t, generator)
+
+	jobID, err := worker.Enqueue(t.Context(), 1, 0, "/path/to/image.jpg", time.Now())
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	worker.processJob(t.Context(), <-worker.queue)
+
+	job, err := jobRepo.GetJobByID(t.Context(), jobID)
+	if err != nil {
+		t.Fatalf("GetJobByID failed: %v", err)
+	}
+	if job.Status != JobStatusFailed {
+		t.Errorf("expected status %q, got %q", JobStatusFailed, job.Status)
+	}
+	expectedAttempts := 1 + worker.retryConfig.MaxRetries
+	if job.Attempts != expectedAttempts || generator.calls != expectedAttempts {
+		t.Errorf("expected %d attempts, got %d (generator calls: %d)", expectedAttempts, job.Attempts, generator.calls)
+	}
+	if job.LastError == "" {
+		t.Error("expected last error to be recorded")
+	}
+
+	diaries, err := repo.GetAllDiaries(t.Context(), OwnerScope{})
+	if err != nil {
+		t.Fatalf("GetAllDiaries failed: %v", err)
+	}
+	if len(diaries) != 0 {
+		t.Errorf("expected no diaries, got %d", len(diaries))
+	}
+}
+
+func TestDiaryWorker_ProcessJob_MissingImage(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"日記"}}]}`))
+	}))
+	defer srv.Close()
+	openai, err := NewOpenAIDiaryGenerator(OpenAIConfig{BaseURL: srv.URL, Model: "llava"})
+	if err != nil {
+		t.Fatalf("NewOpenAIDiaryGenerator failed: %v", err)
+	}
+	generator, err := NewFallbackDiaryGenerator(
+		DiaryProvider{Name: ProviderOpenAI, Generator: openai},
+		DiaryProvider{Name: ProviderTemplate, Generator: &TemplateDiaryGenerator{}},
+	)
+	if err != nil {
+		t.Fatalf("NewFallbackDiaryGenerator failed: %v", err)
+	}
+	worker, repo, jobRepo := newTestDiaryWorker(t, generator)
+
+	// 写真がない場合はリトライせず、定型文のプロバイダにもフォールバックしない
+	jobID, err := worker.Enqueue(t.Context(), 1, 0, filepath.Join(t.TempDir(), "missing.jpg"), time.Now())
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	worker.processJob(t.Context(), <-worker.queue)
+
+	job, err := jobRepo.GetJobByID(t.Context(), jobID)
+	if err != nil {
+		t.Fatalf("GetJobByID failed: %v", err)
+	}
+	if job.Status != JobStatusFailed || job.Attempts != 1 {
+		t.Errorf("expected job to fail after 1 attempt, got status %q after %d attempts", job.Status, job.Attempts)
+	}
+	if requests != 0 {
+		t.Errorf("expected no request to provider, got %d", requests)
+	}
+	for _, stats := range generator.ProviderStats() {
+		if stats.Successes != 0 || stats.Failures != 0 {
+			t.Errorf("expected no provider to be counted, got %+v", stats)
+		}
+	}
+	diaries, err := repo.GetAllDiaries(t.Context(), OwnerScope{})
+	if err != nil {
+		t.Fatalf("GetAllDiaries failed: %v", err)
+	}
+	if len(diaries) != 0 {
+		t.Errorf("expected no diaries, got %d", len(diaries))
+	}
+}
+
+func TestDiaryWorker_ProcessJob_AlreadyProcessed(t *testing.T) {
+	generator := &failingDiaryGenerator{}
+	worker, repo, jobRepo := newTestDiaryWorker(t, generator)
+
+	// 日記保存後、ジョブ状態更新前に停止したケースを再現
+	if err := repo.CreateDiaryForUser(t.Context(), 1, 0, "/path/to/image.jpg", "保存済みの日記", time.Now()); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	if err := jobRepo.CreateJob(t.Context(), "job1", 1, 0, "/path/to/image.jpg", time.Now()); err != nil {
+		t.Fatalf("CreateJob failed: %v", err)
+	}
+	if err := jobRepo.MarkJobRunning(t.Context(), "job1"); err != nil {
+		t.Fatalf("MarkJobRunning failed: %v", err)
+	}
+
+	// Start は起動時に中断ジョブを再キューして処理する
+	worker.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := jobRepo.GetJobByID(t.Context(), "job1")
+		if err != nil {
+			t.Fatalf("GetJobByID failed: %v", err)
+		}
+		if job.Status == JobStatusSucceeded {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job was not processed in time, status: %q", job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := worker.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	if generator.calls != 0 {
+		t.Errorf("expected generator not to be called, got %d calls", generator.calls)
+	}
+	diaries, err := repo.GetAllDiaries(t.Context(), OwnerScope{})
+	if err != nil {
+		t.Fatalf("GetAllDiaries failed: %v", err)
+	}
+	if len(diaries) != 1 {
+		t.Errorf("expected 1 diary, got %d", len(diaries))
+	}
+}
+
+// usageDiaryGenerator は消費トークン数をあわせて返すテスト用のDiaryGenerator
+type usageDiaryGenerator struct{}
+
+func (g *usageDiaryGenerator) Generate(ctx context.Context, req DiaryRequest) (DiaryResult, error) {
+	return DiaryResult{
+		Content: "新しい葉が開きました。",
+		Usage:   &GenerationUsage{Model: "test-model", PromptTokens: 1000, ResponseTokens: 500},
+	}, nil
+}
+
+func TestDiaryWorker_ProcessJob_PausesOverBudget(t *testing.T) {
+	worker, repo, jobRepo := newTestDiaryWorker(t, &usageDiaryGenerator{})
+	usageRepo := NewSQLiteUsageRepository(setupTestDB(t))
+	// 1回の生成の料金は (1000×1 + 500×2) / 100万 = $0.002
+	worker.usage = NewUsageTracker(usageRepo, map[string]ModelPrice{"test-model": {Input: 1, Output: 2}})
+	if err := usageRepo.SaveUsageBudget(t.Context(), 0, 0.002, 0); err != nil {
+		t.Fatalf("SaveUsageBudget failed: %v", err)
+	}
+
+	// 上限に達するまでは生成し、使用量を記録する
+	firstID, err := worker.Enqueue(t.Context(), 1, 0, "/path/to/first.jpg", time.Now())
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	worker.processJob(t.Context(), <-worker.queue)
+	if job, _ := jobRepo.GetJobByID(t.Context(), firstID); job == nil || job.Status != JobStatusSucceeded {
+		t.Fatalf("expected first job to succeed, got %+v", job)
+	}
+	records, err := usageRepo.GetUsageRecords(t.Context(), OwnerScope{UserID: 1}, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetUsageRecords failed: %v", err)
+	}
+	if len(records) != 1 || records[0].DiaryID == 0 || records[0].Model != "test-model" || records[0].Cost != 0.002 {
+		t.Fatalf("unexpected usage records: %+v", records)
+	}
+
+	// 上限に達した後のジョブは失敗させずに一時停止する
+	secondID, err := worker.Enqueue(t.Context(), 1, 0, "/path/to/second.jpg", time.Now())
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	worker.processJob(t.Context(), <-worker.queue)
+	job, err := jobRepo.GetJobByID(t.Context(), secondID)
+	if err != nil {
+		t.Fatalf("GetJobByID failed: %v", err)
+	}
+	if job.Status != JobStatusPaused || job.LastError != budgetPausedMessage {
+		t.Fatalf("expected second job to be paused, got %+v", job)
+	}
+	if processed, _ := repo.IsImageProcessed(t.Context(), "/path/to/second.jpg"); processed {
+		t.Error("expected no diary to be created while paused")
+	}
+
+	// 上限に達している間は再開しない
+	ctx := t.Context()
+	worker.resumePausedJobs(ctx)
+	if len(worker.queue) != 0 {
+		t.Fatal("expected paused job not to be resumed while over budget")
+	}
+
+	// 上限を引き上げると再開して生成する
+	if err := usageRepo.SaveUsageBudget(t.Context(), 0, 1, 0); err != nil {
+		t.Fatalf("SaveUsageBudget failed: %v", err)
+	}
+	worker.resumePausedJobs(ctx)
+	worker.processJob(t.Context(), <-worker.queue)
+	if job, _ := jobRepo.GetJobByID(t.Context(), secondID); job == nil || job.Status != JobStatusSucceeded {
+		t.Errorf("expected resumed job to succeed, got %+v", job)
+	}
+}
+
+// flakyDiaryGenerator は最初のfailures回は時間をかけて失敗し、その後はすぐに成功するテスト用のDiaryGenerator
+type flakyDiaryGenerator struct {
+	failures int
+	delay    time.Duration
+	calls    int
+}
+
+func (g *flakyDiaryGenerator) Generate(ctx context.Context, req DiaryRequest) (DiaryResult, error) {
+	g.calls++
+	if g.calls <= g.failures {
+		time.Sleep(g.delay)
+		return DiaryResult{}, errors.New("generation failed")
+	}
+	return DiaryResult{Content: "新しい葉が開きました。"}, nil
+}
+
+func TestGenerateDiaryContent_LatencyExcludesFailures(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	retryConfig := DefaultRetryConfig()
+	retryConfig.SleepFunc = func(ctx context.Context, d time.Duration) error {
+		time.Sleep(delay)
+		return nil
+	}
+
+	fallback, err := NewFallbackDiaryGenerator(
+		DiaryProvider{Name: ProviderGemini, Generator: &flakyDiaryGenerator{failures: 1, delay: delay}},
+		DiaryProvider{Name: ProviderOllama, Generator: &flakyDiaryGenerator{}},
+	)
+	if err != nil {
+		t.Fatalf("NewFallbackDiaryGenerator failed: %v", err)
+	}
+	tests := []struct {
+		name         string
+		generator    DiaryGenerator
+		wantAttempts int
+	}{
+		// 失敗した試行とリトライの待ち時間は含まない
+		{"retried", &flakyDiaryGenerator{failures: 1, delay: delay}, 2},
+		// 失敗したプロバイダの時間は含まない
+		{"fallback", fallback, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generated, attempts, err := generateDiaryContent(t.Context(), NewMockDiaryRepository(), tt.generator, nil, retryConfig, 1, 0, "/path/to/image.jpg", time.Now())
+			if err != nil {
+				t.Fatalf("generateDiaryContent failed: %v", err)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("expected %d attempts, got %d", tt.wantAttempts, attempts)
+			}
+			if generated.Latency <= 0 || generated.Latency >= delay {
+				t.Errorf("expected latency of only the successful call, got %v", generated.Latency)
+			}
+		})
+	}
+}
+
+// blockingDiaryGenerator は生成を始めたことを通知し、ctxがキャンセルされるまで待つテスト用のDiaryGenerator
+type blockingDiaryGenerator struct {
+	started chan struct{}
+}
+
+func (g *blockingDiaryGenerator) Generate(ctx context.Context, req DiaryRequest) (DiaryResult, error) {
+	close(g.started)
+	<-ctx.Done()
+	return DiaryResult{}, ctx.Err()
+}
+
+func TestDiaryWorker_Shutdown_CancelsRunningJob(t *testing.T) {
+	generator := &blockingDiaryGenerator{started: make(chan struct{})}
+	worker, repo, jobRepo := newTestDiaryWorker(t, generator)
+	worker.Start()
+
+	jobID, err := worker.Enqueue(t.Context(), 1, 0, "/path/to/image.jpg", time.Now())
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	select {
+	case <-generator.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job was not started in time")
+	}
+
+	// 期限までに終わらない生成はキャンセルする
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if err := worker.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	worker.wg.Wait()
+
+	// 中断したジョブは失敗とせず、次回起動時に処理し直す
+	job, err := jobRepo.GetJobByID(t.Context(), jobID)
+	if err != nil {
+		t.Fatalf("GetJobByID failed: %v", err)
+	}
+	if job.Status != JobStatusRunning || job.LastError != "" {
+		t.Errorf("expected interrupted job to remain running, got %+v", job)
+	}
+	if processed, _ := repo.IsImageProcessed(t.Context(), "/path/to/image.jpg"); processed {
+		t.Error("expected no diary to be created for canceled job")
+	}
+}
+
+func TestDiaryWorker_Enqueue_QueueFull(t *testing.T) {
+	worker, _, jobRepo := newTestDiaryWorker(t, &MockDiaryGenerator{})
+
+	// Workerを起動していないため、キュー長1を超えた時点で満杯になる
+	if _, err := worker.Enqueue(t.Context(), 1, 0, "/path/1.jpg", time.Now()); err != nil {
+		t.Fatalf("first Enqueue failed: %v", err)
+	}
+	_, err := worker.Enqueue(t.Context(), 1, 0, "/path/2.jpg", time.Now())
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+
+	// 受け付けなかったジョブはDBに残らない
+	jobs, err := jobRepo.GetQueuedJobs(t.Context())
+	if err != nil {
+		t.Fatalf("GetQueuedJobs failed: %v", err)
+	}
+	if len(jobs) != 1 {
+		t.Errorf("expected 1 queued job, got %d", len(jobs))
+	}
+}
+
+func TestDiaryWorker_Enqueue_AfterShutdown(t *testing.T) {
+	worker, _, _ := newTestDiaryWorker(t, &MockDiaryGenerator{})
+	worker.Start()
+
+	if err := worker.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	_, err := worker.Enqueue(t.Context(), 1, 0, "/path/1.jpg", time.Now())
+	if !errors.Is(err, ErrWorkerStopped) {
+		t.Errorf("expected ErrWorkerStopped, got %v", err)
+	}
+}
+
+func TestLoadDiaryWorkerConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		workers   string
+		queueSize string
+		want      DiaryWorkerConfig
+		wantErr   bool
+	}{
+		{
+			name: "未設定時はデフォルト値",
+			want: DefaultDiaryWorkerConfig(),
+		},
+		{
+			name:      "環境変数で指定",
+			workers:   "4",
+			queueSize: "100",
+			want:      DiaryWorkerConfig{Workers: 4, QueueSize: 100},
+		},
+		{
+			name:    "Worker数が0",
+			workers: "0",
+			wantErr: true,
+		},
+		{
+			name:      "キュー長が数値でない",
+			queueSize: "abc",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DIARY_WORKERS", tt.workers)
+			t.Setenv("DIARY_QUEUE_SIZE", tt.queueSize)
+
+			got, err := LoadDiaryWorkerConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadDiaryWorkerConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("LoadDiaryWorkerConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// recordingDiaryGenerator は受け取ったリクエストを記録するテスト用のDiaryGenerator
+type recordingDiaryGenerator struct {
+	requests []DiaryRequest
+}
+
+func (g *recordingDiaryGenerator) Generate(ctx context.Context, req DiaryRequest) (DiaryResult, error) {
+	g.requests = append(g.requests, req)
+	return DiaryResult{Content: "新しい葉が開きました。"}, nil
+}
+
+// createTestPlants はユーザーの植物を名前ごとに作成し、植物のIDを返す
+func createTestPlants(t *testing.T, plantRepo PlantRepository, userID int, names ...string) []int {
+	t.Helper()
+	ids := make([]int, 0, len(names))
+	for _, name := range names {
+		uuid, err := generateUUID()
+		if err != nil {
+			t.Fatalf("generateUUID failed: %v", err)
+		}
+		if err := plantRepo.CreatePlant(t.Context(), uuid, userID, name, "", "", time.Time{}); err != nil {
+			t.Fatalf("CreatePlant failed: %v", err)
+		}
+		plant, err := plantRepo.GetPlantByUUID(t.Context(), uuid)
+		if err != nil || plant == nil {
+			t.Fatalf("GetPlantByUUID failed: %+v, %v", plant, err)
+		}
+		ids = append(ids, plant.ID)
+	}
+	return ids
+}
+
+func TestGenerateDiaryContent_PastDiariesOfSamePlant(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSQLiteDiaryRepository(db)
+	plants := createTestPlants(t, NewSQLitePlantRepository(db), 1, "ミニトマト", "バジル")
+	capturedAt := time.Date(2026, 3, 10, 1, 0, 0, 0, time.UTC)
+	yesterday := capturedAt.AddDate(0, 0, -1)
+	if err := repo.CreateDiaryForUser(t.Context(), 1, plants[0], "/photos/tomato.jpg", "トマトの実が赤くなりました。", yesterday); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+	if err := repo.CreateDiaryForUser(t.Context(), 1, plants[1], "/photos/basil.jpg", "バジルの葉を摘みました。", yesterday); err != nil {
+		t.Fatalf("CreateDiaryForUser failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		plantID int
+		want    []string
+		exclude []string
+	}{
+		{"tomato", plants[0], []string{"トマトの実"}, []string{"バジルの葉"}},
+		{"basil", plants[1], []string{"バジルの葉"}, []string{"トマトの実"}},
+		// 植物未指定の場合はユーザーの全ての日記を参照する
+		{"no plant", 0, []string{"トマトの実", "バジルの葉"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := &recordingDiaryGenerator{}
+			if _, _, err := generateDiaryContent(t.Context(), repo, generator, nil, DefaultRetryConfig(), 1, tt.plantID, "/photos/new.jpg", capturedAt); err != nil {
+				t.Fatalf("generateDiaryContent failed: %v", err)
+			}
+			prompt := generator.requests[0].Prompt
+			for _, s := range tt.want {
+				if !strings.Contains(prompt, s) {
+					t.Errorf("expected prompt to contain %q, got %q", s, prompt)
+				}
+			}
+			for _, s := range tt.exclude {
+				if strings.Contains(prompt, s) {
+					t.Errorf("expected prompt not to contain %q, got %q", s, prompt)
+				}
+			}
+		})
+	}
+}
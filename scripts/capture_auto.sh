@@ -10,18 +10,20 @@ set -euo pipefail
 # 基本: ./scripts/capture_auto.sh
 # 目標輝度を指定: ./scripts/capture_auto.sh 0.5
 # 目標輝度と最大試行回数を指定: ./scripts/capture_auto.sh 0.5 8
-# API登録付き: ./scripts/capture_auto.sh 0.5 8 --api-url http://192.168.1.10:8080 --user-uuid 550e8400e29b41d4a716446655440000
+# API登録付き: ./scripts/capture_auto.sh 0.5 8 --api-url http://192.168.1.10:8080
+# 植物を指定: ./scripts/capture_auto.sh 0.5 8 --api-url http://192.168.1.10:8080 --plant-uuid 6ba7b8109dad11d180b400c04fd430c8
 #
 #   引数1: TARGET_BRIGHTNESS（省略時: 0.475）
 #          0〜1の浮動小数点値で目標とする平均輝度を指定する。
 #          許容誤差（BRIGHTNESS_TOLERANCE）はスクリプト内の定数で調整できる。
 #   引数2: MAX_ADJUST_RETRIES（省略時: 5）
 #          明るさ調整の最大試行回数を指定する。
-#   --api-url: APIのベースURL。--user-uuid とセットで指定する（省略可）。
-#   --user-uuid: ユーザーUUID（ハイフンなし32文字）。--api-url とセットで指定する（省略可）。
+#   --api-url: APIのベースURL（省略可）。写真はAPIトークンのユーザーのものとして登録される。
+#   --user-uuid: ユーザーUUID（ハイフンなし32文字）。指定した場合、サーバーがAPIトークンのユーザーと一致するか確認する。--api-url 指定時のみ有効（省略可）。
+#   --plant-uuid: 撮影する植物のUUID（POST /api/plants で登録したもの）。--api-url 指定時のみ有効（省略可）。
 #
 # === 環境変数 ===
-# UPLOAD_API_KEY: APIキー。--api-url / --user-uuid 指定時は必須。
+# DIARY_API_TOKEN: 設定ページで発行したAPIトークン（アップロード権限）。--api-url 指定時は必須。
 #
 # === 前提条件 ===
 # - fswebcam がインストールされていること
@@ -48,6 +50,7 @@ EXPOSURE_FILE="${PROJECT_DIR}/data/last_exposure.txt"
 # 引数のパース
 DIARY_API_URL=""
 DIARY_USER_UUID=""
+DIARY_PLANT_UUID=""
 POSITIONAL=()
 while [[ $# -gt 0 ]]; do
   case "$1" in
@@ -63,6 +66,12 @@ while [[ $# -gt 0 ]]; do
         exit 1
       fi
       DIARY_USER_UUID="$2"; shift 2 ;;
+    --plant-uuid)
+      if [[ -z "${2:-}" || "$2" == --* ]]; then
+        echo "ERROR: --plant-uuid には値が必要です。" >&2
+        exit 1
+      fi
+      DIARY_PLANT_UUID="$2"; shift 2 ;;
     *) POSITIONAL+=("$1"); shift ;;
   esac
 done
@@ -82,17 +91,17 @@ if ! [[ "${MAX_ADJUST_RETRIES}" =~ ^[1-9][0-9]*$ ]]; then
     exit 1
 fi
 
-# フラグのバリデーション（片方だけの指定はエラー）
-if [ -n "${DIARY_API_URL}" ] && [ -z "${DIARY_USER_UUID}" ]; then
-    echo "ERROR: --api-url を指定する場合は --user-uuid も必要です。" >&2
-    exit 1
-fi
+# フラグのバリデーション（API関連のフラグは --api-url 指定時のみ有効）
 if [ -z "${DIARY_API_URL}" ] && [ -n "${DIARY_USER_UUID}" ]; then
     echo "ERROR: --user-uuid を指定する場合は --api-url も必要です。" >&2
     exit 1
 fi
-if [ -n "${DIARY_API_URL}" ] && [ -z "${UPLOAD_API_KEY:-}" ]; then
-    echo "ERROR: API登録を行う場合は環境変数 UPLOAD_API_KEY が必要です。" >&2
+if [ -z "${DIARY_API_URL}" ] && [ -n "${DIARY_PLANT_UUID}" ]; then
+    echo "ERROR: --plant-uuid を指定する場合は --api-url も必要です。" >&2
+    exit 1
+fi
+if [ -n "${DIARY_API_URL}" ] && [ -z "${DIARY_API_TOKEN:-}" ]; then
+    echo "ERROR: API登録を行う場合は環境変数 DIARY_API_TOKEN が必要です。" >&2
     exit 1
 fi
 
@@ -254,12 +263,13 @@ for ((i = 1; i <= MAX_ADJUST_RETRIES; i++)); do
         log_message "INFO: Captured ${FINAL_OUTPUT}"
         echo "撮影成功: ${FINAL_OUTPUT}"
 
-        # API登録（--api-url と --user-uuid が両方指定された場合のみ）
+        # API登録（--api-url が指定された場合のみ）
         if [ -n "${DIARY_API_URL}" ]; then
             curl -s -X POST \
-              -H "X-API-Key: ${UPLOAD_API_KEY}" \
+              -H "X-API-Key: ${DIARY_API_TOKEN}" \
               -F "photo=@${FINAL_OUTPUT}" \
-              -F "user_uuid=${DIARY_USER_UUID}" \
+              ${DIARY_USER_UUID:+-F "user_uuid=${DIARY_USER_UUID}"} \
+              ${DIARY_PLANT_UUID:+-F "plant_uuid=${DIARY_PLANT_UUID}"} \
               "${DIARY_API_URL}/api/photos" || log_message "WARN: API upload failed (photo saved locally)"
         fi
 
@@ -318,11 +328,12 @@ log_message "INFO: 露出値を保存: ${BEST_EXPOSURE}"
 log_message "INFO: Captured ${FINAL_OUTPUT}"
 echo "撮影成功: ${FINAL_OUTPUT}"
 
-# API登録（--api-url と --user-uuid が両方指定された場合のみ）
+# API登録（--api-url が指定された場合のみ）
 if [ -n "${DIARY_API_URL}" ]; then
     curl -s -X POST \
-      -H "X-API-Key: ${UPLOAD_API_KEY}" \
+      -H "X-API-Key: ${DIARY_API_TOKEN}" \
       -F "photo=@${FINAL_OUTPUT}" \
-      -F "user_uuid=${DIARY_USER_UUID}" \
+      ${DIARY_USER_UUID:+-F "user_uuid=${DIARY_USER_UUID}"} \
+      ${DIARY_PLANT_UUID:+-F "plant_uuid=${DIARY_PLANT_UUID}"} \
       "${DIARY_API_URL}/api/photos" || log_message "WARN: API upload failed (photo saved locally)"
 fi